@@ -1161,6 +1161,30 @@ func (c *config) IncludeTags() []string {
 	return c.productVariables.IncludeTags
 }
 
+// NeverallowRuleFiles returns the neverallow rule files listed by the product,
+// whose rules apply to the whole tree.
+func (c *config) NeverallowRuleFiles() []string {
+	return c.productVariables.NeverallowRuleFiles
+}
+
+// NeverallowBaselineFiles returns the neverallow baseline files listed by the
+// product, whose violations don't fail the build.
+func (c *config) NeverallowBaselineFiles() []string {
+	return c.productVariables.NeverallowBaselineFiles
+}
+
+// EnforceLicensePolicy returns true if the license metadata of installed device
+// modules is checked against the license policy.
+func (c *config) EnforceLicensePolicy() bool {
+	return Bool(c.productVariables.EnforceLicensePolicy)
+}
+
+// LicensePolicyAllowlists returns the files that list the modules with
+// by_exception_only license conditions that the license policy allows.
+func (c *config) LicensePolicyAllowlists() []string {
+	return c.productVariables.LicensePolicyAllowlists
+}
+
 func (c *config) HostStaticBinaries() bool {
 	return Bool(c.productVariables.HostStaticBinaries)
 }
@@ -172,6 +172,10 @@ func buildLicenseMetadata(ctx ModuleContext, licenseMetadataFile WritablePath) {
 		LicenseMetadataPath:   licenseMetadataFile,
 		LicenseMetadataDepSet: newPathsDepSet(Paths{licenseMetadataFile}, allDepMetadataDepSets),
 	})
+
+	if stamp := base.licensePolicyStamp; stamp != nil && len(base.installFiles) > 0 {
+		buildLicensePolicyCheck(ctx, licenseMetadataFile, stamp)
+	}
 }
 
 func isContainerFromFileExtensions(installPaths InstallPaths, builtPaths Paths) bool {
@@ -0,0 +1,78 @@
+// Copyright 2023 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package android
+
+import (
+	"strings"
+
+	"github.com/google/blueprint"
+)
+
+// When the EnforceLicensePolicy product variable is set, the license metadata
+// of every installed device module is checked against the license policy by a
+// validation action on its install rules, so that the install fails when:
+//   - a module with proprietary license conditions that is installed on the
+//     vendor or odm partition statically links a module with restricted or
+//     restricted_if_statically_linked license conditions.
+//   - a module with by_exception_only license conditions is installed, or is
+//     statically linked into an installed module, without being listed in one
+//     of the files in the LicensePolicyAllowlists product variable.
+
+var (
+	_ = pctx.HostBinToolVariable("licensePolicyCmd", "check_license_policy")
+
+	licensePolicyRule = pctx.AndroidStaticRule("licensePolicyRule", blueprint.RuleParams{
+		Command:     "${licensePolicyCmd} -o $out -d $out.d $flags $in",
+		CommandDeps: []string{"${licensePolicyCmd}"},
+		Depfile:     "$out.d",
+		Deps:        blueprint.DepsGCC,
+	}, "flags")
+)
+
+// licensePolicyStampPath returns the path to the output of the license policy
+// check of a module, or nil if the module isn't checked.
+func licensePolicyStampPath(ctx ModuleContext) WritablePath {
+	if !ctx.Config().EnforceLicensePolicy() || !ctx.Device() {
+		return nil
+	}
+	if !ctx.Module().Enabled() || exemptFromRequiredApplicableLicensesProperty(ctx.Module()) {
+		return nil
+	}
+	return PathForModuleOut(ctx, "license_policy.stamp")
+}
+
+// buildLicensePolicyCheck checks the license metadata of a module that
+// installs files against the license policy.
+func buildLicensePolicyCheck(ctx ModuleContext, licenseMetadataFile Path, stamp WritablePath) {
+	var flags []string
+	if ctx.InstallInVendor() || ctx.InstallInOdm() {
+		flags = append(flags, "-vendor")
+	}
+	allowlists := PathsForSource(ctx, ctx.Config().LicensePolicyAllowlists())
+	for _, allowlist := range allowlists {
+		flags = append(flags, "-allowlist "+allowlist.String())
+	}
+
+	ctx.Build(pctx, BuildParams{
+		Rule:        licensePolicyRule,
+		Input:       licenseMetadataFile,
+		Implicits:   allowlists,
+		Output:      stamp,
+		Description: "license policy",
+		Args: map[string]string{
+			"flags": strings.Join(flags, " "),
+		},
+	})
+}
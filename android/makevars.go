@@ -455,8 +455,12 @@ func (s *makeVarsSingleton) writeInstalls(installs, symlinks []katiInstall) []by
 
 	for _, install := range installs {
 		// Write a rule for each install request in the form:
+		//  [ to: .KATI_VALIDATIONS := validations ]
 		//  to: from [ deps ] [ | order only deps ]
 		//       cp -f -d $< $@ [ && chmod +x $@ ]
+		if len(install.validations) > 0 {
+			fmt.Fprintf(buf, "%s: .KATI_VALIDATIONS := %s\n", install.to.String(), strings.Join(install.validations.Strings(), " "))
+		}
 		fmt.Fprintf(buf, "%s: %s", install.to.String(), install.from.String())
 		for _, dep := range install.implicitDeps {
 			fmt.Fprintf(buf, " %s", dep.String())
@@ -431,6 +431,7 @@ type ModuleContext interface {
 	InstallInRecovery() bool
 	InstallInRoot() bool
 	InstallInVendor() bool
+	InstallInOdm() bool
 	InstallForceOS() (*OsType, *ArchType)
 
 	RequiredModuleNames() []string
@@ -501,6 +502,7 @@ type Module interface {
 	InstallInRecovery() bool
 	InstallInRoot() bool
 	InstallInVendor() bool
+	InstallInOdm() bool
 	InstallForceOS() (*OsType, *ArchType)
 	PartitionTag(DeviceConfig) string
 	HideFromMake()
@@ -1471,6 +1473,10 @@ type ModuleBase struct {
 
 	// The path to the generated license metadata file for the module.
 	licenseMetadataFile WritablePath
+
+	// The path to the output of the license policy check of the module, which is a validation
+	// of its install rules, or nil if the license policy isn't enforced for the module.
+	licensePolicyStamp WritablePath
 }
 
 // A struct containing all relevant information about a Bazel target converted via bp2build.
@@ -2057,6 +2063,10 @@ func (m *ModuleBase) InstallInVendor() bool {
 	return Bool(m.commonProperties.Vendor) || Bool(m.commonProperties.Soc_specific) || Bool(m.commonProperties.Proprietary)
 }
 
+func (m *ModuleBase) InstallInOdm() bool {
+	return Bool(m.commonProperties.Device_specific)
+}
+
 func (m *ModuleBase) InstallInRoot() bool {
 	return false
 }
@@ -2271,6 +2281,7 @@ func (m *ModuleBase) GenerateBuildActions(blueprintCtx blueprint.ModuleContext)
 	}
 
 	m.licenseMetadataFile = PathForModuleOut(ctx, "meta_lic")
+	m.licensePolicyStamp = licensePolicyStampPath(ctx)
 
 	dependencyInstallFiles, dependencyPackagingSpecs := m.computeInstallDeps(ctx)
 	// set m.installFilesDepSet to only the transitive dependencies to be used as the dependencies
@@ -2593,6 +2604,7 @@ type katiInstall struct {
 	orderOnlyDeps Paths
 	executable    bool
 	extraFiles    *extraFilesZip
+	validations   Paths
 
 	absFrom string
 }
@@ -3184,6 +3196,10 @@ func (m *moduleContext) InstallInVendor() bool {
 	return m.module.InstallInVendor()
 }
 
+func (m *moduleContext) InstallInOdm() bool {
+	return m.module.InstallInOdm()
+}
+
 func (m *moduleContext) skipInstall() bool {
 	if m.module.base().commonProperties.SkipInstall {
 		return true
@@ -3249,7 +3265,11 @@ func (m *moduleContext) installFile(installPath InstallPath, name string, srcPat
 	if !m.skipInstall() {
 		deps = append(deps, m.module.base().installFilesDepSet.ToList().Paths()...)
 
-		var implicitDeps, orderOnlyDeps Paths
+		var implicitDeps, orderOnlyDeps, validations Paths
+
+		if stamp := m.module.base().licensePolicyStamp; stamp != nil {
+			validations = append(validations, stamp)
+		}
 
 		if m.Host() {
 			// Installed host modules might be used during the build, depend directly on their
@@ -3270,6 +3290,7 @@ func (m *moduleContext) installFile(installPath InstallPath, name string, srcPat
 				orderOnlyDeps: orderOnlyDeps,
 				executable:    executable,
 				extraFiles:    extraZip,
+				validations:   validations,
 			})
 		} else {
 			rule := Cp
@@ -3292,6 +3313,7 @@ func (m *moduleContext) installFile(installPath InstallPath, name string, srcPat
 				Input:       srcPath,
 				Implicits:   implicitDeps,
 				OrderOnly:   orderOnlyDeps,
+				Validations: validations,
 				Default:     !m.Config().KatiEnabled(),
 				Args: map[string]string{
 					"extraCmds": extraCmds,
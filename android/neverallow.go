@@ -277,6 +277,7 @@ func neverallowMutator(ctx BottomUpMutatorContext) {
 	properties := m.GetProperties()
 
 	osClass := ctx.Module().Target().Os.Class
+	audit := neverallowAuditFor(ctx.Config())
 
 	for _, r := range neverallowRules(ctx.Config()) {
 		n := r.(*rule)
@@ -300,7 +301,15 @@ func neverallowMutator(ctx BottomUpMutatorContext) {
 			continue
 		}
 
-		ctx.ModuleErrorf("violates " + n.String())
+		violation := &neverallowViolation{
+			Rule:       n.auditName(),
+			Module:     ctx.ModuleName(),
+			Dir:        ctx.ModuleDir(),
+			Properties: n.matchedProperties(properties),
+		}
+		if audit.check(violation) {
+			ctx.ModuleErrorf("violates " + n.String())
+		}
 	}
 }
 
@@ -727,7 +736,9 @@ var neverallowRulesKey = NewOnceKey("neverallowRules")
 func neverallowRules(config Config) []Rule {
 	return config.Once(neverallowRulesKey, func() interface{} {
 		// No test rules were set by setTestNeverallowRules, use the global rules
-		return neverallows
+		// and the rules from the neverallow rule files.
+		rules := append([]Rule(nil), neverallows...)
+		return append(rules, loadNeverallowRuleFiles(config).rules...)
 	}).([]Rule)
 }
 
@@ -0,0 +1,285 @@
+// Copyright 2023 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package android
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/google/blueprint/proptools"
+)
+
+// A neverallow audit, enabled with SOONG_NEVERALLOW_AUDIT=true, reports every
+// module in the tree that violates a neverallow rule to
+// $OUT_DIR/soong/neverallow_audit.json instead of failing on the first one.
+// The build still fails after the report is written if there are violations
+// that aren't in a baseline.
+//
+// A neverallow baseline, listed in the NeverallowBaselineFiles product
+// variable, lists the known violations that don't fail the build so that a
+// new rule can be enforced before all of the existing violations are fixed.
+// It has the same format as the violations in the audit report, properties
+// are optional:
+//
+//	{
+//	    "violations": [
+//	        {
+//	            "rule": "acme binaries must build against the NDK",
+//	            "module": "acme_tool",
+//	            "dir": "vendor/acme/tools"
+//	        }
+//	    ]
+//	}
+
+type neverallowViolation struct {
+	// Rule is the reason of the violated rule, or the description of the
+	// rule if it has no reason.
+	Rule   string `json:"rule"`
+	Module string `json:"module"`
+	Dir    string `json:"dir"`
+
+	// Properties are the properties of the module that matched the rule,
+	// as name=value.
+	Properties []string `json:"properties,omitempty"`
+}
+
+type neverallowViolationKey struct {
+	rule, module, dir string
+}
+
+func (v *neverallowViolation) key() neverallowViolationKey {
+	return neverallowViolationKey{v.Rule, v.Module, v.Dir}
+}
+
+type neverallowBaselineJson struct {
+	Violations []neverallowViolation `json:"violations"`
+}
+
+type neverallowAuditReport struct {
+	// New are the violations that aren't in a baseline.
+	New []neverallowViolation `json:"new"`
+
+	// Baselined are the violations that are in a baseline.
+	Baselined []neverallowViolation `json:"baselined"`
+
+	// Fixed are the baseline entries that no module violates anymore,
+	// which can be removed from the baseline.
+	Fixed []neverallowViolation `json:"fixed"`
+}
+
+type neverallowAudit struct {
+	enabled bool
+
+	baseline      map[neverallowViolationKey]neverallowViolation
+	baselineFiles []string
+	errs          []error
+
+	lock       sync.Mutex
+	violations map[neverallowViolationKey]*neverallowViolation
+}
+
+var neverallowAuditKey = NewOnceKey("neverallowAudit")
+
+func neverallowAuditFor(config Config) *neverallowAudit {
+	return config.Once(neverallowAuditKey, func() interface{} {
+		audit := &neverallowAudit{
+			enabled:    config.IsEnvTrue("SOONG_NEVERALLOW_AUDIT"),
+			baseline:   make(map[neverallowViolationKey]neverallowViolation),
+			violations: make(map[neverallowViolationKey]*neverallowViolation),
+		}
+		for _, file := range config.NeverallowBaselineFiles() {
+			audit.baselineFiles = append(audit.baselineFiles, file)
+			if err := audit.readBaseline(config, file); err != nil {
+				audit.errs = append(audit.errs, err)
+			}
+		}
+		return audit
+	}).(*neverallowAudit)
+}
+
+func (a *neverallowAudit) readBaseline(config Config, file string) error {
+	r, err := config.fs.Open(file)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	var baseline neverallowBaselineJson
+	if err := json.Unmarshal(data, &baseline); err != nil {
+		return fmt.Errorf("%s: %s", file, err)
+	}
+	for i, v := range baseline.Violations {
+		if v.Rule == "" || v.Module == "" || v.Dir == "" {
+			return fmt.Errorf("%s: violation %d: rule, module and dir are required", file, i)
+		}
+		a.baseline[v.key()] = v
+	}
+	return nil
+}
+
+// check records a violation of a neverallow rule, and returns true if it
+// should fail the build immediately. In an audit the violations that aren't
+// in a baseline fail the build once the report has been written.
+func (a *neverallowAudit) check(v *neverallowViolation) bool {
+	_, baselined := a.baseline[v.key()]
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	// Each variant of a module reports its own violations, merge them.
+	if existing, ok := a.violations[v.key()]; ok {
+		existing.Properties = FirstUniqueStrings(append(existing.Properties, v.Properties...))
+	} else {
+		a.violations[v.key()] = v
+	}
+
+	return !a.enabled && !baselined
+}
+
+func (a *neverallowAudit) report() *neverallowAuditReport {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	report := &neverallowAuditReport{
+		New:       []neverallowViolation{},
+		Baselined: []neverallowViolation{},
+		Fixed:     []neverallowViolation{},
+	}
+	for key, v := range a.violations {
+		if _, ok := a.baseline[key]; ok {
+			report.Baselined = append(report.Baselined, *v)
+		} else {
+			report.New = append(report.New, *v)
+		}
+	}
+	for key, v := range a.baseline {
+		if _, ok := a.violations[key]; !ok {
+			report.Fixed = append(report.Fixed, v)
+		}
+	}
+
+	for _, violations := range [][]neverallowViolation{report.New, report.Baselined, report.Fixed} {
+		sort.Slice(violations, func(i, j int) bool {
+			a, b := violations[i], violations[j]
+			if a.Dir != b.Dir {
+				return a.Dir < b.Dir
+			}
+			if a.Module != b.Module {
+				return a.Module < b.Module
+			}
+			return a.Rule < b.Rule
+		})
+	}
+	return report
+}
+
+// auditName returns the name of the rule in audit reports and baselines.
+func (r *rule) auditName() string {
+	if r.reason != "" {
+		return r.reason
+	}
+	return r.String()
+}
+
+// matchedProperties returns the properties of a module that match the
+// properties of the rule, as name=value.
+func (r *rule) matchedProperties(properties []interface{}) []string {
+	var ret []string
+	for _, prop := range r.props {
+		names := make([]string, len(prop.fields))
+		for i, field := range prop.fields {
+			names[i] = proptools.PropertyNameForField(field)
+		}
+		name := strings.Join(names, ".")
+
+		for _, propertyStruct := range properties {
+			propertiesValue := reflect.ValueOf(propertyStruct).Elem()
+			for _, v := range prop.fields {
+				if !propertiesValue.IsValid() {
+					break
+				}
+				propertiesValue = propertiesValue.FieldByName(v)
+			}
+			if !propertiesValue.IsValid() {
+				continue
+			}
+
+			// Visit every value rather than stopping at the first
+			// match to report all of them.
+			matchValue(propertiesValue, func(value string) bool {
+				if prop.matcher.Test(value) {
+					ret = append(ret, name+"="+value)
+				}
+				return false
+			})
+		}
+	}
+	return FirstUniqueStrings(ret)
+}
+
+func init() {
+	RegisterSingletonType("neverallow_audit", neverallowAuditSingletonFactory)
+}
+
+func neverallowAuditSingletonFactory() Singleton {
+	return &neverallowAuditSingleton{}
+}
+
+// neverallowAuditSingleton writes the neverallow audit report, and reruns
+// soong_build when the neverallow baselines change.
+type neverallowAuditSingleton struct{}
+
+func (s *neverallowAuditSingleton) GenerateBuildActions(ctx SingletonContext) {
+	audit := neverallowAuditFor(ctx.Config())
+	for _, file := range audit.baselineFiles {
+		ctx.AddNinjaFileDeps(absolutePath(file))
+	}
+	for _, err := range audit.errs {
+		ctx.Errorf("invalid neverallow baseline: %s", err)
+	}
+
+	if !audit.enabled {
+		return
+	}
+
+	report := audit.report()
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		ctx.Errorf("failed to marshal the neverallow audit report: %s", err)
+		return
+	}
+	reportFile := PathForOutput(ctx, "neverallow_audit.json")
+	if err := WriteFileToOutputDir(reportFile, append(data, '\n'), 0666); err != nil {
+		ctx.Errorf("failed to write %s: %s", reportFile, err)
+	}
+	for _, v := range report.New {
+		ctx.Errorf("module %q in %s violates neverallow rule %q, and isn't in a neverallow baseline, see %s",
+			v.Module, v.Dir, v.Rule, reportFile)
+	}
+	// This is necessary to satisfy the dangling rules check as this file is
+	// written by Soong before the build.
+	ctx.Build(pctx, BuildParams{
+		Rule:   Touch,
+		Output: reportFile,
+	})
+}
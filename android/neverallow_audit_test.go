@@ -0,0 +1,119 @@
+// Copyright 2023 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package android
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+var neverallowAuditTestRules = []Rule{
+	NeverAllow().
+		In("vendor").
+		With("include_dirs", "*").
+		Because("vendor modules can't use include_dirs"),
+}
+
+const neverallowAuditTestBaseline = `{
+	"violations": [
+		{"rule": "vendor modules can't use include_dirs", "module": "libold", "dir": "vendor/old"},
+		{"rule": "vendor modules can't use include_dirs", "module": "libfixed", "dir": "vendor/fixed"}
+	]
+}`
+
+var prepareForNeverallowAuditTest = GroupFixturePreparers(
+	prepareForNeverAllowTest,
+	PrepareForTestWithNeverallowRules(neverallowAuditTestRules),
+	FixtureRegisterWithContext(func(ctx RegistrationContext) {
+		ctx.RegisterSingletonType("neverallow_audit", neverallowAuditSingletonFactory)
+	}),
+	FixtureAddTextFile("neverallow_baseline.json", neverallowAuditTestBaseline),
+	FixtureModifyProductVariables(func(variables FixtureProductVariables) {
+		variables.NeverallowBaselineFiles = []string{"neverallow_baseline.json"}
+	}),
+	FixtureAddTextFile("vendor/old/Android.bp", `
+		cc_library {
+			name: "libold",
+			include_dirs: ["frameworks/native/include"],
+		}`),
+	FixtureAddTextFile("vendor/new/Android.bp", `
+		cc_library {
+			name: "libnew",
+			include_dirs: ["frameworks/av/include", "frameworks/native/include"],
+		}`),
+)
+
+func TestNeverallowBaseline(t *testing.T) {
+	prepareForNeverallowAuditTest.
+		ExtendWithErrorHandler(FixtureExpectsAllErrorsToMatchAPattern([]string{
+			regexp.QuoteMeta(`module "libnew": violates neverallow requirements.`),
+		})).
+		RunTest(t)
+}
+
+func TestNeverallowAudit(t *testing.T) {
+	// The violations that aren't in the baseline fail the build after all
+	// of them have been reported.
+	result := GroupFixturePreparers(
+		prepareForNeverallowAuditTest,
+		FixtureMergeEnv(map[string]string{"SOONG_NEVERALLOW_AUDIT": "true"}),
+	).
+		ExtendWithErrorHandler(FixtureExpectsAllErrorsToMatchAPattern([]string{
+			regexp.QuoteMeta(`module "libnew" in vendor/new violates neverallow rule "vendor modules can't use include_dirs", and isn't in a neverallow baseline`),
+		})).
+		RunTest(t)
+
+	const rule = "vendor modules can't use include_dirs"
+	expected := &neverallowAuditReport{
+		New: []neverallowViolation{
+			{
+				Rule:   rule,
+				Module: "libnew",
+				Dir:    "vendor/new",
+				Properties: []string{
+					"include_dirs=frameworks/av/include",
+					"include_dirs=frameworks/native/include",
+				},
+			},
+		},
+		Baselined: []neverallowViolation{
+			{
+				Rule:       rule,
+				Module:     "libold",
+				Dir:        "vendor/old",
+				Properties: []string{"include_dirs=frameworks/native/include"},
+			},
+		},
+		Fixed: []neverallowViolation{
+			{Rule: rule, Module: "libfixed", Dir: "vendor/fixed"},
+		},
+	}
+	if got := neverallowAuditFor(result.Config).report(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected report:\n%#v\ngot:\n%#v", expected, got)
+	}
+}
+
+func TestNeverallowAuditBaselined(t *testing.T) {
+	// An audit of a tree that only has baselined violations succeeds.
+	GroupFixturePreparers(
+		prepareForNeverallowAuditTest,
+		FixtureMergeEnv(map[string]string{"SOONG_NEVERALLOW_AUDIT": "true"}),
+		FixtureOverrideTextFile("vendor/new/Android.bp", `
+			cc_library {
+				name: "libnew",
+			}`),
+	).RunTest(t)
+}
@@ -0,0 +1,282 @@
+// Copyright 2023 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package android
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Neverallow rule files add neverallow rules without changing this package,
+// so that partner and vendor trees can enforce their own policies. They are
+// JSON files with the same predicates as the Rule interface:
+//
+//	{
+//	    "rules": [
+//	        {
+//	            "in": ["vendor/acme"],
+//	            "not_in": ["vendor/acme/legacy"],
+//	            "module_types": ["cc_binary"],
+//	            "not_module_types": [],
+//	            "in_direct_deps": ["libbinder"],
+//	            "os_classes": ["device"],
+//	            "with": [{"property": "vendor", "value": "true"}],
+//	            "without": [{"property": "sdk_version", "regexp": "^$"}],
+//	            "because": "acme binaries must build against the NDK"
+//	        }
+//	    ]
+//	}
+//
+// Paths are relative to the top of the source tree. Each property is matched
+// by exactly one of "value" (where "*" matches any value), "regexp",
+// "starts_with", "is_set" or "not_in_list".
+//
+// Files named *.neverallow.json are found anywhere in the source tree by
+// soong_ui, and their rules only apply to modules in the directory that
+// contains the file. Files listed in the NeverallowRuleFiles product variable
+// apply to the whole tree.
+
+// neverallowRuleFilesList is the list of *.neverallow.json files in the source
+// tree written by soong_ui next to the list of Android.bp files.
+const neverallowRuleFilesList = "neverallow.list"
+
+type neverallowRuleFileJson struct {
+	Rules []neverallowRuleJson `json:"rules"`
+}
+
+type neverallowRuleJson struct {
+	In             []string                 `json:"in"`
+	NotIn          []string                 `json:"not_in"`
+	ModuleTypes    []string                 `json:"module_types"`
+	NotModuleTypes []string                 `json:"not_module_types"`
+	InDirectDeps   []string                 `json:"in_direct_deps"`
+	OsClasses      []string                 `json:"os_classes"`
+	With           []neverallowPropertyJson `json:"with"`
+	Without        []neverallowPropertyJson `json:"without"`
+	Because        string                   `json:"because"`
+}
+
+type neverallowPropertyJson struct {
+	Property   string   `json:"property"`
+	Value      *string  `json:"value"`
+	Regexp     *string  `json:"regexp"`
+	StartsWith *string  `json:"starts_with"`
+	IsSet      bool     `json:"is_set"`
+	NotInList  []string `json:"not_in_list"`
+}
+
+func (p *neverallowPropertyJson) matcher() (ValueMatcher, error) {
+	if p.Property == "" {
+		return nil, fmt.Errorf("missing property name")
+	}
+
+	var matchers []ValueMatcher
+	if p.Value != nil {
+		matchers = append(matchers, selectMatcher(*p.Value))
+	}
+	if p.Regexp != nil {
+		re, err := regexp.Compile(*p.Regexp)
+		if err != nil {
+			return nil, fmt.Errorf("property %q: %s", p.Property, err)
+		}
+		matchers = append(matchers, &regexMatcher{re})
+	}
+	if p.StartsWith != nil {
+		matchers = append(matchers, StartsWith(*p.StartsWith))
+	}
+	if p.IsSet {
+		matchers = append(matchers, isSetMatcherInstance)
+	}
+	if p.NotInList != nil {
+		matchers = append(matchers, NotInList(p.NotInList))
+	}
+
+	if len(matchers) != 1 {
+		return nil, fmt.Errorf("property %q must have exactly one of value, regexp, starts_with, "+
+			"is_set or not_in_list, found %d", p.Property, len(matchers))
+	}
+	return matchers[0], nil
+}
+
+var neverallowOsClasses = map[string]OsClass{
+	"device": Device,
+	"host":   Host,
+}
+
+// parseNeverallowRuleFile returns the rules of a neverallow rule file. If
+// scope is not empty the rules only apply to modules in the scope directory.
+func parseNeverallowRuleFile(file string, data []byte, scope string) ([]Rule, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	var ruleFile neverallowRuleFileJson
+	if err := decoder.Decode(&ruleFile); err != nil {
+		return nil, fmt.Errorf("%s: %s", file, err)
+	}
+
+	var rules []Rule
+	for i, r := range ruleFile.Rules {
+		rule, err := r.rule(scope)
+		if err != nil {
+			return nil, fmt.Errorf("%s: rule %d: %s", file, i, err)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+func (r *neverallowRuleJson) rule(scope string) (Rule, error) {
+	if r.Because == "" {
+		return nil, fmt.Errorf("missing because")
+	}
+
+	rule := NeverAllow().Because(r.Because)
+
+	// A rule file at the top of the tree applies to the whole tree.
+	if scope != "" && filepath.Clean(scope) != "." {
+		scope = filepath.Clean(scope) + "/"
+		for _, in := range r.In {
+			if !strings.HasPrefix(filepath.Clean(in)+"/", scope) {
+				return nil, fmt.Errorf("%q is outside of %q, which contains the rule file", in, scope)
+			}
+		}
+		if len(r.In) == 0 {
+			rule.In(scope)
+		}
+	}
+	if len(r.In) > 0 {
+		rule.In(r.In...)
+	}
+	if len(r.NotIn) > 0 {
+		rule.NotIn(r.NotIn...)
+	}
+	if len(r.ModuleTypes) > 0 {
+		rule.ModuleType(r.ModuleTypes...)
+	}
+	if len(r.NotModuleTypes) > 0 {
+		rule.NotModuleType(r.NotModuleTypes...)
+	}
+	if len(r.InDirectDeps) > 0 {
+		rule.InDirectDeps(r.InDirectDeps...)
+	}
+	for _, c := range r.OsClasses {
+		osClass, ok := neverallowOsClasses[c]
+		if !ok {
+			return nil, fmt.Errorf("unknown os class %q, expected device or host", c)
+		}
+		rule.WithOsClass(osClass)
+	}
+	for _, p := range r.With {
+		matcher, err := p.matcher()
+		if err != nil {
+			return nil, err
+		}
+		rule.WithMatcher(p.Property, matcher)
+	}
+	for _, p := range r.Without {
+		matcher, err := p.matcher()
+		if err != nil {
+			return nil, err
+		}
+		rule.WithoutMatcher(p.Property, matcher)
+	}
+	return rule, nil
+}
+
+type neverallowRuleFiles struct {
+	rules []Rule
+
+	// files are the files that the rules were read from, which soong_build
+	// must run again for when they change.
+	files []string
+
+	errs []error
+}
+
+var neverallowRuleFilesKey = NewOnceKey("neverallowRuleFiles")
+
+// loadNeverallowRuleFiles reads the neverallow rule files in the source tree
+// and those listed by the product.
+func loadNeverallowRuleFiles(config Config) *neverallowRuleFiles {
+	return config.Once(neverallowRuleFilesKey, func() interface{} {
+		ret := &neverallowRuleFiles{}
+		read := func(file, scope string) {
+			ret.files = append(ret.files, file)
+			data, err := os.ReadFile(absolutePath(file))
+			if err != nil {
+				ret.errs = append(ret.errs, err)
+				return
+			}
+			rules, err := parseNeverallowRuleFile(file, data, scope)
+			if err != nil {
+				ret.errs = append(ret.errs, err)
+				return
+			}
+			ret.rules = append(ret.rules, rules...)
+		}
+
+		list := neverallowRuleFilesListPath(config)
+		if data, err := os.ReadFile(absolutePath(list)); err == nil {
+			for _, file := range strings.Fields(string(data)) {
+				read(file, filepath.Dir(file))
+			}
+		} else if !os.IsNotExist(err) {
+			ret.errs = append(ret.errs, err)
+		}
+
+		for _, file := range config.NeverallowRuleFiles() {
+			read(file, "")
+		}
+		return ret
+	}).(*neverallowRuleFiles)
+}
+
+func neverallowRuleFilesListPath(config Config) string {
+	return filepath.Join(filepath.Dir(config.moduleListFile), neverallowRuleFilesList)
+}
+
+func init() {
+	RegisterSingletonType("neverallow_rule_files", neverallowRuleFilesSingletonFactory)
+}
+
+func neverallowRuleFilesSingletonFactory() Singleton {
+	return &neverallowRuleFilesSingleton{}
+}
+
+// neverallowRuleFilesSingleton reruns soong_build when neverallow rule files
+// are added, removed or changed, and reports the rule files that are invalid.
+type neverallowRuleFilesSingleton struct{}
+
+func (s *neverallowRuleFilesSingleton) GenerateBuildActions(ctx SingletonContext) {
+	ruleFiles := loadNeverallowRuleFiles(ctx.Config())
+	if list := absolutePath(neverallowRuleFilesListPath(ctx.Config())); fileExists(list) {
+		ctx.AddNinjaFileDeps(list)
+	}
+	for _, file := range ruleFiles.files {
+		ctx.AddNinjaFileDeps(absolutePath(file))
+	}
+	for _, err := range ruleFiles.errs {
+		ctx.Errorf("invalid neverallow rule file: %s", err)
+	}
+}
+
+func fileExists(file string) bool {
+	_, err := os.Stat(file)
+	return err == nil
+}
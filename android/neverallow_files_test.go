@@ -0,0 +1,120 @@
+// Copyright 2023 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package android
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestNeverallowRuleFile(t *testing.T) {
+	rules, err := parseNeverallowRuleFile("vendor/acme/acme.neverallow.json", []byte(`{
+		"rules": [
+			{
+				"module_types": ["cc_library"],
+				"with": [{"property": "include_dirs", "starts_with": "frameworks/"}],
+				"because": "acme libraries can't use framework headers"
+			},
+			{
+				"in": ["vendor/acme/apps"],
+				"with": [{"property": "libs", "value": "framework"}],
+				"without": [{"property": "sdk_version", "regexp": "^$"}],
+				"because": "acme apps can't use framework with an sdk_version"
+			}
+		]
+	}`), "vendor/acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	GroupFixturePreparers(
+		prepareForNeverAllowTest,
+		PrepareForTestWithNeverallowRules(rules),
+		FixtureAddTextFile("vendor/acme/Android.bp", `
+			cc_library {
+				name: "libacme",
+				include_dirs: ["frameworks/native/include"],
+			}`),
+		FixtureAddTextFile("vendor/acme/apps/Android.bp", `
+			java_library {
+				name: "AcmeApp",
+				libs: ["framework"],
+				sdk_version: "current",
+			}`),
+		// The rules of a rule file don't apply outside of its directory.
+		FixtureAddTextFile("vendor/other/Android.bp", `
+			cc_library {
+				name: "libother",
+				include_dirs: ["frameworks/native/include"],
+			}`),
+	).
+		ExtendWithErrorHandler(FixtureExpectsAllErrorsToMatchAPattern([]string{
+			regexp.QuoteMeta(`module "libacme": violates neverallow requirements. Not allowed:` +
+				"\n\tin dirs: [\"vendor/acme/\"]"),
+			regexp.QuoteMeta(`module "AcmeApp": violates neverallow requirements. Not allowed:` +
+				"\n\tin dirs: [\"vendor/acme/apps/\"]"),
+		})).
+		RunTest(t)
+}
+
+func TestNeverallowRuleFileErrors(t *testing.T) {
+	testCases := []struct {
+		name  string
+		data  string
+		scope string
+		err   string
+	}{
+		{
+			name: "unknown field",
+			data: `{"rules": [{"module_type": ["cc_library"], "because": "x"}]}`,
+			err:  `a.neverallow.json: json: unknown field "module_type"`,
+		},
+		{
+			name: "missing because",
+			data: `{"rules": [{"module_types": ["cc_library"]}]}`,
+			err:  `a.neverallow.json: rule 0: missing because`,
+		},
+		{
+			name: "two matchers",
+			data: `{"rules": [{"with": [{"property": "srcs", "value": "a", "is_set": true}], "because": "x"}]}`,
+			err: `a.neverallow.json: rule 0: property "srcs" must have exactly one of value, regexp, ` +
+				`starts_with, is_set or not_in_list, found 2`,
+		},
+		{
+			name: "invalid regexp",
+			data: `{"rules": [{"with": [{"property": "srcs", "regexp": "("}], "because": "x"}]}`,
+			err:  "a.neverallow.json: rule 0: property \"srcs\": error parsing regexp: missing closing ): `(`",
+		},
+		{
+			name: "unknown os class",
+			data: `{"rules": [{"os_classes": ["hostcross"], "because": "x"}]}`,
+			err:  `a.neverallow.json: rule 0: unknown os class "hostcross", expected device or host`,
+		},
+		{
+			name:  "outside of scope",
+			data:  `{"rules": [{"in": ["vendor/other"], "because": "x"}]}`,
+			scope: "vendor/acme",
+			err:   `a.neverallow.json: rule 0: "vendor/other" is outside of "vendor/acme/", which contains the rule file`,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := parseNeverallowRuleFile("a.neverallow.json", []byte(tc.data), tc.scope)
+			if err == nil || err.Error() != tc.err {
+				t.Errorf("expected error %q, got %v", tc.err, err)
+			}
+		})
+	}
+}
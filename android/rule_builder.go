@@ -19,6 +19,7 @@ import (
 	"fmt"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
 	"testing"
 
@@ -464,6 +465,119 @@ func (r *RuleBuilder) depFileMergerCmd(depFiles WritablePaths) *RuleBuilderComma
 		Inputs(depFiles.Paths())
 }
 
+// sboxDeclaredInputs returns the declared inputs and tools of the rule, including the rsp files
+// and the files listed in them, for the audit and cache messages of the sbox manifest.
+func (r *RuleBuilder) sboxDeclaredInputs(inputs, tools Paths, rspFiles []rspFileAndPaths) []string {
+	var declared []string
+	declared = append(declared, inputs.Strings()...)
+	declared = append(declared, tools.Strings()...)
+	for _, c := range r.commands {
+		for _, tool := range c.packagedTools {
+			declared = append(declared, tool.srcPath.String())
+		}
+	}
+	for _, rspFile := range rspFiles {
+		declared = append(declared, rspFile.file.String())
+		declared = append(declared, rspFile.paths.Strings()...)
+	}
+	return FirstUniqueStrings(declared)
+}
+
+// sboxAudit returns the audit message of the sbox manifest, which lists the declared inputs and
+// tools of the rule so that sbox can report the files accessed by the command that are not
+// declared.  The undeclared files accessed by all audited rules are appended to
+// out/soong/sbox_audit.jsonl.
+func (r *RuleBuilder) sboxAudit(inputs, tools Paths, rspFiles []rspFileAndPaths) *sbox_proto.Audit {
+	audit := &sbox_proto.Audit{
+		Report:          proto.String(PathForOutput(r.ctx, "sbox_audit.jsonl").String()),
+		SandboxedInputs: proto.Bool(r.sboxInputs),
+		Inputs:          r.sboxDeclaredInputs(inputs, tools, rspFiles),
+	}
+	if m, ok := r.ctx.(interface {
+		ModuleName() string
+		ModuleDir() string
+	}); ok {
+		audit.Module = proto.String("//" + m.ModuleDir() + ":" + m.ModuleName())
+	}
+	return audit
+}
+
+const defaultSboxCacheMaxSize = "10G"
+
+var sboxCacheConfigKey = NewOnceKey("sboxCacheConfig")
+
+type sboxCacheConfig struct {
+	dir     string
+	maxSize int64
+	err     error
+}
+
+// sboxCache returns the cache message of the sbox manifest, or nil if SOONG_SBOX_CACHE_DIR isn't
+// set or the tools of the rule aren't sandboxed.  sbox looks up the outputs of the rule in the
+// local action cache in SOONG_SBOX_CACHE_DIR, which is keyed by the hash of the commands, the
+// contents of the declared inputs and tools, the environment and the host toolchain in JAVA_HOME
+// and PATH, and evicts the least recently used entries when the cache is bigger than
+// SOONG_SBOX_CACHE_MAX_SIZE.  Only rules built with SandboxTools or SandboxInputs are cached, so
+// that the dependencies of their tools are declared.  The key doesn't cover other files that a
+// command reads without declaring them, SOONG_SBOX_AUDIT reports the rules that do.
+func (r *RuleBuilder) sboxCache(inputs, tools Paths, rspFiles []rspFileAndPaths) *sbox_proto.Cache {
+	config := r.ctx.Config()
+	if config.Getenv("SOONG_SBOX_CACHE_DIR") == "" || !r.sboxTools {
+		return nil
+	}
+
+	cacheConfig := config.Once(sboxCacheConfigKey, func() interface{} {
+		dir := config.Getenv("SOONG_SBOX_CACHE_DIR")
+		if !filepath.IsAbs(dir) {
+			return sboxCacheConfig{err: fmt.Errorf("SOONG_SBOX_CACHE_DIR %q must be an absolute path", dir)}
+		}
+
+		value := config.Getenv("SOONG_SBOX_CACHE_MAX_SIZE")
+		if value == "" {
+			value = defaultSboxCacheMaxSize
+		}
+		size, err := parseByteSize(value)
+		if err != nil {
+			err = fmt.Errorf("invalid SOONG_SBOX_CACHE_MAX_SIZE %q: %w", value, err)
+		}
+		return sboxCacheConfig{filepath.Clean(dir), size, err}
+	}).(sboxCacheConfig)
+	if cacheConfig.err != nil {
+		ReportPathErrorf(r.ctx, "%s", cacheConfig.err)
+		return nil
+	}
+
+	return &sbox_proto.Cache{
+		Dir:     proto.String(cacheConfig.dir),
+		MaxSize: proto.Int64(cacheConfig.maxSize),
+		Inputs:  r.sboxDeclaredInputs(inputs, tools, rspFiles),
+	}
+}
+
+// parseByteSize parses a size in bytes with an optional K, M or G suffix, for example 512M.
+func parseByteSize(s string) (int64, error) {
+	multiplier := int64(1)
+	switch {
+	case strings.HasSuffix(s, "K"):
+		multiplier = 1 << 10
+	case strings.HasSuffix(s, "M"):
+		multiplier = 1 << 20
+	case strings.HasSuffix(s, "G"):
+		multiplier = 1 << 30
+	}
+	if multiplier != 1 {
+		s = s[:len(s)-1]
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if n <= 0 {
+		return 0, fmt.Errorf("size must be positive")
+	}
+	return n * multiplier, nil
+}
+
 // Build adds the built command line to the build graph, with dependencies on Inputs and Tools, and output files for
 // Outputs.
 func (r *RuleBuilder) Build(name string, desc string) {
@@ -598,6 +712,17 @@ func (r *RuleBuilder) Build(name string, desc string) {
 			Rel(r.ctx, r.outDir.String(), path.String())
 		}
 
+		// If auditing sbox rules is enabled, have sbox trace the files accessed by the command
+		// and report the ones that were not declared as inputs or outputs of the rule.
+		if r.ctx.Config().IsEnvTrue("SOONG_SBOX_AUDIT") {
+			manifest.Audit = r.sboxAudit(inputs, tools, rspFiles)
+		}
+
+		// If the sbox action cache is enabled and the tools are sandboxed, have sbox restore the
+		// outputs of the rule from the cache instead of running the command when it has run
+		// before with the same inputs and tools.
+		manifest.Cache = r.sboxCache(inputs, tools, rspFiles)
+
 		// Add a hash of the list of input files to the manifest so that the textproto file
 		// changes when the list of input files changes and causes the sbox rule that
 		// depends on it to rerun.
@@ -500,6 +500,7 @@ type testRuleBuilderModule struct {
 
 		Restat      bool
 		Sbox        bool
+		Sbox_tools  bool
 		Sbox_inputs bool
 	}
 }
@@ -519,7 +520,7 @@ func (t *testRuleBuilderModule) GenerateAndroidBuildActions(ctx ModuleContext) {
 	manifestPath := PathForModuleOut(ctx, "sbox.textproto")
 
 	testRuleBuilder_Build(ctx, in, implicit, orderOnly, validation, out, outDep, outDir,
-		manifestPath, t.properties.Restat, t.properties.Sbox, t.properties.Sbox_inputs,
+		manifestPath, t.properties.Restat, t.properties.Sbox, t.properties.Sbox_tools, t.properties.Sbox_inputs,
 		rspFile, rspFileContents, rspFile2, rspFileContents2)
 }
 
@@ -544,19 +545,22 @@ func (t *testRuleBuilderSingleton) GenerateBuildActions(ctx SingletonContext) {
 	manifestPath := PathForOutput(ctx, "singleton/sbox.textproto")
 
 	testRuleBuilder_Build(ctx, in, implicit, orderOnly, validation, out, outDep, outDir,
-		manifestPath, true, false, false,
+		manifestPath, true, false, false, false,
 		rspFile, rspFileContents, rspFile2, rspFileContents2)
 }
 
 func testRuleBuilder_Build(ctx BuilderContext, in Paths, implicit, orderOnly, validation Path,
 	out, outDep, outDir, manifestPath WritablePath,
-	restat, sbox, sboxInputs bool,
+	restat, sbox, sboxTools, sboxInputs bool,
 	rspFile WritablePath, rspFileContents Paths, rspFile2 WritablePath, rspFileContents2 Paths) {
 
 	rule := NewRuleBuilder(pctx, ctx)
 
 	if sbox {
 		rule.Sbox(outDir, manifestPath)
+		if sboxTools {
+			rule.SandboxTools()
+		}
 		if sboxInputs {
 			rule.SandboxInputs()
 		}
@@ -720,6 +724,164 @@ func TestRuleBuilder_Build(t *testing.T) {
 	})
 }
 
+func TestRuleBuilderSboxAudit(t *testing.T) {
+	bp := `
+		rule_builder_test {
+			name: "foo_sbox",
+			srcs: ["in"],
+			sbox: true,
+		}
+		rule_builder_test {
+			name: "foo_sbox_inputs",
+			srcs: ["in"],
+			sbox: true,
+			sbox_inputs: true,
+		}
+	`
+
+	t.Run("disabled", func(t *testing.T) {
+		result := GroupFixturePreparers(
+			prepareForRuleBuilderTest,
+			FixtureWithRootAndroidBp(bp),
+		).RunTest(t)
+
+		manifest := RuleBuilderSboxProtoForTests(t, result.ModuleForTests("foo_sbox", "").Output("sbox.textproto"))
+		if manifest.Audit != nil {
+			t.Errorf("expected no audit in manifest, got %v", manifest.Audit)
+		}
+	})
+
+	t.Run("enabled", func(t *testing.T) {
+		result := GroupFixturePreparers(
+			prepareForRuleBuilderTest,
+			FixtureWithRootAndroidBp(bp),
+			FixtureMergeEnv(map[string]string{"SOONG_SBOX_AUDIT": "true"}),
+		).RunTest(t)
+
+		for _, name := range []string{"foo_sbox", "foo_sbox_inputs"} {
+			t.Run(name, func(t *testing.T) {
+				outDir := "out/soong/.intermediates/" + name
+				manifest := RuleBuilderSboxProtoForTests(t, result.ModuleForTests(name, "").Output("sbox.textproto"))
+				audit := manifest.GetAudit()
+
+				AssertStringEquals(t, "module", "//:"+name, audit.GetModule())
+				AssertStringEquals(t, "report", "out/soong/sbox_audit.jsonl",
+					StringRelativeToTop(result.Config, audit.GetReport()))
+				AssertBoolEquals(t, "sandboxed inputs", name == "foo_sbox_inputs", audit.GetSandboxedInputs())
+				AssertArrayString(t, "inputs",
+					[]string{"implicit", "in", "cp", outDir + "/rsp", "rsp_in", outDir + "/rsp2", "rsp_in2"},
+					StringsRelativeToTop(result.Config, audit.GetInputs()))
+			})
+		}
+	})
+}
+
+func TestRuleBuilderSboxCache(t *testing.T) {
+	bp := `
+		rule_builder_test {
+			name: "foo_sbox",
+			srcs: ["in"],
+			sbox: true,
+		}
+		rule_builder_test {
+			name: "foo_sbox_tools",
+			srcs: ["in"],
+			sbox: true,
+			sbox_tools: true,
+		}
+		rule_builder_test {
+			name: "foo_sbox_inputs",
+			srcs: ["in"],
+			sbox: true,
+			sbox_inputs: true,
+		}
+	`
+
+	testCases := []struct {
+		name        string
+		env         map[string]string
+		wantCache   bool
+		wantMaxSize int
+	}{
+		{
+			name: "disabled",
+		},
+		{
+			name:        "default size",
+			env:         map[string]string{"SOONG_SBOX_CACHE_DIR": "/tmp/sbox_cache"},
+			wantCache:   true,
+			wantMaxSize: 10 << 30,
+		},
+		{
+			name: "size",
+			env: map[string]string{
+				"SOONG_SBOX_CACHE_DIR":      "/tmp/sbox_cache",
+				"SOONG_SBOX_CACHE_MAX_SIZE": "512M",
+			},
+			wantCache:   true,
+			wantMaxSize: 512 << 20,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result := GroupFixturePreparers(
+				prepareForRuleBuilderTest,
+				FixtureWithRootAndroidBp(bp),
+				FixtureMergeEnv(tc.env),
+			).RunTest(t)
+
+			// Rules whose tools aren't sandboxed are never cached, the dependencies of their
+			// tools aren't declared.
+			manifest := RuleBuilderSboxProtoForTests(t, result.ModuleForTests("foo_sbox", "").Output("sbox.textproto"))
+			if cache := manifest.GetCache(); cache != nil {
+				t.Errorf("expected no cache in the manifest of a rule without sandboxed tools, got %v", cache)
+			}
+
+			for _, name := range []string{"foo_sbox_tools", "foo_sbox_inputs"} {
+				manifest = RuleBuilderSboxProtoForTests(t, result.ModuleForTests(name, "").Output("sbox.textproto"))
+				cache := manifest.GetCache()
+				if !tc.wantCache {
+					if cache != nil {
+						t.Errorf("%s: expected no cache in manifest, got %v", name, cache)
+					}
+					continue
+				}
+
+				outDir := "out/soong/.intermediates/" + name
+				AssertStringEquals(t, name+" dir", "/tmp/sbox_cache", cache.GetDir())
+				AssertIntEquals(t, name+" max size", tc.wantMaxSize, int(cache.GetMaxSize()))
+				AssertArrayString(t, name+" inputs",
+					[]string{"implicit", "in", "cp", outDir + "/rsp", "rsp_in", outDir + "/rsp2", "rsp_in2"},
+					StringsRelativeToTop(result.Config, cache.GetInputs()))
+			}
+		})
+	}
+
+	t.Run("relative dir", func(t *testing.T) {
+		GroupFixturePreparers(
+			prepareForRuleBuilderTest,
+			FixtureWithRootAndroidBp(bp),
+			FixtureMergeEnv(map[string]string{"SOONG_SBOX_CACHE_DIR": "sbox_cache"}),
+		).ExtendWithErrorHandler(FixtureExpectsAtLeastOneErrorMatchingPattern(
+			`SOONG_SBOX_CACHE_DIR "sbox_cache" must be an absolute path`)).
+			RunTest(t)
+	})
+
+	t.Run("invalid size", func(t *testing.T) {
+		GroupFixturePreparers(
+			prepareForRuleBuilderTest,
+			FixtureWithRootAndroidBp(bp),
+			FixtureMergeEnv(map[string]string{
+				"SOONG_SBOX_CACHE_DIR":      "/tmp/sbox_cache",
+				"SOONG_SBOX_CACHE_MAX_SIZE": "lots",
+			}),
+		).ExtendWithErrorHandler(FixtureExpectsAtLeastOneErrorMatchingPattern(
+			`invalid SOONG_SBOX_CACHE_MAX_SIZE "lots"`)).
+			RunTest(t)
+	})
+}
+
 func TestRuleBuilderHashInputs(t *testing.T) {
 	// The basic idea here is to verify that the command (in the case of a
 	// non-sbox rule) or the sbox textproto manifest contain a hash of the
@@ -461,6 +461,12 @@ type productVariables struct {
 	IgnorePrefer32OnDevice bool `json:",omitempty"`
 
 	IncludeTags []string `json:",omitempty"`
+
+	NeverallowRuleFiles     []string `json:",omitempty"`
+	NeverallowBaselineFiles []string `json:",omitempty"`
+
+	EnforceLicensePolicy    *bool    `json:",omitempty"`
+	LicensePolicyAllowlists []string `json:",omitempty"`
 }
 
 func boolPtr(v bool) *bool {
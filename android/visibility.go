@@ -440,6 +440,7 @@ func visibilityRuleEnforcer(ctx TopDownMutatorContext) {
 	}
 
 	qualified := createQualifiedModuleName(ctx.ModuleName(), ctx.ModuleDir())
+	audit := visibilityAuditFor(ctx.Config())
 
 	// Visit all the dependencies making sure that this module has access to them all.
 	ctx.VisitDirectDeps(func(dep Module) {
@@ -458,6 +459,10 @@ func visibilityRuleEnforcer(ctx TopDownMutatorContext) {
 			return
 		}
 
+		if audit != nil {
+			audit.addUser(depQualified, qualified)
+		}
+
 		rule := effectiveVisibilityRules(ctx.Config(), depQualified)
 		if !rule.matches(qualified) {
 			ctx.ModuleErrorf("depends on %s which is not visible to this module\nYou may need to add %q to its visibility", depQualified, "//"+ctx.ModuleDir())
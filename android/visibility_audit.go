@@ -0,0 +1,237 @@
+// Copyright 2023 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package android
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// The visibility audit, enabled with SOONG_VISIBILITY_AUDIT=true, writes the
+// effective visibility of every module and the modules in other packages that
+// depend on it to $OUT_DIR/soong/visibility_audit.json. It also reports the
+// visibility that is broader than the current usage:
+//
+//	{
+//	    "modules": [
+//	        {
+//	            "module": "//frameworks/foo:libfoo",
+//	            "type": "cc_library",
+//	            "visibility": ["//visibility:public"],
+//	            "users": ["//vendor/acme/bar:libbar", "//vendor/acme/baz:libbaz"],
+//	            "suggested_visibility": ["//vendor/acme/bar", "//vendor/acme/baz"]
+//	        }
+//	    ]
+//	}
+//
+// Only dependencies between Soong modules are counted, a module that is only
+// used by Make or installed by a product may have no users.
+
+// visibilityAuditMaxListedPackages is the largest number of packages that a
+// suggested visibility lists individually, above which it uses the closest
+// common ancestor of the packages instead.
+const visibilityAuditMaxListedPackages = 3
+
+type visibilityAuditModule struct {
+	Module     string   `json:"module"`
+	Type       string   `json:"type"`
+	Visibility []string `json:"visibility"`
+	Users      []string `json:"users"`
+
+	// UnusedRules are the visibility rules that none of the users need.
+	UnusedRules []string `json:"unused_rules,omitempty"`
+
+	// SuggestedVisibility is the narrowest visibility that still allows
+	// all of the users, when the visibility is broader than the usage.
+	SuggestedVisibility []string `json:"suggested_visibility,omitempty"`
+}
+
+type visibilityAuditReport struct {
+	Modules []visibilityAuditModule `json:"modules"`
+}
+
+type visibilityAudit struct {
+	lock sync.Mutex
+
+	// users are the modules in other packages that depend on each module.
+	users map[qualifiedModuleName]map[qualifiedModuleName]bool
+}
+
+var visibilityAuditKey = NewOnceKey("visibilityAudit")
+
+// visibilityAuditFor returns the visibility audit, or nil if it is disabled.
+func visibilityAuditFor(config Config) *visibilityAudit {
+	return config.Once(visibilityAuditKey, func() interface{} {
+		if !config.IsEnvTrue("SOONG_VISIBILITY_AUDIT") {
+			return (*visibilityAudit)(nil)
+		}
+		return &visibilityAudit{
+			users: make(map[qualifiedModuleName]map[qualifiedModuleName]bool),
+		}
+	}).(*visibilityAudit)
+}
+
+// addUser records that user depends on dep.
+func (a *visibilityAudit) addUser(dep, user qualifiedModuleName) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if a.users[dep] == nil {
+		a.users[dep] = make(map[qualifiedModuleName]bool)
+	}
+	a.users[dep][user] = true
+}
+
+func (a *visibilityAudit) module(config Config, qualified qualifiedModuleName, moduleType string) visibilityAuditModule {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	var users []qualifiedModuleName
+	for user := range a.users[qualified] {
+		users = append(users, user)
+	}
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].String() < users[j].String()
+	})
+
+	rule := effectiveVisibilityRules(config, qualified)
+	ret := visibilityAuditModule{
+		Module:     qualified.String(),
+		Type:       moduleType,
+		Visibility: rule.Strings(),
+		Users:      make([]string, 0, len(users)),
+	}
+	for _, user := range users {
+		ret.Users = append(ret.Users, user.String())
+	}
+
+	isPublic := false
+	for _, r := range rule {
+		switch r.(type) {
+		case privateRule:
+			continue
+		case publicRule:
+			isPublic = true
+		}
+		used := false
+		for _, user := range users {
+			if r.matches(user) {
+				used = true
+				break
+			}
+		}
+		if !used {
+			ret.UnusedRules = append(ret.UnusedRules, r.String())
+		}
+	}
+
+	if isPublic || len(ret.UnusedRules) > 0 {
+		ret.SuggestedVisibility = suggestedVisibility(users).Strings()
+	}
+	return ret
+}
+
+// suggestedVisibility returns the narrowest visibility that allows all of the
+// users, or nil if the users have no common ancestor package.
+func suggestedVisibility(users []qualifiedModuleName) compositeRule {
+	if len(users) == 0 {
+		return compositeRule{privateRule{}}
+	}
+
+	var pkgs []string
+	for _, user := range users {
+		pkgs = append(pkgs, user.pkg)
+	}
+	pkgs = SortedUniqueStrings(pkgs)
+
+	if len(pkgs) <= visibilityAuditMaxListedPackages {
+		ret := make(compositeRule, 0, len(pkgs))
+		for _, pkg := range pkgs {
+			ret = append(ret, packageRule{pkg})
+		}
+		return ret
+	}
+
+	ancestor := pkgs[0]
+	for _, pkg := range pkgs[1:] {
+		for !isAncestor(ancestor, pkg) {
+			i := strings.LastIndexByte(ancestor, '/')
+			if i < 0 {
+				return nil
+			}
+			ancestor = ancestor[:i]
+		}
+	}
+	return compositeRule{subpackagesRule{ancestor}}
+}
+
+func init() {
+	RegisterSingletonType("visibility_audit", visibilityAuditSingletonFactory)
+}
+
+func visibilityAuditSingletonFactory() Singleton {
+	return &visibilityAuditSingleton{}
+}
+
+// visibilityAuditSingleton writes the visibility audit report.
+type visibilityAuditSingleton struct {
+	report *visibilityAuditReport
+}
+
+func (s *visibilityAuditSingleton) GenerateBuildActions(ctx SingletonContext) {
+	audit := visibilityAuditFor(ctx.Config())
+	if audit == nil {
+		return
+	}
+
+	s.report = &visibilityAuditReport{Modules: []visibilityAuditModule{}}
+	seen := make(map[qualifiedModuleName]bool)
+	ctx.VisitAllModules(func(m Module) {
+		// Defaults and package modules can't be depended on directly.
+		if _, ok := m.(Defaults); ok {
+			return
+		}
+		if _, ok := m.(*packageModule); ok {
+			return
+		}
+
+		qualified := createQualifiedModuleName(ctx.ModuleName(m), ctx.ModuleDir(m))
+		if seen[qualified] {
+			return
+		}
+		seen[qualified] = true
+		s.report.Modules = append(s.report.Modules, audit.module(ctx.Config(), qualified, ctx.ModuleType(m)))
+	})
+	sort.Slice(s.report.Modules, func(i, j int) bool {
+		return s.report.Modules[i].Module < s.report.Modules[j].Module
+	})
+
+	data, err := json.MarshalIndent(s.report, "", "  ")
+	if err != nil {
+		ctx.Errorf("failed to marshal the visibility audit report: %s", err)
+		return
+	}
+	reportFile := PathForOutput(ctx, "visibility_audit.json")
+	if err := WriteFileToOutputDir(reportFile, append(data, '\n'), 0666); err != nil {
+		ctx.Errorf("failed to write %s: %s", reportFile, err)
+	}
+	// This is necessary to satisfy the dangling rules check as this file is
+	// written by Soong before the build.
+	ctx.Build(pctx, BuildParams{
+		Rule:   Touch,
+		Output: reportFile,
+	})
+}
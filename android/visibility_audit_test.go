@@ -0,0 +1,119 @@
+// Copyright 2023 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package android
+
+import (
+	"testing"
+)
+
+func TestVisibilityAudit(t *testing.T) {
+	result := GroupFixturePreparers(
+		PrepareForTestWithArchMutator,
+		PrepareForTestWithDefaults,
+		PrepareForTestWithPackageModule,
+		PrepareForTestWithVisibility,
+		FixtureRegisterWithContext(func(ctx RegistrationContext) {
+			ctx.RegisterModuleType("mock_library", newMockLibraryModule)
+			ctx.RegisterSingletonType("visibility_audit", visibilityAuditSingletonFactory)
+		}),
+		FixtureMergeEnv(map[string]string{"SOONG_VISIBILITY_AUDIT": "true"}),
+		MockFS{
+			"top/Android.bp": []byte(`
+				mock_library {
+					name: "libpublic",
+				}
+				mock_library {
+					name: "libnarrow",
+					visibility: ["//a", "//unused"],
+				}
+				mock_library {
+					name: "libprivate",
+					visibility: ["//visibility:private"],
+				}`),
+			"a/Android.bp": []byte(`
+				mock_library {
+					name: "liba",
+					deps: ["libpublic", "libnarrow"],
+				}`),
+			"a/b/Android.bp": []byte(`
+				mock_library {
+					name: "libab",
+					deps: ["libpublic"],
+				}`),
+		}.AddToFixture(),
+	).RunTest(t)
+
+	report := result.SingletonForTests("visibility_audit").Singleton().(*visibilityAuditSingleton).report
+	expected := []visibilityAuditModule{
+		{
+			Module:              "//a/b:libab",
+			Type:                "mock_library",
+			Visibility:          []string{"//visibility:public"},
+			Users:               []string{},
+			UnusedRules:         []string{"//visibility:public"},
+			SuggestedVisibility: []string{"//visibility:private"},
+		},
+		{
+			Module:              "//a:liba",
+			Type:                "mock_library",
+			Visibility:          []string{"//visibility:public"},
+			Users:               []string{},
+			UnusedRules:         []string{"//visibility:public"},
+			SuggestedVisibility: []string{"//visibility:private"},
+		},
+		{
+			Module:              "//top:libnarrow",
+			Type:                "mock_library",
+			Visibility:          []string{"//a", "//unused"},
+			Users:               []string{"//a:liba"},
+			UnusedRules:         []string{"//unused"},
+			SuggestedVisibility: []string{"//a"},
+		},
+		{
+			Module:     "//top:libprivate",
+			Type:       "mock_library",
+			Visibility: []string{"//visibility:private"},
+			Users:      []string{},
+		},
+		{
+			Module:              "//top:libpublic",
+			Type:                "mock_library",
+			Visibility:          []string{"//visibility:public"},
+			Users:               []string{"//a/b:libab", "//a:liba"},
+			SuggestedVisibility: []string{"//a", "//a/b"},
+		},
+	}
+	AssertDeepEquals(t, "visibility audit", expected, report.Modules)
+}
+
+func TestSuggestedVisibility(t *testing.T) {
+	users := func(pkgs ...string) []qualifiedModuleName {
+		var ret []qualifiedModuleName
+		for _, pkg := range pkgs {
+			ret = append(ret, qualifiedModuleName{pkg, "lib"})
+		}
+		return ret
+	}
+
+	AssertDeepEquals(t, "common ancestor",
+		[]string{"//vendor/acme:__subpackages__"},
+		suggestedVisibility(users("vendor/acme/x", "vendor/acme/y/z", "vendor/acme/w", "vendor/acme")).Strings())
+	AssertDeepEquals(t, "no common ancestor",
+		[]string{},
+		suggestedVisibility(users("a", "b", "c", "d")).Strings())
+	AssertDeepEquals(t, "listed packages",
+		[]string{"//a", "//b/c"},
+		suggestedVisibility(users("b/c", "a", "b/c")).Strings())
+}
@@ -0,0 +1,170 @@
+// Copyright 2023 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// filetrace runs a command and records the files under the given paths that it
+// and its descendants access, including the accesses that failed. soong_ui
+// runs the sandboxed phases of the build through it when the sandbox policy
+// restricts paths for the phase, and reports the accesses to those paths when
+// the phase fails.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"android/soong/filetrace"
+)
+
+var (
+	outputFile string
+	paths      pathList
+)
+
+type pathList []string
+
+func (l *pathList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *pathList) Set(path string) error {
+	*l = append(*l, filepath.Clean(path))
+	return nil
+}
+
+func init() {
+	flag.StringVar(&outputFile, "o", "", "file to write the accesses to")
+	flag.Var(&paths, "p", "record the accesses under this path, can be repeated (default all paths)")
+}
+
+func usageViolation(violation string) {
+	if violation != "" {
+		fmt.Fprintf(os.Stderr, "Usage error: %s.\n\n", violation)
+	}
+
+	fmt.Fprintf(os.Stderr,
+		"Usage: filetrace -o <file> [-p <path>]... -- <executable> <argv0> [<arg>...]\n")
+
+	flag.PrintDefaults()
+
+	os.Exit(1)
+}
+
+func main() {
+	flag.Usage = func() {
+		usageViolation("")
+	}
+	flag.Parse()
+
+	if outputFile == "" {
+		usageViolation("-o is required")
+	}
+	if flag.NArg() < 2 {
+		usageViolation("an executable and its argv are required")
+	}
+
+	os.Exit(run(outputFile, paths, flag.Arg(0), flag.Args()[1:]))
+}
+
+// run runs the executable with the given argv and writes its accesses to the
+// paths to outputFile. It returns the exit code of the executable.
+func run(outputFile string, paths []string, executable string, args []string) int {
+	command := func() *exec.Cmd {
+		return &exec.Cmd{
+			Path:   executable,
+			Args:   args,
+			Stdin:  os.Stdin,
+			Stdout: os.Stdout,
+			Stderr: os.Stderr,
+		}
+	}
+
+	cmd := command()
+	accesses, err := filetrace.Trace(cmd, func(access filetrace.Access) bool {
+		return underAny(access.Path, paths)
+	})
+	if cmd.Process == nil {
+		if err == filetrace.ErrAlreadyTraced || errors.Is(err, syscall.EPERM) {
+			// Tracing isn't possible here, run the command anyway.
+			fmt.Fprintf(os.Stderr, "filetrace: %s, not tracing file accesses\n", err)
+			return exitCode(command().Run())
+		}
+		fmt.Fprintln(os.Stderr, "filetrace:", err)
+		return 1
+	}
+
+	if writeErr := filetrace.WriteFile(outputFile, unique(accesses)); writeErr != nil {
+		fmt.Fprintln(os.Stderr, "filetrace: failed to write accesses:", writeErr)
+	}
+	return exitCode(err)
+}
+
+// underAny returns true if path is one of paths, or in one of them. All paths
+// are under an empty list of paths.
+func underAny(path string, paths []string) bool {
+	if len(paths) == 0 {
+		return true
+	}
+	for _, dir := range paths {
+		if path == dir || strings.HasPrefix(path, dir+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// unique returns the accesses without duplicates, in the order they were made.
+func unique(accesses []filetrace.Access) []filetrace.Access {
+	seen := make(map[filetrace.Access]bool, len(accesses))
+	var ret []filetrace.Access
+	for _, access := range accesses {
+		if !seen[access] {
+			seen[access] = true
+			ret = append(ret, access)
+		}
+	}
+	return ret
+}
+
+// exitCode returns the exit code of a command that ran with err, using the
+// same convention as the shell for commands that were killed by a signal.
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	var status syscall.WaitStatus
+	var tracedErr *filetrace.ExitError
+	var exitErr *exec.ExitError
+	if errors.As(err, &tracedErr) {
+		status = tracedErr.Status
+	} else if errors.As(err, &exitErr) {
+		if s, ok := exitErr.Sys().(syscall.WaitStatus); ok {
+			status = s
+		} else {
+			return exitErr.ExitCode()
+		}
+	} else {
+		fmt.Fprintln(os.Stderr, "filetrace:", err)
+		return 1
+	}
+	if status.Signaled() {
+		return 128 + int(status.Signal())
+	}
+	return status.ExitStatus()
+}
@@ -0,0 +1,92 @@
+// Copyright 2023 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"syscall"
+	"testing"
+
+	"android/soong/filetrace"
+)
+
+func TestUnderAny(t *testing.T) {
+	testCases := []struct {
+		path  string
+		paths []string
+		want  bool
+	}{
+		{path: "/a/b", paths: nil, want: true},
+		{path: "/a/b", paths: []string{"/a"}, want: true},
+		{path: "/a", paths: []string{"/a"}, want: true},
+		{path: "/ab", paths: []string{"/a"}, want: false},
+		{path: "/c/d", paths: []string{"/a", "/c"}, want: true},
+	}
+	for _, tc := range testCases {
+		if got := underAny(tc.path, tc.paths); got != tc.want {
+			t.Errorf("underAny(%q, %q) = %v, want %v", tc.path, tc.paths, got, tc.want)
+		}
+	}
+}
+
+func TestUnique(t *testing.T) {
+	accesses := []filetrace.Access{
+		{Path: "/a"},
+		{Path: "/b", Write: true},
+		{Path: "/a"},
+		{Path: "/a", Errno: syscall.ENOENT},
+		{Path: "/b", Write: true},
+	}
+	want := []filetrace.Access{
+		{Path: "/a"},
+		{Path: "/b", Write: true},
+		{Path: "/a", Errno: syscall.ENOENT},
+	}
+	if got := unique(accesses); !reflect.DeepEqual(got, want) {
+		t.Errorf("unique() = %v, want %v", got, want)
+	}
+}
+
+func TestRun(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("tracing file accesses is only supported on Linux")
+	}
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("sh is not available")
+	}
+
+	dir := t.TempDir()
+	input := filepath.Join(dir, "restricted", "input")
+	output := filepath.Join(dir, "accesses.json")
+
+	code := run(output, []string{filepath.Join(dir, "restricted")}, sh,
+		[]string{"sh", "-c", "cat " + input + " " + input + "; exit 3"})
+	if code != 3 {
+		t.Errorf("run() = %d, want 3", code)
+	}
+
+	accesses, err := filetrace.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []filetrace.Access{{Path: input, Errno: syscall.ENOENT}}
+	if !reflect.DeepEqual(accesses, want) {
+		t.Errorf("accesses = %v, want %v", accesses, want)
+	}
+}
@@ -60,12 +60,17 @@ var buildVariant = flag.String("variant", "eng", "build variant to use")
 var shardCount = flag.Int("shard-count", 1, "split the products into multiple shards (to spread the build onto multiple machines, etc)")
 var shard = flag.Int("shard", 1, "1-indexed shard to execute")
 
+var resume = flag.Bool("resume", false, "skip the products that already have a result in the output directory (requires --out or --incremental)")
+var retryFailed = flag.Bool("retry-failed", false, "run the products that failed in a previous run in the output directory (requires --out or --incremental)")
+
 var skipProducts multipleStringArg
 var includeProducts multipleStringArg
+var mergeDirs multipleStringArg
 
 func init() {
 	flag.Var(&skipProducts, "skip-products", "comma-separated list of products to skip (known failures, etc)")
 	flag.Var(&includeProducts, "products", "comma-separated list of products to build")
+	flag.Var(&mergeDirs, "merge", "comma-separated list of output directories of shards to merge the results of into --out, without building anything")
 }
 
 // multipleStringArg is a flag.Value that takes comma separated lists and converts them to a
@@ -168,6 +173,8 @@ type mpContext struct {
 	SoongUi     string
 	MainOutDir  string
 	MainLogsDir string
+
+	Results *resultStore
 }
 
 func findNamedProducts(soongUi string, log logger.Logger) []string {
@@ -316,6 +323,38 @@ func main() {
 	log.SetOutput(filepath.Join(configLogsDir, "soong.log"))
 	trace.SetOutput(filepath.Join(configLogsDir, "build.trace"))
 
+	if len(mergeDirs) > 0 {
+		if *outDir == "" {
+			log.Fatalf("--merge requires --out")
+		}
+		var runs []map[string]*productResult
+		for _, dir := range mergeDirs {
+			results, err := (&resultStore{dir: filepath.Join(dir, resultsDirName)}).load()
+			if err != nil {
+				log.Fatalf("Error loading the results of %s: %v", dir, err)
+			}
+			runs = append(runs, results)
+		}
+		merged := mergeResults(runs...)
+		if err := writeResults(outputDir, merged); err != nil {
+			log.Fatalf("Error writing the merged results: %v", err)
+		}
+		fmt.Fprint(output, merged.summary())
+		return
+	}
+
+	if (*resume || *retryFailed) && *outDir == "" && !*incremental {
+		log.Fatalf("--resume and --retry-failed require --out or --incremental to find the previous results")
+	} else if !*resume && !*retryFailed {
+		// The results of a previous run would be merged with the results
+		// of this one.
+		os.RemoveAll(filepath.Join(outputDir, resultsDirName))
+	}
+	results, err := newResultStore(outputDir)
+	if err != nil {
+		log.Fatalf("Failed to create the results directory: %v", err)
+	}
+
 	var jobs = *numJobs
 	if jobs < 1 {
 		jobs = runtime.NumCPU() / 4
@@ -380,6 +419,15 @@ func main() {
 		finalProductsList = splitList(finalProductsList, *shardCount)[*shard-1]
 	}
 
+	previousResults, err := results.load()
+	if err != nil {
+		log.Fatalf("Error loading the previous results: %v", err)
+	}
+	if toRun := productsToRun(finalProductsList, previousResults, *resume, *retryFailed); len(toRun) != len(finalProductsList) {
+		log.Printf("Skipping %d products with previous results\n", len(finalProductsList)-len(toRun))
+		finalProductsList = toRun
+	}
+
 	log.Verbose("Got product list: ", finalProductsList)
 
 	s := stat.StartTool()
@@ -391,6 +439,7 @@ func main() {
 		SoongUi:     soongUi,
 		MainOutDir:  outputDir,
 		MainLogsDir: logsDir,
+		Results:     results,
 	}
 
 	products := make(chan string, len(productsList))
@@ -436,10 +485,28 @@ func main() {
 
 	s.Finish()
 
-	if failures.count == 1 {
+	allResults, err := results.load()
+	if err != nil {
+		log.Fatalf("Error loading the results: %v", err)
+	}
+	merged := mergeResults(allResults)
+	if err := writeResults(outputDir, merged); err != nil {
+		log.Fatalf("Error writing the results: %v", err)
+	}
+	if *alternateResultDir {
+		if err := writeResults(configLogsDir, merged); err != nil {
+			log.Fatalf("Error writing the results: %v", err)
+		}
+	}
+	log.Printf("Results: %s\n", filepath.Join(outputDir, summaryFile))
+
+	// The exit status includes the products that failed in the previous
+	// runs that were skipped by --resume.
+	failed := merged.failedProducts()
+	if count := len(failed) + failures.count; count == 1 {
 		log.Fatal("1 failure")
-	} else if failures.count > 1 {
-		log.Fatalf("%d failures %q", failures.count, failures.fails)
+	} else if count > 1 {
+		log.Fatalf("%d failures %q", count, failed)
 	} else {
 		fmt.Fprintln(output, "Success")
 	}
@@ -533,6 +600,7 @@ func runSoongUiForProduct(mpctx *mpContext, product string) {
 
 	before := time.Now()
 	err = cmd.Run()
+	duration := time.Since(before)
 
 	if !*onlyConfig && !*onlySoong {
 		katiBuildNinjaFile := filepath.Join(outDir, "build-"+product+".ninja")
@@ -550,6 +618,24 @@ func runSoongUiForProduct(mpctx *mpContext, product string) {
 		errOutput = errMsgFromLog(consoleLogPath)
 	}
 
+	result := &productResult{
+		Product:    product,
+		Status:     resultSuccess,
+		Phase:      phaseDone,
+		Started:    before,
+		DurationMs: duration.Milliseconds(),
+		Shard:      *shard,
+		ShardCount: *shardCount,
+	}
+	if err != nil {
+		result.Status = resultFailure
+		result.Phase = reachedPhase(outDir, product, before)
+		result.FailureLog = errOutput
+	}
+	if err := mpctx.Results.save(result); err != nil {
+		mpctx.Logger.Fatalf("Error saving the result of %s: %v", product, err)
+	}
+
 	mpctx.Status.FinishAction(status.ActionResult{
 		Action: action,
 		Error:  err,
@@ -557,19 +643,15 @@ func runSoongUiForProduct(mpctx *mpContext, product string) {
 	})
 }
 
+// failureCount counts the errors that aren't the failure of a product. The
+// failed products are read from their results.
 type failureCount struct {
 	count int
-	fails []string
 }
 
 func (f *failureCount) StartAction(action *status.Action, counts status.Counts) {}
 
-func (f *failureCount) FinishAction(result status.ActionResult, counts status.Counts) {
-	if result.Error != nil {
-		f.count += 1
-		f.fails = append(f.fails, result.Action.Description)
-	}
-}
+func (f *failureCount) FinishAction(result status.ActionResult, counts status.Counts) {}
 
 func (f *failureCount) Message(level status.MsgLevel, message string) {
 	if level >= status.ErrorLvl {
@@ -0,0 +1,261 @@
+// Copyright 2023 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	resultSuccess = "success"
+	resultFailure = "failure"
+
+	// The phases of soong_ui that a product reached, in order.
+	phaseProductConfig = "product_config"
+	phaseSoong         = "soong"
+	phaseKati          = "kati"
+	phaseDone          = "done"
+
+	// resultsDirName is the directory in the output directory that
+	// contains a result file for each product.
+	resultsDirName = "results"
+
+	mergedResultsFile = "results.json"
+	summaryFile       = "summary.txt"
+
+	// summarySlowestProducts is the number of slowest products in the
+	// summary.
+	summarySlowestProducts = 10
+)
+
+// productResult is the result of running soong_ui for a product, stored in
+// <out>/results/<product>.json as soon as the product finishes so that an
+// interrupted run can be resumed.
+type productResult struct {
+	Product string `json:"product"`
+	Status  string `json:"status"`
+
+	// Phase is the last phase of soong_ui that the product reached, or
+	// phaseDone if it succeeded.
+	Phase string `json:"phase"`
+
+	Started    time.Time `json:"started"`
+	DurationMs int64     `json:"duration_ms"`
+
+	// FailureLog is the excerpt of the console output of a failed product.
+	FailureLog string `json:"failure_log,omitempty"`
+
+	Shard      int `json:"shard"`
+	ShardCount int `json:"shard_count"`
+}
+
+func (r *productResult) duration() time.Duration {
+	return time.Duration(r.DurationMs) * time.Millisecond
+}
+
+// mergedResults is the format of results.json.
+type mergedResults struct {
+	Succeeded int              `json:"succeeded"`
+	Failed    int              `json:"failed"`
+	Products  []*productResult `json:"products"`
+}
+
+// resultStore is a directory with a result file for each product.
+type resultStore struct {
+	dir string
+}
+
+func newResultStore(outputDir string) (*resultStore, error) {
+	dir := filepath.Join(outputDir, resultsDirName)
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return nil, err
+	}
+	return &resultStore{dir: dir}, nil
+}
+
+func (s *resultStore) file(product string) string {
+	return filepath.Join(s.dir, product+".json")
+}
+
+// save writes the result of a product. The result is written to a temporary
+// file first, so that an interrupted run never leaves a partial result.
+func (s *resultStore) save(result *productResult) error {
+	data, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := s.file(result.Product) + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0666); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.file(result.Product))
+}
+
+// load returns the results of all products in the store.
+func (s *resultStore) load() (map[string]*productResult, error) {
+	files, err := filepath.Glob(filepath.Join(s.dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make(map[string]*productResult, len(files))
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		result := &productResult{}
+		if err := json.Unmarshal(data, result); err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		ret[result.Product] = result
+	}
+	return ret, nil
+}
+
+// productsToRun filters the products of the run by their previous results.
+// With resume, products that already have a result are skipped. With
+// retryFailed, only products that failed are run again.
+func productsToRun(products []string, previous map[string]*productResult, resume, retryFailed bool) []string {
+	if !resume && !retryFailed {
+		return products
+	}
+
+	var ret []string
+	for _, product := range products {
+		result, ok := previous[product]
+		switch {
+		case ok && result.Status == resultFailure && retryFailed:
+			ret = append(ret, product)
+		case !ok && resume:
+			ret = append(ret, product)
+		}
+	}
+	return ret
+}
+
+// reachedPhase returns the last phase of soong_ui that a product reached,
+// from the files in its out directory that were written since before.
+func reachedPhase(outDir, product string, before time.Time) string {
+	writtenSince := func(file string) bool {
+		info, err := os.Stat(filepath.Join(outDir, file))
+		return err == nil && !info.ModTime().Before(before)
+	}
+
+	switch {
+	case writtenSince("build-" + product + ".ninja"):
+		return phaseKati
+	case writtenSince("soong/build.ninja"):
+		// Soong finished, so soong_ui went on to kati.
+		return phaseKati
+	case writtenSince("soong/soong.variables"):
+		return phaseSoong
+	default:
+		return phaseProductConfig
+	}
+}
+
+// mergeResults merges the results of several runs, like the shards of a run.
+// When a product has several results, the most recent one is used.
+func mergeResults(runs ...map[string]*productResult) *mergedResults {
+	latest := make(map[string]*productResult)
+	for _, run := range runs {
+		for product, result := range run {
+			if prev, ok := latest[product]; !ok || result.Started.After(prev.Started) {
+				latest[product] = result
+			}
+		}
+	}
+
+	ret := &mergedResults{Products: make([]*productResult, 0, len(latest))}
+	for _, result := range latest {
+		ret.Products = append(ret.Products, result)
+		if result.Status == resultSuccess {
+			ret.Succeeded++
+		} else {
+			ret.Failed++
+		}
+	}
+	sort.Slice(ret.Products, func(i, j int) bool {
+		return ret.Products[i].Product < ret.Products[j].Product
+	})
+	return ret
+}
+
+// failedProducts returns the sorted names of the products that failed.
+func (m *mergedResults) failedProducts() []string {
+	var ret []string
+	for _, result := range m.Products {
+		if result.Status != resultSuccess {
+			ret = append(ret, result.Product)
+		}
+	}
+	return ret
+}
+
+// summary returns a human-readable summary of the results.
+func (m *mergedResults) summary() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d products: %d succeeded, %d failed\n", len(m.Products), m.Succeeded, m.Failed)
+
+	if m.Failed > 0 {
+		fmt.Fprintln(&b, "\nFailed products:")
+		for _, result := range m.Products {
+			if result.Status != resultSuccess {
+				fmt.Fprintf(&b, "  %s (failed in %s after %s)\n", result.Product, result.Phase,
+					result.duration().Round(time.Second))
+				if result.FailureLog != "" {
+					for _, line := range strings.Split(strings.TrimRight(result.FailureLog, "\n"), "\n") {
+						fmt.Fprintf(&b, "    %s\n", line)
+					}
+				}
+			}
+		}
+	}
+
+	slowest := append([]*productResult(nil), m.Products...)
+	sort.SliceStable(slowest, func(i, j int) bool {
+		return slowest[i].DurationMs > slowest[j].DurationMs
+	})
+	if len(slowest) > summarySlowestProducts {
+		slowest = slowest[:summarySlowestProducts]
+	}
+	if len(slowest) > 0 {
+		fmt.Fprintln(&b, "\nSlowest products:")
+		for _, result := range slowest {
+			fmt.Fprintf(&b, "  %-40s %s\n", result.Product, result.duration().Round(time.Second))
+		}
+	}
+	return b.String()
+}
+
+// writeResults writes results.json and summary.txt to dir.
+func writeResults(dir string, results *mergedResults) error {
+	data, err := json.MarshalIndent(results, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, mergedResultsFile), append(data, '\n'), 0666); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, summaryFile), []byte(results.summary()), 0666)
+}
@@ -0,0 +1,179 @@
+// Copyright 2023 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProductsToRun(t *testing.T) {
+	products := []string{"a", "b", "c"}
+	previous := map[string]*productResult{
+		"a": {Product: "a", Status: resultSuccess},
+		"b": {Product: "b", Status: resultFailure},
+	}
+
+	testcases := []struct {
+		name        string
+		resume      bool
+		retryFailed bool
+		want        []string
+	}{
+		{
+			name: "all",
+			want: []string{"a", "b", "c"},
+		},
+		{
+			name:   "resume",
+			resume: true,
+			want:   []string{"c"},
+		},
+		{
+			name:        "retry failed",
+			retryFailed: true,
+			want:        []string{"b"},
+		},
+		{
+			name:        "resume and retry failed",
+			resume:      true,
+			retryFailed: true,
+			want:        []string{"b", "c"},
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := productsToRun(products, previous, tc.resume, tc.retryFailed)
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("expected %q, got %q", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestResultStore(t *testing.T) {
+	store, err := newResultStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	started := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+	want := map[string]*productResult{
+		"a": {Product: "a", Status: resultSuccess, Phase: phaseDone, Started: started, DurationMs: 1000},
+		"b": {Product: "b", Status: resultFailure, Phase: phaseKati, Started: started, FailureLog: "error\n",
+			Shard: 2, ShardCount: 3},
+	}
+	for _, result := range want {
+		if err := store.save(result); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := store.load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
+
+func TestReachedPhase(t *testing.T) {
+	outDir := t.TempDir()
+	before := time.Now().Add(-time.Minute)
+
+	write := func(file string, modTime time.Time) {
+		path := filepath.Join(outDir, file)
+		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, nil, 0666); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Files from a previous build don't count.
+	write("soong/soong.variables", before.Add(-time.Hour))
+	if got := reachedPhase(outDir, "a", before); got != phaseProductConfig {
+		t.Errorf("expected %q, got %q", phaseProductConfig, got)
+	}
+
+	write("soong/soong.variables", time.Now())
+	if got := reachedPhase(outDir, "a", before); got != phaseSoong {
+		t.Errorf("expected %q, got %q", phaseSoong, got)
+	}
+
+	write("soong/build.ninja", time.Now())
+	if got := reachedPhase(outDir, "a", before); got != phaseKati {
+		t.Errorf("expected %q, got %q", phaseKati, got)
+	}
+}
+
+func TestMergeResults(t *testing.T) {
+	started := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+	shard1 := map[string]*productResult{
+		"b": {Product: "b", Status: resultFailure, Phase: phaseSoong, Started: started, DurationMs: 2000,
+			FailureLog: "FAILED: out/soong/build.ninja\nerror: bad module\n"},
+		"c": {Product: "c", Status: resultSuccess, Phase: phaseDone, Started: started, DurationMs: 5000},
+	}
+	shard2 := map[string]*productResult{
+		"a": {Product: "a", Status: resultSuccess, Phase: phaseDone, Started: started, DurationMs: 3000},
+		// A retry of c that failed after the first result.
+		"c": {Product: "c", Status: resultFailure, Phase: phaseKati, Started: started.Add(time.Hour), DurationMs: 4000},
+	}
+
+	merged := mergeResults(shard1, shard2)
+	if merged.Succeeded != 1 || merged.Failed != 2 {
+		t.Errorf("expected 1 success and 2 failures, got %d and %d", merged.Succeeded, merged.Failed)
+	}
+	var products []string
+	for _, result := range merged.Products {
+		products = append(products, result.Product)
+	}
+	if want := []string{"a", "b", "c"}; !reflect.DeepEqual(products, want) {
+		t.Errorf("expected products %q, got %q", want, products)
+	}
+	if want := []string{"b", "c"}; !reflect.DeepEqual(merged.failedProducts(), want) {
+		t.Errorf("expected failed products %q, got %q", want, merged.failedProducts())
+	}
+
+	want := strings.Join([]string{
+		"3 products: 1 succeeded, 2 failed",
+		"",
+		"Failed products:",
+		"  b (failed in soong after 2s)",
+		"    FAILED: out/soong/build.ninja",
+		"    error: bad module",
+		"  c (failed in kati after 4s)",
+		"",
+		"Slowest products:",
+		"  c                                        4s",
+		"  a                                        3s",
+		"  b                                        2s",
+		"",
+	}, "\n")
+	if got := merged.summary(); got != want {
+		t.Errorf("expected summary:\n%s\ngot:\n%s", want, got)
+	}
+}
@@ -41,6 +41,7 @@ import (
 	"path/filepath"
 	"strconv"
 	"syscall"
+	"time"
 
 	"android/soong/ui/build/paths"
 )
@@ -70,9 +71,18 @@ func main() {
 		os.Exit(1)
 	}
 
+	// soong_ui writes the tools whose configuration is different from the
+	// embedded one next to the interposer.
+	config := func(name string) paths.PathConfig {
+		if config, ok, err := paths.LoadOverride(interposer+"_config", name); err == nil && ok {
+			return config
+		}
+		return paths.GetConfig(name)
+	}
+
 	exitCode, err := Main(os.Stdout, os.Stderr, interposer, os.Args, mainOpts{
 		sendLog:       paths.SendLog,
-		config:        paths.GetConfig,
+		config:        config,
 		lookupParents: lookupParents,
 	})
 	if err != nil {
@@ -85,8 +95,12 @@ var usage = fmt.Errorf(`To use the PATH interposer:
  * Write the original PATH variable to <interposer>_origpath
  * Set up a directory of symlinks to the PATH interposer, and use that in PATH
 
-If a tool isn't in the allowed list, a log will be posted to the unix domain
-socket at <interposer>_log.`)
+Every invocation is posted to the unix domain socket at <interposer>_log, along
+with the process tree if the tool isn't in the allowed list.`)
+
+// allowedLogTimeout is how long to wait for the log of an allowed tool to be
+// sent after the tool exits.
+const allowedLogTimeout = 10 * time.Millisecond
 
 type mainOpts struct {
 	sendLog       func(logSocket string, entry *paths.LogEntry, done chan interface{})
@@ -124,24 +138,39 @@ func Main(stdout, stderr io.Writer, interposer string, args []string, opts mainO
 		return 1, fmt.Errorf("Failed to set PATH env: %v", err)
 	}
 
-	if config := opts.config(base); config.Log || config.Error {
-		var procs []paths.LogProcess
-		if opts.lookupParents != nil {
-			procs = opts.lookupParents()
-		}
+	config := opts.config(base)
 
-		if opts.sendLog != nil {
-			waitForLog := make(chan interface{})
-			opts.sendLog(interposer+"_log", &paths.LogEntry{
-				Basename: base,
-				Args:     args,
-				Parents:  procs,
-			}, waitForLog)
+	// Looking up the process tree is expensive, only do it for the tools
+	// that are logged.
+	var procs []paths.LogProcess
+	if (config.Log || config.Error) && opts.lookupParents != nil {
+		procs = opts.lookupParents()
+	}
+
+	// The log is sent while the tool runs. soong_ui reports the invocations
+	// of logged and forbidden tools, so wait for their log to be sent. The
+	// invocations of allowed tools are only counted, their log is dropped
+	// if it can't be sent shortly after the tool exits.
+	if opts.sendLog != nil {
+		waitForLog := make(chan interface{})
+		go opts.sendLog(interposer+"_log", &paths.LogEntry{
+			Basename: base,
+			Args:     args,
+			Parents:  procs,
+		}, waitForLog)
+		if config.Log || config.Error {
 			defer func() { <-waitForLog }()
+		} else {
+			defer func() {
+				select {
+				case <-waitForLog:
+				case <-time.After(allowedLogTimeout):
+				}
+			}()
 		}
-		if config.Error {
-			return 1, fmt.Errorf("%q is not allowed to be used. See https://android.googlesource.com/platform/build/+/master/Changes.md#PATH_Tools for more information.", base)
-		}
+	}
+	if config.Error {
+		return 1, fmt.Errorf("%q is not allowed to be used. See https://android.googlesource.com/platform/build/+/master/Changes.md#PATH_Tools for more information.", base)
 	}
 
 	cmd.Path, err = exec.LookPath(base)
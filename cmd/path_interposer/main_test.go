@@ -20,6 +20,7 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"android/soong/ui/build/paths"
 )
@@ -106,10 +107,14 @@ func TestInterposer(t *testing.T) {
 		{
 			name: "true",
 			args: []string{"/my/path/true"},
+
+			logEntry: "true",
 		},
 		{
 			name: "relative true",
 			args: []string{"true"},
+
+			logEntry: "true",
 		},
 		{
 			name: "exit code",
@@ -179,6 +184,43 @@ func TestInterposer(t *testing.T) {
 	}
 }
 
+func TestInterposerDoesNotWaitForAllowedLog(t *testing.T) {
+	interposer := setup(t)
+
+	// The log of an allowed tool is never sent, the tool still exits.
+	blocked := make(chan struct{})
+	defer close(blocked)
+	exitCode, err := Main(ioutil.Discard, ioutil.Discard, interposer, []string{"true"}, mainOpts{
+		sendLog: func(logSocket string, entry *paths.LogEntry, done chan interface{}) {
+			<-blocked
+			close(done)
+		},
+		config: func(name string) paths.PathConfig { return paths.Allowed },
+	})
+	if exitCode != 0 || err != nil {
+		t.Errorf("expected the allowed tool to succeed, got %d, %v", exitCode, err)
+	}
+
+	// The log of a logged tool is always waited for.
+	logged := make(chan struct{})
+	exitCode, err = Main(ioutil.Discard, ioutil.Discard, interposer, []string{"true"}, mainOpts{
+		sendLog: func(logSocket string, entry *paths.LogEntry, done chan interface{}) {
+			time.Sleep(2 * allowedLogTimeout)
+			close(logged)
+			close(done)
+		},
+		config: func(name string) paths.PathConfig { return paths.Log },
+	})
+	if exitCode != 0 || err != nil {
+		t.Errorf("expected the logged tool to succeed, got %d, %v", exitCode, err)
+	}
+	select {
+	case <-logged:
+	default:
+		t.Errorf("expected the log of a logged tool to be sent before exiting")
+	}
+}
+
 func TestMissingPath(t *testing.T) {
 	interposer := setup(t)
 	err := os.Remove(interposer + "_origpath")
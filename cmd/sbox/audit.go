@@ -0,0 +1,256 @@
+// Copyright 2023 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"android/soong/cmd/sbox/sbox_proto"
+	"android/soong/filetrace"
+	"android/soong/makedeps"
+	"android/soong/response"
+)
+
+// When the manifest has an audit message the commands are traced, and the files in the source
+// or output directories that the commands read or wrote without declaring them are appended to
+// a report.  This is used to find the rules that would break if their inputs were sandboxed.
+
+// auditReport is a line of the audit report.
+type auditReport struct {
+	Module           string   `json:"module"`
+	Manifest         string   `json:"manifest"`
+	SandboxedInputs  bool     `json:"sandboxed_inputs"`
+	UndeclaredReads  []string `json:"undeclared_reads"`
+	UndeclaredWrites []string `json:"undeclared_writes"`
+}
+
+type sboxAudit struct {
+	audit    *sbox_proto.Audit
+	manifest string
+
+	// topDirs are the directory that sbox was started in and the same directory with symlinks
+	// resolved.  Files outside of it aren't audited.
+	topDirs []string
+
+	// declaredReads and declaredWrites are the declared files relative to the top directory.
+	declaredReads  map[string]bool
+	declaredWrites map[string]bool
+
+	undeclaredReads  map[string]bool
+	undeclaredWrites map[string]bool
+}
+
+// newSboxAudit returns an audit of the commands in a manifest.  topDir is the directory that sbox
+// was started in.
+func newSboxAudit(manifest *sbox_proto.Manifest, manifestFile, topDir string) *sboxAudit {
+	topDirs := []string{topDir}
+	if realTopDir, err := filepath.EvalSymlinks(topDir); err == nil && realTopDir != topDir {
+		topDirs = append(topDirs, realTopDir)
+	}
+
+	a := &sboxAudit{
+		audit:            manifest.Audit,
+		manifest:         manifestFile,
+		topDirs:          topDirs,
+		declaredReads:    make(map[string]bool),
+		declaredWrites:   make(map[string]bool),
+		undeclaredReads:  make(map[string]bool),
+		undeclaredWrites: make(map[string]bool),
+	}
+
+	a.declareReads(manifest.Audit.Inputs...)
+	a.declareWrites(manifest.GetOutputDepfile(), manifest.Audit.GetReport())
+	for _, command := range manifest.Commands {
+		for _, copyPair := range command.CopyBefore {
+			a.declareReads(copyPair.GetFrom())
+		}
+		for _, copyPair := range command.CopyAfter {
+			a.declareWrites(copyPair.GetTo())
+		}
+	}
+
+	return a
+}
+
+func (a *sboxAudit) declareReads(paths ...string) {
+	for _, path := range paths {
+		if path != "" {
+			a.declaredReads[filepath.Clean(path)] = true
+		}
+	}
+}
+
+func (a *sboxAudit) declareWrites(paths ...string) {
+	for _, path := range paths {
+		if path != "" {
+			a.declaredWrites[filepath.Clean(path)] = true
+		}
+	}
+}
+
+// runCommand runs a command from the manifest and records the files that it accessed that were
+// not declared.  tempDir is the sandbox directory of the command, files in it are not audited.
+func (a *sboxAudit) runCommand(cmd *exec.Cmd, command *sbox_proto.Command, tempDir, depFile string) error {
+	accesses, err := filetrace.Trace(cmd, filetrace.Succeeded)
+	if err == filetrace.ErrUnsupported || err == filetrace.ErrAlreadyTraced {
+		fmt.Fprintf(os.Stderr, "sbox: %s, not auditing %s\n", err, a.manifest)
+		return cmd.Run()
+	}
+
+	// The files listed in rsp files are declared inputs.
+	for _, rspFile := range command.RspFiles {
+		a.declareReads(rspFile.GetFile())
+		if files, err := readRspFile(rspFile.GetFile()); err == nil {
+			a.declareReads(files...)
+		}
+	}
+
+	// The files listed in the depfile are tracked by ninja.
+	if depFile != "" {
+		if data, err := ioutil.ReadFile(depFile); err == nil {
+			if deps, err := makedeps.Parse(depFile, bytes.NewBuffer(data)); err == nil {
+				dir := ""
+				if command.GetChdir() {
+					dir = tempDir
+				}
+				for _, dep := range deps.Inputs {
+					if rel, ok := a.relToTop(joinPath(dir, dep), tempDir); ok {
+						a.declareReads(rel)
+					}
+				}
+			}
+		}
+	}
+
+	a.record(accesses, tempDir)
+	return err
+}
+
+func readRspFile(file string) ([]string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return response.ReadRspFile(f)
+}
+
+// record adds the undeclared file accesses of a command to the audit.
+func (a *sboxAudit) record(accesses []filetrace.Access, tempDir string) {
+	for _, access := range accesses {
+		rel, ok := a.relToTop(access.Path, tempDir)
+		if !ok {
+			continue
+		}
+		if access.Write {
+			if !a.declaredWrites[rel] {
+				a.undeclaredWrites[rel] = true
+			}
+		} else if !a.declaredReads[rel] && !a.declaredWrites[rel] {
+			if info, err := os.Stat(access.Path); err == nil && info.IsDir() {
+				continue
+			}
+			a.undeclaredReads[rel] = true
+		}
+	}
+}
+
+// relToTop returns the path of a file relative to the top directory, or false if it is not in
+// the top directory or it is in the sandbox directory of a command.
+func (a *sboxAudit) relToTop(path, tempDir string) (string, bool) {
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(a.topDirs[0], path)
+	}
+	if tempDir != "" {
+		if absTempDir, err := filepath.Abs(tempDir); err == nil && isUnder(path, absTempDir) {
+			return "", false
+		}
+		if realTempDir, err := filepath.EvalSymlinks(tempDir); err == nil && isUnder(path, realTempDir) {
+			return "", false
+		}
+	}
+	for _, topDir := range a.topDirs {
+		if isUnder(path, topDir) && path != topDir {
+			rel, err := filepath.Rel(topDir, path)
+			if err == nil {
+				return rel, true
+			}
+		}
+	}
+	return "", false
+}
+
+// isUnder returns true if path is dir or a path in dir.
+func isUnder(path, dir string) bool {
+	return path == dir || strings.HasPrefix(path, dir+string(filepath.Separator))
+}
+
+// report returns the line to add to the audit report, or nil if all the accesses were declared.
+func (a *sboxAudit) report() *auditReport {
+	if len(a.undeclaredReads) == 0 && len(a.undeclaredWrites) == 0 {
+		return nil
+	}
+	sortedKeys := func(m map[string]bool) []string {
+		ret := make([]string, 0, len(m))
+		for k := range m {
+			ret = append(ret, k)
+		}
+		sort.Strings(ret)
+		return ret
+	}
+	return &auditReport{
+		Module:           a.audit.GetModule(),
+		Manifest:         a.manifest,
+		SandboxedInputs:  a.audit.GetSandboxedInputs(),
+		UndeclaredReads:  sortedKeys(a.undeclaredReads),
+		UndeclaredWrites: sortedKeys(a.undeclaredWrites),
+	}
+}
+
+// writeReport appends the undeclared file accesses to the audit report.  Many sbox processes
+// append to the same report concurrently, so the line is written with a single write call to a
+// file opened with O_APPEND.
+func (a *sboxAudit) writeReport() error {
+	report := a.report()
+	if report == nil || a.audit.GetReport() == "" {
+		return nil
+	}
+	line, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	if err := os.MkdirAll(filepath.Dir(a.audit.GetReport()), 0777); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(a.audit.GetReport(), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0666)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(line); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
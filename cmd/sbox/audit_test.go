@@ -0,0 +1,109 @@
+// Copyright 2023 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"android/soong/cmd/sbox/sbox_proto"
+	"android/soong/filetrace"
+
+	"google.golang.org/protobuf/proto"
+)
+
+func TestAuditRecord(t *testing.T) {
+	topDir := t.TempDir()
+	tempDir := filepath.Join(topDir, "out/soong/.temp/sbox/1234")
+	if err := os.MkdirAll(filepath.Join(topDir, "frameworks/base"), 0777); err != nil {
+		t.Fatal(err)
+	}
+
+	manifest := &sbox_proto.Manifest{
+		Commands: []*sbox_proto.Command{
+			{
+				CopyBefore: []*sbox_proto.Copy{
+					{From: proto.String("out/host/bin/tool"), To: proto.String("tools/out/bin/tool")},
+				},
+				CopyAfter: []*sbox_proto.Copy{
+					{From: proto.String("out/gen/foo.h"), To: proto.String("out/soong/.intermediates/foo/gen/foo.h")},
+				},
+			},
+		},
+		OutputDepfile: proto.String("out/soong/.intermediates/foo/gen/foo.d"),
+		Audit: &sbox_proto.Audit{
+			Module: proto.String("//frameworks/base:foo"),
+			Inputs: []string{"frameworks/base/foo.txt", "./frameworks/base/bar.txt"},
+			Report: proto.String("out/soong/sbox_audit.jsonl"),
+		},
+	}
+	a := newSboxAudit(manifest, "out/soong/.intermediates/foo/genrule.sbox.textproto", topDir)
+
+	abs := func(path string) string { return filepath.Join(topDir, path) }
+	a.record([]filetrace.Access{
+		// Declared inputs.
+		{Path: abs("frameworks/base/foo.txt")},
+		{Path: abs("frameworks/base/bar.txt")},
+		{Path: abs("out/host/bin/tool")},
+		// Files in the sandbox and outside of the top directory.
+		{Path: filepath.Join(tempDir, "out/gen/foo.h"), Write: true},
+		{Path: filepath.Join(tempDir, "tools/out/bin/tool")},
+		{Path: "/usr/lib/libc.so.6"},
+		// Directories.
+		{Path: abs("frameworks/base")},
+		// Undeclared accesses.
+		{Path: abs("frameworks/base/baz.txt")},
+		{Path: abs("frameworks/base/baz.txt")},
+		{Path: abs("out/soong/.intermediates/bar/bar.h")},
+		{Path: abs("frameworks/base/foo.txt"), Write: true},
+		// Declared outputs.
+		{Path: abs("out/soong/.intermediates/foo/gen/foo.h"), Write: true},
+		{Path: abs("out/soong/.intermediates/foo/gen/foo.d"), Write: true},
+	}, tempDir)
+
+	want := &auditReport{
+		Module:           "//frameworks/base:foo",
+		Manifest:         "out/soong/.intermediates/foo/genrule.sbox.textproto",
+		UndeclaredReads:  []string{"frameworks/base/baz.txt", "out/soong/.intermediates/bar/bar.h"},
+		UndeclaredWrites: []string{"frameworks/base/foo.txt"},
+	}
+	if got := a.report(); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected report:\n%#v\ngot:\n%#v", want, got)
+	}
+}
+
+func TestAuditNoReport(t *testing.T) {
+	topDir := t.TempDir()
+	manifest := &sbox_proto.Manifest{
+		Audit: &sbox_proto.Audit{
+			Inputs: []string{"foo.txt"},
+			Report: proto.String(filepath.Join(topDir, "sbox_audit.jsonl")),
+		},
+	}
+	a := newSboxAudit(manifest, "sbox.textproto", topDir)
+	a.record([]filetrace.Access{{Path: filepath.Join(topDir, "foo.txt")}}, "")
+
+	if got := a.report(); got != nil {
+		t.Errorf("expected no report, got %#v", got)
+	}
+	if err := a.writeReport(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(topDir, "sbox_audit.jsonl")); !os.IsNotExist(err) {
+		t.Errorf("expected no report file, got %v", err)
+	}
+}
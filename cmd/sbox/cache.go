@@ -0,0 +1,510 @@
+// Copyright 2023 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"android/soong/cmd/sbox/sbox_proto"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// When the manifest has a cache message the outputs of the commands are stored in a local
+// action cache after they run, and restored from it instead of running the commands when an
+// action with the same commands, input contents and environment runs again, for example after
+// switching back to a branch that was built before.  The key covers the declared inputs and tools
+// and the host toolchain, the files in $JAVA_HOME and the executables in $PATH, but not the other
+// files that the commands read without declaring them.  The contents of the toolchain are hashed
+// once for each fingerprint of the paths, sizes and modification times of its files, and the
+// digest is kept in the toolchains directory of the cache.
+//
+// Each entry is a directory named after the cache key that contains the outputs of the commands
+// in the order of the copy_after rules, the output depfile and the output of the commands.  The
+// modification time of an entry is updated when it is restored, and the least recently used
+// entries are removed when the total size of the entries is over the maximum size.
+
+const (
+	cacheVersion = "sbox action cache 2"
+
+	cacheLogFile     = "log"
+	cacheDepFile     = "depfile"
+	cacheSizeFile    = "size"
+	cacheLockFile    = "lock"
+	cacheTempPrefix  = "tmp-"
+	cacheToolchains  = "toolchains"
+	cacheTempMaxAge  = time.Hour
+	cacheKeyDirChars = 2
+)
+
+// cacheKeyEnv are the environment variables that may change the outputs of the commands.  The
+// other variables passed by soong_ui to ninja, like RBE_invocation_id, change between builds
+// and don't change the outputs.
+var cacheKeyEnv = []string{
+	"ASAN_OPTIONS",
+	"EMMA_INSTRUMENT_FRAMEWORK",
+	"JAVA_HOME",
+	"LANG",
+	"LC_MESSAGES",
+	"OUT_DIR",
+	"PATH",
+	"TARGET_BUILD_APPS",
+	"TARGET_BUILD_VARIANT",
+	"TARGET_PRODUCT",
+}
+
+type actionCache struct {
+	dir     string
+	maxSize int64
+	inputs  []string
+}
+
+func newActionCache(cache *sbox_proto.Cache) *actionCache {
+	return &actionCache{
+		dir:     cache.GetDir(),
+		maxSize: cache.GetMaxSize(),
+		inputs:  cache.Inputs,
+	}
+}
+
+// writeKeyField adds a named value to the cache key.
+func writeKeyField(h hash.Hash, name string, value []byte) {
+	fmt.Fprintf(h, "%s %d\n", name, len(value))
+	h.Write(value)
+}
+
+// key returns the cache key of a manifest.  topDir is the directory that sbox was started in.
+func (c *actionCache) key(manifest *sbox_proto.Manifest, topDir string) (string, error) {
+	h := sha256.New()
+	writeKeyField(h, "version", []byte(cacheVersion))
+	writeKeyField(h, "top", []byte(topDir))
+
+	// The cache and audit messages don't change the outputs.
+	m := proto.Clone(manifest).(*sbox_proto.Manifest)
+	m.Cache = nil
+	m.Audit = nil
+	data, err := proto.MarshalOptions{Deterministic: true}.Marshal(m)
+	if err != nil {
+		return "", err
+	}
+	writeKeyField(h, "manifest", data)
+
+	for _, name := range cacheKeyEnv {
+		if value, ok := os.LookupEnv(name); ok {
+			writeKeyField(h, "env "+name, []byte(value))
+		}
+	}
+
+	toolchain, err := c.toolchainDigest()
+	if err != nil {
+		return "", err
+	}
+	writeKeyField(h, "toolchain", []byte(toolchain))
+
+	inputs, err := c.declaredInputs(manifest)
+	if err != nil {
+		return "", err
+	}
+	for _, input := range inputs {
+		sum, err := hashFile(input)
+		if err != nil {
+			return "", err
+		}
+		writeKeyField(h, "input "+input, sum)
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// declaredInputs returns the sorted list of inputs of the commands in a manifest.
+func (c *actionCache) declaredInputs(manifest *sbox_proto.Manifest) ([]string, error) {
+	set := make(map[string]bool)
+	for _, input := range c.inputs {
+		set[filepath.Clean(input)] = true
+	}
+	for _, command := range manifest.Commands {
+		for _, copyPair := range command.CopyBefore {
+			set[filepath.Clean(copyPair.GetFrom())] = true
+		}
+		for _, rspFile := range command.RspFiles {
+			set[filepath.Clean(rspFile.GetFile())] = true
+			files, err := readRspFile(rspFile.GetFile())
+			if err != nil {
+				return nil, err
+			}
+			for _, file := range files {
+				set[filepath.Clean(file)] = true
+			}
+		}
+	}
+
+	inputs := make([]string, 0, len(set))
+	for input := range set {
+		inputs = append(inputs, input)
+	}
+	sort.Strings(inputs)
+	return inputs, nil
+}
+
+type toolchainFile struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+// toolchainFiles returns the files of the host toolchain that the commands can use without
+// declaring them: the files in $JAVA_HOME, and the executables in the directories in $PATH.
+func toolchainFiles() ([]toolchainFile, error) {
+	seen := make(map[string]bool)
+	var files []toolchainFile
+	add := func(path string) {
+		// Follow symlinks, like the executables in $PATH that point to the path interposer.
+		info, err := os.Stat(path)
+		if err != nil || !info.Mode().IsRegular() || seen[path] {
+			return
+		}
+		seen[path] = true
+		files = append(files, toolchainFile{path, info.Size(), info.ModTime()})
+	}
+
+	if javaHome := os.Getenv("JAVA_HOME"); javaHome != "" {
+		err := filepath.Walk(javaHome, func(path string, info os.FileInfo, err error) error {
+			if os.IsNotExist(err) && path == javaHome {
+				return filepath.SkipDir
+			} else if err != nil {
+				return err
+			}
+			add(path)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, dir := range filepath.SplitList(os.Getenv("PATH")) {
+		if dir == "" {
+			continue
+		}
+		entries, err := ioutil.ReadDir(dir)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			add(filepath.Join(dir, entry.Name()))
+		}
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i].path < files[j].path })
+	return files, nil
+}
+
+// toolchainDigest returns the hash of the contents of the host toolchain.  It is only computed
+// when the fingerprint of the toolchain files isn't in the cache yet.
+func (c *actionCache) toolchainDigest() (string, error) {
+	files, err := toolchainFiles()
+	if err != nil {
+		return "", err
+	}
+
+	fingerprint := sha256.New()
+	for _, file := range files {
+		fmt.Fprintf(fingerprint, "%s %d %d\n", file.path, file.size, file.modTime.UnixNano())
+	}
+	digestFile := filepath.Join(c.dir, cacheToolchains, hex.EncodeToString(fingerprint.Sum(nil)))
+	if data, err := ioutil.ReadFile(digestFile); err == nil {
+		return strings.TrimSpace(string(data)), nil
+	}
+
+	h := sha256.New()
+	for _, file := range files {
+		sum, err := hashFile(file.path)
+		if err != nil {
+			return "", err
+		}
+		writeKeyField(h, "file "+file.path, sum)
+	}
+	digest := hex.EncodeToString(h.Sum(nil))
+
+	// Keeping the digest is only an optimization, write it to a temporary file and rename it
+	// so that concurrent sbox processes never see a partial digest, and ignore errors.
+	if err := os.MkdirAll(filepath.Dir(digestFile), 0777); err == nil {
+		if f, err := ioutil.TempFile(filepath.Dir(digestFile), cacheTempPrefix); err == nil {
+			_, err := f.WriteString(digest + "\n")
+			if closeErr := f.Close(); err == nil && closeErr == nil {
+				err = os.Rename(f.Name(), digestFile)
+			}
+			if err != nil {
+				os.Remove(f.Name())
+			}
+		}
+	}
+
+	return digest, nil
+}
+
+func hashFile(file string) ([]byte, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, fmt.Errorf("error reading %q: %w", file, err)
+	}
+	return h.Sum(nil), nil
+}
+
+// cachedOutputs returns the copy rules of the outputs of the commands in a manifest, the outputs
+// are stored in a cache entry in this order.
+func cachedOutputs(manifest *sbox_proto.Manifest) []*sbox_proto.Copy {
+	var copies []*sbox_proto.Copy
+	for _, command := range manifest.Commands {
+		copies = append(copies, command.CopyAfter...)
+	}
+	return copies
+}
+
+func (c *actionCache) entryDir(key string) string {
+	return filepath.Join(c.dir, key[:cacheKeyDirChars], key)
+}
+
+// restore copies the outputs of the commands in a manifest out of the cache entry with the given
+// key and writes the output of the commands to stdout.  It returns false if there is no entry.
+func (c *actionCache) restore(key string, manifest *sbox_proto.Manifest, stdout io.Writer) (bool, error) {
+	entry := c.entryDir(key)
+	if _, err := os.Stat(entry); os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	copies := cachedOutputs(manifest)
+	if err := clearOutputDirectory(copies, outputDir, writeType(writeIfChanged)); err != nil {
+		return false, err
+	}
+	for i, copyPair := range copies {
+		err := copyOneFile(filepath.Join(entry, strconv.Itoa(i)), copyPair.GetTo(), false,
+			requireFromExists, writeType(writeIfChanged))
+		if err != nil {
+			return false, err
+		}
+	}
+	if depFile := manifest.GetOutputDepfile(); depFile != "" {
+		err := copyOneFile(filepath.Join(entry, cacheDepFile), depFile, false, requireFromExists, alwaysWrite)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	log, err := ioutil.ReadFile(filepath.Join(entry, cacheLogFile))
+	if err != nil {
+		return false, err
+	}
+	stdout.Write(log)
+
+	// Mark the entry as recently used.
+	now := time.Now()
+	os.Chtimes(entry, now, now)
+
+	return true, nil
+}
+
+// store adds the outputs of the commands in a manifest, which must have run successfully, and
+// their output to the cache.
+func (c *actionCache) store(key string, manifest *sbox_proto.Manifest, log []byte) error {
+	if err := os.MkdirAll(c.dir, 0777); err != nil {
+		return err
+	}
+
+	// Write the entry to a temporary directory and rename it, so that concurrent sbox processes
+	// never see a partial entry.
+	tempDir, err := ioutil.TempDir(c.dir, cacheTempPrefix)
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tempDir)
+
+	for i, copyPair := range cachedOutputs(manifest) {
+		err := copyOneFile(copyPair.GetTo(), filepath.Join(tempDir, strconv.Itoa(i)), false,
+			requireFromExists, alwaysWrite)
+		if err != nil {
+			return err
+		}
+	}
+	if depFile := manifest.GetOutputDepfile(); depFile != "" {
+		err := copyOneFile(depFile, filepath.Join(tempDir, cacheDepFile), false, requireFromExists, alwaysWrite)
+		if err != nil {
+			return err
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(tempDir, cacheLogFile), log, 0666); err != nil {
+		return err
+	}
+
+	size, err := dirSize(tempDir)
+	if err != nil {
+		return err
+	}
+
+	entry := c.entryDir(key)
+	if err := os.MkdirAll(filepath.Dir(entry), 0777); err != nil {
+		return err
+	}
+	if err := os.Rename(tempDir, entry); err != nil {
+		if _, statErr := os.Stat(entry); statErr == nil {
+			// Another sbox process stored the same entry.
+			return nil
+		}
+		return err
+	}
+
+	return c.addSize(size)
+}
+
+// lock takes an exclusive lock on the cache and returns a function that releases it.
+func (c *actionCache) lock() (func(), error) {
+	f, err := os.OpenFile(filepath.Join(c.dir, cacheLockFile), os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return func() {
+		syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		f.Close()
+	}, nil
+}
+
+// addSize adds the size of a new entry to the total size of the cache, and trims the cache if
+// it is over the maximum size.
+func (c *actionCache) addSize(size int64) error {
+	unlock, err := c.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	sizeFile := filepath.Join(c.dir, cacheSizeFile)
+	total := size
+	if data, err := ioutil.ReadFile(sizeFile); err == nil {
+		if n, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64); err == nil {
+			total += n
+		}
+	}
+
+	if c.maxSize > 0 && total > c.maxSize {
+		if total, err = c.trim(); err != nil {
+			return err
+		}
+	}
+
+	return ioutil.WriteFile(sizeFile, []byte(strconv.FormatInt(total, 10)+"\n"), 0666)
+}
+
+type cacheEntry struct {
+	path    string
+	size    int64
+	lastUse time.Time
+}
+
+// trim removes the least recently used entries until the cache is at most 90% of the maximum
+// size, so that it isn't trimmed again on the next store, and returns the new total size.  It
+// also removes temporary directories left behind by sbox processes that were killed.  The cache
+// must be locked.
+func (c *actionCache) trim() (int64, error) {
+	var entries []cacheEntry
+	var total int64
+
+	dirs, err := ioutil.ReadDir(c.dir)
+	if err != nil {
+		return 0, err
+	}
+	for _, dir := range dirs {
+		path := filepath.Join(c.dir, dir.Name())
+		if strings.HasPrefix(dir.Name(), cacheTempPrefix) {
+			if time.Since(dir.ModTime()) > cacheTempMaxAge {
+				os.RemoveAll(path)
+			}
+			continue
+		}
+		if !dir.IsDir() || len(dir.Name()) != cacheKeyDirChars {
+			continue
+		}
+		keys, err := ioutil.ReadDir(path)
+		if err != nil {
+			return 0, err
+		}
+		for _, key := range keys {
+			entry := filepath.Join(path, key.Name())
+			size, err := dirSize(entry)
+			if err != nil {
+				return 0, err
+			}
+			entries = append(entries, cacheEntry{entry, size, key.ModTime()})
+			total += size
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].lastUse.Before(entries[j].lastUse)
+	})
+
+	target := c.maxSize / 10 * 9
+	for _, entry := range entries {
+		if total <= target {
+			break
+		}
+		if err := os.RemoveAll(entry.path); err != nil {
+			return 0, err
+		}
+		total -= entry.size
+	}
+
+	return total, nil
+}
+
+// dirSize returns the total size of the files in a directory.
+func dirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
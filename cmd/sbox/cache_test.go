@@ -0,0 +1,261 @@
+// Copyright 2023 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"android/soong/cmd/sbox/sbox_proto"
+
+	"google.golang.org/protobuf/proto"
+)
+
+func writeTestFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0666); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readTestFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// setTestToolchain points $JAVA_HOME and $PATH at a small toolchain in dir.
+func setTestToolchain(t *testing.T, dir string) (javaHome, bin string) {
+	t.Helper()
+	javaHome = filepath.Join(dir, "jdk")
+	bin = filepath.Join(dir, "bin")
+	writeTestFile(t, filepath.Join(javaHome, "lib/modules"), "modules")
+	writeTestFile(t, filepath.Join(bin, "python3"), "python3")
+	t.Setenv("JAVA_HOME", javaHome)
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+filepath.Join(dir, "missing"))
+	return javaHome, bin
+}
+
+func TestActionCacheKey(t *testing.T) {
+	dir := t.TempDir()
+	setTestToolchain(t, dir)
+	in := filepath.Join(dir, "in")
+	tool := filepath.Join(dir, "tool")
+	writeTestFile(t, in, "foo")
+	writeTestFile(t, tool, "tool")
+
+	manifest := &sbox_proto.Manifest{
+		Commands: []*sbox_proto.Command{
+			{
+				Command:    proto.String("tools/tool in > out/gen"),
+				CopyBefore: []*sbox_proto.Copy{{From: proto.String(tool), To: proto.String("tools/tool")}},
+			},
+		},
+		Cache: &sbox_proto.Cache{
+			Dir:    proto.String(filepath.Join(dir, "cache")),
+			Inputs: []string{in},
+		},
+	}
+	cache := newActionCache(manifest.Cache)
+
+	key := func() string {
+		t.Helper()
+		key, err := cache.key(manifest, dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return key
+	}
+
+	orig := key()
+	if key() != orig {
+		t.Errorf("expected the same key for the same manifest")
+	}
+
+	manifest.Cache.MaxSize = proto.Int64(1000)
+	manifest.Audit = &sbox_proto.Audit{Module: proto.String("//:foo")}
+	if key() != orig {
+		t.Errorf("expected the cache and audit messages not to change the key")
+	}
+
+	writeTestFile(t, in, "bar")
+	changedInput := key()
+	if changedInput == orig {
+		t.Errorf("expected a different key when an input changes")
+	}
+
+	writeTestFile(t, tool, "tool2")
+	if k := key(); k == orig || k == changedInput {
+		t.Errorf("expected a different key when a tool changes")
+	}
+
+	writeTestFile(t, tool, "tool")
+	writeTestFile(t, in, "foo")
+	t.Setenv("TARGET_PRODUCT", "other")
+	if key() == orig {
+		t.Errorf("expected a different key when the environment changes")
+	}
+}
+
+func TestActionCacheToolchain(t *testing.T) {
+	dir := t.TempDir()
+	javaHome, bin := setTestToolchain(t, dir)
+	cache := newActionCache(&sbox_proto.Cache{Dir: proto.String(filepath.Join(dir, "cache"))})
+	manifest := &sbox_proto.Manifest{
+		Commands: []*sbox_proto.Command{{Command: proto.String("java -version")}},
+	}
+
+	key := func() string {
+		t.Helper()
+		key, err := cache.key(manifest, dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return key
+	}
+
+	orig := key()
+
+	// Touching the toolchain, like a checkout of another branch does, keeps the key.
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(filepath.Join(javaHome, "lib/modules"), later, later); err != nil {
+		t.Fatal(err)
+	}
+	if key() != orig {
+		t.Errorf("expected the same key when the toolchain files are touched")
+	}
+
+	writeTestFile(t, filepath.Join(javaHome, "lib/modules"), "other modules")
+	changedJdk := key()
+	if changedJdk == orig {
+		t.Errorf("expected a different key when a file in JAVA_HOME changes")
+	}
+
+	writeTestFile(t, filepath.Join(bin, "python3"), "other python3")
+	if k := key(); k == orig || k == changedJdk {
+		t.Errorf("expected a different key when an executable in PATH changes")
+	}
+}
+
+func TestActionCacheStoreRestore(t *testing.T) {
+	dir := t.TempDir()
+	setTestToolchain(t, dir)
+	outputDir = filepath.Join(dir, "out/gen")
+	writeIfChanged = false
+	out := filepath.Join(outputDir, "out")
+	depFile := filepath.Join(dir, "out/gen.d")
+
+	manifest := &sbox_proto.Manifest{
+		Commands: []*sbox_proto.Command{
+			{
+				Command:   proto.String("echo foo > __SBOX_SANDBOX_DIR__/out/out"),
+				CopyAfter: []*sbox_proto.Copy{{From: proto.String("out/out"), To: proto.String(out)}},
+			},
+		},
+		OutputDepfile: proto.String(depFile),
+		Cache: &sbox_proto.Cache{
+			Dir: proto.String(filepath.Join(dir, "cache")),
+		},
+	}
+	cache := newActionCache(manifest.Cache)
+	key, err := cache.key(manifest, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if hit, err := cache.restore(key, manifest, &bytes.Buffer{}); hit || err != nil {
+		t.Fatalf("expected a miss, got %v, %v", hit, err)
+	}
+
+	writeTestFile(t, out, "foo\n")
+	writeTestFile(t, depFile, "outputfile: in\n")
+	if err := cache.store(key, manifest, []byte("warning: foo\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	os.RemoveAll(filepath.Join(dir, "out"))
+	writeTestFile(t, filepath.Join(outputDir, "stale"), "stale")
+
+	stdout := &bytes.Buffer{}
+	if hit, err := cache.restore(key, manifest, stdout); !hit || err != nil {
+		t.Fatalf("expected a hit, got %v, %v", hit, err)
+	}
+	if got := readTestFile(t, out); got != "foo\n" {
+		t.Errorf("expected restored output %q, got %q", "foo\n", got)
+	}
+	if got := readTestFile(t, depFile); got != "outputfile: in\n" {
+		t.Errorf("expected restored depfile %q, got %q", "outputfile: in\n", got)
+	}
+	if got := stdout.String(); got != "warning: foo\n" {
+		t.Errorf("expected restored command output %q, got %q", "warning: foo\n", got)
+	}
+	if _, err := os.Stat(filepath.Join(outputDir, "stale")); !os.IsNotExist(err) {
+		t.Errorf("expected stale output to be removed, got %v", err)
+	}
+
+	// Storing the same entry again is not an error.
+	if err := cache.store(key, manifest, nil); err != nil {
+		t.Errorf("unexpected error storing an existing entry: %s", err)
+	}
+}
+
+func TestActionCacheTrim(t *testing.T) {
+	dir := t.TempDir()
+	cache := &actionCache{dir: dir, maxSize: 250}
+
+	// Create entries of 100 bytes that were last used in the order of their names.
+	entries := []string{"aa01", "aa02", "bb03"}
+	for i, key := range entries {
+		entry := cache.entryDir(key)
+		writeTestFile(t, filepath.Join(entry, cacheLogFile), strings.Repeat("x", 100))
+		lastUse := time.Now().Add(time.Duration(i-len(entries)) * time.Hour)
+		if err := os.Chtimes(entry, lastUse, lastUse); err != nil {
+			t.Fatal(err)
+		}
+	}
+	staleTemp := filepath.Join(dir, cacheTempPrefix+"1234")
+	writeTestFile(t, filepath.Join(staleTemp, cacheLogFile), "")
+	old := time.Now().Add(-2 * cacheTempMaxAge)
+	if err := os.Chtimes(staleTemp, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cache.addSize(300); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range entries {
+		_, err := os.Stat(cache.entryDir(key))
+		if want := key != "aa01"; (err == nil) != want {
+			t.Errorf("expected entry %s to exist: %v, got %v", key, want, err)
+		}
+	}
+	if _, err := os.Stat(staleTemp); !os.IsNotExist(err) {
+		t.Errorf("expected stale temporary directory to be removed, got %v", err)
+	}
+	if got := strings.TrimSpace(readTestFile(t, filepath.Join(dir, cacheSizeFile))); got != "200" {
+		t.Errorf("expected cache size 200, got %s", got)
+	}
+}
@@ -124,6 +124,28 @@ func run() error {
 		return fmt.Errorf("at least one commands entry is required in %q", manifestFile)
 	}
 
+	// If the manifest has a cache message, restore the outputs from the action cache instead of
+	// running the commands when they have run before with the same inputs.  The cache isn't used
+	// when auditing, the commands have to run to trace their file accesses.
+	var cache *actionCache
+	var cacheKey string
+	if manifest.Cache != nil && manifest.Audit == nil {
+		topDir, err := os.Getwd()
+		if err != nil {
+			return err
+		}
+		cache = newActionCache(manifest.Cache)
+		cacheKey, err = cache.key(manifest, topDir)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "sbox: not caching %s: %s\n", manifestFile, err)
+			cache = nil
+		} else if hit, err := cache.restore(cacheKey, manifest, os.Stdout); hit {
+			return nil
+		} else if err != nil {
+			fmt.Fprintf(os.Stderr, "sbox: failed to restore %s from the cache: %s\n", manifestFile, err)
+		}
+	}
+
 	// setup sandbox directory
 	err = os.MkdirAll(sandboxesRoot, 0777)
 	if err != nil {
@@ -161,21 +183,40 @@ func run() error {
 		}
 	}()
 
+	var audit *sboxAudit
+	if manifest.Audit != nil {
+		topDir, err := os.Getwd()
+		if err != nil {
+			return err
+		}
+		audit = newSboxAudit(manifest, manifestFile, topDir)
+	}
+
 	// If there is more than one command in the manifest use a separate directory for each one.
 	useSubDir := len(manifest.Commands) > 1
 	var commandDepFiles []string
 
+	// Keep the output of the commands to store it in the cache.
+	var stdout io.Writer = os.Stdout
+	var log bytes.Buffer
+	if cache != nil {
+		stdout = io.MultiWriter(os.Stdout, &log)
+	}
+
 	for i, command := range manifest.Commands {
 		localTempDir := tempDir
 		if useSubDir {
 			localTempDir = filepath.Join(localTempDir, strconv.Itoa(i))
 		}
-		depFile, err := runCommand(command, localTempDir, i)
+		depFile, err := runCommand(command, localTempDir, i, audit, stdout)
 		if err != nil {
 			// Running the command failed, keep the temporary output directory around in
 			// case a user wants to inspect it for debugging purposes.  Soong will delete
 			// it at the beginning of the next build anyway.
 			keepOutDir = true
+			if audit != nil {
+				audit.writeReport()
+			}
 			return err
 		}
 		if depFile != "" {
@@ -183,6 +224,12 @@ func run() error {
 		}
 	}
 
+	if audit != nil {
+		if err := audit.writeReport(); err != nil {
+			return fmt.Errorf("failed writing audit report: %w", err)
+		}
+	}
+
 	outputDepFile := manifest.GetOutputDepfile()
 	if len(commandDepFiles) > 0 && outputDepFile == "" {
 		return fmt.Errorf("Sandboxed commands used %s but output depfile is not set in manifest file",
@@ -197,6 +244,12 @@ func run() error {
 		}
 	}
 
+	if cache != nil {
+		if err := cache.store(cacheKey, manifest, log.Bytes()); err != nil {
+			fmt.Fprintf(os.Stderr, "sbox: failed to store %s in the cache: %s\n", manifestFile, err)
+		}
+	}
+
 	return nil
 }
 
@@ -235,8 +288,11 @@ func readManifest(file string) (*sbox_proto.Manifest, error) {
 }
 
 // runCommand runs a single command from a manifest.  If the command references the
-// __SBOX_DEPFILE__ placeholder it returns the name of the depfile that was used.
-func runCommand(command *sbox_proto.Command, tempDir string, commandIndex int) (depFile string, err error) {
+// __SBOX_DEPFILE__ placeholder it returns the name of the depfile that was used.  If audit is
+// not nil the files accessed by the command are traced and recorded in it.  The output of the
+// command is written to stdout.
+func runCommand(command *sbox_proto.Command, tempDir string, commandIndex int,
+	audit *sboxAudit, stdout io.Writer) (depFile string, err error) {
 	rawCommand := command.GetCommand()
 	if rawCommand == "" {
 		return "", fmt.Errorf("command is required")
@@ -309,7 +365,15 @@ func runCommand(command *sbox_proto.Command, tempDir string, commandIndex int) (
 			return "", fmt.Errorf("Failed to update PATH: %w", err)
 		}
 	}
-	err = cmd.Run()
+	if audit != nil {
+		auditDepFile := ""
+		if depFile != "" {
+			auditDepFile = joinPath(tempDir, "deps.d")
+		}
+		err = audit.runCommand(cmd, command, tempDir, auditDepFile)
+	} else {
+		err = cmd.Run()
+	}
 
 	if err != nil {
 		// The command failed, do a best effort copy of output files out of the sandbox.  This is
@@ -321,7 +385,8 @@ func runCommand(command *sbox_proto.Command, tempDir string, commandIndex int) (
 
 	// If the command  was executed but failed with an error, print a debugging message before
 	// the command's output so it doesn't scroll the real error message off the screen.
-	if exit, ok := err.(*exec.ExitError); ok && !exit.Success() {
+	var exit interface{ ExitCode() int }
+	if errors.As(err, &exit) && exit.ExitCode() != 0 {
 		fmt.Fprintf(os.Stderr,
 			"The failing command was run inside an sbox sandbox in temporary directory\n"+
 				"%s\n"+
@@ -331,7 +396,7 @@ func runCommand(command *sbox_proto.Command, tempDir string, commandIndex int) (
 	}
 
 	// Write the command's combined stdout/stderr.
-	os.Stdout.Write(buf.Bytes())
+	stdout.Write(buf.Bytes())
 
 	if err != nil {
 		return "", err
@@ -0,0 +1,316 @@
+// Copyright 2023 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// The subset of the JSON written by soong_build --module_graph_file and
+// --module_actions_file that is used by queries.
+type jsonVariation struct {
+	Mutator   string
+	Variation string
+}
+
+type jsonModuleName struct {
+	Name       string
+	Variations []jsonVariation
+}
+
+type jsonDep struct {
+	jsonModuleName
+	Tag string
+}
+
+type jsonProperty struct {
+	Name   string
+	Value  string
+	Values []string
+}
+
+type jsonModule struct {
+	jsonModuleName
+	Deps      []jsonDep
+	Type      string
+	Blueprint string
+	CreatedBy *string
+	Module    struct {
+		Android struct {
+			SetProperties []jsonProperty
+		}
+	}
+}
+
+type jsonAction struct {
+	Inputs  []string
+	Outputs []string
+}
+
+type jsonModuleActions struct {
+	jsonModuleName
+	Module struct {
+		Actions []jsonAction
+	}
+}
+
+// module is a single variant of a module in the module graph.
+type module struct {
+	name       string
+	variant    string
+	typ        string
+	blueprint  string
+	createdBy  string
+	partition  string
+	properties []jsonProperty
+
+	deps  []dep
+	rdeps []*module
+
+	// inputs and outputs are the files read and written by the actions of
+	// the module, they are only set once the module actions file is
+	// loaded.
+	inputs  []string
+	outputs []string
+}
+
+type dep struct {
+	module *module
+	tag    string
+}
+
+// label returns the name of the module variant as it is printed by queries,
+// and as it can be used in a query to select that variant only.
+func (m *module) label() string {
+	if m.variant == "" {
+		return m.name
+	}
+	return m.name + "{" + m.variant + "}"
+}
+
+// property returns the values of the property with the given name, which is
+// case insensitive and uses dots to separate nested properties, for example
+// static_libs or target.android.srcs.
+func (m *module) property(name string) ([]string, bool) {
+	for _, p := range m.properties {
+		if strings.EqualFold(p.Name, name) {
+			if p.Values != nil {
+				return p.Values, true
+			}
+			return []string{p.Value}, true
+		}
+	}
+	return nil, false
+}
+
+// variantString returns the variations of a module variant as a comma
+// separated list of mutator:variation pairs, skipping empty variations.
+func variantString(variations []jsonVariation) string {
+	var parts []string
+	for _, v := range variations {
+		if v.Variation != "" {
+			parts = append(parts, v.Mutator+":"+v.Variation)
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+func moduleKey(name jsonModuleName) string {
+	return name.Name + "{" + variantString(name.Variations) + "}"
+}
+
+// modulePartition returns where a module variant is installed, from the
+// variations of its os and image mutators and from the properties that move
+// modules out of the system partition.
+func modulePartition(m *jsonModule) string {
+	isSet := func(name string) bool {
+		for _, p := range m.Module.Android.SetProperties {
+			if strings.EqualFold(p.Name, name) && p.Value == "true" {
+				return true
+			}
+		}
+		return false
+	}
+
+	for _, v := range m.Variations {
+		switch {
+		case v.Mutator == "os" && v.Variation != "" && v.Variation != "android":
+			return "host"
+		case v.Mutator != "image":
+		case strings.HasPrefix(v.Variation, "vendor."):
+			if isSet("device_specific") {
+				return "odm"
+			}
+			return "vendor"
+		case strings.HasPrefix(v.Variation, "product."):
+			return "product"
+		case v.Variation == "recovery", v.Variation == "ramdisk",
+			v.Variation == "vendor_ramdisk", v.Variation == "debug_ramdisk":
+			return v.Variation
+		}
+	}
+
+	switch {
+	case isSet("device_specific"):
+		return "odm"
+	case isSet("vendor"), isSet("soc_specific"), isSet("proprietary"):
+		return "vendor"
+	case isSet("product_specific"):
+		return "product"
+	case isSet("system_ext_specific"):
+		return "system_ext"
+	}
+	return "system"
+}
+
+// moduleGraph is the module graph written by soong_build.
+type moduleGraph struct {
+	modules []*module
+	byName  map[string][]*module
+	byKey   map[string]*module
+
+	// actionsLoaded is set once the inputs and outputs of the modules
+	// have been read from the module actions file.
+	actionsLoaded bool
+}
+
+// openJson opens a JSON file written by soong_build, which may have been
+// compressed when it was copied to the dist directory.
+func openJson(file string) (io.ReadCloser, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(file, ".gz") {
+		return f, nil
+	}
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{gz, f}, nil
+}
+
+// readJsonArray decodes the elements of a JSON array one at a time, so that
+// the multi-gigabyte files written by soong_build are never held in memory
+// all at once.
+func readJsonArray(r io.Reader, each func(dec *json.Decoder) error) error {
+	dec := json.NewDecoder(bufio.NewReaderSize(r, 1024*1024))
+	if tok, err := dec.Token(); err != nil {
+		return err
+	} else if tok != json.Delim('[') {
+		return fmt.Errorf("expected a JSON array, got %v", tok)
+	}
+	for dec.More() {
+		if err := each(dec); err != nil {
+			return err
+		}
+	}
+	_, err := dec.Token()
+	return err
+}
+
+// readModuleGraph reads the module graph written by soong_build
+// --module_graph_file.
+func readModuleGraph(r io.Reader) (*moduleGraph, error) {
+	g := &moduleGraph{
+		byName: make(map[string][]*module),
+		byKey:  make(map[string]*module),
+	}
+
+	var depKeys [][]jsonDep
+	err := readJsonArray(r, func(dec *json.Decoder) error {
+		var jm jsonModule
+		if err := dec.Decode(&jm); err != nil {
+			return err
+		}
+		m := &module{
+			name:       jm.Name,
+			variant:    variantString(jm.Variations),
+			typ:        jm.Type,
+			blueprint:  jm.Blueprint,
+			partition:  modulePartition(&jm),
+			properties: jm.Module.Android.SetProperties,
+		}
+		if jm.CreatedBy != nil {
+			m.createdBy = *jm.CreatedBy
+		}
+		key := moduleKey(jm.jsonModuleName)
+		if _, exists := g.byKey[key]; exists {
+			// Modules with the same name in different namespaces
+			// can't be told apart by the graph, the first one is
+			// used for their dependencies.
+			key += "\x00" + jm.Blueprint
+		}
+		g.byKey[key] = m
+		g.byName[m.name] = append(g.byName[m.name], m)
+		g.modules = append(g.modules, m)
+		depKeys = append(depKeys, jm.Deps)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for i, m := range g.modules {
+		for _, d := range depKeys[i] {
+			if to := g.byKey[moduleKey(d.jsonModuleName)]; to != nil {
+				m.deps = append(m.deps, dep{to, d.Tag})
+				to.rdeps = append(to.rdeps, m)
+			}
+		}
+	}
+
+	sort.SliceStable(g.modules, func(i, j int) bool {
+		return g.modules[i].label() < g.modules[j].label()
+	})
+	return g, nil
+}
+
+// readModuleActions adds the inputs and outputs of the actions of each
+// module from the file written by soong_build --module_actions_file.
+func (g *moduleGraph) readModuleActions(r io.Reader) error {
+	err := readJsonArray(r, func(dec *json.Decoder) error {
+		var jm jsonModuleActions
+		if err := dec.Decode(&jm); err != nil {
+			return err
+		}
+		m := g.byKey[moduleKey(jm.jsonModuleName)]
+		if m == nil {
+			return nil
+		}
+		for _, a := range jm.Module.Actions {
+			m.inputs = append(m.inputs, a.Inputs...)
+			m.outputs = append(m.outputs, a.Outputs...)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	g.actionsLoaded = true
+	return nil
+}
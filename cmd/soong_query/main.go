@@ -0,0 +1,193 @@
+// Copyright 2023 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// soong_query answers questions about the module graph written by
+// soong_build --module_graph_file and --module_actions_file, which are
+// generated with m json-module-graph. For example:
+//
+//	soong_query 'somepath(MyApp, libfoo)'
+//	soong_query --output graph 'allpaths(MyApp, libfoo)' | dot -Tsvg > paths.svg
+//	soong_query 'kind("cc_library.*", partition(vendor, deps(vendor_image_deps)))'
+//	soong_query 'rdeps(universe, produces("/libfoo\.so$"), 1)'
+//	soong_query 'visible(universe, libfoo) - rdeps(universe, libfoo, 1)'
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	graphFile   = flag.String("graph", "", "module graph written by soong_build --module_graph_file (default $OUT_DIR/soong/module-graph.json)")
+	actionsFile = flag.String("actions", "", "module actions written by soong_build --module_actions_file (default $OUT_DIR/soong/module-actions.json)")
+	visibility  = flag.String("visibility", "", "visibility audit report written by soong_build with SOONG_VISIBILITY_AUDIT=true (default $OUT_DIR/soong/visibility_audit.json)")
+	output      = flag.String("output", "label", "output format: label, graph or json")
+)
+
+const queryHelp = `Functions:
+  deps(x [, depth])          modules that x depends on
+  rdeps(universe, x [, depth])
+                             modules in universe that depend on x
+  somepath(from, to)         a shortest dependency path from from to to
+  allpaths(from, to)         every module on a dependency path from from to to
+  kind(regex, x)             modules of x with a matching module type
+  variant(regex, x)          modules of x with a matching variant, for example link:shared
+  partition(regex, x)        modules of x installed in a matching partition
+  filter(regex, x)           modules of x with a matching name
+  attr(name, regex, x)       modules of x that set the property name to a matching value
+  produces(regex)            modules with actions that write a matching file
+  consumes(regex)            modules with actions that read a matching file
+  visible(universe, x)       modules in universe that x is visible to
+
+Operators: x + y (union), x - y (except), x ^ y (intersect)
+Patterns: a module name, a glob of module names, the label of a variant, or universe
+`
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] <query>\n\n", os.Args[0])
+	flag.PrintDefaults()
+	fmt.Fprintf(flag.CommandLine.Output(), "\n%s", queryHelp)
+}
+
+func outDir() string {
+	if dir := os.Getenv("OUT_DIR"); dir != "" {
+		return dir
+	}
+	return "out"
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(1)
+	}
+	if *output != "label" && *output != "graph" && *output != "json" {
+		fmt.Fprintf(os.Stderr, "unknown --output %q\n", *output)
+		os.Exit(1)
+	}
+	if *graphFile == "" {
+		*graphFile = filepath.Join(outDir(), "soong", "module-graph.json")
+	}
+	if *actionsFile == "" {
+		*actionsFile = filepath.Join(outDir(), "soong", "module-actions.json")
+	}
+	if *visibility == "" {
+		*visibility = filepath.Join(outDir(), "soong", "visibility_audit.json")
+	}
+
+	expr, err := parseQuery(strings.Join(flag.Args(), " "))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid query: %s\n", err)
+		os.Exit(1)
+	}
+
+	g, err := loadModuleGraph(*graphFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\nRun m json-module-graph to generate the module graph.\n", err)
+		os.Exit(1)
+	}
+	q := &queryContext{
+		graph: g,
+		loadActions: func() error {
+			return loadModuleActions(g, *actionsFile)
+		},
+		loadVisibility: func() (map[string][]string, error) {
+			return loadVisibility(*visibility)
+		},
+	}
+
+	result, err := expr.eval(q)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "query failed: %s\n", err)
+		os.Exit(1)
+	}
+	if len(result) == 0 {
+		fmt.Fprintln(os.Stderr, "Empty results")
+		return
+	}
+
+	// The JSON output includes the outputs of the modules when the module
+	// actions file exists.
+	if *output == "json" && !g.actionsLoaded {
+		if _, err := os.Stat(*actionsFile); err == nil {
+			if err := q.loadActions(); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+		}
+	}
+
+	w := bufio.NewWriter(os.Stdout)
+	modules := sortedModules(g, result)
+	switch *output {
+	case "label":
+		err = writeLabels(w, modules)
+	case "graph":
+		err = writeGraph(w, modules, result)
+	case "json":
+		err = writeJson(w, modules)
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func loadModuleGraph(file string) (*moduleGraph, error) {
+	r, err := openJson(file)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	g, err := readModuleGraph(r)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	return g, nil
+}
+
+func loadModuleActions(g *moduleGraph, file string) error {
+	r, err := openJson(file)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	if err := g.readModuleActions(r); err != nil {
+		return fmt.Errorf("%s: %w", file, err)
+	}
+	return nil
+}
+
+func loadVisibility(file string) (map[string][]string, error) {
+	r, err := openJson(file)
+	if err != nil {
+		return nil, fmt.Errorf("%w\nRun SOONG_VISIBILITY_AUDIT=true m nothing to generate the visibility audit report.", err)
+	}
+	defer r.Close()
+	visibility, err := readVisibility(r)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	return visibility, nil
+}
@@ -0,0 +1,181 @@
+// Copyright 2023 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// sortedModules returns the modules of a query result so that every module
+// comes before the modules it depends on, which prints the modules of a path
+// in order, and otherwise in the order of their labels.
+func sortedModules(g *moduleGraph, set moduleSet) []*module {
+	inDegree := make(map[*module]int, len(set))
+	for m := range set {
+		for _, d := range m.deps {
+			if set[d.module] && d.module != m {
+				inDegree[d.module]++
+			}
+		}
+	}
+
+	// g.modules is sorted by label, walking it repeatedly keeps the
+	// order stable.
+	ret := make([]*module, 0, len(set))
+	done := make(map[*module]bool, len(set))
+	for len(ret) < len(set) {
+		progress := false
+		for _, m := range g.modules {
+			if !set[m] || done[m] || inDegree[m] > 0 {
+				continue
+			}
+			done[m] = true
+			progress = true
+			ret = append(ret, m)
+			for _, d := range m.deps {
+				if set[d.module] && d.module != m && !done[d.module] {
+					inDegree[d.module]--
+				}
+			}
+		}
+		if !progress {
+			// The module graph has no cycles, but add the rest in
+			// the order of their labels rather than looping
+			// forever if it does.
+			for _, m := range g.modules {
+				if set[m] && !done[m] {
+					done[m] = true
+					ret = append(ret, m)
+				}
+			}
+		}
+	}
+	return ret
+}
+
+// tagType returns the type of a dependency tag, without its fields.
+func tagType(tag string) string {
+	if i := strings.IndexByte(tag, ' '); i >= 0 {
+		return tag[:i]
+	}
+	return tag
+}
+
+func writeLabels(w io.Writer, modules []*module) error {
+	for _, m := range modules {
+		if _, err := fmt.Fprintln(w, m.label()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeGraph writes the modules of a query result and the dependencies
+// between them in the graphviz dot format, with the types of the dependency
+// tags as the labels of the edges.
+func writeGraph(w io.Writer, modules []*module, set moduleSet) error {
+	var b strings.Builder
+	b.WriteString("digraph soong_query {\n")
+	b.WriteString("  node [shape=box];\n")
+	for _, m := range modules {
+		fmt.Fprintf(&b, "  %q [tooltip=%q];\n", m.label(), m.typ+" "+m.blueprint)
+	}
+	for _, m := range modules {
+		var tos []*module
+		tags := make(map[*module][]string)
+		for _, d := range m.deps {
+			if !set[d.module] {
+				continue
+			}
+			if _, ok := tags[d.module]; !ok {
+				tos = append(tos, d.module)
+			}
+			if tag := tagType(d.tag); !inList(tag, tags[d.module]) {
+				tags[d.module] = append(tags[d.module], tag)
+			}
+		}
+		for _, to := range tos {
+			fmt.Fprintf(&b, "  %q -> %q [label=%q];\n", m.label(), to.label(), strings.Join(tags[to], "\\n"))
+		}
+	}
+	b.WriteString("}\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+type jsonOutputDep struct {
+	Label string `json:"label"`
+	Tag   string `json:"tag"`
+}
+
+type jsonOutputModule struct {
+	Label      string              `json:"label"`
+	Name       string              `json:"name"`
+	Variant    string              `json:"variant,omitempty"`
+	Type       string              `json:"type"`
+	Blueprint  string              `json:"blueprint"`
+	CreatedBy  string              `json:"created_by,omitempty"`
+	Partition  string              `json:"partition"`
+	Properties map[string][]string `json:"properties,omitempty"`
+	Deps       []jsonOutputDep     `json:"deps,omitempty"`
+	Outputs    []string            `json:"outputs,omitempty"`
+}
+
+// writeJson writes the modules of a query result with all of their direct
+// dependencies, the properties set in their blueprint files, and the outputs
+// of their actions if the module actions file was loaded.
+func writeJson(w io.Writer, modules []*module) error {
+	out := make([]jsonOutputModule, 0, len(modules))
+	for _, m := range modules {
+		jm := jsonOutputModule{
+			Label:     m.label(),
+			Name:      m.name,
+			Variant:   m.variant,
+			Type:      m.typ,
+			Blueprint: m.blueprint,
+			CreatedBy: m.createdBy,
+			Partition: m.partition,
+			Outputs:   m.outputs,
+		}
+		if len(m.properties) > 0 {
+			jm.Properties = make(map[string][]string)
+			for _, p := range m.properties {
+				jm.Properties[p.Name], _ = m.property(p.Name)
+			}
+		}
+		for _, d := range m.deps {
+			jm.Deps = append(jm.Deps, jsonOutputDep{d.module.label(), d.tag})
+		}
+		sort.SliceStable(jm.Deps, func(i, j int) bool { return jm.Deps[i].Label < jm.Deps[j].Label })
+		out = append(out, jm)
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(out)
+}
+
+func inList(s string, list []string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
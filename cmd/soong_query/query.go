@@ -0,0 +1,590 @@
+// Copyright 2023 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// moduleSet is the result of a query.
+type moduleSet map[*module]bool
+
+// queryContext is what queries are evaluated against.
+type queryContext struct {
+	graph *moduleGraph
+
+	// loadActions reads the module actions file into the graph, it is
+	// only called by queries on the inputs and outputs of modules.
+	loadActions func() error
+
+	// loadVisibility reads the visibility audit report, it is only called
+	// by queries on the visibility of modules.
+	loadVisibility func() (map[string][]string, error)
+	visibility     map[string][]string
+}
+
+type queryExpr interface {
+	eval(q *queryContext) (moduleSet, error)
+}
+
+type argKind int
+
+const (
+	exprArg argKind = iota
+	wordArg
+	intArg
+)
+
+type queryArg struct {
+	expr queryExpr
+	word string
+	n    int
+}
+
+type queryFunction struct {
+	args []argKind
+
+	// optional is the number of trailing arguments that may be left out.
+	optional int
+
+	eval func(q *queryContext, args []queryArg) (moduleSet, error)
+}
+
+var queryFunctions = map[string]queryFunction{
+	"deps":      {[]argKind{exprArg, intArg}, 1, evalDeps},
+	"rdeps":     {[]argKind{exprArg, exprArg, intArg}, 1, evalRdeps},
+	"somepath":  {[]argKind{exprArg, exprArg}, 0, evalSomepath},
+	"allpaths":  {[]argKind{exprArg, exprArg}, 0, evalAllpaths},
+	"kind":      {[]argKind{wordArg, exprArg}, 0, moduleFilter(func(m *module) []string { return []string{m.typ} })},
+	"variant":   {[]argKind{wordArg, exprArg}, 0, moduleFilter(func(m *module) []string { return []string{m.variant} })},
+	"partition": {[]argKind{wordArg, exprArg}, 0, moduleFilter(func(m *module) []string { return []string{m.partition} })},
+	"filter":    {[]argKind{wordArg, exprArg}, 0, moduleFilter(func(m *module) []string { return []string{m.name} })},
+	"attr":      {[]argKind{wordArg, wordArg, exprArg}, 0, evalAttr},
+	"produces":  {[]argKind{wordArg}, 0, actionFilter(func(m *module) []string { return m.outputs })},
+	"consumes":  {[]argKind{wordArg}, 0, actionFilter(func(m *module) []string { return m.inputs })},
+	"visible":   {[]argKind{exprArg, exprArg}, 0, evalVisible},
+}
+
+// The grammar of queries is:
+//
+//	expr    := primary (op primary)*
+//	op      := "+" | "union" | "-" | "except" | "^" | "intersect"
+//	primary := "(" expr ")" | function "(" args ")" | pattern
+//
+// Operators are left associative and have the same precedence. A pattern is
+// a module name, which selects all of its variants, a glob of module names,
+// a label of a single variant, as printed by the label output, or universe,
+// which selects every module. Words that contain spaces, commas or
+// parentheses can be quoted with double quotes.
+type queryParser struct {
+	tokens []string
+	pos    int
+}
+
+// parseQuery parses a query expression.
+func parseQuery(s string) (queryExpr, error) {
+	tokens, err := tokenizeQuery(s)
+	if err != nil {
+		return nil, err
+	}
+	p := &queryParser{tokens: tokens}
+	expr, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	if tok, ok := p.peek(); ok {
+		return nil, fmt.Errorf("unexpected %q at the end of the query", tok)
+	}
+	return expr, nil
+}
+
+func tokenizeQuery(s string) ([]string, error) {
+	var tokens []string
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case unicode.IsSpace(rune(c)):
+			i++
+		case c == '(' || c == ')' || c == ',':
+			tokens = append(tokens, string(c))
+			i++
+		case c == '"':
+			end := strings.IndexByte(s[i+1:], '"')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated quote in %q", s[i:])
+			}
+			// Quoted words keep their quote so that they are never
+			// taken for an operator or a function.
+			tokens = append(tokens, s[i:i+end+2])
+			i += end + 2
+		default:
+			// Commas inside the braces of a label are part of the
+			// label.
+			start, braces := i, 0
+			for ; i < len(s); i++ {
+				c := s[i]
+				if c == '{' {
+					braces++
+				} else if c == '}' && braces > 0 {
+					braces--
+				} else if braces == 0 && (unicode.IsSpace(rune(c)) || strings.IndexByte("(),\"", c) >= 0) {
+					break
+				}
+			}
+			tokens = append(tokens, s[start:i])
+		}
+	}
+	return tokens, nil
+}
+
+func (p *queryParser) peek() (string, bool) {
+	if p.pos >= len(p.tokens) {
+		return "", false
+	}
+	return p.tokens[p.pos], true
+}
+
+func (p *queryParser) next() (string, error) {
+	tok, ok := p.peek()
+	if !ok {
+		return "", fmt.Errorf("unexpected end of the query")
+	}
+	p.pos++
+	return tok, nil
+}
+
+func (p *queryParser) expect(want string) error {
+	tok, err := p.next()
+	if err != nil {
+		return fmt.Errorf("expected %q: %w", want, err)
+	}
+	if tok != want {
+		return fmt.Errorf("expected %q, got %q", want, tok)
+	}
+	return nil
+}
+
+var queryOperators = map[string]string{
+	"+":         "+",
+	"union":     "+",
+	"-":         "-",
+	"except":    "-",
+	"^":         "^",
+	"intersect": "^",
+}
+
+func (p *queryParser) parseExpr() (queryExpr, error) {
+	left, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		tok, ok := p.peek()
+		op, isOp := queryOperators[tok]
+		if !ok || !isOp {
+			return left, nil
+		}
+		p.pos++
+		right, err := p.parsePrimary()
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryExpr{op, left, right}
+	}
+}
+
+func (p *queryParser) parsePrimary() (queryExpr, error) {
+	tok, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	if tok == "(" {
+		expr, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		return expr, p.expect(")")
+	}
+	if tok == ")" || tok == "," || queryOperators[tok] != "" {
+		return nil, fmt.Errorf("unexpected %q", tok)
+	}
+	if next, _ := p.peek(); next == "(" {
+		return p.parseCall(tok)
+	}
+	return &patternExpr{unquote(tok)}, nil
+}
+
+func (p *queryParser) parseCall(name string) (queryExpr, error) {
+	f, ok := queryFunctions[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown function %q", name)
+	}
+	p.pos++
+
+	call := &callExpr{name: name, f: f}
+	for i, kind := range f.args {
+		if i > 0 {
+			if tok, _ := p.peek(); tok != "," && i >= len(f.args)-f.optional {
+				break
+			}
+			if err := p.expect(","); err != nil {
+				return nil, fmt.Errorf("%s: %w", name, err)
+			}
+		}
+		var arg queryArg
+		switch kind {
+		case exprArg:
+			expr, err := p.parseExpr()
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", name, err)
+			}
+			arg.expr = expr
+		case wordArg, intArg:
+			tok, err := p.next()
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", name, err)
+			}
+			arg.word = unquote(tok)
+			if kind == intArg {
+				if arg.n, err = strconv.Atoi(arg.word); err != nil || arg.n < 0 {
+					return nil, fmt.Errorf("%s: expected a depth, got %q", name, tok)
+				}
+			}
+		}
+		call.args = append(call.args, arg)
+	}
+	if err := p.expect(")"); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return call, nil
+}
+
+func unquote(tok string) string {
+	if len(tok) >= 2 && tok[0] == '"' {
+		return tok[1 : len(tok)-1]
+	}
+	return tok
+}
+
+type patternExpr struct {
+	pattern string
+}
+
+func (e *patternExpr) eval(q *queryContext) (moduleSet, error) {
+	ret := make(moduleSet)
+	switch {
+	case e.pattern == "universe":
+		for _, m := range q.graph.modules {
+			ret[m] = true
+		}
+	case strings.Contains(e.pattern, "{"):
+		name := e.pattern[:strings.Index(e.pattern, "{")]
+		for _, m := range q.graph.byName[name] {
+			if m.label() == e.pattern {
+				ret[m] = true
+			}
+		}
+	case strings.ContainsAny(e.pattern, "*?["):
+		if _, err := path.Match(e.pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", e.pattern, err)
+		}
+		for _, m := range q.graph.modules {
+			if match, _ := path.Match(e.pattern, m.name); match {
+				ret[m] = true
+			}
+		}
+	default:
+		for _, m := range q.graph.byName[e.pattern] {
+			ret[m] = true
+		}
+	}
+	if len(ret) == 0 {
+		return nil, fmt.Errorf("no module matches %q", e.pattern)
+	}
+	return ret, nil
+}
+
+type binaryExpr struct {
+	op          string
+	left, right queryExpr
+}
+
+func (e *binaryExpr) eval(q *queryContext) (moduleSet, error) {
+	left, err := e.left.eval(q)
+	if err != nil {
+		return nil, err
+	}
+	right, err := e.right.eval(q)
+	if err != nil {
+		return nil, err
+	}
+	ret := make(moduleSet)
+	switch e.op {
+	case "+":
+		for m := range left {
+			ret[m] = true
+		}
+		for m := range right {
+			ret[m] = true
+		}
+	case "-":
+		for m := range left {
+			if !right[m] {
+				ret[m] = true
+			}
+		}
+	case "^":
+		for m := range left {
+			if right[m] {
+				ret[m] = true
+			}
+		}
+	}
+	return ret, nil
+}
+
+type callExpr struct {
+	name string
+	f    queryFunction
+	args []queryArg
+}
+
+func (e *callExpr) eval(q *queryContext) (moduleSet, error) {
+	ret, err := e.f.eval(q, e.args)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", e.name, err)
+	}
+	return ret, nil
+}
+
+// reachable returns the modules that can be reached from the given modules
+// in at most maxDepth steps, or in any number of steps if maxDepth is
+// negative. Only modules for which include returns true are visited.
+func reachable(from moduleSet, maxDepth int, next func(*module) []*module, include func(*module) bool) moduleSet {
+	ret := make(moduleSet, len(from))
+	var queue []*module
+	for m := range from {
+		ret[m] = true
+		queue = append(queue, m)
+	}
+	for depth := 0; len(queue) > 0 && (maxDepth < 0 || depth < maxDepth); depth++ {
+		var nextQueue []*module
+		for _, m := range queue {
+			for _, n := range next(m) {
+				if !ret[n] && include(n) {
+					ret[n] = true
+					nextQueue = append(nextQueue, n)
+				}
+			}
+		}
+		queue = nextQueue
+	}
+	return ret
+}
+
+func depModules(m *module) []*module {
+	ret := make([]*module, len(m.deps))
+	for i, d := range m.deps {
+		ret[i] = d.module
+	}
+	return ret
+}
+
+func rdepModules(m *module) []*module {
+	return m.rdeps
+}
+
+func includeAll(*module) bool { return true }
+
+func depth(args []queryArg, i int) int {
+	if len(args) > i {
+		return args[i].n
+	}
+	return -1
+}
+
+// deps(x, [depth]) returns the modules that x depends on, transitively.
+func evalDeps(q *queryContext, args []queryArg) (moduleSet, error) {
+	x, err := args[0].expr.eval(q)
+	if err != nil {
+		return nil, err
+	}
+	return reachable(x, depth(args, 1), depModules, includeAll), nil
+}
+
+// rdeps(universe, x, [depth]) returns the modules in universe that depend on
+// x, transitively.
+func evalRdeps(q *queryContext, args []queryArg) (moduleSet, error) {
+	universe, err := args[0].expr.eval(q)
+	if err != nil {
+		return nil, err
+	}
+	x, err := args[1].expr.eval(q)
+	if err != nil {
+		return nil, err
+	}
+	ret := reachable(x, depth(args, 2), rdepModules, func(m *module) bool { return universe[m] })
+	for m := range ret {
+		if !universe[m] {
+			delete(ret, m)
+		}
+	}
+	return ret, nil
+}
+
+// somepath(from, to) returns the modules on a shortest dependency path from
+// a module in from to a module in to.
+func evalSomepath(q *queryContext, args []queryArg) (moduleSet, error) {
+	from, err := args[0].expr.eval(q)
+	if err != nil {
+		return nil, err
+	}
+	to, err := args[1].expr.eval(q)
+	if err != nil {
+		return nil, err
+	}
+
+	// Sources are visited in the order of their labels so that the path
+	// that is found doesn't change from one run to the next.
+	parent := make(map[*module]*module)
+	var queue []*module
+	for _, m := range q.graph.modules {
+		if from[m] {
+			parent[m] = nil
+			queue = append(queue, m)
+		}
+	}
+	for len(queue) > 0 {
+		m := queue[0]
+		queue = queue[1:]
+		if to[m] {
+			ret := make(moduleSet)
+			for ; m != nil; m = parent[m] {
+				ret[m] = true
+			}
+			return ret, nil
+		}
+		for _, d := range m.deps {
+			if _, seen := parent[d.module]; !seen {
+				parent[d.module] = m
+				queue = append(queue, d.module)
+			}
+		}
+	}
+	return moduleSet{}, nil
+}
+
+// allpaths(from, to) returns the modules on any dependency path from a module
+// in from to a module in to.
+func evalAllpaths(q *queryContext, args []queryArg) (moduleSet, error) {
+	from, err := args[0].expr.eval(q)
+	if err != nil {
+		return nil, err
+	}
+	to, err := args[1].expr.eval(q)
+	if err != nil {
+		return nil, err
+	}
+	forward := reachable(from, -1, depModules, includeAll)
+	ret := reachable(to, -1, rdepModules, func(m *module) bool { return forward[m] })
+	for m := range ret {
+		if !forward[m] {
+			delete(ret, m)
+		}
+	}
+	return ret, nil
+}
+
+func compileQueryRegexp(s string) (*regexp.Regexp, error) {
+	re, err := regexp.Compile(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid regular expression %q: %w", s, err)
+	}
+	return re, nil
+}
+
+// moduleFilter returns a function that keeps the modules of its second
+// argument for which one of the values matches the regular expression in its
+// first argument.
+func moduleFilter(values func(*module) []string) func(*queryContext, []queryArg) (moduleSet, error) {
+	return func(q *queryContext, args []queryArg) (moduleSet, error) {
+		re, err := compileQueryRegexp(args[0].word)
+		if err != nil {
+			return nil, err
+		}
+		x, err := args[1].expr.eval(q)
+		if err != nil {
+			return nil, err
+		}
+		return filterModules(x, re, values), nil
+	}
+}
+
+func filterModules(x moduleSet, re *regexp.Regexp, values func(*module) []string) moduleSet {
+	ret := make(moduleSet)
+	for m := range x {
+		for _, v := range values(m) {
+			if re.MatchString(v) {
+				ret[m] = true
+				break
+			}
+		}
+	}
+	return ret
+}
+
+// attr(name, regex, x) keeps the modules of x that set the property name to a
+// value that matches regex. Each value of a list property is matched
+// separately.
+func evalAttr(q *queryContext, args []queryArg) (moduleSet, error) {
+	re, err := compileQueryRegexp(args[1].word)
+	if err != nil {
+		return nil, err
+	}
+	x, err := args[2].expr.eval(q)
+	if err != nil {
+		return nil, err
+	}
+	return filterModules(x, re, func(m *module) []string {
+		values, _ := m.property(args[0].word)
+		return values
+	}), nil
+}
+
+// actionFilter returns a function that selects the modules with actions that
+// read or write a file that matches the regular expression in its argument.
+func actionFilter(files func(*module) []string) func(*queryContext, []queryArg) (moduleSet, error) {
+	return func(q *queryContext, args []queryArg) (moduleSet, error) {
+		re, err := compileQueryRegexp(args[0].word)
+		if err != nil {
+			return nil, err
+		}
+		if !q.graph.actionsLoaded {
+			if err := q.loadActions(); err != nil {
+				return nil, err
+			}
+		}
+		x := make(moduleSet, len(q.graph.modules))
+		for _, m := range q.graph.modules {
+			x[m] = true
+		}
+		return filterModules(x, re, files), nil
+	}
+}
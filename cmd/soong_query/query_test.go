@@ -0,0 +1,175 @@
+// Copyright 2023 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testModuleGraph = `[
+{"Name": "MyApp", "Variations": null, "Type": "android_app", "Blueprint": "app/Android.bp",
+ "Deps": [
+  {"Name": "libjni", "Variations": [{"Mutator": "link", "Variation": "shared"}, {"Mutator": "os", "Variation": "android"}], "Tag": "java.dependencyTag {name:jni}"},
+  {"Name": "libutil", "Variations": [{"Mutator": "os", "Variation": "android"}], "Tag": "java.dependencyTag {name:staticlib}"}
+ ],
+ "Module": {"Android": {"SetProperties": [{"Name": "Jni_libs", "Type": "string slice", "Values": ["libjni"]}]}}},
+{"Name": "libjni", "Variations": [{"Mutator": "link", "Variation": "shared"}, {"Mutator": "os", "Variation": "android"}], "Type": "cc_library", "Blueprint": "jni/Android.bp",
+ "Deps": [
+  {"Name": "libfoo", "Variations": [{"Mutator": "link", "Variation": "shared"}, {"Mutator": "os", "Variation": "android"}], "Tag": "cc.libraryDependencyTag {Kind:sharedLibraryDependency}"}
+ ]},
+{"Name": "libutil", "Variations": [{"Mutator": "os", "Variation": "android"}], "Type": "java_library", "Blueprint": "util/Android.bp",
+ "Deps": [
+  {"Name": "libfoo", "Variations": [{"Mutator": "link", "Variation": "shared"}, {"Mutator": "os", "Variation": "android"}], "Tag": "cc.libraryDependencyTag {Kind:sharedLibraryDependency}"}
+ ]},
+{"Name": "libfoo", "Variations": [{"Mutator": "link", "Variation": "shared"}, {"Mutator": "os", "Variation": "android"}], "Type": "cc_library", "Blueprint": "foo/Android.bp"},
+{"Name": "libfoo", "Variations": [{"Mutator": "link", "Variation": "shared"}, {"Mutator": "os", "Variation": "linux_glibc"}], "Type": "cc_library", "Blueprint": "foo/Android.bp"},
+{"Name": "libvendor", "Variations": [{"Mutator": "image", "Variation": "vendor.VER"}], "Type": "cc_library", "Blueprint": "vendor/Android.bp",
+ "Module": {"Android": {"SetProperties": [{"Name": "Vendor", "Type": "bool", "Value": "true"}]}}}
+]`
+
+const testModuleActions = `[
+{"Name": "libfoo", "Variations": [{"Mutator": "link", "Variation": "shared"}, {"Mutator": "os", "Variation": "android"}],
+ "Module": {"Actions": [{"Desc": "", "Inputs": ["foo/foo.c"], "Outputs": ["out/soong/.intermediates/foo/android_shared/libfoo.so"]}]}}
+]`
+
+const testVisibility = `{"modules": [
+{"module": "//foo:libfoo", "visibility": ["//jni", "//util:__subpackages__"]},
+{"module": "//util:libutil", "visibility": ["//visibility:public"]},
+{"module": "//vendor:libvendor", "visibility": ["//visibility:private"]}
+]}`
+
+func testQueryContext(t *testing.T) *queryContext {
+	t.Helper()
+	g, err := readModuleGraph(strings.NewReader(testModuleGraph))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &queryContext{
+		graph: g,
+		loadActions: func() error {
+			return g.readModuleActions(strings.NewReader(testModuleActions))
+		},
+		loadVisibility: func() (map[string][]string, error) {
+			return readVisibility(strings.NewReader(testVisibility))
+		},
+	}
+}
+
+func runQuery(t *testing.T, q *queryContext, query string) []string {
+	t.Helper()
+	expr, err := parseQuery(query)
+	if err != nil {
+		t.Fatalf("%s: %s", query, err)
+	}
+	result, err := expr.eval(q)
+	if err != nil {
+		t.Fatalf("%s: %s", query, err)
+	}
+	var labels []string
+	for _, m := range sortedModules(q.graph, result) {
+		labels = append(labels, m.label())
+	}
+	return labels
+}
+
+func TestQuery(t *testing.T) {
+	const (
+		libjni     = "libjni{link:shared,os:android}"
+		libutil    = "libutil{os:android}"
+		libfoo     = "libfoo{link:shared,os:android}"
+		libfooHost = "libfoo{link:shared,os:linux_glibc}"
+		libvendor  = "libvendor{image:vendor.VER}"
+	)
+
+	testCases := []struct {
+		query string
+		want  []string
+	}{
+		{"libfoo", []string{libfoo, libfooHost}},
+		{"lib*", []string{libfooHost, libjni, libutil, libvendor, libfoo}},
+		{"deps(MyApp)", []string{"MyApp", libjni, libutil, libfoo}},
+		{"deps(MyApp, 1)", []string{"MyApp", libjni, libutil}},
+		{"rdeps(universe, " + libfoo + ")", []string{"MyApp", libjni, libutil, libfoo}},
+		{"rdeps(universe - MyApp, libfoo, 1)", []string{libfooHost, libjni, libutil, libfoo}},
+		{"somepath(MyApp, libfoo)", []string{"MyApp", libjni, libfoo}},
+		{"somepath(libvendor, libfoo)", nil},
+		{"allpaths(MyApp, libfoo)", []string{"MyApp", libjni, libutil, libfoo}},
+		{"kind(cc_library, deps(MyApp))", []string{libjni, libfoo}},
+		{"variant(os:linux, universe)", []string{libfooHost}},
+		{"partition(vendor, universe) + partition(host, universe)", []string{libfooHost, libvendor}},
+		{"attr(jni_libs, ^libjni$, universe)", []string{"MyApp"}},
+		{"filter(util, universe) ^ deps(MyApp)", []string{libutil}},
+		{`rdeps(universe, produces("/libfoo\.so$"), 1)`, []string{libjni, libutil, libfoo}},
+		{`consumes("foo\.c")`, []string{libfoo}},
+		{"visible(universe, libfoo)", []string{libfooHost, libjni, libutil, libfoo}},
+		{"visible(universe, libutil)", []string{"MyApp", libfooHost, libjni, libutil, libvendor, libfoo}},
+		{"visible(universe, libvendor + MyApp)", []string{"MyApp", libvendor}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.query, func(t *testing.T) {
+			if got := runQuery(t, testQueryContext(t), tc.query); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("expected %q, got %q", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestQueryErrors(t *testing.T) {
+	testCases := map[string]string{
+		"deps(MyApp":            `deps: expected ")": unexpected end of the query`,
+		"deps(MyApp, x)":        `deps: expected a depth, got "x"`,
+		"unknown(MyApp)":        `unknown function "unknown"`,
+		"MyApp +":               `unexpected end of the query`,
+		"MyApp libfoo":          `unexpected "libfoo" at the end of the query`,
+		"kind(\"(\", universe)": `kind: invalid regular expression "(": error parsing regexp: missing closing ): ` + "`(`",
+		"deps(libmissing)":      `deps: no module matches "libmissing"`,
+	}
+	for query, want := range testCases {
+		expr, err := parseQuery(query)
+		if err == nil {
+			_, err = expr.eval(testQueryContext(t))
+		}
+		if err == nil || err.Error() != want {
+			t.Errorf("%s: expected error %q, got %v", query, want, err)
+		}
+	}
+}
+
+func TestWriteGraph(t *testing.T) {
+	q := testQueryContext(t)
+	expr, err := parseQuery("somepath(MyApp, libfoo)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := expr.eval(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var b strings.Builder
+	if err := writeGraph(&b, sortedModules(q.graph, result), result); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`"MyApp" -> "libjni{link:shared,os:android}" [label="java.dependencyTag"];`,
+		`"libjni{link:shared,os:android}" -> "libfoo{link:shared,os:android}" [label="cc.libraryDependencyTag"];`,
+	} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("expected the graph to contain %q, got:\n%s", want, b.String())
+		}
+	}
+}
@@ -0,0 +1,117 @@
+// Copyright 2023 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"path"
+	"strings"
+)
+
+// jsonVisibilityReport is the part of the visibility audit report written by
+// soong_build with SOONG_VISIBILITY_AUDIT=true that queries use.
+type jsonVisibilityReport struct {
+	Modules []struct {
+		Module     string   `json:"module"`
+		Visibility []string `json:"visibility"`
+	} `json:"modules"`
+}
+
+// readVisibility returns the effective visibility rules of the modules in a
+// visibility audit report by the qualified name of the module, for example
+// //frameworks/foo:libfoo.
+func readVisibility(r io.Reader) (map[string][]string, error) {
+	var report jsonVisibilityReport
+	if err := json.NewDecoder(r).Decode(&report); err != nil {
+		return nil, err
+	}
+	ret := make(map[string][]string, len(report.Modules))
+	for _, m := range report.Modules {
+		ret[m.Module] = m.Visibility
+	}
+	return ret, nil
+}
+
+// pkg returns the package of the module, which is the directory of its
+// blueprint file.
+func (m *module) pkg() string {
+	dir := path.Dir(m.blueprint)
+	if dir == "." {
+		return ""
+	}
+	return dir
+}
+
+// qualifiedName returns the name of the module as it is written in visibility
+// rules and in the visibility audit report.
+func (m *module) qualifiedName() string {
+	return "//" + m.pkg() + ":" + m.name
+}
+
+// visibleTo returns true if the visibility rules allow modules in pkg to
+// depend on a module.
+func visibleTo(rules []string, pkg string) bool {
+	for _, rule := range rules {
+		switch {
+		case rule == "//visibility:public":
+			return true
+		case rule == "//visibility:private":
+			continue
+		case strings.HasSuffix(rule, ":__subpackages__"):
+			prefix := strings.TrimSuffix(strings.TrimPrefix(rule, "//"), ":__subpackages__")
+			if prefix == "" || pkg == prefix || strings.HasPrefix(pkg, prefix+"/") {
+				return true
+			}
+		default:
+			if strings.TrimSuffix(strings.TrimPrefix(rule, "//"), ":__pkg__") == pkg {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// visible(universe, x) returns the modules in universe that are allowed to
+// depend on a module of x. Modules are always visible to the modules in the
+// same package. Modules that are missing from the visibility audit report,
+// like defaults, are only visible in their own package.
+func evalVisible(q *queryContext, args []queryArg) (moduleSet, error) {
+	universe, err := args[0].expr.eval(q)
+	if err != nil {
+		return nil, err
+	}
+	x, err := args[1].expr.eval(q)
+	if err != nil {
+		return nil, err
+	}
+	if q.visibility == nil {
+		if q.visibility, err = q.loadVisibility(); err != nil {
+			return nil, err
+		}
+	}
+
+	ret := make(moduleSet)
+	for m := range universe {
+		pkg := m.pkg()
+		for target := range x {
+			if pkg == target.pkg() || visibleTo(q.visibility[target.qualifiedName()], pkg) {
+				ret[m] = true
+				break
+			}
+		}
+	}
+	return ret, nil
+}
@@ -16,6 +16,7 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io/ioutil"
@@ -51,6 +52,10 @@ type command struct {
 	// Sets a prefix string to use for filenames of log files.
 	logsPrefix string
 
+	// Record the metrics of the command in the local build history, and
+	// export them as OpenTelemetry spans when that is configured.
+	buildHistory bool
+
 	// Creates the build configuration based on the args and build context.
 	config func(ctx build.Context, args ...string) build.Config
 
@@ -64,11 +69,12 @@ type command struct {
 // list of supported commands (flags) supported by soong ui
 var commands = []command{
 	{
-		flag:        "--make-mode",
-		description: "build the modules by the target name (i.e. soong_docs)",
-		config:      build.NewConfig,
-		stdio:       stdio,
-		run:         runMake,
+		flag:         "--make-mode",
+		description:  "build the modules by the target name (i.e. soong_docs)",
+		buildHistory: true,
+		config:       build.NewConfig,
+		stdio:        stdio,
+		run:          runMake,
 	}, {
 		flag:         "--dumpvar-mode",
 		description:  "print the value of the legacy make variable VAR to stdout",
@@ -86,11 +92,56 @@ var commands = []command{
 		stdio:        customStdio,
 		run:          dumpVars,
 	}, {
-		flag:        "--build-mode",
-		description: "build modules based on the specified build action",
-		config:      buildActionConfig,
+		flag:         "--dumpvars-server-mode",
+		description:  "answer queries for the values of legacy make variables without rerunning product config",
+		simpleOutput: true,
+		logsPrefix:   "dumpvars-",
+		config:       dumpVarConfig,
+		stdio:        customStdio,
+		run:          dumpVarsServer,
+	}, {
+		flag:         "--build-mode",
+		description:  "build modules based on the specified build action",
+		buildHistory: true,
+		config:       buildActionConfig,
+		stdio:        stdio,
+		run:          runMake,
+	}, {
+		flag:         "--retry-failed",
+		description:  "rebuild the outputs of the actions that failed in the previous build",
+		buildHistory: true,
+		config:       build.NewConfig,
+		stdio:        stdio,
+		run:          retryFailed,
+	}, {
+		flag:         "--verify-noop",
+		description:  "build, then fail if ninja would run any actions again in an incremental build",
+		buildHistory: true,
+		config:       build.NewConfig,
+		stdio:        stdio,
+		run:          verifyNoop,
+	}, {
+		flag:        "--gc-out",
+		description: "remove intermediates and installed files that are no longer produced by the build",
+		config:      flagsOnlyConfig,
 		stdio:       stdio,
-		run:         runMake,
+		run:         gcOut,
+	}, {
+		flag:         "--doctor",
+		description:  "check the host for conditions that are known to break builds",
+		simpleOutput: true,
+		logsPrefix:   "doctor-",
+		config:       flagsOnlyConfig,
+		stdio:        stdio,
+		run:          doctor,
+	}, {
+		flag:         "--build-history",
+		description:  "print the trends of the builds recorded in the local build history",
+		simpleOutput: true,
+		logsPrefix:   "build_history-",
+		config:       buildHistoryConfig,
+		stdio:        stdio,
+		run:          buildHistory,
 	}, {
 		flag:        "--upload-metrics-only",
 		description: "upload metrics without building anything",
@@ -167,6 +218,16 @@ func main() {
 	trace := tracer.New(log)
 	defer trace.Close()
 
+	// The build is recorded in the local build history and exported as
+	// OpenTelemetry spans once the status outputs and metrics have been
+	// written, so this must be deferred before stat.Finish and met.Dump.
+	var recordBuild []func()
+	defer func() {
+		for _, record := range recordBuild {
+			record()
+		}
+	}()
+
 	// Create a new Status instance, which manages action counts and event output channels.
 	stat := &status.Status{}
 	defer stat.Finish()
@@ -223,6 +284,20 @@ func main() {
 	}
 	defer met.Dump(soongMetricsFile)
 
+	if c.buildHistory {
+		recordBuild = append(recordBuild, func() {
+			build.RecordBuildHistory(buildCtx, config, buildStarted)
+		})
+		if build.OtlpTraceEnabled(config.Environment()) {
+			traceParent, _ := config.Environment().Get("TRACEPARENT")
+			otlp := tracer.NewOtlpExporter(traceParent)
+			stat.AddOutput(otlp.StatusOutput())
+			recordBuild = append(recordBuild, func() {
+				build.ExportOtlpTrace(buildCtx, config, otlp, buildStarted)
+			})
+		}
+	}
+
 	c.run(buildCtx, config, args)
 
 }
@@ -252,8 +327,14 @@ func logAndSymlinkSetup(buildCtx build.Context, config build.Config) {
 	stat.AddOutput(status.NewVerboseLog(log, filepath.Join(logsDir, logsPrefix+"verbose.log")))
 	stat.AddOutput(status.NewErrorLog(log, filepath.Join(logsDir, logsPrefix+"error.log")))
 	stat.AddOutput(status.NewProtoErrorLog(log, buildErrorFile))
-	stat.AddOutput(status.NewCriticalPath(log))
+	stat.AddOutput(status.NewRebuildReasonsLog(log, filepath.Join(logsDir, logsPrefix+"rebuild_reasons.pb")))
+	stat.AddOutput(status.NewCriticalPath(log, status.CriticalPathFiles{
+		History:       filepath.Join(config.OutDir(), logsPrefix+"action_history"),
+		ModuleActions: config.ModuleActionsFile(),
+		ModuleReport:  filepath.Join(logsDir, logsPrefix+"critical_path_report.json"),
+	}))
 	stat.AddOutput(status.NewBuildProgressLog(log, filepath.Join(logsDir, logsPrefix+"build_progress.pb")))
+	stat.AddOutput(status.NewEventSocket(log, filepath.Join(config.OutDir(), logsPrefix+"build_events.sock")))
 
 	buildCtx.Verbosef("Detected %.3v GB total RAM", float32(config.TotalRAM())/(1024*1024*1024))
 	buildCtx.Verbosef("Parallelism (local/remote/highmem): %v/%v/%v",
@@ -307,6 +388,11 @@ func fixBadDanglingLink(ctx build.Context, name string) {
 
 func dumpVar(ctx build.Context, config build.Config, args []string) {
 	logAndSymlinkSetup(ctx, config)
+
+	// Run concurrently with other read-only commands and with builds, except
+	// while a build regenerates the product config.
+	lock := build.BecomeSharedOrFail(ctx, config)
+	defer lock.Unlock()
 	flags := flag.NewFlagSet("dumpvar", flag.ExitOnError)
 	flags.SetOutput(ctx.Writer)
 
@@ -361,6 +447,11 @@ func dumpVar(ctx build.Context, config build.Config, args []string) {
 func dumpVars(ctx build.Context, config build.Config, args []string) {
 	logAndSymlinkSetup(ctx, config)
 
+	// Run concurrently with other read-only commands and with builds, except
+	// while a build regenerates the product config.
+	lock := build.BecomeSharedOrFail(ctx, config)
+	defer lock.Unlock()
+
 	flags := flag.NewFlagSet("dumpvars", flag.ExitOnError)
 	flags.SetOutput(ctx.Writer)
 
@@ -383,50 +474,113 @@ func dumpVars(ctx build.Context, config build.Config, args []string) {
 	varPrefix := flags.String("var-prefix", "", "String to prepend to all variable names when dumping")
 	absVarPrefix := flags.String("abs-var-prefix", "", "String to prepent to all absolute path variable names when dumping")
 
+	format := flags.String("format", "shell", "Output format, either shell or json")
+
 	flags.Parse(args)
 
 	if flags.NArg() != 0 {
 		flags.Usage()
 		ctx.Fatalf("Invalid usage")
 	}
+	if *format != "shell" && *format != "json" {
+		flags.Usage()
+		ctx.Fatalf("Invalid format %q", *format)
+	}
+	if *format == "json" && (*varPrefix != "" || *absVarPrefix != "") {
+		ctx.Fatalf("--var-prefix and --abs-var-prefix can't be used with --format=json")
+	}
 
 	vars := strings.Fields(*varsStr)
 	absVars := strings.Fields(*absVarsStr)
 
-	allVars := append([]string{}, vars...)
-	allVars = append(allVars, absVars...)
+	allVars := build.DumpVarsNames(vars, absVars)
 
-	if i := indexList("report_config", allVars); i != -1 {
-		allVars = append(allVars[:i], allVars[i+1:]...)
-		allVars = append(allVars, build.BannerVars...)
+	if len(allVars) == 0 && *format == "shell" {
+		return
 	}
 
-	if len(allVars) == 0 {
-		return
+	varData := map[string]string{}
+	if len(allVars) > 0 {
+		var err error
+		varData, err = build.DumpMakeVars(ctx, config, nil, allVars)
+		if err != nil {
+			ctx.Fatal(err)
+		}
 	}
 
-	varData, err := build.DumpMakeVars(ctx, config, nil, allVars)
+	resp, err := build.NewDumpVarsResponse(varData, vars, absVars)
 	if err != nil {
-		ctx.Fatal(err)
+		ctx.Fatalln(err)
 	}
 
-	for _, name := range vars {
-		if name == "report_config" {
-			fmt.Printf("%sreport_config='%s'\n", *varPrefix, build.Banner(varData))
-		} else {
-			fmt.Printf("%s%s='%s'\n", *varPrefix, name, varData[name])
+	if *format == "json" {
+		data, err := json.MarshalIndent(resp, "", "  ")
+		if err != nil {
+			ctx.Fatal(err)
 		}
+		fmt.Println(string(data))
+		return
+	}
+
+	for _, name := range vars {
+		fmt.Printf("%s%s='%s'\n", *varPrefix, name, resp.Vars[name])
 	}
 	for _, name := range absVars {
-		var res []string
-		for _, path := range strings.Fields(varData[name]) {
-			abs, err := filepath.Abs(path)
-			if err != nil {
-				ctx.Fatalln("Failed to get absolute path of", path, err)
-			}
-			res = append(res, abs)
+		fmt.Printf("%s%s='%s'\n", *absVarPrefix, name, resp.AbsVars[name])
+	}
+}
+
+func dumpVarsServer(ctx build.Context, config build.Config, args []string) {
+	logAndSymlinkSetup(ctx, config)
+
+	flags := flag.NewFlagSet("dumpvars-server", flag.ExitOnError)
+	flags.SetOutput(ctx.Writer)
+
+	flags.Usage = func() {
+		fmt.Fprintf(ctx.Writer, "usage: %s --dumpvars-server-mode [--socket=PATH] [--idle-timeout=DURATION] [--preload=\"VAR VAR ...\"]\n\n", os.Args[0])
+		fmt.Fprintln(ctx.Writer, "In dumpvars server mode, answer queries for the values of legacy make variables.")
+		fmt.Fprintln(ctx.Writer, "Each query is a line of JSON like {\"vars\": [\"TARGET_PRODUCT\"], \"abs_vars\": [\"OUT_DIR\"]},")
+		fmt.Fprintln(ctx.Writer, "and is answered with a line in the format of --dumpvars-mode --format=json, with")
+		fmt.Fprintln(ctx.Writer, "an \"error\" field if product config failed.")
+		fmt.Fprintln(ctx.Writer, "")
+		fmt.Fprintln(ctx.Writer, "Product config runs once for all the variables queried so far, and only runs")
+		fmt.Fprintln(ctx.Writer, "again when a query asks for a new variable, when a build regenerates the product")
+		fmt.Fprintln(ctx.Writer, "config, or when a query adds \"reload\": true. A reload also forgets the variables")
+		fmt.Fprintln(ctx.Writer, "of earlier queries.")
+		fmt.Fprintln(ctx.Writer, "")
+		fmt.Fprintln(ctx.Writer, "Queries are read from stdin until it is closed, or from the clients of a Unix")
+		fmt.Fprintln(ctx.Writer, "domain socket until no queries are received for the idle timeout.")
+		fmt.Fprintln(ctx.Writer, "")
+		flags.PrintDefaults()
+	}
+
+	socket := flags.String("socket", "", "Unix domain socket to listen on instead of stdin and stdout")
+	idleTimeout := flags.Duration("idle-timeout", 10*time.Minute, "Exit after no queries have been received on the socket for this long")
+	preload := flags.String("preload", "", "Space-separated list of variables to dump before answering queries")
+
+	flags.Parse(args)
+
+	if flags.NArg() != 0 {
+		flags.Usage()
+		ctx.Fatalf("Invalid usage")
+	}
+
+	server := build.NewDumpVarsServer(ctx, config)
+	if vars := strings.Fields(*preload); len(vars) > 0 {
+		if err := server.Preload(vars); err != nil {
+			ctx.Fatal(err)
 		}
-		fmt.Printf("%s%s='%s'\n", *absVarPrefix, name, strings.Join(res, " "))
+	}
+
+	var err error
+	if *socket != "" {
+		ctx.Verbosef("Answering dumpvars queries on %s", *socket)
+		err = server.ServeSocket(*socket, *idleTimeout)
+	} else {
+		err = server.Serve(os.Stdin, os.Stdout)
+	}
+	if err != nil {
+		ctx.Fatal(err)
 	}
 }
 
@@ -445,6 +599,17 @@ func dumpVarConfig(ctx build.Context, args ...string) build.Config {
 	return build.NewConfig(ctx)
 }
 
+// flagsOnlyConfig does not require any arguments to be parsed by the
+// NewConfig, for commands that parse their own flags in run.
+func flagsOnlyConfig(ctx build.Context, args ...string) build.Config {
+	return build.NewConfig(ctx)
+}
+
+// buildHistoryConfig does not require a product, only the environment.
+func buildHistoryConfig(ctx build.Context, args ...string) build.Config {
+	return build.UploadOnlyConfig(ctx)
+}
+
 // uploadOnlyConfig explicitly requires no arguments.
 func uploadOnlyConfig(ctx build.Context, args ...string) build.Config {
 	if len(args) > 0 {
@@ -575,6 +740,129 @@ func runMake(ctx build.Context, config build.Config, _ []string) {
 	build.Build(ctx, config)
 }
 
+func retryFailed(ctx build.Context, config build.Config, _ []string) {
+	// logAndSymlinkSetup removes the errors of the previous build, so read
+	// them first.
+	buildErrorFile := filepath.Join(config.LogsDir(), config.GetLogsPrefix()+"build_error")
+	failedOutputs, err := build.ReadFailedOutputs(buildErrorFile)
+	if os.IsNotExist(err) {
+		ctx.Println("No errors were recorded for the previous build, nothing to retry.")
+		return
+	} else if err != nil {
+		ctx.Fatalln("Failed to read the errors of the previous build:", err)
+	}
+
+	logAndSymlinkSetup(ctx, config)
+	build.RetryFailed(ctx, config, failedOutputs)
+}
+
+func verifyNoop(ctx build.Context, config build.Config, _ []string) {
+	runMake(ctx, config, nil)
+
+	report := build.VerifyNoop(ctx, config, filepath.Join(config.LogsDir(), "noop_report.json"))
+	if !report.Clean() {
+		ctx.Fatalf("The build is not a no-op, %d actions would run again", report.ActionCount)
+	}
+}
+
+func gcOut(ctx build.Context, config build.Config, args []string) {
+	logAndSymlinkSetup(ctx, config)
+
+	flags := flag.NewFlagSet("gc-out", flag.ExitOnError)
+	flags.SetOutput(ctx.Writer)
+
+	flags.Usage = func() {
+		fmt.Fprintf(ctx.Writer, "usage: %s --gc-out [--dry-run]\n\n", os.Args[0])
+		fmt.Fprintln(ctx.Writer, "In gc-out mode, remove the intermediates under out/soong/.intermediates")
+		fmt.Fprintln(ctx.Writer, "and out/target that are no longer produced by the build graph of the")
+		fmt.Fprintln(ctx.Writer, "most recent build, for example because the module that produced them")
+		fmt.Fprintln(ctx.Writer, "was renamed or removed. Installed files are left to installclean. Run")
+		fmt.Fprintln(ctx.Writer, "it after a build of the current product.")
+		fmt.Fprintln(ctx.Writer, "")
+		flags.PrintDefaults()
+	}
+	dryRun := flags.Bool("dry-run", false, "Only report the files that would be removed, with their sizes")
+	flags.Parse(args)
+
+	if flags.NArg() != 0 {
+		flags.Usage()
+		ctx.Fatalf("Invalid usage")
+	}
+
+	build.GarbageCollectOutDir(ctx, config, *dryRun)
+}
+
+func doctor(ctx build.Context, config build.Config, args []string) {
+	flags := flag.NewFlagSet("doctor", flag.ExitOnError)
+	flags.SetOutput(ctx.Writer)
+
+	flags.Usage = func() {
+		fmt.Fprintf(ctx.Writer, "usage: %s --doctor [--results=FILE]\n\n", os.Args[0])
+		fmt.Fprintln(ctx.Writer, "In doctor mode, check the host for conditions that are known to break")
+		fmt.Fprintln(ctx.Writer, "builds, like low limits, a full disk or missing prebuilts, and print how")
+		fmt.Fprintln(ctx.Writer, "to fix each problem that is found.")
+		fmt.Fprintln(ctx.Writer, "")
+		flags.PrintDefaults()
+	}
+	results := flags.String("results", filepath.Join(config.LogsDir(), "doctor.json"), "File to write the results of the checks to, as JSON")
+	flags.Parse(args)
+
+	if flags.NArg() != 0 {
+		flags.Usage()
+		ctx.Fatalf("Invalid usage")
+	}
+
+	if !build.RunDoctor(ctx, config, *results) {
+		ctx.Fatalln("Found problems that will break the build")
+	}
+}
+
+func buildHistory(ctx build.Context, config build.Config, args []string) {
+	flags := flag.NewFlagSet("build-history", flag.ExitOnError)
+	flags.SetOutput(ctx.Writer)
+
+	flags.Usage = func() {
+		fmt.Fprintf(ctx.Writer, "usage: %s --build-history [--last=N] [--goals=GOALS] [--product=PRODUCT] [--json]\n\n", os.Args[0])
+		fmt.Fprintln(ctx.Writer, "Print the builds recorded in the local build history, how the time of")
+		fmt.Fprintln(ctx.Writer, "analysis, ninja and the critical path changed over time, and the slowest")
+		fmt.Fprintln(ctx.Writer, "phases of the builds of each goal. Every build is recorded in")
+		fmt.Fprintln(ctx.Writer, "$SOONG_BUILD_HISTORY_DIR, or ~/.cache/soong/build_history, unless")
+		fmt.Fprintln(ctx.Writer, "SOONG_DISABLE_BUILD_HISTORY=true.")
+		fmt.Fprintln(ctx.Writer, "")
+		flags.PrintDefaults()
+	}
+	last := flags.Int("last", 20, "Number of most recent builds to show, or 0 for all of them")
+	goals := flags.String("goals", "", "Only show the builds of these space separated goals")
+	product := flags.String("product", "", "Only show the builds of this product, or product-variant")
+	jsonOutput := flags.Bool("json", false, "Print the builds as JSON instead of the trends")
+	flags.Parse(args)
+
+	if flags.NArg() != 0 {
+		flags.Usage()
+		ctx.Fatalf("Invalid usage")
+	}
+
+	dir := build.BuildHistoryDir(config.Environment())
+	if dir == "" {
+		ctx.Fatalln("The build history is disabled")
+	}
+	entries, err := build.ReadBuildHistory(dir)
+	if err != nil {
+		ctx.Fatalf("Failed to read the build history: %v", err)
+	}
+	entries = build.FilterBuildHistory(entries, *goals, *product, *last)
+
+	if *jsonOutput {
+		data, err := json.MarshalIndent(entries, "", "  ")
+		if err != nil {
+			ctx.Fatal(err)
+		}
+		fmt.Fprintln(ctx.Writer, string(data))
+		return
+	}
+	build.PrintBuildHistoryTrends(ctx.Writer, entries)
+}
+
 // getCommand finds the appropriate command based on args[1] flag. args[0]
 // is the soong_ui filename.
 func getCommand(args []string) (*command, []string, error) {
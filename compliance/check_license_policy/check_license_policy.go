@@ -0,0 +1,265 @@
+// Copyright 2023 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// check_license_policy checks the license metadata of an installed module,
+// written by build_license_metadata, against the license policy:
+//   - a module with proprietary license conditions that is installed on the
+//     vendor partition (-vendor) must not statically link a module with
+//     restricted or restricted_if_statically_linked license conditions.
+//   - a module with by_exception_only license conditions must be listed in an
+//     allowlist (-allowlist) to be installed or statically linked into an
+//     installed module.
+//
+// Allowlists list one module name per line, text after a # is a comment.
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/prototext"
+
+	"android/soong/compliance/license_metadata_proto"
+)
+
+func newMultiString(flags *flag.FlagSet, name, usage string) *multiString {
+	var f multiString
+	flags.Var(&f, name, usage)
+	return &f
+}
+
+type multiString []string
+
+func (ms *multiString) String() string     { return strings.Join(*ms, ", ") }
+func (ms *multiString) Set(s string) error { *ms = append(*ms, s); return nil }
+
+func main() {
+	flags := flag.NewFlagSet("flags", flag.ExitOnError)
+
+	outFile := flags.String("o", "", "stamp file written when the module complies with the policy")
+	depFile := flags.String("d", "", "depfile listing the license metadata files that were read")
+	vendor := flags.Bool("vendor", false, "the module is installed on the vendor or odm partition")
+	allowlists := newMultiString(flags, "allowlist", "file listing the modules allowed to have by_exception_only license conditions")
+
+	flags.Parse(os.Args[1:])
+
+	if flags.NArg() != 1 || *outFile == "" {
+		fmt.Fprintf(os.Stderr, "usage: check_license_policy -o <stamp> [-d <depfile>] [-vendor] [-allowlist <file>]... <meta_lic>\n")
+		os.Exit(1)
+	}
+
+	allowed := make(map[string]bool)
+	for _, file := range *allowlists {
+		if err := readAllowlist(file, allowed); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %s\n", err.Error())
+			os.Exit(2)
+		}
+	}
+
+	p := &policy{vendor: *vendor, allowed: allowed, read: readMetadata}
+	violations, err := p.check(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %s\n", err.Error())
+		os.Exit(2)
+	}
+	if len(violations) > 0 {
+		fmt.Fprintf(os.Stderr, "%s violates the license policy:\n", flags.Arg(0))
+		for _, v := range violations {
+			fmt.Fprintf(os.Stderr, "  %s\n", v)
+		}
+		os.Exit(1)
+	}
+
+	if *depFile != "" {
+		deps := fmt.Sprintf("%s: %s\n", *outFile, strings.Join(p.files, " "))
+		if err := ioutil.WriteFile(*depFile, []byte(deps), 0666); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %s\n", err.Error())
+			os.Exit(2)
+		}
+	}
+	if err := ioutil.WriteFile(*outFile, nil, 0666); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %s\n", err.Error())
+		os.Exit(2)
+	}
+}
+
+// readAllowlist adds the module names listed in an allowlist to allowed.
+func readAllowlist(file string, allowed map[string]bool) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		if line = strings.TrimSpace(line); line != "" {
+			allowed[line] = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("error reading %q: %w", file, err)
+	}
+	return nil
+}
+
+func readMetadata(file string) (*license_metadata_proto.LicenseMetadata, error) {
+	buf, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("error reading textproto %q: %w", file, err)
+	}
+	metadata := &license_metadata_proto.LicenseMetadata{}
+	if err := prototext.Unmarshal(buf, metadata); err != nil {
+		return nil, fmt.Errorf("error parsing textproto %q: %w", file, err)
+	}
+	return metadata, nil
+}
+
+type policy struct {
+	// vendor is true if the module is installed on the vendor partition.
+	vendor bool
+
+	// allowed are the modules that may have by_exception_only license
+	// conditions.
+	allowed map[string]bool
+
+	read func(file string) (*license_metadata_proto.LicenseMetadata, error)
+
+	// files are the license metadata files that were read.
+	files []string
+}
+
+// isDynamic returns true if a dependency isn't linked into the module that
+// depends on it.
+func isDynamic(dep *license_metadata_proto.AnnotatedDependency) bool {
+	for _, annotation := range dep.Annotations {
+		if annotation == "dynamic" || annotation == "toolchain" {
+			return true
+		}
+	}
+	return false
+}
+
+func hasCondition(metadata *license_metadata_proto.LicenseMetadata, conditions ...string) bool {
+	for _, c := range metadata.LicenseConditions {
+		for _, condition := range conditions {
+			if c == condition {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func moduleName(file string, metadata *license_metadata_proto.LicenseMetadata) string {
+	if name := metadata.GetModuleName(); name != "" {
+		return name
+	}
+	return file
+}
+
+// check returns the violations of the license policy by the module with the
+// given license metadata file and the modules that it statically links.
+func (p *policy) check(file string) ([]string, error) {
+	root, err := p.read(file)
+	if err != nil {
+		return nil, err
+	}
+	p.files = append(p.files, file)
+	rootName := moduleName(file, root)
+	proprietaryVendor := p.vendor && hasCondition(root, "proprietary")
+
+	var violations []string
+	checkByExceptionOnly := func(name string, metadata *license_metadata_proto.LicenseMetadata, path []string) {
+		if hasCondition(metadata, "by_exception_only") && !p.allowed[name] {
+			violations = append(violations, fmt.Sprintf("%s has by_exception_only license conditions%s "+
+				"and isn't in a license policy allowlist%s", name, kinds(metadata), via(path)))
+		}
+	}
+	checkByExceptionOnly(rootName, root, nil)
+
+	// The modules in a container are checked on their own when they are
+	// installed.
+	if root.GetIsContainer() {
+		return violations, nil
+	}
+
+	type queued struct {
+		file string
+		path []string
+	}
+	var queue []queued
+	seen := map[string]bool{file: true}
+	enqueue := func(metadata *license_metadata_proto.LicenseMetadata, path []string) {
+		for _, dep := range metadata.Deps {
+			if isDynamic(dep) || seen[dep.GetFile()] {
+				continue
+			}
+			seen[dep.GetFile()] = true
+			queue = append(queue, queued{dep.GetFile(), path})
+		}
+	}
+	enqueue(root, []string{rootName})
+
+	for len(queue) > 0 {
+		q := queue[0]
+		queue = queue[1:]
+
+		metadata, err := p.read(q.file)
+		if err != nil {
+			return nil, err
+		}
+		p.files = append(p.files, q.file)
+		name := moduleName(q.file, metadata)
+		path := append(append([]string(nil), q.path...), name)
+
+		if proprietaryVendor && hasCondition(metadata, "restricted", "restricted_if_statically_linked") {
+			violations = append(violations, fmt.Sprintf("%s has proprietary license conditions and is installed "+
+				"on the vendor partition, but statically links %s with restricted license conditions%s%s",
+				rootName, name, kinds(metadata), via(path)))
+		}
+		checkByExceptionOnly(name, metadata, path)
+
+		enqueue(metadata, path)
+	}
+
+	sort.Strings(violations)
+	return violations, nil
+}
+
+// kinds returns the license kinds of a module to add to a violation.
+func kinds(metadata *license_metadata_proto.LicenseMetadata) string {
+	if len(metadata.LicenseKinds) == 0 {
+		return ""
+	}
+	return " (" + strings.Join(metadata.LicenseKinds, ", ") + ")"
+}
+
+// via returns a description of the dependency path to a module that is
+// statically linked through other modules.
+func via(path []string) string {
+	if len(path) <= 2 {
+		return ""
+	}
+	return " via " + strings.Join(path, " -> ")
+}
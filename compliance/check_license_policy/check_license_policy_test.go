@@ -0,0 +1,120 @@
+// Copyright 2023 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"google.golang.org/protobuf/encoding/prototext"
+
+	"android/soong/compliance/license_metadata_proto"
+)
+
+var testMetadata = map[string]string{
+	"vendor_bin.meta_lic": `
+		module_name: "vendor_bin"
+		license_conditions: "proprietary"
+		deps: { file: "libgpl.meta_lic" }
+		deps: { file: "libdynamic_gpl.meta_lic" annotations: "dynamic" }
+		deps: { file: "libnotice.meta_lic" }`,
+	"libgpl.meta_lic": `
+		module_name: "libgpl"
+		license_kinds: "SPDX-license-identifier-GPL-2.0"
+		license_conditions: "restricted"`,
+	"libdynamic_gpl.meta_lic": `
+		module_name: "libdynamic_gpl"
+		license_kinds: "SPDX-license-identifier-GPL-2.0"
+		license_conditions: "restricted"`,
+	"libnotice.meta_lic": `
+		module_name: "libnotice"
+		license_conditions: "notice"
+		deps: { file: "libexception.meta_lic" annotations: "static" }`,
+	"libexception.meta_lic": `
+		module_name: "libexception"
+		license_kinds: "SPDX-license-identifier-CPL-1.0"
+		license_conditions: "by_exception_only"
+		license_conditions: "not_allowed"`,
+	"image.meta_lic": `
+		module_name: "image"
+		is_container: true
+		deps: { file: "libgpl.meta_lic" }`,
+}
+
+func readTestMetadata(file string) (*license_metadata_proto.LicenseMetadata, error) {
+	text, ok := testMetadata[file]
+	if !ok {
+		return nil, fmt.Errorf("%s not found", file)
+	}
+	metadata := &license_metadata_proto.LicenseMetadata{}
+	if err := prototext.Unmarshal([]byte(text), metadata); err != nil {
+		return nil, err
+	}
+	return metadata, nil
+}
+
+func TestCheck(t *testing.T) {
+	const (
+		restricted = "vendor_bin has proprietary license conditions and is installed on the vendor partition, " +
+			"but statically links libgpl with restricted license conditions (SPDX-license-identifier-GPL-2.0)"
+		byExceptionOnly = "libexception has by_exception_only license conditions (SPDX-license-identifier-CPL-1.0) " +
+			"and isn't in a license policy allowlist via vendor_bin -> libnotice -> libexception"
+	)
+
+	testCases := []struct {
+		name    string
+		file    string
+		vendor  bool
+		allowed map[string]bool
+		want    []string
+	}{
+		{
+			name:   "vendor",
+			file:   "vendor_bin.meta_lic",
+			vendor: true,
+			want:   []string{byExceptionOnly, restricted},
+		},
+		{
+			name:    "allowlisted",
+			file:    "vendor_bin.meta_lic",
+			vendor:  true,
+			allowed: map[string]bool{"libexception": true},
+			want:    []string{restricted},
+		},
+		{
+			name: "system",
+			file: "vendor_bin.meta_lic",
+			want: []string{byExceptionOnly},
+		},
+		{
+			name:   "container",
+			file:   "image.meta_lic",
+			vendor: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			p := &policy{vendor: tc.vendor, allowed: tc.allowed, read: readTestMetadata}
+			got, err := p.check(tc.file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("expected violations:\n%q\ngot:\n%q", tc.want, got)
+			}
+		})
+	}
+}
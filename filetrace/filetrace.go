@@ -0,0 +1,88 @@
+// Copyright 2023 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package filetrace records the files that a command and its descendants
+// access. It is used by sbox to audit the undeclared inputs of RuleBuilder
+// actions, and by soong_ui to report the accesses of a sandboxed phase of the
+// build to the paths that the sandbox policy restricts.
+package filetrace
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"strconv"
+	"syscall"
+)
+
+var (
+	ErrUnsupported   = errors.New("tracing file accesses is only supported on Linux")
+	ErrAlreadyTraced = errors.New("tracing file accesses is not possible in a process that is already traced")
+)
+
+// Access is a file that was accessed by a traced command.
+type Access struct {
+	// Path is the absolute path to the file.
+	Path  string `json:"path"`
+	Write bool   `json:"write,omitempty"`
+
+	// Errno is the error of the system call that accessed the file, or 0 if
+	// it succeeded.
+	Errno syscall.Errno `json:"errno,omitempty"`
+}
+
+// Succeeded returns a filter for Trace that only records the accesses of the
+// system calls that succeeded.
+func Succeeded(access Access) bool {
+	return access.Errno == 0
+}
+
+// ExitError is returned by Trace when the command fails, like exec.ExitError is
+// returned by exec.Cmd.Run.
+type ExitError struct {
+	Status syscall.WaitStatus
+}
+
+func (e *ExitError) Error() string {
+	if e.Status.Signaled() {
+		return "signal: " + e.Status.Signal().String()
+	}
+	return "exit status " + strconv.Itoa(e.Status.ExitStatus())
+}
+
+func (e *ExitError) ExitCode() int {
+	return e.Status.ExitStatus()
+}
+
+// WriteFile writes a list of accesses to a file that can be read by ReadFile.
+func WriteFile(file string, accesses []Access) error {
+	data, err := json.Marshal(accesses)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, data, 0666)
+}
+
+// ReadFile reads a list of accesses written by WriteFile.
+func ReadFile(file string) ([]Access, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var accesses []Access
+	if err := json.Unmarshal(data, &accesses); err != nil {
+		return nil, err
+	}
+	return accesses, nil
+}
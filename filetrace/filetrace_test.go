@@ -0,0 +1,40 @@
+// Copyright 2023 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filetrace
+
+import (
+	"path/filepath"
+	"reflect"
+	"syscall"
+	"testing"
+)
+
+func TestAccessesFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "accesses.json")
+	want := []Access{
+		{Path: "/src/foo"},
+		{Path: "/src/bar", Write: true, Errno: syscall.EROFS},
+	}
+	if err := WriteFile(file, want); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
@@ -0,0 +1,439 @@
+// Copyright 2023 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filetrace
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// The tracer uses ptrace to stop the command and all of its descendants at the entry and exit
+// of every system call, and records the files that were opened, executed or renamed to.
+// PTRACE_GET_SYSCALL_INFO (Linux 5.3) is used to read the system call number and arguments so
+// that the tracer doesn't need to know the register layout of the traced architecture.
+
+const (
+	ptraceGetSyscallInfo = 0x420e
+	ptraceOExitKill      = 0x100000
+
+	ptraceSyscallInfoEntry = 1
+	ptraceSyscallInfoExit  = 2
+
+	auditArchI386    = 0x40000003
+	auditArchX86_64  = 0xc000003e
+	auditArchAarch64 = 0xc00000b7
+
+	atFdcwd = -100
+	pathMax = 4096
+
+	// wNothread makes wait4 only wait for the children and tracees of the calling thread.
+	wNothread = 0x20000000
+)
+
+// ptraceSyscallInfo matches struct ptrace_syscall_info.  Data holds the entry.nr and
+// entry.args fields for a system call entry stop, and the exit.rval and exit.is_error
+// fields for a system call exit stop.
+type ptraceSyscallInfo struct {
+	Op                 uint8
+	_                  [3]uint8
+	Arch               uint32
+	InstructionPointer uint64
+	StackPointer       uint64
+	Data               [8]uint64
+}
+
+type syscallKind int
+
+const (
+	sysOpen     syscallKind = iota // open(path, flags)
+	sysOpenat                      // openat(dirfd, path, flags)
+	sysOpenat2                     // openat2(dirfd, path, how)
+	sysCreat                       // creat(path)
+	sysExecve                      // execve(path)
+	sysExecveat                    // execveat(dirfd, path)
+	sysRename                      // rename(oldpath, newpath)
+	sysRenameat                    // renameat(olddirfd, oldpath, newdirfd, newpath)
+)
+
+// tracedSyscalls maps the system call numbers of each architecture to the system calls that
+// access files.
+var tracedSyscalls = map[uint32]map[uint64]syscallKind{
+	auditArchX86_64: {
+		2: sysOpen, 257: sysOpenat, 437: sysOpenat2, 85: sysCreat, 59: sysExecve,
+		322: sysExecveat, 82: sysRename, 264: sysRenameat, 316: sysRenameat,
+	},
+	auditArchI386: {
+		5: sysOpen, 295: sysOpenat, 437: sysOpenat2, 8: sysCreat, 11: sysExecve,
+		358: sysExecveat, 38: sysRename, 302: sysRenameat, 353: sysRenameat,
+	},
+	auditArchAarch64: {
+		56: sysOpenat, 437: sysOpenat2, 221: sysExecve, 281: sysExecveat,
+		38: sysRenameat, 276: sysRenameat,
+	},
+}
+
+// Trace runs cmd and returns the files that it and its descendants accessed.  Only the accesses
+// that filter returns true for are recorded, all of them if filter is nil.  The command must not
+// have been started, and it is reaped by Trace, so cmd.Wait must not be called.  If the command
+// fails the error is an *ExitError.
+func Trace(cmd *exec.Cmd, filter func(Access) bool) ([]Access, error) {
+	if alreadyTraced() {
+		return nil, ErrAlreadyTraced
+	}
+
+	// All ptrace requests have to be made from the thread that started the command.
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	// exec.Cmd copies the output of the command to writers that aren't files in goroutines
+	// that are only waited for by exec.Cmd.Wait, which can't be used because the command is
+	// reaped by the tracer.  Use pipes and copy the output here instead.
+	var copiers []chan error
+	var pipeWriters []*os.File
+	pipeTo := func(w io.Writer) (io.Writer, error) {
+		if w == nil {
+			return nil, nil
+		}
+		if f, ok := w.(*os.File); ok {
+			return f, nil
+		}
+		pr, pw, err := os.Pipe()
+		if err != nil {
+			return nil, err
+		}
+		copyErr := make(chan error, 1)
+		go func() {
+			_, err := io.Copy(w, pr)
+			pr.Close()
+			copyErr <- err
+		}()
+		copiers = append(copiers, copyErr)
+		pipeWriters = append(pipeWriters, pw)
+		return pw, nil
+	}
+	closePipes := func() {
+		for _, pw := range pipeWriters {
+			pw.Close()
+		}
+		pipeWriters = nil
+	}
+	waitForCopies := func() error {
+		closePipes()
+		var ret error
+		for _, copyErr := range copiers {
+			if err := <-copyErr; err != nil && ret == nil {
+				ret = err
+			}
+		}
+		return ret
+	}
+
+	stdout, err := pipeTo(cmd.Stdout)
+	if err != nil {
+		waitForCopies()
+		return nil, err
+	}
+	stderr := stdout
+	if cmd.Stderr != cmd.Stdout {
+		if stderr, err = pipeTo(cmd.Stderr); err != nil {
+			waitForCopies()
+			return nil, err
+		}
+	}
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Ptrace = true
+
+	err = cmd.Start()
+	// Only the command holds the write ends of the pipes now.
+	closePipes()
+	if err != nil {
+		waitForCopies()
+		return nil, err
+	}
+
+	t := &tracer{
+		filter:  filter,
+		pending: make(map[int][]Access),
+		seen:    make(map[int]bool),
+	}
+	status, err := t.trace(cmd.Process.Pid)
+	if err != nil {
+		// Kill the command, it is killed anyway when the tracer exits because of
+		// PTRACE_O_EXITKILL.
+		cmd.Process.Kill()
+		return nil, err
+	}
+	if err := waitForCopies(); err != nil {
+		return nil, err
+	}
+	if t.err != nil {
+		fmt.Fprintf(os.Stderr, "failed to trace file accesses: %s\n", t.err)
+	}
+	if !status.Exited() || status.ExitStatus() != 0 {
+		return t.accesses, &ExitError{status}
+	}
+	return t.accesses, nil
+}
+
+// alreadyTraced returns true if this process is traced, in which case the commands that it
+// starts are traced by the same tracer and can't be traced again.
+func alreadyTraced() bool {
+	data, err := ioutil.ReadFile("/proc/self/status")
+	if err != nil {
+		return false
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.HasPrefix(line, "TracerPid:") {
+			return strings.TrimSpace(strings.TrimPrefix(line, "TracerPid:")) != "0"
+		}
+	}
+	return false
+}
+
+type tracer struct {
+	// filter selects the accesses to record, all of them if it is nil.
+	filter func(Access) bool
+	// accesses are the file accesses of system calls that finished.
+	accesses []Access
+	// pending are the file accesses of the system call that each process is in.
+	pending map[int][]Access
+	// seen are the processes that have stopped at least once.
+	seen map[int]bool
+	// err is set if reading a system call failed, file accesses are no longer recorded after
+	// that.
+	err error
+}
+
+// trace traces the process with the given pid, which must be stopped after PTRACE_TRACEME and
+// exec, and all of its descendants until they have all exited.  It returns the status of pid.
+func (t *tracer) trace(pid int) (syscall.WaitStatus, error) {
+	var status syscall.WaitStatus
+	if _, err := syscall.Wait4(pid, &status, syscall.WALL, nil); err != nil {
+		return status, err
+	}
+	if !status.Stopped() {
+		return status, nil
+	}
+	t.seen[pid] = true
+
+	options := syscall.PTRACE_O_TRACESYSGOOD | syscall.PTRACE_O_TRACEFORK | syscall.PTRACE_O_TRACEVFORK |
+		syscall.PTRACE_O_TRACECLONE | syscall.PTRACE_O_TRACEEXEC | ptraceOExitKill
+	if err := syscall.PtraceSetOptions(pid, options); err != nil {
+		return status, fmt.Errorf("failed to set ptrace options: %w", err)
+	}
+	if err := syscall.PtraceSyscall(pid, 0); err != nil {
+		return status, err
+	}
+
+	for {
+		var ws syscall.WaitStatus
+		wpid, err := syscall.Wait4(-1, &ws, syscall.WALL|wNothread, nil)
+		if err == syscall.EINTR {
+			continue
+		} else if err == syscall.ECHILD {
+			// All traced processes have exited.
+			return status, nil
+		} else if err != nil {
+			return status, err
+		}
+
+		if ws.Exited() || ws.Signaled() {
+			delete(t.pending, wpid)
+			if wpid == pid {
+				status = ws
+			}
+			continue
+		}
+		if !ws.Stopped() {
+			continue
+		}
+
+		signal := 0
+		switch sig := ws.StopSignal(); {
+		case sig == syscall.SIGTRAP|0x80:
+			t.syscallStop(wpid)
+		case sig == syscall.SIGTRAP && ws.TrapCause() > 0:
+			// A fork, clone or exec event, the new processes are traced automatically.
+		case sig == syscall.SIGSTOP && !t.seen[wpid]:
+			// The initial stop of a new process.
+		default:
+			signal = int(sig)
+		}
+		t.seen[wpid] = true
+
+		// The process may have been killed in the meantime, ignore the error.
+		syscall.PtraceSyscall(wpid, signal)
+	}
+}
+
+// syscallStop records the file accesses of a process that is stopped at the entry or exit of
+// a system call.
+func (t *tracer) syscallStop(pid int) {
+	if t.err != nil {
+		return
+	}
+	var info ptraceSyscallInfo
+	_, _, errno := syscall.Syscall6(syscall.SYS_PTRACE, ptraceGetSyscallInfo, uintptr(pid),
+		unsafe.Sizeof(info), uintptr(unsafe.Pointer(&info)), 0, 0)
+	if errno == syscall.ESRCH {
+		return
+	} else if errno != 0 {
+		t.err = fmt.Errorf("PTRACE_GET_SYSCALL_INFO failed, Linux 5.3 or later is required: %w", errno)
+		return
+	}
+
+	switch info.Op {
+	case ptraceSyscallInfoEntry:
+		if kind, ok := tracedSyscalls[info.Arch][info.Data[0]]; ok {
+			t.pending[pid] = syscallAccesses(pid, kind, info.Data[1:7])
+		} else {
+			delete(t.pending, pid)
+		}
+	case ptraceSyscallInfoExit:
+		rval, isError := int64(info.Data[0]), info.Data[1]&0xff != 0
+		for _, access := range t.pending[pid] {
+			if isError && rval < 0 {
+				access.Errno = syscall.Errno(-rval)
+			}
+			if t.filter == nil || t.filter(access) {
+				t.accesses = append(t.accesses, access)
+			}
+		}
+		delete(t.pending, pid)
+	}
+}
+
+// syscallAccesses returns the files that a system call will access if it succeeds.
+func syscallAccesses(pid int, kind syscallKind, args []uint64) []Access {
+	access := func(dirfd int32, pathAddr uint64, write bool) []Access {
+		path := resolvePath(pid, dirfd, pathAddr)
+		if path == "" {
+			return nil
+		}
+		return []Access{{Path: path, Write: write}}
+	}
+
+	switch kind {
+	case sysOpen:
+		return access(atFdcwd, args[0], isWriteFlags(args[1]))
+	case sysOpenat:
+		return access(int32(args[0]), args[1], isWriteFlags(args[2]))
+	case sysOpenat2:
+		// The flags are the first field of struct open_how.
+		how, err := readMemory(pid, args[2], 8)
+		if err != nil {
+			return nil
+		}
+		return access(int32(args[0]), args[1], isWriteFlags(binary.LittleEndian.Uint64(how)))
+	case sysCreat:
+		return access(atFdcwd, args[0], true)
+	case sysExecve:
+		return access(atFdcwd, args[0], false)
+	case sysExecveat:
+		return access(int32(args[0]), args[1], false)
+	case sysRename:
+		return access(atFdcwd, args[1], true)
+	case sysRenameat:
+		return access(int32(args[2]), args[3], true)
+	}
+	return nil
+}
+
+// isWriteFlags returns true if a file opened with the given flags may be written.
+func isWriteFlags(flags uint64) bool {
+	return flags&syscall.O_ACCMODE != syscall.O_RDONLY || flags&(syscall.O_CREAT|syscall.O_TRUNC) != 0
+}
+
+// resolvePath reads a path argument of a system call from the memory of a process and returns
+// it as an absolute path, or "" if it can't be read.  Relative paths are resolved against
+// dirfd, which may be AT_FDCWD, in the process.
+func resolvePath(pid int, dirfd int32, pathAddr uint64) string {
+	path, err := readString(pid, pathAddr)
+	if err != nil || path == "" {
+		return ""
+	}
+	if filepath.IsAbs(path) {
+		return filepath.Clean(path)
+	}
+
+	var dir string
+	if dirfd == atFdcwd {
+		dir, err = os.Readlink(fmt.Sprintf("/proc/%d/cwd", pid))
+	} else {
+		dir, err = os.Readlink(fmt.Sprintf("/proc/%d/fd/%d", pid, dirfd))
+	}
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, path)
+}
+
+// readString reads a NUL terminated string from the memory of a process.
+func readString(pid int, addr uint64) (string, error) {
+	f, err := os.Open(fmt.Sprintf("/proc/%d/mem", pid))
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	pageSize := uint64(os.Getpagesize())
+	var ret []byte
+	buf := make([]byte, 256)
+	for len(ret) < pathMax {
+		// Don't read past the end of the page, the next page may not be mapped.
+		n := pageSize - addr%pageSize
+		if n > uint64(len(buf)) {
+			n = uint64(len(buf))
+		}
+		if _, err := f.ReadAt(buf[:n], int64(addr)); err != nil {
+			return "", err
+		}
+		if i := bytes.IndexByte(buf[:n], 0); i >= 0 {
+			return string(append(ret, buf[:i]...)), nil
+		}
+		ret = append(ret, buf[:n]...)
+		addr += n
+	}
+	return "", errors.New("string is too long")
+}
+
+// readMemory reads n bytes from the memory of a process.
+func readMemory(pid int, addr uint64, n int) ([]byte, error) {
+	f, err := os.Open(fmt.Sprintf("/proc/%d/mem", pid))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	buf := make([]byte, n)
+	if _, err := f.ReadAt(buf, int64(addr)); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
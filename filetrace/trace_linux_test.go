@@ -0,0 +1,100 @@
+// Copyright 2023 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filetrace
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestTrace(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "in"), []byte("foo\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	// The file is read by a child process of bash and written through a rename.
+	cmd := exec.Command("bash", "-c", "cat in > tmp && mv tmp out && echo done; cat missing; exit 3")
+	cmd.Dir = dir
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	accesses, err := Trace(cmd, nil)
+	if errors.Is(err, syscall.EPERM) {
+		t.Skipf("ptrace is not allowed: %s", err)
+	}
+	var exitErr *ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode() != 3 {
+		t.Fatalf("expected exit status 3, got %v", err)
+	}
+	if got := stdout.String(); got != "done\n" {
+		t.Errorf("expected output %q, got %q", "done\n", got)
+	}
+	if got := stderr.String(); !strings.Contains(got, "missing") {
+		t.Errorf("expected an error about missing, got %q", got)
+	}
+
+	reads := make(map[string]bool)
+	writes := make(map[string]bool)
+	failed := make(map[string]syscall.Errno)
+	for _, access := range accesses {
+		if rel, err := filepath.Rel(dir, access.Path); err == nil {
+			if access.Errno != 0 {
+				failed[rel] = access.Errno
+			} else if access.Write {
+				writes[rel] = true
+			} else {
+				reads[rel] = true
+			}
+		}
+	}
+	if !reads["in"] {
+		t.Errorf("expected a read of in, got reads %v", reads)
+	}
+	if !writes["tmp"] || !writes["out"] {
+		t.Errorf("expected writes of tmp and out, got writes %v", writes)
+	}
+	if reads["out"] || writes["in"] {
+		t.Errorf("unexpected accesses: reads %v, writes %v", reads, writes)
+	}
+	if failed["missing"] != syscall.ENOENT {
+		t.Errorf("expected a failed read of missing, got failed accesses %v", failed)
+	}
+}
+
+func TestTraceFilter(t *testing.T) {
+	dir := t.TempDir()
+	cmd := exec.Command("bash", "-c", "cat missing; echo foo > out")
+	cmd.Dir = dir
+
+	accesses, err := Trace(cmd, Succeeded)
+	if errors.Is(err, syscall.EPERM) {
+		t.Skipf("ptrace is not allowed: %s", err)
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	for _, access := range accesses {
+		if access.Errno != 0 {
+			t.Errorf("expected only accesses that succeeded, got %+v", access)
+		}
+	}
+}
@@ -140,32 +140,41 @@ func checkProblematicFiles(ctx Context) {
 
 // checkCaseSensitivity issues a warning if a case-insensitive file system is being used.
 func checkCaseSensitivity(ctx Context, config Config) {
-	outDir := config.OutDir()
-	lowerCase := filepath.Join(outDir, "casecheck.txt")
-	upperCase := filepath.Join(outDir, "CaseCheck.txt")
+	caseSensitive, err := isCaseSensitive(config.OutDir())
+	if err != nil {
+		ctx.Fatalln("Failed to check case sensitivity:", err)
+	}
+
+	if !caseSensitive {
+		ctx.Println("************************************************************")
+		ctx.Println("You are building on a case-insensitive filesystem.")
+		ctx.Println("Please move your source tree to a case-sensitive filesystem.")
+		ctx.Println("************************************************************")
+		ctx.Fatalln("Case-insensitive filesystems not supported")
+	}
+}
+
+// isCaseSensitive returns whether the filesystem of dir is case-sensitive.
+func isCaseSensitive(dir string) (bool, error) {
+	lowerCase := filepath.Join(dir, "casecheck.txt")
+	upperCase := filepath.Join(dir, "CaseCheck.txt")
 	lowerData := "a"
 	upperData := "B"
 
 	if err := ioutil.WriteFile(lowerCase, []byte(lowerData), 0666); err != nil { // a+rw
-		ctx.Fatalln("Failed to check case sensitivity:", err)
+		return false, err
 	}
 
 	if err := ioutil.WriteFile(upperCase, []byte(upperData), 0666); err != nil { // a+rw
-		ctx.Fatalln("Failed to check case sensitivity:", err)
+		return false, err
 	}
 
 	res, err := ioutil.ReadFile(lowerCase)
 	if err != nil {
-		ctx.Fatalln("Failed to check case sensitivity:", err)
+		return false, err
 	}
 
-	if string(res) != lowerData {
-		ctx.Println("************************************************************")
-		ctx.Println("You are building on a case-insensitive filesystem.")
-		ctx.Println("Please move your source tree to a case-sensitive filesystem.")
-		ctx.Println("************************************************************")
-		ctx.Fatalln("Case-insensitive filesystems not supported")
-	}
+	return string(res) == lowerData, nil
 }
 
 // help prints a help/usage message, via the build/make/help.sh script.
@@ -252,9 +261,14 @@ func Build(ctx Context, config Config) {
 	ensureEmptyDirectoriesExist(ctx, config.TempDir())
 
 	SetupPath(ctx, config)
+	defer summarizeHostToolUsage(ctx, config)
 
 	what := evaluateWhatToRun(config, ctx.Verboseln)
 
+	// Only keep the record of the previous ninja invocation for --retry-failed
+	// while it's the most recent build.
+	os.Remove(config.LastNinjaBuildFile())
+
 	if config.StartGoma() {
 		startGoma(ctx, config)
 	}
@@ -266,7 +280,12 @@ func Build(ctx Context, config Config) {
 	}
 
 	if what&RunProductConfig != 0 {
+		// Wait for read-only commands like --dumpvars-mode that use the
+		// product config, and keep new ones from starting while it is
+		// regenerated.
+		configLock := LockConfigOrFail(ctx, config)
 		runMakeProductConfig(ctx, config)
+		configLock.Unlock()
 	}
 
 	// Everything below here depends on product config.
@@ -320,6 +339,7 @@ func Build(ctx Context, config Config) {
 			installCleanIfNecessary(ctx, config)
 		}
 
+		writeLastNinjaBuild(ctx, config)
 		runNinjaForBuild(ctx, config)
 	}
 }
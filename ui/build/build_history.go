@@ -0,0 +1,491 @@
+// Copyright 2023 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	bazel_metrics_proto "android/soong/ui/metrics/bazel_metrics_proto"
+	bp2build_metrics_proto "android/soong/ui/metrics/bp2build_metrics_proto"
+	soong_metrics_proto "android/soong/ui/metrics/metrics_proto"
+	"android/soong/ui/status"
+	soong_build_progress_proto "android/soong/ui/status/build_progress_proto"
+
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// buildHistoryFile is the file in the build history directory with a
+	// JSON BuildHistoryEntry on each line.
+	buildHistoryFile = "builds.jsonl"
+
+	// maxBuildHistorySize is the size of the build history file above which
+	// the oldest half of the builds is removed.
+	maxBuildHistorySize = 16 * 1024 * 1024
+
+	// buildHistorySlowestPhases is the number of phases that are printed
+	// for each goal.
+	buildHistorySlowestPhases = 5
+)
+
+// BuildHistoryEntry is a build in the local build history, summarized from
+// the metrics files that soong_ui writes to the logs directory.
+type BuildHistoryEntry struct {
+	Started time.Time `json:"started"`
+	OutDir  string    `json:"out_dir"`
+	Product string    `json:"product"`
+	Variant string    `json:"variant"`
+	Goals   []string  `json:"goals,omitempty"`
+	Failed  bool      `json:"failed,omitempty"`
+
+	TotalMs int64 `json:"total_ms"`
+
+	// AnalysisMs is the time spent in Soong, Kati and Bazel analysis, and
+	// NinjaMs the time spent running actions.
+	AnalysisMs int64 `json:"analysis_ms"`
+	NinjaMs    int64 `json:"ninja_ms"`
+
+	// CriticalPathMs is the length of the critical path of the actions
+	// that ran, or 0 if none ran.
+	CriticalPathMs int64 `json:"critical_path_ms,omitempty"`
+
+	// SoongAnalysisCached is set when Soong ran, but didn't need to
+	// regenerate its ninja file.
+	SoongAnalysisCached bool `json:"soong_analysis_cached,omitempty"`
+
+	// ActionsRun and ActionsFailed are the number of actions that ninja
+	// ran, and the number of them that failed.
+	ActionsRun    uint64 `json:"actions_run"`
+	ActionsFailed uint64 `json:"actions_failed,omitempty"`
+
+	// Phases contains the time of each phase of the build that soong_ui,
+	// soong_build, bp2build and Bazel recorded.
+	Phases map[string]int64 `json:"phases,omitempty"`
+}
+
+// NewBuildHistoryEntry summarizes the metrics files in logsDir that were
+// written with logsPrefix by the build that started at buildStarted. Files
+// from older builds are ignored, and an error is returned if the soong_metrics
+// file of the build is missing.
+func NewBuildHistoryEntry(logsDir, logsPrefix string, buildStarted time.Time) (*BuildHistoryEntry, error) {
+	readProto := func(name string, msg proto.Message) (bool, error) {
+		return readBuildMetrics(logsDir, logsPrefix+name, buildStarted, msg)
+	}
+
+	base := &soong_metrics_proto.MetricsBase{}
+	if ok, err := readProto("soong_metrics", base); err != nil {
+		return nil, err
+	} else if !ok {
+		return nil, fmt.Errorf("no soong_metrics in %s for the build started at %s", logsDir, buildStarted)
+	}
+
+	e := &BuildHistoryEntry{
+		Started: time.Unix(0, base.GetBuildDateTimestamp()*int64(time.Second)),
+		OutDir:  base.GetOutDir(),
+		Product: base.GetTargetProduct(),
+		Variant: strings.ToLower(base.GetTargetBuildVariant().String()),
+		Goals:   base.GetBuildConfig().GetTargets(),
+		Failed:  base.GetNonZeroExit(),
+		TotalMs: int64(base.GetTotal().GetRealTime()) / int64(time.Millisecond),
+		Phases:  make(map[string]int64),
+	}
+	if base.BuildDateTimestamp == nil {
+		e.Started = buildStarted
+	}
+
+	e.AnalysisMs = perfInfoUnionMs(base.GetSoongRuns(), base.GetKatiRuns(), base.GetBazelRuns())
+	e.NinjaMs = perfInfoUnionMs(base.GetNinjaRuns())
+	e.SoongAnalysisCached = len(base.GetSoongRuns()) > 0 && len(base.GetSoongRegenReasons()) == 0
+
+	for _, runs := range [][]*soong_metrics_proto.PerfInfo{
+		base.GetSetupTools(), base.GetSoongRuns(), base.GetKatiRuns(), base.GetBazelRuns(), base.GetNinjaRuns(),
+	} {
+		for _, run := range runs {
+			if run.GetNonZeroExit() {
+				e.Failed = true
+			}
+			e.Phases[run.GetDescription()] += int64(run.GetRealTime()) / int64(time.Millisecond)
+		}
+	}
+
+	soongBuild := &soong_metrics_proto.SoongBuildMetrics{}
+	if ok, err := readProto("soong_build_metrics.pb", soongBuild); err != nil {
+		return nil, err
+	} else if ok {
+		for _, event := range soongBuild.GetEvents() {
+			e.Phases["soong_build "+event.GetDescription()] += int64(event.GetRealTime()) / int64(time.Millisecond)
+		}
+	}
+
+	bp2build := &bp2build_metrics_proto.Bp2BuildMetrics{}
+	if ok, err := readProto("bp2build_metrics.pb", bp2build); err != nil {
+		return nil, err
+	} else if ok {
+		for _, event := range bp2build.GetEvents() {
+			e.Phases["bp2build "+event.GetName()] += int64(event.GetRealTime()) / int64(time.Millisecond)
+		}
+	}
+
+	bazel := &bazel_metrics_proto.BazelMetrics{}
+	if ok, err := readProto("bazel_metrics.pb", bazel); err != nil {
+		return nil, err
+	} else if ok {
+		for _, phase := range bazel.GetPhaseTimings() {
+			e.Phases["bazel "+phase.GetPhaseName()] += phase.GetDurationNanos() / int64(time.Millisecond)
+		}
+	}
+
+	progress := &soong_build_progress_proto.BuildProgress{}
+	if _, err := readProto("build_progress.pb", progress); err != nil {
+		return nil, err
+	}
+	e.ActionsRun = progress.GetFinishedActions()
+	e.ActionsFailed = progress.GetFailedActions()
+
+	reportFile := filepath.Join(logsDir, logsPrefix+"critical_path_report.json")
+	if info, err := os.Stat(reportFile); err == nil && !info.ModTime().Before(buildStarted) {
+		data, err := ioutil.ReadFile(reportFile)
+		if err != nil {
+			return nil, err
+		}
+		report := &status.CriticalPathReport{}
+		if err := json.Unmarshal(data, report); err != nil {
+			return nil, fmt.Errorf("%s: %w", reportFile, err)
+		}
+		e.CriticalPathMs = report.CriticalPathMs
+	}
+
+	return e, nil
+}
+
+// readBuildMetrics reads a metrics file in logsDir into msg, and returns
+// whether it was written by the build that started at buildStarted.
+func readBuildMetrics(logsDir, name string, buildStarted time.Time, msg proto.Message) (bool, error) {
+	file := filepath.Join(logsDir, name)
+	if info, err := os.Stat(file); os.IsNotExist(err) || (err == nil && info.ModTime().Before(buildStarted)) {
+		return false, nil
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return false, err
+	}
+	if err := proto.Unmarshal(data, msg); err != nil {
+		return false, fmt.Errorf("%s: %w", file, err)
+	}
+	return true, nil
+}
+
+// perfInfoUnionMs returns the time covered by the events, without counting
+// nested or overlapping events more than once.
+func perfInfoUnionMs(runs ...[]*soong_metrics_proto.PerfInfo) int64 {
+	type interval struct{ start, end uint64 }
+	var intervals []interval
+	for _, r := range runs {
+		for _, run := range r {
+			intervals = append(intervals, interval{run.GetStartTime(), run.GetStartTime() + run.GetRealTime()})
+		}
+	}
+	sort.Slice(intervals, func(i, j int) bool { return intervals[i].start < intervals[j].start })
+
+	var total, end uint64
+	for _, i := range intervals {
+		if i.start > end {
+			end = i.start
+		}
+		if i.end > end {
+			total += i.end - end
+			end = i.end
+		}
+	}
+	return int64(total) / int64(time.Millisecond)
+}
+
+// BuildHistoryDir returns the directory of the local build history, or an
+// empty string if it is disabled.
+func BuildHistoryDir(env *Environment) string {
+	if env.IsEnvTrue("SOONG_DISABLE_BUILD_HISTORY") {
+		return ""
+	}
+	if dir, ok := env.Get("SOONG_BUILD_HISTORY_DIR"); ok && dir != "" {
+		return dir
+	}
+	if home, ok := env.Get("HOME"); ok && home != "" {
+		return filepath.Join(home, ".cache", "soong", "build_history")
+	}
+	return ""
+}
+
+// AppendBuildHistory adds a build to the build history in dir.
+func AppendBuildHistory(dir string, e *BuildHistoryEntry) error {
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return err
+	}
+	file := filepath.Join(dir, buildHistoryFile)
+	if info, err := os.Stat(file); err == nil && info.Size() > maxBuildHistorySize {
+		if err := pruneBuildHistory(file); err != nil {
+			return err
+		}
+	}
+
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	// Builds in other trees may append to the history at the same time,
+	// each entry is written with a single append so they don't interleave.
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0666)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// pruneBuildHistory removes the oldest half of the builds from the history
+// file.
+func pruneBuildHistory(file string) error {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	lines := strings.SplitAfter(strings.TrimSuffix(string(data), "\n"), "\n")
+	kept := strings.Join(lines[len(lines)/2:], "")
+	if !strings.HasSuffix(kept, "\n") {
+		kept += "\n"
+	}
+
+	tmp := file + ".tmp"
+	if err := ioutil.WriteFile(tmp, []byte(kept), 0666); err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
+
+// ReadBuildHistory returns the builds in the build history in dir, ordered by
+// the time they started. Lines that can't be parsed, for example because a
+// build was interrupted while it wrote its entry, are skipped.
+func ReadBuildHistory(dir string) ([]*BuildHistoryEntry, error) {
+	f, err := os.Open(filepath.Join(dir, buildHistoryFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var ret []*BuildHistoryEntry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 16*1024*1024)
+	for scanner.Scan() {
+		e := &BuildHistoryEntry{}
+		if err := json.Unmarshal(scanner.Bytes(), e); err != nil {
+			continue
+		}
+		ret = append(ret, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(ret, func(i, j int) bool { return ret[i].Started.Before(ret[j].Started) })
+	return ret, nil
+}
+
+// RecordBuildHistory adds the build that just finished to the local build
+// history. Failures are only logged, the build history must never fail a
+// build.
+func RecordBuildHistory(ctx Context, config Config, buildStarted time.Time) {
+	dir := BuildHistoryDir(config.Environment())
+	if dir == "" {
+		return
+	}
+
+	e, err := NewBuildHistoryEntry(config.LogsDir(), config.GetLogsPrefix(), buildStarted)
+	if err != nil {
+		ctx.Verboseln("Not recording the build in the build history:", err)
+		return
+	}
+	if err := AppendBuildHistory(dir, e); err != nil {
+		ctx.Verboseln("Failed to record the build in the build history:", err)
+	}
+}
+
+// goalsKey returns the goals of a build as they are shown by the trends.
+func (e *BuildHistoryEntry) goalsKey() string {
+	if len(e.Goals) == 0 {
+		return "<default>"
+	}
+	return strings.Join(e.Goals, " ")
+}
+
+// FilterBuildHistory returns the last builds of the history, only keeping
+// the builds of goals and product if they are not empty.
+func FilterBuildHistory(entries []*BuildHistoryEntry, goals, product string, last int) []*BuildHistoryEntry {
+	var ret []*BuildHistoryEntry
+	for _, e := range entries {
+		if goals != "" && e.goalsKey() != goals {
+			continue
+		}
+		if product != "" && e.Product != product && e.Product+"-"+e.Variant != product {
+			continue
+		}
+		ret = append(ret, e)
+	}
+	if last > 0 && len(ret) > last {
+		ret = ret[len(ret)-last:]
+	}
+	return ret
+}
+
+// historyMs formats a duration in milliseconds for the trends.
+func historyMs(ms int64) string {
+	if ms == 0 {
+		return "-"
+	}
+	return (time.Duration(ms) * time.Millisecond).Round(time.Second).String()
+}
+
+// medianMs returns the median of the non-zero values.
+func medianMs(entries []*BuildHistoryEntry, value func(*BuildHistoryEntry) int64) int64 {
+	var values []int64
+	for _, e := range entries {
+		if v := value(e); v > 0 {
+			values = append(values, v)
+		}
+	}
+	if len(values) == 0 {
+		return 0
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+	return values[len(values)/2]
+}
+
+func soongCacheHitRate(entries []*BuildHistoryEntry) string {
+	hits, total := 0, 0
+	for _, e := range entries {
+		if _, ok := e.Phases["soong"]; ok {
+			total++
+			if e.SoongAnalysisCached {
+				hits++
+			}
+		}
+	}
+	if total == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%d%%", hits*100/total)
+}
+
+// PrintBuildHistoryTrends prints the builds, how their times changed from
+// the older half of the builds to the newer half, and the slowest phases of
+// the builds of each goal.
+func PrintBuildHistoryTrends(w io.Writer, entries []*BuildHistoryEntry) {
+	if len(entries) == 0 {
+		fmt.Fprintln(w, "No builds have been recorded.")
+		return
+	}
+
+	fmt.Fprintf(w, "%-16s  %-28s  %-20s  %-6s  %8s  %8s  %8s  %8s  %7s\n",
+		"Started", "Product", "Goals", "Result", "Total", "Analysis", "Ninja", "Critical", "Actions")
+	for _, e := range entries {
+		result := "ok"
+		if e.Failed {
+			result = "failed"
+		}
+		goals := e.goalsKey()
+		if len(goals) > 20 {
+			goals = goals[:17] + "..."
+		}
+		fmt.Fprintf(w, "%-16s  %-28s  %-20s  %-6s  %8s  %8s  %8s  %8s  %7d\n",
+			e.Started.Local().Format("2006-01-02 15:04"), e.Product+"-"+e.Variant, goals, result,
+			historyMs(e.TotalMs), historyMs(e.AnalysisMs), historyMs(e.NinjaMs), historyMs(e.CriticalPathMs),
+			e.ActionsRun)
+	}
+
+	if len(entries) >= 2 {
+		older, newer := entries[:len(entries)/2], entries[len(entries)/2:]
+		fmt.Fprintf(w, "\nTrends (median of the %d older builds -> median of the %d newer builds):\n", len(older), len(newer))
+		for _, t := range []struct {
+			name  string
+			value func(*BuildHistoryEntry) int64
+		}{
+			{"total", func(e *BuildHistoryEntry) int64 { return e.TotalMs }},
+			{"analysis", func(e *BuildHistoryEntry) int64 { return e.AnalysisMs }},
+			{"ninja", func(e *BuildHistoryEntry) int64 { return e.NinjaMs }},
+			{"critical path", func(e *BuildHistoryEntry) int64 { return e.CriticalPathMs }},
+		} {
+			before, after := medianMs(older, t.value), medianMs(newer, t.value)
+			change := ""
+			if before > 0 && after > 0 {
+				change = fmt.Sprintf(" (%+d%%)", (after-before)*100/before)
+			}
+			fmt.Fprintf(w, "  %-30s %8s -> %8s%s\n", t.name+":", historyMs(before), historyMs(after), change)
+		}
+		fmt.Fprintf(w, "  %-30s %8s -> %8s\n", "soong analysis cache hit rate:", soongCacheHitRate(older), soongCacheHitRate(newer))
+	}
+
+	byGoal := make(map[string][]*BuildHistoryEntry)
+	var goals []string
+	for _, e := range entries {
+		key := e.goalsKey()
+		if _, ok := byGoal[key]; !ok {
+			goals = append(goals, key)
+		}
+		byGoal[key] = append(byGoal[key], e)
+	}
+	sort.Strings(goals)
+
+	fmt.Fprintln(w, "\nSlowest phases by goal (mean time per build):")
+	for _, goal := range goals {
+		builds := byGoal[goal]
+		totals := make(map[string]int64)
+		var phases []string
+		for _, e := range builds {
+			for phase, ms := range e.Phases {
+				if _, ok := totals[phase]; !ok {
+					phases = append(phases, phase)
+				}
+				totals[phase] += ms
+			}
+		}
+		sort.Slice(phases, func(i, j int) bool {
+			if totals[phases[i]] != totals[phases[j]] {
+				return totals[phases[i]] > totals[phases[j]]
+			}
+			return phases[i] < phases[j]
+		})
+		if len(phases) > buildHistorySlowestPhases {
+			phases = phases[:buildHistorySlowestPhases]
+		}
+
+		fmt.Fprintf(w, "  %s (%d builds):\n", goal, len(builds))
+		for _, phase := range phases {
+			fmt.Fprintf(w, "    %-40s %8s\n", phase, historyMs(totals[phase]/int64(len(builds))))
+		}
+	}
+}
@@ -0,0 +1,200 @@
+// Copyright 2023 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	bazel_metrics_proto "android/soong/ui/metrics/bazel_metrics_proto"
+	soong_metrics_proto "android/soong/ui/metrics/metrics_proto"
+	soong_build_progress_proto "android/soong/ui/status/build_progress_proto"
+
+	"google.golang.org/protobuf/proto"
+)
+
+func perfInfo(desc string, start, realTime time.Duration) *soong_metrics_proto.PerfInfo {
+	return &soong_metrics_proto.PerfInfo{
+		Description: proto.String(desc),
+		StartTime:   proto.Uint64(uint64(start)),
+		RealTime:    proto.Uint64(uint64(realTime)),
+	}
+}
+
+func TestNewBuildHistoryEntry(t *testing.T) {
+	logsDir := t.TempDir()
+	started := time.Now().Add(-time.Minute).Truncate(time.Second)
+
+	write := func(name string, msg proto.Message) {
+		data, err := proto.Marshal(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(logsDir, name), data, 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := NewBuildHistoryEntry(logsDir, "", started); err == nil {
+		t.Errorf("expected an error without soong_metrics")
+	}
+
+	write("soong_metrics", &soong_metrics_proto.MetricsBase{
+		BuildDateTimestamp: proto.Int64(started.Unix()),
+		TargetProduct:      proto.String("aosp_arm64"),
+		TargetBuildVariant: soong_metrics_proto.MetricsBase_USERDEBUG.Enum(),
+		BuildConfig:        &soong_metrics_proto.BuildConfig{Targets: []string{"droid"}},
+		Total:              perfInfo("total", 0, 100*time.Second),
+		// The nested blueprint bootstrap is only counted once.
+		SoongRuns: []*soong_metrics_proto.PerfInfo{
+			perfInfo("soong", 0, 20*time.Second),
+			perfInfo("blueprint bootstrap", time.Second, 2*time.Second),
+		},
+		KatiRuns:  []*soong_metrics_proto.PerfInfo{perfInfo("kati build", 20*time.Second, 10*time.Second)},
+		NinjaRuns: []*soong_metrics_proto.PerfInfo{perfInfo("ninja", 30*time.Second, 70*time.Second)},
+	})
+	write("bazel_metrics.pb", &bazel_metrics_proto.BazelMetrics{
+		PhaseTimings: []*bazel_metrics_proto.PhaseTiming{
+			{PhaseName: proto.String("analysis"), DurationNanos: proto.Int64(int64(3 * time.Second))},
+		},
+	})
+	write("build_progress.pb", &soong_build_progress_proto.BuildProgress{
+		FinishedActions: proto.Uint64(42),
+		FailedActions:   proto.Uint64(1),
+	})
+	if err := ioutil.WriteFile(filepath.Join(logsDir, "critical_path_report.json"),
+		[]byte(`{"critical_path_ms": 65000, "modules": null}`), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	// A report from a previous build is ignored.
+	oldFile := filepath.Join(logsDir, "bp2build_metrics.pb")
+	if err := ioutil.WriteFile(oldFile, []byte("not a proto"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(oldFile, started.Add(-time.Hour), started.Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := NewBuildHistoryEntry(logsDir, "", started)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &BuildHistoryEntry{
+		Started:             started,
+		Product:             "aosp_arm64",
+		Variant:             "userdebug",
+		Goals:               []string{"droid"},
+		TotalMs:             100000,
+		AnalysisMs:          30000,
+		NinjaMs:             70000,
+		CriticalPathMs:      65000,
+		SoongAnalysisCached: true,
+		ActionsRun:          42,
+		ActionsFailed:       1,
+		Phases: map[string]int64{
+			"soong":               20000,
+			"blueprint bootstrap": 2000,
+			"kati build":          10000,
+			"ninja":               70000,
+			"bazel analysis":      3000,
+		},
+	}
+	if !got.Started.Equal(want.Started) {
+		t.Errorf("expected the build to start at %s, got %s", want.Started, got.Started)
+	}
+	got.Started = want.Started
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %+v, got %+v", want, got)
+	}
+}
+
+func TestBuildHistory(t *testing.T) {
+	dir := t.TempDir()
+	start := time.Date(2023, 5, 1, 10, 0, 0, 0, time.UTC)
+
+	// Entries are appended in the order builds finish, not in the order
+	// they started.
+	for i, minutes := range []int{2, 0, 1, 3} {
+		e := &BuildHistoryEntry{
+			Started: start.Add(time.Duration(minutes) * time.Minute),
+			Product: "aosp_arm64",
+			Variant: "eng",
+			Goals:   []string{"droid"},
+			TotalMs: int64(minutes+1) * 60000,
+			NinjaMs: int64(i+1) * 1000,
+			Phases:  map[string]int64{"ninja": int64(i+1) * 1000},
+		}
+		if minutes == 3 {
+			e.Goals = nil
+		}
+		if err := AppendBuildHistory(dir, e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entries, err := ReadBuildHistory(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var totals []int64
+	for _, e := range entries {
+		totals = append(totals, e.TotalMs)
+	}
+	if want := []int64{60000, 120000, 180000, 240000}; !reflect.DeepEqual(totals, want) {
+		t.Errorf("expected the builds in order of their start, got totals %v", totals)
+	}
+
+	if got := FilterBuildHistory(entries, "droid", "", 2); len(got) != 2 || got[1].TotalMs != 180000 {
+		t.Errorf("expected the last 2 droid builds, got %+v", got)
+	}
+	if got := FilterBuildHistory(entries, "", "aosp_arm64-user", 0); len(got) != 0 {
+		t.Errorf("expected no user builds, got %+v", got)
+	}
+
+	var b strings.Builder
+	PrintBuildHistoryTrends(&b, entries)
+	for _, want := range []string{
+		"total:                             2m0s ->     4m0s (+100%)",
+		"  <default> (1 builds):\n    ninja",
+		"  droid (3 builds):\n    ninja",
+	} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("expected the trends to contain %q, got:\n%s", want, b.String())
+		}
+	}
+}
+
+func TestPruneBuildHistory(t *testing.T) {
+	file := filepath.Join(t.TempDir(), buildHistoryFile)
+	if err := ioutil.WriteFile(file, []byte("1\n2\n3\n4\n5\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := pruneBuildHistory(file); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(data), "3\n4\n5\n"; got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+}
@@ -109,12 +109,18 @@ func dataClean(ctx Context, config Config) {
 // intermediates.  Instead of recompiling, we can just copy the results.
 func installClean(ctx Context, config Config) {
 	dataClean(ctx, config)
+	removeGlobs(ctx, installCleanGlobs(config)...)
+}
 
+// installCleanGlobs returns the glob patterns of the installed files that
+// installClean removes.
+func installCleanGlobs(config Config) []string {
+	var globs []string
 	if hostCrossOutPath := config.hostCrossOut(); hostCrossOutPath != "" {
 		hostCrossOut := func(path string) string {
 			return filepath.Join(hostCrossOutPath, path)
 		}
-		removeGlobs(ctx,
+		globs = append(globs,
 			hostCrossOut("bin"),
 			hostCrossOut("coverage"),
 			hostCrossOut("lib*"),
@@ -138,7 +144,7 @@ func installClean(ctx Context, config Config) {
 	// Host bin, frameworks, and lib* are intentionally omitted, since
 	// otherwise we'd have to rebuild any generated files created with
 	// those tools.
-	removeGlobs(ctx,
+	return append(globs,
 		hostOut("apex"),
 		hostOut("obj/NOTICE_FILES"),
 		hostOut("obj/PACKAGING"),
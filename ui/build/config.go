@@ -83,6 +83,8 @@ type configImpl struct {
 	skipSoong         bool
 	skipNinja         bool
 	skipSoongTests    bool
+	explainRegen      bool // Print why soong_build regenerated the Soong ninja file.
+	explainRebuilds   bool // Record why ninja reran each action.
 	searchApiDir      bool // Scan the Android.bp files generated in out/api_surfaces
 	skipMetricsUpload bool
 	buildStartedTime  int64 // For metrics-upload-only - manually specify a build-started time
@@ -95,6 +97,12 @@ type configImpl struct {
 	targetDeviceDir string
 	sandboxConfig   *SandboxConfig
 
+	// From the environment variable policy files
+	envPolicy *envPolicy
+
+	// From the sandbox policy files
+	sandboxPolicy *sandboxPolicy
+
 	// Autodetected
 	totalRAM uint64
 
@@ -104,6 +112,9 @@ type configImpl struct {
 
 	pathReplaced bool
 
+	// Set by SetupPath when the path_interposer is used.
+	hostToolUsage *hostToolUsage
+
 	bazelProdMode    bool
 	bazelDevMode     bool
 	bazelStagingMode bool
@@ -111,6 +122,9 @@ type configImpl struct {
 	// Set by multiproduct_kati
 	emptyNinjaFile bool
 
+	// Set by soong_ui --retry-failed
+	retry *retryState
+
 	metricsUploader string
 
 	bazelForceEnabledModules string
@@ -394,6 +408,24 @@ func NewConfig(ctx Context, args ...string) Config {
 		"ANDROID_PRE_BUILD_PATHS",
 	)
 
+	// Apply the environment variable policy of the tree and the product on
+	// top of the variables that are always unset above.
+	policyFiles, requiredPolicyFiles := envPolicyFiles(ret.environ)
+	policy, err := loadEnvPolicy(policyFiles, requiredPolicyFiles)
+	if err != nil {
+		ctx.Fatalln("Invalid environment variable policy:", err)
+	}
+	for _, warning := range policy.apply(ret.environ) {
+		ctx.Println("Warning:", warning)
+	}
+	ret.envPolicy = policy
+
+	sandboxPolicyFiles, requiredSandboxPolicyFiles := sandboxPolicyFiles(ret.environ)
+	ret.sandboxPolicy, err = loadSandboxPolicy(sandboxPolicyFiles, requiredSandboxPolicyFiles)
+	if err != nil {
+		ctx.Fatalln("Invalid sandbox policy:", err)
+	}
+
 	if ret.UseGoma() || ret.ForceUseGoma() {
 		ctx.Println("Goma for Android has been deprecated and replaced with RBE. See go/rbe_for_android for instructions on how to use RBE.")
 		ctx.Fatalln("USE_GOMA / FORCE_USE_GOMA flag is no longer supported.")
@@ -777,6 +809,10 @@ func (c *configImpl) parseArgs(ctx Context, args []string) {
 			c.skipConfig = true
 		} else if arg == "--skip-soong-tests" {
 			c.skipSoongTests = true
+		} else if arg == "--explain-regen" {
+			c.explainRegen = true
+		} else if arg == "--explain-rebuilds" {
+			c.explainRebuilds = true
 		} else if arg == "--skip-metrics-upload" {
 			c.skipMetricsUpload = true
 		} else if arg == "--mk-metrics" {
@@ -1145,6 +1181,12 @@ func (c *configImpl) GetLogsPrefix() string {
 	return c.logsPrefix
 }
 
+// HostToolInvocationsFile is the log of the invocations of host tools through
+// the path_interposer.
+func (c *configImpl) HostToolInvocationsFile() string {
+	return filepath.Join(c.LogsDir(), c.logsPrefix+"host_tool_invocations.jsonl")
+}
+
 func (c *configImpl) SetLogsPrefix(prefix string) {
 	c.logsPrefix = prefix
 }
@@ -1397,6 +1439,10 @@ func (c *configImpl) LastKatiSuffixFile() string {
 	return filepath.Join(c.OutDir(), "last_kati_suffix")
 }
 
+func (c *configImpl) LastNinjaBuildFile() string {
+	return filepath.Join(c.OutDir(), "last_ninja_build.json")
+}
+
 func (c *configImpl) HasKatiSuffix() bool {
 	return c.katiSuffix != ""
 }
@@ -1417,6 +1463,12 @@ func (c *configImpl) SoongVarsFile() string {
 	return filepath.Join(c.SoongOutDir(), "soong.variables")
 }
 
+// LastSoongVarsFile is a copy of the soong.variables file that was used by the
+// most recent soong_build run, to explain why soong_build runs again.
+func (c *configImpl) LastSoongVarsFile() string {
+	return filepath.Join(c.SoongOutDir(), ".soong.variables.last")
+}
+
 func (c *configImpl) SoongNinjaFile() string {
 	return filepath.Join(c.SoongOutDir(), "build.ninja")
 }
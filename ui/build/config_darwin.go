@@ -38,3 +38,16 @@ func detectTotalRAM(ctx Context) uint64 {
 
 	return binary.LittleEndian.Uint64([]byte(s))
 }
+
+func filesystemStats(path string) (fsStats, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return fsStats{}, err
+	}
+	return fsStats{
+		totalBytes: st.Blocks * uint64(st.Bsize),
+		freeBytes:  st.Bavail * uint64(st.Bsize),
+		freeInodes: st.Ffree,
+		tmpfs:      false,
+	}, nil
+}
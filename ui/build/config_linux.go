@@ -25,3 +25,19 @@ func detectTotalRAM(ctx Context) uint64 {
 	}
 	return uint64(info.Totalram) * uint64(info.Unit)
 }
+
+// tmpfsMagic is the f_type of a tmpfs filesystem in statfs.
+const tmpfsMagic = 0x01021994
+
+func filesystemStats(path string) (fsStats, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return fsStats{}, err
+	}
+	return fsStats{
+		totalBytes: st.Blocks * uint64(st.Bsize),
+		freeBytes:  st.Bavail * uint64(st.Bsize),
+		freeInodes: st.Ffree,
+		tmpfs:      st.Type == tmpfsMagic,
+	}, nil
+}
@@ -0,0 +1,357 @@
+// Copyright 2023 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	// Below doctorMinFreeDiskGB the build is likely to run out of space,
+	// and below doctorWarnFreeDiskGB a full build from scratch may.
+	doctorMinFreeDiskGB  = 50
+	doctorWarnFreeDiskGB = 250
+
+	doctorMinFreeInodes  = 1000000
+	doctorWarnFreeInodes = 5000000
+
+	// doctorMinTmpfsGB is the size below which a tmpfs /tmp is likely to
+	// fill up with the temporary files of the tools that ignore TMPDIR.
+	doctorMinTmpfsGB = 8
+
+	// doctorMinOpenFiles is the open file limit that large builds need
+	// without RBE.
+	doctorMinOpenFiles = 4096
+)
+
+// DoctorStatus is the result of a check of --doctor.
+type DoctorStatus string
+
+const (
+	DoctorOK      DoctorStatus = "ok"
+	DoctorWarning DoctorStatus = "warning"
+	DoctorError   DoctorStatus = "error"
+	DoctorSkipped DoctorStatus = "skipped"
+)
+
+// DoctorResult is the result of a check of --doctor, and an entry of the
+// result file.
+type DoctorResult struct {
+	Name    string       `json:"name"`
+	Status  DoctorStatus `json:"status"`
+	Message string       `json:"message"`
+
+	// Remediation describes how to fix the problem that was found.
+	Remediation string `json:"remediation,omitempty"`
+}
+
+type doctorCheck struct {
+	name string
+	run  func(ctx Context, config Config) DoctorResult
+}
+
+// doctorChecks are the checks of --doctor for host conditions that are known
+// to break builds, in the order that they are run.
+var doctorChecks = []doctorCheck{
+	{"open_files", checkOpenFiles},
+	{"disk_space", checkDiskSpace},
+	{"tmpfs", checkTmpfs},
+	{"case_sensitivity", checkCaseSensitivityForDoctor},
+	{"prebuilts", checkPrebuilts},
+	{"out_dir_lock", checkOutDirLock},
+	{"sandbox", checkSandbox},
+	{"rbe", checkRBE},
+}
+
+func doctorOK(format string, args ...interface{}) DoctorResult {
+	return DoctorResult{Status: DoctorOK, Message: fmt.Sprintf(format, args...)}
+}
+
+func doctorSkipped(format string, args ...interface{}) DoctorResult {
+	return DoctorResult{Status: DoctorSkipped, Message: fmt.Sprintf(format, args...)}
+}
+
+func doctorProblem(status DoctorStatus, remediation string, format string, args ...interface{}) DoctorResult {
+	return DoctorResult{Status: status, Message: fmt.Sprintf(format, args...), Remediation: remediation}
+}
+
+// RunDoctor runs the checks of --doctor, prints the result of each check and
+// writes them to resultsFile. It returns false if any check found an error.
+func RunDoctor(ctx Context, config Config, resultsFile string) bool {
+	ensureDirectoriesExist(ctx, config.OutDir())
+
+	var results []DoctorResult
+	healthy := true
+	for _, check := range doctorChecks {
+		ctx.Verbosef("Running doctor check %s", check.name)
+		result := check.run(ctx, config)
+		result.Name = check.name
+		results = append(results, result)
+		if result.Status == DoctorError {
+			healthy = false
+		}
+
+		fmt.Fprintf(ctx.Writer, "%-9s %s: %s\n", "["+string(result.Status)+"]", result.Name, result.Message)
+		if result.Remediation != "" {
+			fmt.Fprintf(ctx.Writer, "%-9s Fix: %s\n", "", result.Remediation)
+		}
+	}
+
+	data, err := json.MarshalIndent(struct {
+		Healthy bool           `json:"healthy"`
+		Checks  []DoctorResult `json:"checks"`
+	}{healthy, results}, "", "  ")
+	if err != nil {
+		ctx.Fatal(err)
+	}
+	if err := ioutil.WriteFile(resultsFile, append(data, '\n'), 0666); err != nil {
+		ctx.Fatalf("Failed to write %s: %v", resultsFile, err)
+	}
+	fmt.Fprintf(ctx.Writer, "\nWrote the results to %s\n", resultsFile)
+
+	return healthy
+}
+
+func checkOpenFiles(ctx Context, config Config) DoctorResult {
+	var limits syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &limits); err != nil {
+		return doctorProblem(DoctorWarning, "", "failed to get the open file limit: %v", err)
+	}
+
+	// soong_ui raises the soft limit to the hard limit at startup, so only
+	// the hard limit matters.
+	remediation := "raise the hard nofile limit in /etc/security/limits.conf, and log in again"
+	if config.UseRBE() && limits.Max < rbeLeastNFiles {
+		return doctorProblem(DoctorError, remediation,
+			"the hard limit of %d open files is less than the %d that RBE needs", limits.Max, rbeLeastNFiles)
+	}
+	if limits.Max < doctorMinOpenFiles {
+		return doctorProblem(DoctorWarning, remediation,
+			"the hard limit of %d open files is less than %d", limits.Max, doctorMinOpenFiles)
+	}
+	return doctorOK("%d open files allowed (soft limit %d)", limits.Max, limits.Cur)
+}
+
+func checkDiskSpace(ctx Context, config Config) DoctorResult {
+	stats, err := filesystemStats(config.OutDir())
+	if err != nil {
+		return doctorProblem(DoctorWarning, "", "failed to get the free space of %s: %v", config.OutDir(), err)
+	}
+
+	freeGB := stats.freeBytes / (1 << 30)
+	remediation := "free up space, or set OUT_DIR to a larger filesystem"
+	if freeGB < doctorMinFreeDiskGB {
+		return doctorProblem(DoctorError, remediation, "only %dGB free in %s, builds need at least %dGB",
+			freeGB, config.OutDir(), doctorMinFreeDiskGB)
+	}
+	if stats.freeInodes < doctorMinFreeInodes {
+		return doctorProblem(DoctorError, remediation, "only %d free inodes in %s, builds need at least %d",
+			stats.freeInodes, config.OutDir(), doctorMinFreeInodes)
+	}
+	if freeGB < doctorWarnFreeDiskGB {
+		return doctorProblem(DoctorWarning, remediation, "%dGB free in %s, full builds may need %dGB",
+			freeGB, config.OutDir(), doctorWarnFreeDiskGB)
+	}
+	if stats.freeInodes < doctorWarnFreeInodes {
+		return doctorProblem(DoctorWarning, remediation, "%d free inodes in %s, full builds may need %d",
+			stats.freeInodes, config.OutDir(), doctorWarnFreeInodes)
+	}
+	return doctorOK("%dGB and %d inodes free in %s", freeGB, stats.freeInodes, config.OutDir())
+}
+
+func checkTmpfs(ctx Context, config Config) DoctorResult {
+	tmpDir := os.TempDir()
+	stats, err := filesystemStats(tmpDir)
+	if err != nil {
+		return doctorProblem(DoctorWarning, "", "failed to get the size of %s: %v", tmpDir, err)
+	}
+	if !stats.tmpfs {
+		return doctorOK("%s is not a tmpfs", tmpDir)
+	}
+
+	sizeGB := stats.totalBytes / (1 << 30)
+	if sizeGB < doctorMinTmpfsGB {
+		return doctorProblem(DoctorWarning, "increase the size of the tmpfs, or set TMPDIR to a directory on disk",
+			"%s is a %dGB tmpfs, tools that write large temporary files may fill it up", tmpDir, sizeGB)
+	}
+	return doctorOK("%s is a %dGB tmpfs", tmpDir, sizeGB)
+}
+
+func checkCaseSensitivityForDoctor(ctx Context, config Config) DoctorResult {
+	caseSensitive, err := isCaseSensitive(config.OutDir())
+	if err != nil {
+		return doctorProblem(DoctorError, "make sure that OUT_DIR is writable",
+			"failed to check case sensitivity: %v", err)
+	}
+	if !caseSensitive {
+		return doctorProblem(DoctorError, "move the source tree and OUT_DIR to a case-sensitive filesystem",
+			"%s is on a case-insensitive filesystem", config.OutDir())
+	}
+	return doctorOK("%s is on a case-sensitive filesystem", config.OutDir())
+}
+
+// goPrebuiltVersion returns the version of the prebuilt Go toolchain that
+// soong_ui is built with.
+func goPrebuiltVersion(config Config) (string, error) {
+	data, err := ioutil.ReadFile(filepath.Join("prebuilts/go", config.HostPrebuiltTag(), "VERSION"))
+	if err != nil {
+		return "", err
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return "", fmt.Errorf("empty VERSION file")
+	}
+	return fields[0], nil
+}
+
+func checkPrebuilts(ctx Context, config Config) DoctorResult {
+	tools := []string{"ckati", "ninja"}
+	if runtime.GOOS == "linux" {
+		tools = append(tools, "nsjail")
+	}
+
+	var problems []string
+	for _, tool := range tools {
+		path := config.PrebuiltBuildTool(tool)
+		if info, err := os.Stat(path); err != nil {
+			problems = append(problems, fmt.Sprintf("%s is missing", path))
+		} else if info.Mode()&0111 == 0 {
+			problems = append(problems, fmt.Sprintf("%s is not executable", path))
+		}
+	}
+	if len(problems) > 0 {
+		return doctorProblem(DoctorError, "sync prebuilts/build-tools with `repo sync`",
+			"%s", strings.Join(problems, ", "))
+	}
+
+	ninja := config.PrebuiltBuildTool("ninja")
+	if out, err := exec.Command(ninja, "--version").Output(); err != nil {
+		return doctorProblem(DoctorError, "sync prebuilts/build-tools with `repo sync`",
+			"%s --version failed: %v", ninja, err)
+	} else {
+		ctx.Verbosef("ninja version %s", strings.TrimSpace(string(out)))
+	}
+
+	goVersion, err := goPrebuiltVersion(config)
+	if err != nil {
+		return doctorProblem(DoctorError, "sync prebuilts/go with `repo sync`",
+			"failed to read the version of prebuilts/go: %v", err)
+	}
+	if goVersion != runtime.Version() {
+		return doctorProblem(DoctorWarning, "soong_ui.bash rebuilds soong_ui on the next build, or sync prebuilts/go if it is out of date",
+			"soong_ui was built with %s, but prebuilts/go is %s", runtime.Version(), goVersion)
+	}
+	return doctorOK("%s are present, and prebuilts/go is %s", strings.Join(tools, ", "), goVersion)
+}
+
+func checkOutDirLock(ctx Context, config Config) DoctorResult {
+	for _, open := range []func() (*fileLock, error){
+		func() (*fileLock, error) { return newLock(config.OutDir()) },
+		func() (*fileLock, error) { return newConfigLock(config.OutDir(), false) },
+	} {
+		lock, err := open()
+		if err != nil {
+			return doctorProblem(DoctorError, "make sure that OUT_DIR is writable", "%v", err)
+		}
+		err = lock.tryLock()
+		holders := lock.holders()
+		lock.Unlock()
+		if err == nil {
+			continue
+		}
+
+		if len(holders) == 0 {
+			return doctorProblem(DoctorWarning, fmt.Sprintf("find the process with `fuser %s`", lock.description()),
+				"%s is locked by a process that didn't record itself", lock.description())
+		}
+		var descriptions []string
+		for _, holder := range holders {
+			descriptions = append(descriptions, holder.String())
+		}
+		remediation := "wait for the build to finish"
+		if time.Since(holders[0].Started) > 24*time.Hour {
+			remediation = fmt.Sprintf("the build may be stuck, stop it with `kill %d`", holders[0].Pid)
+		}
+		return doctorProblem(DoctorWarning, remediation, "%s is locked by %s",
+			lock.description(), strings.Join(descriptions, ", "))
+	}
+	return doctorOK("no other Soong process is using %s", config.OutDir())
+}
+
+func checkSandbox(ctx Context, config Config) DoctorResult {
+	cmd := Command(ctx, config, "doctor sandbox", "true")
+	cmd.Sandbox = katiSandbox
+	if !cmd.sandboxSupported() {
+		remediation := fmt.Sprintf("see %s for the error of the sandbox",
+			filepath.Join(config.LogsDir(), config.GetLogsPrefix()+"soong.log"))
+		if runtime.GOOS == "linux" {
+			remediation = "nsjail needs unprivileged user namespaces, enable them with `sysctl -w kernel.unprivileged_userns_clone=1`, " + remediation
+		}
+		return doctorProblem(DoctorWarning, remediation, "build sandboxing is not available, actions can write to the source tree")
+	}
+	return doctorOK("build sandboxing is available")
+}
+
+func checkRBE(ctx Context, config Config) DoctorResult {
+	if !config.UseRBE() {
+		return doctorSkipped("RBE is not enabled")
+	}
+
+	bootstrap := filepath.Join(config.rbeDir(), bootstrapCmd)
+	if _, err := os.Stat(bootstrap); err != nil {
+		return doctorProblem(DoctorError, "set RBE_DIR to the directory of the RBE client, or sync prebuilts/remoteexecution-client",
+			"the RBE bootstrap binary %s is missing", bootstrap)
+	}
+	if authVar, val := config.rbeAuth(); val == "" {
+		return doctorProblem(DoctorError, "set one of the RBE credential variables, like RBE_use_application_default_credentials=true",
+			"no RBE credentials are configured, %s is empty", authVar)
+	}
+	if prodCredsAuthType(config) && !config.GoogleProdCredsExist() {
+		return doctorProblem(DoctorError, "run `gcert` to get new credentials", "the RBE credentials are missing or expired")
+	}
+	if config.StartRBE() {
+		if _, err := config.rbeSockAddr(absPath(ctx, config.TempDir())); err != nil {
+			return doctorProblem(DoctorError, "use a shorter OUT_DIR", "no socket address is available for reproxy: %v", err)
+		}
+	}
+	if n, err := ulimit(ctx, config, "-u"); err != nil {
+		return doctorProblem(DoctorWarning, "", "failed to get the process limit: %v", err)
+	} else if n < rbeLeastNProcs {
+		return doctorProblem(DoctorError, "raise the nproc limit in /etc/security/limits.conf, and log in again",
+			"the limit of %d processes is less than the %d that RBE needs", n, rbeLeastNProcs)
+	}
+	if config.OutDir() != defaultOutDir {
+		return doctorProblem(DoctorWarning, "see http://go/android_rbe_out_dir for a workaround",
+			"setting OUT_DIR to a path other than %s may result in slow RBE builds", defaultOutDir)
+	}
+	return doctorOK("RBE is configured with the client in %s", config.rbeDir())
+}
+
+// fsStats describes the filesystem of a path, see filesystemStats.
+type fsStats struct {
+	totalBytes uint64
+	freeBytes  uint64
+	freeInodes uint64
+	tmpfs      bool
+}
@@ -0,0 +1,108 @@
+// Copyright 2023 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func doctorTestConfig(outDir string) Config {
+	env := Environment([]string{"OUT_DIR=" + outDir})
+	return Config{&configImpl{environ: &env}}
+}
+
+func TestRunDoctor(t *testing.T) {
+	defer func(checks []doctorCheck) { doctorChecks = checks }(doctorChecks)
+	doctorChecks = []doctorCheck{
+		{"good", func(Context, Config) DoctorResult { return doctorOK("all good") }},
+		{"bad", func(Context, Config) DoctorResult {
+			return doctorProblem(DoctorError, "fix it", "something is broken")
+		}},
+		{"unused", func(Context, Config) DoctorResult { return doctorSkipped("not enabled") }},
+	}
+
+	ctx := testContext()
+	dir := t.TempDir()
+	resultsFile := filepath.Join(dir, "doctor.json")
+	if RunDoctor(ctx, doctorTestConfig(filepath.Join(dir, "out")), resultsFile) {
+		t.Error("expected a failed check to make the host unhealthy")
+	}
+
+	output := ctx.Writer.(*bytes.Buffer).String()
+	for _, want := range []string{
+		"[ok]      good: all good\n",
+		"[error]   bad: something is broken\n          Fix: fix it\n",
+		"[skipped] unused: not enabled\n",
+	} {
+		if !strings.Contains(output, want) {
+			t.Errorf("expected output to contain %q, got %q", want, output)
+		}
+	}
+
+	data, err := ioutil.ReadFile(resultsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var results struct {
+		Healthy bool
+		Checks  []DoctorResult
+	}
+	if err := json.Unmarshal(data, &results); err != nil {
+		t.Fatal(err)
+	}
+	if results.Healthy || len(results.Checks) != 3 || results.Checks[1] != (DoctorResult{
+		Name: "bad", Status: DoctorError, Message: "something is broken", Remediation: "fix it",
+	}) {
+		t.Errorf("unexpected results %+v", results)
+	}
+}
+
+func TestDoctorOutDirLock(t *testing.T) {
+	outDir := t.TempDir()
+	config := doctorTestConfig(outDir)
+
+	if result := checkOutDirLock(testContext(), config); result.Status != DoctorOK {
+		t.Errorf("expected an unlocked out directory to be ok, got %+v", result)
+	}
+
+	lock, err := newLock(outDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lock.Unlock()
+	if err := lock.tryLock(); err != nil {
+		t.Fatal(err)
+	}
+	if err := lock.recordHolder(lockHolder{
+		Pid:     os.Getppid(),
+		Command: []string{"soong_ui", "--make-mode", "droid"},
+		Started: time.Now().Add(-48 * time.Hour),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	result := checkOutDirLock(testContext(), config)
+	if result.Status != DoctorWarning || !strings.Contains(result.Message, "soong_ui --make-mode droid") ||
+		!strings.Contains(result.Remediation, "may be stuck") {
+		t.Errorf("expected the holder of the lock to be reported, got %+v", result)
+	}
+}
@@ -0,0 +1,283 @@
+// Copyright 2023 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"android/soong/ui/logger"
+)
+
+// DumpVarsRequest is a line of input to the dumpvars server.
+type DumpVarsRequest struct {
+	// Vars and AbsVars are the variables to return, like the --vars and
+	// --abs-vars arguments of --dumpvars-mode.
+	Vars    []string `json:"vars,omitempty"`
+	AbsVars []string `json:"abs_vars,omitempty"`
+
+	// Reload discards the cached values of all variables, and the variables
+	// of earlier requests, before answering.
+	Reload bool `json:"reload,omitempty"`
+}
+
+// DumpVarsResponse is the output of --dumpvars-mode --format=json, and a line
+// of output of the dumpvars server.
+type DumpVarsResponse struct {
+	Vars    map[string]string `json:"vars"`
+	AbsVars map[string]string `json:"abs_vars"`
+
+	Error string `json:"error,omitempty"`
+}
+
+// DumpVarsNames returns the make variables that need to be dumped for vars and
+// absVars, with report_config replaced by the variables of the banner.
+func DumpVarsNames(vars, absVars []string) []string {
+	var ret []string
+	for _, name := range append(append([]string{}, vars...), absVars...) {
+		if name == "report_config" {
+			ret = append(ret, BannerVars...)
+		} else {
+			ret = append(ret, name)
+		}
+	}
+	return ret
+}
+
+// NewDumpVarsResponse returns the values of vars and absVars from the values
+// of the make variables in varData. The report_config variable is the banner,
+// and the paths in absVars are made absolute.
+func NewDumpVarsResponse(varData map[string]string, vars, absVars []string) (*DumpVarsResponse, error) {
+	ret := &DumpVarsResponse{
+		Vars:    make(map[string]string, len(vars)),
+		AbsVars: make(map[string]string, len(absVars)),
+	}
+	for _, name := range vars {
+		if name == "report_config" {
+			ret.Vars[name] = Banner(varData)
+		} else {
+			ret.Vars[name] = varData[name]
+		}
+	}
+	for _, name := range absVars {
+		var res []string
+		for _, path := range strings.Fields(varData[name]) {
+			abs, err := filepath.Abs(path)
+			if err != nil {
+				return nil, fmt.Errorf("Failed to get absolute path of %s: %w", path, err)
+			}
+			res = append(res, abs)
+		}
+		ret.AbsVars[name] = strings.Join(res, " ")
+	}
+	return ret, nil
+}
+
+// DumpVarsServer answers queries for the values of make variables. The values
+// of all the variables that have been queried so far are dumped together with
+// one run of product config, and cached until a build regenerates the product
+// config or a request asks to reload them.
+type DumpVarsServer struct {
+	lock sync.Mutex
+
+	dump  func(vars []string) (map[string]string, error)
+	stamp func() time.Time
+
+	// names are the variables that have been queried so far.
+	names []string
+
+	cache      map[string]string
+	cacheStamp time.Time
+
+	// requests is signalled for every request, to reset the idle timeout.
+	requests chan bool
+}
+
+// NewDumpVarsServer returns a DumpVarsServer for the product config of config.
+func NewDumpVarsServer(ctx Context, config Config) *DumpVarsServer {
+	dump := func(vars []string) (map[string]string, error) {
+		// Only hold the shared lock while running product config, so
+		// that builds can regenerate it in between requests.
+		lock := BecomeSharedOrFail(ctx, config)
+		defer lock.Unlock()
+
+		ctx.Verbosef("Dumping make variables %q", vars)
+		return DumpMakeVars(ctx, config, nil, vars)
+	}
+	stamp := func() time.Time {
+		// soong.variables is only rewritten when product config
+		// changes.
+		if info, err := os.Stat(config.SoongVarsFile()); err == nil {
+			return info.ModTime()
+		}
+		return time.Time{}
+	}
+	return newDumpVarsServer(dump, stamp)
+}
+
+func newDumpVarsServer(dump func([]string) (map[string]string, error), stamp func() time.Time) *DumpVarsServer {
+	return &DumpVarsServer{
+		dump:     dump,
+		stamp:    stamp,
+		requests: make(chan bool, 1),
+	}
+}
+
+// Preload dumps the given variables before the first request.
+func (s *DumpVarsServer) Preload(vars []string) error {
+	_, err := s.Query(DumpVarsRequest{Vars: vars})
+	return err
+}
+
+// Query returns the values of the variables in the request.
+func (s *DumpVarsServer) Query(req DumpVarsRequest) (*DumpVarsResponse, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	select {
+	case s.requests <- true:
+	default:
+	}
+
+	if stamp := s.stamp(); req.Reload || !stamp.Equal(s.cacheStamp) {
+		s.cache = nil
+		s.cacheStamp = stamp
+	}
+	if req.Reload {
+		// Forget the variables of earlier requests too, in case one of
+		// them is what breaks product config.
+		s.names = nil
+	}
+
+	complete := s.cache != nil
+	for _, name := range DumpVarsNames(req.Vars, req.AbsVars) {
+		if !inList(name, s.names) {
+			s.names = append(s.names, name)
+			complete = false
+		}
+	}
+	if !complete {
+		varData, err := s.dumpNames()
+		if err != nil {
+			// The cache doesn't have the new names, dump them again
+			// on the next request.
+			s.cache = nil
+			return nil, err
+		}
+		s.cache = make(map[string]string, len(s.names))
+		for _, name := range s.names {
+			s.cache[name] = varData[name]
+		}
+	}
+
+	return NewDumpVarsResponse(s.cache, req.Vars, req.AbsVars)
+}
+
+// dumpNames dumps all the variables that have been queried so far. Product
+// config reports its errors with ctx.Fatal, they are returned to the client
+// instead of stopping the server.
+func (s *DumpVarsServer) dumpNames() (varData map[string]string, err error) {
+	defer logger.Recover(func(fatalErr error) {
+		varData, err = nil, fatalErr
+	})
+	return s.dump(s.names)
+}
+
+// Serve answers the requests read from r, one JSON request per line, with a
+// JSON response per line to w until r is closed.
+func (s *DumpVarsServer) Serve(r io.Reader, w io.Writer) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1024*1024)
+	encoder := json.NewEncoder(w)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		var req DumpVarsRequest
+		resp := &DumpVarsResponse{}
+		if err := json.Unmarshal([]byte(line), &req); err != nil {
+			resp.Error = fmt.Sprintf("invalid request: %s", err)
+		} else if ret, err := s.Query(req); err != nil {
+			resp.Error = err.Error()
+		} else {
+			resp = ret
+		}
+		if err := encoder.Encode(resp); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// ServeSocket answers the requests of the clients that connect to a Unix
+// domain socket at path, until no request has been received for idleTimeout.
+func (s *DumpVarsServer) ServeSocket(path string, idleTimeout time.Duration) error {
+	// A socket left over from a previous server would make Listen fail.
+	os.Remove(path)
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(path)
+
+	idle := make(chan bool)
+	go func() {
+		timer := time.NewTimer(idleTimeout)
+		for {
+			select {
+			case <-s.requests:
+				if !timer.Stop() {
+					<-timer.C
+				}
+				timer.Reset(idleTimeout)
+			case <-timer.C:
+				close(idle)
+				listener.Close()
+				return
+			}
+		}
+	}()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			select {
+			case <-idle:
+				return nil
+			default:
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		go func() {
+			defer conn.Close()
+			s.Serve(conn, conn)
+		}()
+	}
+}
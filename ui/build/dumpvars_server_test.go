@@ -0,0 +1,260 @@
+// Copyright 2023 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"android/soong/ui/logger"
+)
+
+type fakeProductConfig struct {
+	values map[string]string
+	dumps  [][]string
+	stamp  time.Time
+}
+
+func (f *fakeProductConfig) server() *DumpVarsServer {
+	return newDumpVarsServer(func(vars []string) (map[string]string, error) {
+		f.dumps = append(f.dumps, vars)
+		ret := make(map[string]string)
+		for _, v := range vars {
+			ret[v] = f.values[v]
+		}
+		return ret, nil
+	}, func() time.Time { return f.stamp })
+}
+
+func TestDumpVarsServerQuery(t *testing.T) {
+	f := &fakeProductConfig{values: map[string]string{
+		"TARGET_PRODUCT": "aosp_arm64",
+		"OUT_DIR":        "/out",
+		"HOST_OUT":       "/out/host/linux-x86",
+	}}
+	s := f.server()
+
+	resp, err := s.Query(DumpVarsRequest{Vars: []string{"TARGET_PRODUCT"}, AbsVars: []string{"HOST_OUT"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &DumpVarsResponse{
+		Vars:    map[string]string{"TARGET_PRODUCT": "aosp_arm64"},
+		AbsVars: map[string]string{"HOST_OUT": "/out/host/linux-x86"},
+	}
+	if !reflect.DeepEqual(resp, want) {
+		t.Errorf("expected %+v, got %+v", want, resp)
+	}
+
+	// Cached variables don't run product config again, and a new variable
+	// is dumped together with the ones queried before.
+	if _, err := s.Query(DumpVarsRequest{Vars: []string{"TARGET_PRODUCT"}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Query(DumpVarsRequest{Vars: []string{"TARGET_PRODUCT", "OUT_DIR"}}); err != nil {
+		t.Fatal(err)
+	}
+	all := []string{"TARGET_PRODUCT", "HOST_OUT", "OUT_DIR"}
+	if want := [][]string{{"TARGET_PRODUCT", "HOST_OUT"}, all}; !reflect.DeepEqual(f.dumps, want) {
+		t.Errorf("expected dumps %q, got %q", want, f.dumps)
+	}
+
+	// A regenerated product config discards the cache, and a reload
+	// discards the variables of earlier requests too.
+	f.dumps = nil
+	f.stamp = time.Unix(1000, 0)
+	f.values["TARGET_PRODUCT"] = "aosp_x86_64"
+	resp, err = s.Query(DumpVarsRequest{Vars: []string{"TARGET_PRODUCT"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Vars["TARGET_PRODUCT"] != "aosp_x86_64" {
+		t.Errorf("expected the regenerated value, got %q", resp.Vars["TARGET_PRODUCT"])
+	}
+	if _, err := s.Query(DumpVarsRequest{Vars: []string{"TARGET_PRODUCT"}, Reload: true}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Query(DumpVarsRequest{Vars: []string{"OUT_DIR"}}); err != nil {
+		t.Fatal(err)
+	}
+	if want := [][]string{all, {"TARGET_PRODUCT"}, {"TARGET_PRODUCT", "OUT_DIR"}}; !reflect.DeepEqual(f.dumps, want) {
+		t.Errorf("expected dumps %q, got %q", want, f.dumps)
+	}
+
+	// report_config is the banner.
+	resp, err = s.Query(DumpVarsRequest{Vars: []string{"report_config"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(resp.Vars["report_config"], "TARGET_PRODUCT=aosp_x86_64\n") {
+		t.Errorf("expected the banner, got %q", resp.Vars["report_config"])
+	}
+}
+
+func TestDumpVarsServerFatal(t *testing.T) {
+	fail := true
+	s := newDumpVarsServer(func(vars []string) (map[string]string, error) {
+		if fail {
+			logger.New(ioutil.Discard).Fatalf("product config failed")
+		}
+		return map[string]string{"TARGET_PRODUCT": "aosp_arm64"}, nil
+	}, func() time.Time { return time.Time{} })
+
+	// Errors of product config are returned instead of exiting, and the
+	// variables are dumped again on the next query.
+	if _, err := s.Query(DumpVarsRequest{Vars: []string{"TARGET_PRODUCT"}}); err == nil || err.Error() != "product config failed" {
+		t.Errorf("expected the product config error, got %v", err)
+	}
+	fail = false
+	resp, err := s.Query(DumpVarsRequest{Vars: []string{"TARGET_PRODUCT"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Vars["TARGET_PRODUCT"] != "aosp_arm64" {
+		t.Errorf("expected the value after the error, got %q", resp.Vars["TARGET_PRODUCT"])
+	}
+}
+
+func TestDumpVarsServerRetry(t *testing.T) {
+	var dumps [][]string
+	fail := false
+	s := newDumpVarsServer(func(vars []string) (map[string]string, error) {
+		dumps = append(dumps, vars)
+		if fail {
+			return nil, errors.New("product config failed")
+		}
+		ret := make(map[string]string)
+		for _, v := range vars {
+			ret[v] = "value of " + v
+		}
+		return ret, nil
+	}, func() time.Time { return time.Time{} })
+
+	if _, err := s.Query(DumpVarsRequest{Vars: []string{"TARGET_PRODUCT"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A new variable that fails to dump is dumped again when it is queried
+	// after the error, instead of being answered from the cache of the
+	// earlier variables.
+	fail = true
+	if _, err := s.Query(DumpVarsRequest{Vars: []string{"TARGET_PRODUCT", "OUT_DIR"}}); err == nil {
+		t.Error("expected the product config error")
+	}
+	fail = false
+	resp, err := s.Query(DumpVarsRequest{Vars: []string{"TARGET_PRODUCT", "OUT_DIR"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Vars["OUT_DIR"] != "value of OUT_DIR" {
+		t.Errorf("expected the value after the error, got %q", resp.Vars["OUT_DIR"])
+	}
+
+	// A variable that keeps failing can be dropped with a reload.
+	fail = true
+	if _, err := s.Query(DumpVarsRequest{Vars: []string{"BROKEN"}}); err == nil {
+		t.Error("expected the product config error")
+	}
+	fail = false
+	if _, err := s.Query(DumpVarsRequest{Vars: []string{"TARGET_PRODUCT"}, Reload: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	all := []string{"TARGET_PRODUCT", "OUT_DIR"}
+	want := [][]string{{"TARGET_PRODUCT"}, all, all, {"TARGET_PRODUCT", "OUT_DIR", "BROKEN"}, {"TARGET_PRODUCT"}}
+	if !reflect.DeepEqual(dumps, want) {
+		t.Errorf("expected dumps %q, got %q", want, dumps)
+	}
+}
+
+func TestDumpVarsServerServe(t *testing.T) {
+	f := &fakeProductConfig{values: map[string]string{"TARGET_PRODUCT": "aosp_arm64"}}
+	s := f.server()
+
+	in := strings.NewReader(`{"vars": ["TARGET_PRODUCT"]}
+
+not json
+`)
+	var out strings.Builder
+	if err := s.Serve(in, &out); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 responses, got %q", lines)
+	}
+	if want := `{"vars":{"TARGET_PRODUCT":"aosp_arm64"},"abs_vars":{}}`; lines[0] != want {
+		t.Errorf("expected %s, got %s", want, lines[0])
+	}
+	var resp DumpVarsResponse
+	if err := json.Unmarshal([]byte(lines[1]), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(resp.Error, "invalid request") {
+		t.Errorf("expected an invalid request error, got %+v", resp)
+	}
+}
+
+func TestDumpVarsServerSocket(t *testing.T) {
+	f := &fakeProductConfig{values: map[string]string{"TARGET_PRODUCT": "aosp_arm64"}}
+	s := f.server()
+
+	path := filepath.Join(t.TempDir(), "dumpvars.sock")
+	done := make(chan error)
+	go func() {
+		done <- s.ServeSocket(path, 100*time.Millisecond)
+	}()
+
+	var conn net.Conn
+	var err error
+	for i := 0; i < 50; i++ {
+		if conn, err = net.Dial("unix", path); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Write([]byte(`{"vars": ["TARGET_PRODUCT"]}` + "\n")); err != nil {
+		t.Fatal(err)
+	}
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if !strings.Contains(line, `"TARGET_PRODUCT":"aosp_arm64"`) {
+		t.Errorf("unexpected response %q", line)
+	}
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("expected the server to exit after the idle timeout")
+	}
+}
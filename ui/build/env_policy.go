@@ -0,0 +1,203 @@
+// Copyright 2023 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+)
+
+const (
+	// envPolicyFile is the environment variable policy of the tree.
+	envPolicyFile = "build/soong/env_policy.json"
+
+	// envPolicyProductDir contains optional policies named after
+	// TARGET_PRODUCT that override the policy of the tree.
+	envPolicyProductDir = "build/soong/env_policy"
+
+	// envPolicyOverrideVar names an optional policy file that overrides
+	// both the policy of the tree and the policy of the product.
+	envPolicyOverrideVar = "ANDROID_BUILD_ENV_POLICY"
+)
+
+// The tools that environment variables can be passed through to, which run
+// with an allowlisted environment. soong_build isn't one of them, it can read
+// any variable and reruns when the variables it read change.
+var envPolicyTools = []string{"kati", "ninja"}
+
+var envVarNameRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// envPolicyVariable declares how soong_ui handles an environment variable.
+type envPolicyVariable struct {
+	Name string `json:"name"`
+
+	// Passthrough lists the tools that the variable is passed through to,
+	// in addition to the variables that are always passed through.
+	Passthrough []string `json:"passthrough,omitempty"`
+
+	// Unset removes the variable from the environment at startup.
+	Unset bool `json:"unset,omitempty"`
+
+	// Rewrite sets the variable to a fixed value at startup.
+	Rewrite *string `json:"rewrite,omitempty"`
+
+	// Warn is printed at startup if the variable is set.
+	Warn string `json:"warn,omitempty"`
+}
+
+type envPolicyJson struct {
+	Variables []envPolicyVariable `json:"variables"`
+}
+
+// envPolicy is the merged environment variable policy of the tree, the product
+// and the override file. A variable that is declared in a later file replaces
+// the declaration in an earlier one.
+type envPolicy struct {
+	variables map[string]envPolicyVariable
+}
+
+// envPolicyFiles returns the policy files that apply to the build, in order of
+// increasing priority. Only the override file is required to exist.
+func envPolicyFiles(env *Environment) (files []string, required []string) {
+	return layeredPolicyFiles(env, envPolicyFile, envPolicyProductDir, envPolicyOverrideVar)
+}
+
+// layeredPolicyFiles returns the policy file of the tree, the policy file of
+// TARGET_PRODUCT in productDir and the file named by overrideVar, in order of
+// increasing priority. Only the override file is required to exist.
+func layeredPolicyFiles(env *Environment, treeFile, productDir, overrideVar string) (files []string, required []string) {
+	files = []string{treeFile}
+	if product, ok := env.Get("TARGET_PRODUCT"); ok && product != "" {
+		files = append(files, filepath.Join(productDir, product+".json"))
+	}
+	if override, ok := env.Get(overrideVar); ok && override != "" {
+		files = append(files, override)
+		required = append(required, override)
+	}
+	return files, required
+}
+
+// loadEnvPolicy reads and validates the given policy files. Missing files are
+// skipped unless they are in required.
+func loadEnvPolicy(files []string, required []string) (*envPolicy, error) {
+	p := &envPolicy{variables: make(map[string]envPolicyVariable)}
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if os.IsNotExist(err) && !inList(file, required) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		variables, err := parseEnvPolicy(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		for _, v := range variables {
+			p.variables[v.Name] = v
+		}
+	}
+	return p, nil
+}
+
+// parseEnvPolicy parses and validates the contents of a policy file.
+func parseEnvPolicy(data []byte) ([]envPolicyVariable, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	var policy envPolicyJson
+	if err := decoder.Decode(&policy); err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	for _, v := range policy.Variables {
+		if !envVarNameRegexp.MatchString(v.Name) {
+			return nil, fmt.Errorf("invalid environment variable name %q", v.Name)
+		}
+		if seen[v.Name] {
+			return nil, fmt.Errorf("%s is declared more than once", v.Name)
+		}
+		seen[v.Name] = true
+
+		if v.Unset && v.Rewrite != nil {
+			return nil, fmt.Errorf("%s can't be both unset and rewritten", v.Name)
+		}
+		if v.Unset && len(v.Passthrough) > 0 {
+			return nil, fmt.Errorf("%s can't be both unset and passed through", v.Name)
+		}
+		for _, tool := range v.Passthrough {
+			if !inList(tool, envPolicyTools) {
+				return nil, fmt.Errorf("%s is passed through to unknown tool %q, expected one of %q",
+					v.Name, tool, envPolicyTools)
+			}
+		}
+		if !v.Unset && v.Rewrite == nil && v.Warn == "" && len(v.Passthrough) == 0 {
+			return nil, fmt.Errorf("%s doesn't declare a policy", v.Name)
+		}
+	}
+	return policy.Variables, nil
+}
+
+func (p *envPolicy) sortedNames() []string {
+	names := make([]string, 0, len(p.variables))
+	for name := range p.variables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// apply unsets and rewrites the variables in env at startup, and returns the
+// warnings for the variables that are set.
+func (p *envPolicy) apply(env *Environment) []string {
+	if p == nil {
+		return nil
+	}
+
+	var warnings []string
+	for _, name := range p.sortedNames() {
+		v := p.variables[name]
+		if _, ok := env.Get(name); ok && v.Warn != "" {
+			warnings = append(warnings, fmt.Sprintf("%s is set: %s", name, v.Warn))
+		}
+		if v.Unset {
+			env.Unset(name)
+		} else if v.Rewrite != nil {
+			env.Set(name, *v.Rewrite)
+		}
+	}
+	return warnings
+}
+
+// passthrough returns the variables that are passed through to a tool.
+func (p *envPolicy) passthrough(tool string) []string {
+	if p == nil {
+		return nil
+	}
+
+	var names []string
+	for _, name := range p.sortedNames() {
+		if inList(tool, p.variables[name].Passthrough) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
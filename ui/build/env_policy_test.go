@@ -0,0 +1,139 @@
+// Copyright 2023 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseEnvPolicy(t *testing.T) {
+	testCases := []struct {
+		name   string
+		policy string
+		err    string
+	}{
+		{
+			name: "valid",
+			policy: `{"variables": [
+				{"name": "FOO", "passthrough": ["kati", "ninja"]},
+				{"name": "BAR", "unset": true, "warn": "don't"},
+				{"name": "BAZ", "rewrite": ""}
+			]}`,
+		},
+		{
+			name:   "unknown field",
+			policy: `{"variables": [{"name": "FOO", "pasthrough": ["ninja"]}]}`,
+			err:    `unknown field "pasthrough"`,
+		},
+		{
+			name:   "invalid name",
+			policy: `{"variables": [{"name": "FOO=1", "unset": true}]}`,
+			err:    `invalid environment variable name "FOO=1"`,
+		},
+		{
+			name:   "duplicate",
+			policy: `{"variables": [{"name": "FOO", "unset": true}, {"name": "FOO", "warn": "x"}]}`,
+			err:    "FOO is declared more than once",
+		},
+		{
+			name:   "unset and rewritten",
+			policy: `{"variables": [{"name": "FOO", "unset": true, "rewrite": "1"}]}`,
+			err:    "FOO can't be both unset and rewritten",
+		},
+		{
+			name:   "unset and passed through",
+			policy: `{"variables": [{"name": "FOO", "unset": true, "passthrough": ["ninja"]}]}`,
+			err:    "FOO can't be both unset and passed through",
+		},
+		{
+			name:   "unknown tool",
+			policy: `{"variables": [{"name": "FOO", "passthrough": ["make"]}]}`,
+			err:    `FOO is passed through to unknown tool "make"`,
+		},
+		{
+			name:   "no policy",
+			policy: `{"variables": [{"name": "FOO"}]}`,
+			err:    "FOO doesn't declare a policy",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := parseEnvPolicy([]byte(tc.policy))
+			if tc.err == "" && err != nil {
+				t.Errorf("unexpected error: %s", err)
+			} else if tc.err != "" && (err == nil || !strings.Contains(err.Error(), tc.err)) {
+				t.Errorf("expected error containing %q, got %v", tc.err, err)
+			}
+		})
+	}
+}
+
+func TestEnvPolicy(t *testing.T) {
+	dir := t.TempDir()
+	tree := filepath.Join(dir, "tree.json")
+	product := filepath.Join(dir, "product.json")
+	if err := ioutil.WriteFile(tree, []byte(`{"variables": [
+		{"name": "PASSED", "passthrough": ["ninja"]},
+		{"name": "REMOVED", "unset": true, "warn": "it is obsolete"},
+		{"name": "REWRITTEN", "rewrite": "fixed"},
+		{"name": "OVERRIDDEN", "passthrough": ["ninja"]}
+	]}`), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(product, []byte(`{"variables": [
+		{"name": "OVERRIDDEN", "passthrough": ["kati"]}
+	]}`), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	policy, err := loadEnvPolicy([]string{tree, product, filepath.Join(dir, "missing.json")}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	env := &Environment{"PASSED=1", "REMOVED=1", "REWRITTEN=1", "OTHER=1"}
+	warnings := policy.apply(env)
+	if want := []string{"REMOVED is set: it is obsolete"}; !reflect.DeepEqual(warnings, want) {
+		t.Errorf("expected warnings %q, got %q", want, warnings)
+	}
+	if want := []string{"PASSED=1", "OTHER=1", "REWRITTEN=fixed"}; !reflect.DeepEqual(env.Environ(), want) {
+		t.Errorf("expected environment %q, got %q", want, env.Environ())
+	}
+
+	for tool, want := range map[string][]string{
+		"kati":  {"OVERRIDDEN"},
+		"ninja": {"PASSED"},
+	} {
+		if got := policy.passthrough(tool); !reflect.DeepEqual(got, want) {
+			t.Errorf("expected %s passthrough %q, got %q", tool, want, got)
+		}
+	}
+
+	if _, err := loadEnvPolicy([]string{filepath.Join(dir, "missing.json")},
+		[]string{filepath.Join(dir, "missing.json")}); !os.IsNotExist(err) {
+		t.Errorf("expected a not exist error for a missing required policy, got %v", err)
+	}
+
+	var nilPolicy *envPolicy
+	if got := nilPolicy.passthrough("ninja"); got != nil {
+		t.Errorf("expected no passthrough without a policy, got %q", got)
+	}
+}
@@ -37,6 +37,10 @@ type Cmd struct {
 	name   string
 
 	started time.Time
+
+	// sandboxAccesses records the accesses of the command to the paths that
+	// the sandbox policy restricts.
+	sandboxAccesses *sandboxAccesses
 }
 
 func Command(ctx Context, config Config, name string, executable string, args ...string) *Cmd {
@@ -77,7 +81,14 @@ func (c *Cmd) report() {
 			c.Cmd.ProcessState.UserTime().Round(time.Millisecond),
 			c.Cmd.ProcessState.SystemTime().Round(time.Millisecond),
 			rusage.Maxrss/1024)
+
+		if !state.Success() {
+			if err := c.sandboxAccesses.load(); err != nil {
+				c.ctx.Verbosef("Failed to read the sandbox accesses of %q: %v", c.name, err)
+			}
+		}
 	}
+	c.sandboxAccesses.remove()
 }
 
 func (c *Cmd) Start() error {
@@ -123,6 +134,9 @@ func (c *Cmd) reportError(err error) {
 	if err == nil {
 		return
 	}
+	for _, line := range c.sandboxAccesses.report() {
+		c.ctx.Println(line)
+	}
 	if e, ok := err.(*exec.ExitError); ok {
 		c.ctx.Fatalf("%s failed with: %v", c.name, e.ProcessState.String())
 	} else {
@@ -88,8 +88,9 @@ func NewSourceFinder(ctx Context, config Config) (f *finder.Finder) {
 			// Bazel top-level file to mark a directory as a Bazel workspace.
 			"WORKSPACE",
 		},
-		// Bazel Starlark configuration files and all .mk files for product/board configuration.
-		IncludeSuffixes: []string{".bzl", ".mk"},
+		// Bazel Starlark configuration files, all .mk files for product/board configuration
+		// and neverallow rule files.
+		IncludeSuffixes: []string{".bzl", ".mk", neverallowRuleFileSuffix},
 	}
 	dumpDir := config.FileListDir()
 	f, err = finder.New(cacheParams, filesystem, logger.New(ioutil.Discard),
@@ -120,6 +121,21 @@ func findBazelFiles(entries finder.DirEntries) (dirNames []string, fileNames []s
 	return entries.DirNames, matches
 }
 
+// neverallowRuleFileSuffix is the suffix of the files that add neverallow rules
+// to soong_build for the directory that contains them.
+const neverallowRuleFileSuffix = ".neverallow.json"
+
+// Finds the list of neverallow rule files in the tree.
+func findNeverallowRuleFiles(entries finder.DirEntries) (dirNames []string, fileNames []string) {
+	matches := []string{}
+	for _, foundName := range entries.FileNames {
+		if strings.HasSuffix(foundName, neverallowRuleFileSuffix) {
+			matches = append(matches, foundName)
+		}
+	}
+	return entries.DirNames, matches
+}
+
 func findProductAndBoardConfigFiles(entries finder.DirEntries) (dirNames []string, fileNames []string) {
 	matches := []string{}
 	for _, foundName := range entries.FileNames {
@@ -213,6 +229,13 @@ func FindSources(ctx Context, config Config, f *finder.Finder) {
 		ctx.Fatalf("Could not export product/board configuration list: %v", err)
 	}
 
+	// Recursively look for all neverallow rule files.
+	neverallowFiles := f.FindMatching(".", findNeverallowRuleFiles)
+	err = dumpListToFile(ctx, config, neverallowFiles, filepath.Join(dumpDir, "neverallow.list"))
+	if err != nil {
+		ctx.Fatalf("Could not export neverallow rule file list: %v", err)
+	}
+
 	if config.Dist() {
 		f.WaitForDbDump()
 		// Dist the files.db plain text database.
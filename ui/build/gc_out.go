@@ -0,0 +1,308 @@
+// Copyright 2023 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"bufio"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"android/soong/ui/metrics"
+)
+
+// liveOutputs is the set of files in the out directory that are still produced
+// by the current build graph.
+type liveOutputs struct {
+	// outputs contains every live file.
+	outputs map[string]bool
+
+	// dirs contains every directory that has a live file somewhere below it.
+	dirs map[string]bool
+
+	// outputDirs contains every directory that directly contains a live
+	// file. Actions may write undeclared files next to their outputs, so
+	// nothing in these directories is ever removed.
+	outputDirs map[string]bool
+}
+
+func newLiveOutputs() *liveOutputs {
+	return &liveOutputs{
+		outputs:    make(map[string]bool),
+		dirs:       make(map[string]bool),
+		outputDirs: make(map[string]bool),
+	}
+}
+
+func (l *liveOutputs) add(path string) {
+	path = filepath.Clean(path)
+	l.outputs[path] = true
+
+	dir := filepath.Dir(path)
+	l.outputDirs[dir] = true
+	for !l.dirs[dir] && dir != "." && dir != "/" {
+		l.dirs[dir] = true
+		dir = filepath.Dir(dir)
+	}
+}
+
+// outGarbage is a file or directory that is no longer produced by the build.
+type outGarbage struct {
+	path string
+	size int64
+}
+
+// productOutBookkeeping are the files in PRODUCT_OUT, relative to it, that are
+// written outside of ninja to keep track of previous builds.
+var productOutBookkeeping = []string{
+	"previous_build_config.mk",
+	".installable_files*",
+	"build_fingerprint.txt",
+	"build_thumbprint.txt",
+	"clean_steps.mk",
+}
+
+// findOutGarbage walks root, and returns the files and directories that don't
+// contain any live outputs, and are not next to any live outputs. Directories
+// that don't contain live outputs are returned as a whole instead of their
+// contents. Files and directories that match one of the keep glob patterns are
+// never returned or walked.
+func findOutGarbage(root string, live *liveOutputs, keep []string) ([]outGarbage, error) {
+	var garbage []outGarbage
+
+	var visit func(dir string) error
+	visit = func(dir string) error {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			path := filepath.Join(dir, entry.Name())
+			if live.outputs[path] || matchesAnyGlob(keep, path) {
+				continue
+			}
+			if entry.IsDir() && live.dirs[path] {
+				if err := visit(path); err != nil {
+					return err
+				}
+				continue
+			}
+			if live.outputDirs[dir] {
+				continue
+			}
+
+			size, err := diskUsage(path)
+			if err != nil {
+				return err
+			}
+			garbage = append(garbage, outGarbage{path: path, size: size})
+		}
+		return nil
+	}
+
+	if !live.dirs[root] {
+		// Nothing in the current build graph lives here, which more
+		// likely means the build graph is incomplete than that
+		// everything is garbage.
+		return nil, nil
+	}
+	if err := visit(root); err != nil {
+		return nil, err
+	}
+	return garbage, nil
+}
+
+func matchesAnyGlob(globs []string, path string) bool {
+	for _, glob := range globs {
+		if match, _ := filepath.Match(glob, path); match {
+			return true
+		}
+	}
+	return false
+}
+
+// diskUsage returns the total size of the files in path.
+func diskUsage(path string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(path, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
+
+// readNinjaOutputs adds every output of the combined ninja file to live.
+func readNinjaOutputs(ctx Context, config Config, live *liveOutputs) {
+	cmd := Command(ctx, config, "ninja -t targets",
+		config.PrebuiltBuildTool("ninja"),
+		"-f", config.CombinedNinjaFile(),
+		"-t", "targets", "all")
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		ctx.Fatalln("Failed to list ninja outputs:", err)
+	}
+	cmd.StartOrFatal()
+
+	// Each line is "<output>: <rule>".
+	scanner := bufio.NewScanner(out)
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.LastIndex(line, ": "); i > 0 {
+			live.add(line[:i])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		ctx.Fatalln("Failed to read ninja outputs:", err)
+	}
+	cmd.WaitOrFatal()
+}
+
+// gcRoots returns the directories to collect garbage in, and sets the Kati
+// suffix of the config to the one of the combined ninja file that the most
+// recent build ran. last_kati_suffix can't be used for this: it is left behind
+// by older builds when Kati is skipped, and then the Kati outputs in
+// PRODUCT_OUT and out/target/common are not part of the build graph even though
+// they are still in use.
+func gcRoots(config Config, last *lastNinjaBuild) []string {
+	config.SetKatiSuffix(last.KatiSuffix)
+
+	roots := []string{filepath.Join(config.SoongOutDir(), ".intermediates")}
+	if config.HasKatiSuffix() {
+		// The outputs of other products are not part of the build graph,
+		// leave them alone.
+		roots = append(roots,
+			filepath.Join(config.OutDir(), "target", "common"),
+			config.ProductOut())
+	}
+	return roots
+}
+
+// GarbageCollectOutDir removes the intermediates in the out directory that are
+// no longer produced by the build, for example because the module that produced
+// them was renamed or removed. It uses the ninja files of the most recent
+// build, so the build should be up to date before it's run. When dryRun is set,
+// the files are only reported.
+//
+// Installed files are left alone: cleanOldFiles removes the files that are no
+// longer installed at the end of every build, and installClean removes the
+// rest when the product changes.
+func GarbageCollectOutDir(ctx Context, config Config, dryRun bool) {
+	ctx.BeginTrace(metrics.Total, "gc-out")
+	defer ctx.EndTrace()
+
+	// Make sure that no other Soong process is running with the same output directory
+	buildLock := BecomeSingletonOrFail(ctx, config)
+	defer buildLock.Unlock()
+
+	SetupOutDir(ctx, config)
+	SetupPath(ctx, config)
+	defer summarizeHostToolUsage(ctx, config)
+	runMakeProductConfig(ctx, config)
+
+	last, err := readLastNinjaBuild(config.LastNinjaBuildFile())
+	if os.IsNotExist(err) {
+		ctx.Fatalln("The most recent build didn't run ninja, run a build before collecting garbage in the out directory.")
+	} else if err != nil {
+		ctx.Fatalln("Failed to read the most recent build:", err)
+	}
+
+	roots := gcRoots(config, last)
+	if !config.HasKatiSuffix() {
+		ctx.Println("The most recent build didn't use the ninja files generated by Kati, only collecting Soong intermediates.")
+	}
+
+	if _, err := os.Stat(config.CombinedNinjaFile()); err != nil {
+		ctx.Fatalf("%s doesn't exist, run a build before collecting garbage in the out directory.", config.CombinedNinjaFile())
+	}
+
+	keep := installCleanGlobs(config)
+	for _, file := range productOutBookkeeping {
+		keep = append(keep, filepath.Join(config.ProductOut(), file))
+	}
+
+	live := newLiveOutputs()
+	readNinjaOutputs(ctx, config, live)
+	if len(live.outputs) == 0 {
+		ctx.Fatalln("The build graph doesn't have any outputs, refusing to collect garbage.")
+	}
+
+	var garbage []outGarbage
+	for _, root := range roots {
+		rootGarbage, err := findOutGarbage(root, live, keep)
+		if err != nil {
+			ctx.Fatalf("Failed to walk %s: %v", root, err)
+		}
+		garbage = append(garbage, rootGarbage...)
+	}
+
+	sort.SliceStable(garbage, func(i, j int) bool {
+		return garbage[i].size > garbage[j].size
+	})
+
+	var total int64
+	for _, g := range garbage {
+		total += g.size
+		ctx.Verbosef("%s %s", humanBytes(g.size), g.path)
+	}
+
+	for i, g := range garbage {
+		if i == 20 {
+			ctx.Printf("... and %d more, see the verbose log for the full list", len(garbage)-i)
+			break
+		}
+		ctx.Printf("%10s %s", humanBytes(g.size), g.path)
+	}
+
+	if dryRun {
+		ctx.Printf("Would remove %d files and directories, %s in total.", len(garbage), humanBytes(total))
+		return
+	}
+
+	var removed int64
+	for _, g := range garbage {
+		if err := os.RemoveAll(g.path); err != nil {
+			ctx.Printf("Failed to remove %s: %v", g.path, err)
+			continue
+		}
+		removed += g.size
+	}
+	ctx.Printf("Removed %d files and directories, freed %s.", len(garbage), humanBytes(removed))
+}
+
+func humanBytes(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%dB", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
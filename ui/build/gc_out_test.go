@@ -0,0 +1,171 @@
+// Copyright 2023 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestFindOutGarbage(t *testing.T) {
+	root := filepath.Join(t.TempDir(), ".intermediates")
+
+	files := map[string]string{
+		// A live module, with an undeclared file next to its output.
+		"foo/libfoo/android_arm64/libfoo.so":     "live",
+		"foo/libfoo/android_arm64/libfoo.so.rsp": "undeclared",
+		"foo/libfoo/android_arm64/obj/foo.o":     "live",
+		"foo/libfoo/android_arm64/obj/old.o":     "next to a live output",
+		// A variant that isn't built anymore.
+		"foo/libfoo/android_x86/libfoo.so": "1234",
+		// A module that was removed.
+		"foo/libold/android_arm64/libold.so": "12345678",
+		"foo/libold/android_arm64/obj/a.o":   "1",
+		// A stray file in a directory without live outputs.
+		"foo/stray": "12",
+		// A module directory that was removed.
+		"bar/libbar/android_arm64/libbar.so": "123",
+		// Files that are kept even though they aren't live.
+		"baz/previous_build_config.mk": "kept",
+		"baz/system/bin/foo":           "kept",
+	}
+	for path, contents := range files {
+		path = filepath.Join(root, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(contents), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	live := newLiveOutputs()
+	live.add(filepath.Join(root, "foo/libfoo/android_arm64/libfoo.so"))
+	live.add(filepath.Join(root, "foo/libfoo/android_arm64/obj/foo.o"))
+
+	live.add(filepath.Join(root, "baz/obj/live"))
+	keep := []string{
+		filepath.Join(root, "baz/previous_build_config.mk"),
+		filepath.Join(root, "baz/system"),
+	}
+
+	garbage, err := findOutGarbage(root, live, keep)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Slice(garbage, func(i, j int) bool { return garbage[i].path < garbage[j].path })
+
+	want := []outGarbage{
+		{path: filepath.Join(root, "bar"), size: 3},
+		{path: filepath.Join(root, "foo/libfoo/android_x86"), size: 4},
+		{path: filepath.Join(root, "foo/libold"), size: 9},
+		{path: filepath.Join(root, "foo/stray"), size: 2},
+	}
+	if !reflect.DeepEqual(garbage, want) {
+		t.Errorf("expected garbage:\n%v\ngot:\n%v", want, garbage)
+	}
+
+	// A root without any live outputs is left alone.
+	garbage, err = findOutGarbage(filepath.Join(root, "bar"), live, nil)
+	if err != nil || len(garbage) != 0 {
+		t.Errorf("expected no garbage without live outputs, got %v, %v", garbage, err)
+	}
+}
+
+func TestGcRoots(t *testing.T) {
+	ctx := testContext()
+
+	setup := func(t *testing.T, skipKatiNinja bool) Config {
+		outDir := t.TempDir()
+		env := Environment([]string{
+			"OUT_DIR=" + outDir,
+			"TARGET_DEVICE=generic",
+		})
+		config := Config{&configImpl{
+			environ:       &env,
+			arguments:     []string{"droid"},
+			skipKati:      true,
+			skipKatiNinja: skipKatiNinja,
+			katiSuffix:    "-aosp_arm",
+		}}
+		for _, file := range ninjaFiles(config) {
+			if err := os.MkdirAll(filepath.Dir(file), 0777); err != nil {
+				t.Fatal(err)
+			}
+			if err := ioutil.WriteFile(file, nil, 0666); err != nil {
+				t.Fatal(err)
+			}
+		}
+		writeLastNinjaBuild(ctx, config)
+		return config
+	}
+
+	roots := func(t *testing.T, config Config) []string {
+		last, err := readLastNinjaBuild(config.LastNinjaBuildFile())
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Start from a fresh config like soong_ui --gc-out does.
+		env := Environment([]string{
+			"OUT_DIR=" + config.OutDir(),
+			"TARGET_DEVICE=generic",
+		})
+		gcConfig := Config{&configImpl{environ: &env}}
+		return gcRoots(gcConfig, last)
+	}
+
+	t.Run("kati", func(t *testing.T) {
+		config := setup(t, false)
+		want := []string{
+			filepath.Join(config.SoongOutDir(), ".intermediates"),
+			filepath.Join(config.OutDir(), "target", "common"),
+			config.ProductOut(),
+		}
+		if got := roots(t, config); !reflect.DeepEqual(got, want) {
+			t.Errorf("expected roots %q, got %q", want, got)
+		}
+	})
+
+	t.Run("skip kati ninja", func(t *testing.T) {
+		// last_kati_suffix is left behind by an older build, but the
+		// combined ninja file doesn't include the Kati ninja files.
+		config := setup(t, true)
+		if err := ioutil.WriteFile(config.LastKatiSuffixFile(), []byte("-aosp_arm"), 0666); err != nil {
+			t.Fatal(err)
+		}
+		want := []string{filepath.Join(config.SoongOutDir(), ".intermediates")}
+		if got := roots(t, config); !reflect.DeepEqual(got, want) {
+			t.Errorf("expected roots %q, got %q", want, got)
+		}
+	})
+}
+
+func TestHumanBytes(t *testing.T) {
+	for size, want := range map[int64]string{
+		12:         "12B",
+		2048:       "2.0KiB",
+		5 << 30:    "5.0GiB",
+		1536 << 20: "1.5GiB",
+		600 << 30:  "600.0GiB",
+	} {
+		if got := humanBytes(size); got != want {
+			t.Errorf("humanBytes(%d) = %q, want %q", size, got, want)
+		}
+	}
+}
@@ -35,20 +35,34 @@ const gomaLeastNFiles = 16000
 // Note that since go syscall package do not have RLIMIT_NPROC constant,
 // we use bash ulimit instead.
 func ulimitOrFatal(ctx Context, config Config, opt string) int {
+	num, err := ulimit(ctx, config, opt)
+	if err != nil {
+		ctx.Fatal(err)
+	}
+	return num
+}
+
+// ulimit returns the limit of the shell for a ulimit option, or math.MaxInt32
+// if it is unlimited.
+func ulimit(ctx Context, config Config, opt string) (int, error) {
 	commandText := fmt.Sprintf("ulimit %s", opt)
 	cmd := Command(ctx, config, commandText, "bash", "-c", commandText)
-	output := strings.TrimRight(string(cmd.CombinedOutputOrFatal()), "\n")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return 0, fmt.Errorf("%s failed with: %v", commandText, err)
+	}
+	output := strings.TrimRight(string(out), "\n")
 	ctx.Verbose(output + "\n")
 	ctx.Verbose("done\n")
 
 	if output == "unlimited" {
-		return math.MaxInt32
+		return math.MaxInt32, nil
 	}
 	num, err := strconv.Atoi(output)
 	if err != nil {
-		ctx.Fatalf("ulimit returned unexpected value: %s: %v\n", opt, err)
+		return 0, fmt.Errorf("ulimit returned unexpected value: %s: %v\n", opt, err)
 	}
-	return num
+	return num, nil
 }
 
 func startGoma(ctx Context, config Config) {
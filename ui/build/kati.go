@@ -319,7 +319,7 @@ func runKatiPackage(ctx Context, config Config) {
 
 	// Run Kati against a restricted set of environment variables.
 	runKati(ctx, config, katiPackageSuffix, args, func(env *Environment) {
-		env.Allow([]string{
+		env.Allow(append([]string{
 			// Some generic basics
 			"LANG",
 			"LC_MESSAGES",
@@ -336,7 +336,7 @@ func runKatiPackage(ctx Context, config Config) {
 			"ANDROID_BUILD_SHELL",
 			"DIST_DIR",
 			"OUT_DIR",
-		}...)
+		}, config.envPolicy.passthrough("kati")...)...)
 
 		if config.Dist() {
 			env.Set("DIST", "true")
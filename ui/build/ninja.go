@@ -49,6 +49,16 @@ func runNinjaForBuild(ctx Context, config Config) {
 		"--frontend_file", fifo,
 	}
 
+	// Explaining why each action runs slows down ninja's scan of the graph
+	// and keeps the explanations in memory until the actions run, so it's
+	// only done when asked for.
+	if config.explainRebuilds {
+		args = append(args, "-d", "explain")
+	}
+
+	if config.retry != nil {
+		args = append(args, config.retry.failedOutputs...)
+	}
 	args = append(args, config.NinjaArgs()...)
 
 	var parallel int
@@ -111,7 +121,7 @@ func runNinjaForBuild(ctx Context, config Config) {
 	if cmd.Environment.IsEnvTrue("ALLOW_NINJA_ENV") {
 		ctx.Println("Allowing all environment variables during ninja; incremental builds may be unsafe.")
 	} else {
-		cmd.Environment.Allow(append([]string{
+		cmd.Environment.Allow(append(append([]string{
 			// Set the path to a symbolizer (e.g. llvm-symbolizer) so ASAN-based
 			// tools can symbolize crashes.
 			"ASAN_SYMBOLIZER_PATH",
@@ -172,7 +182,7 @@ func runNinjaForBuild(ctx Context, config Config) {
 
 			// LLVM compiler wrapper options
 			"TOOLCHAIN_RUSAGE_OUTPUT",
-		}, config.BuildBrokenNinjaUsesEnvVars()...)...)
+		}, config.BuildBrokenNinjaUsesEnvVars()...), config.envPolicy.passthrough("ninja")...)...)
 	}
 
 	cmd.Environment.Set("DIST_DIR", config.DistDir())
@@ -207,6 +217,13 @@ func runNinjaForBuild(ctx Context, config Config) {
 		}
 	}()
 
+	// Record the resource usage of the system while ninja runs, so that slow
+	// stretches of the build can be correlated with memory pressure.
+	if ctx.Tracer != nil {
+		stopSampling := ctx.Tracer.SampleResources(time.Second)
+		defer stopSampling()
+	}
+
 	ctx.Status.Status("Starting ninja...")
 	cmd.RunAndStreamOrFatal()
 }
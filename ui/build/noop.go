@@ -0,0 +1,306 @@
+// Copyright 2023 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"android/soong/ui/metrics"
+	"android/soong/ui/status"
+)
+
+// maxNoopReportActions is the number of dirty outputs that are printed by
+// --verify-noop, all of them are written to the report file.
+const maxNoopReportActions = 50
+
+// NoopAction is an output that ninja would build again right after a build,
+// and an entry of the report file of --verify-noop.
+type NoopAction struct {
+	Output string `json:"output"`
+
+	// Module is the module that owns the output, if it could be found.
+	Module string `json:"module,omitempty"`
+
+	// Reasons are why the output is dirty, as explained by ninja.
+	Reasons []string `json:"reasons"`
+}
+
+// NoopReport is the result of --verify-noop.
+type NoopReport struct {
+	// ActionCount is the number of actions that ninja would run.
+	ActionCount int `json:"action_count"`
+
+	// Descriptions are the descriptions of the actions that ninja would
+	// run, in order.
+	Descriptions []string `json:"descriptions,omitempty"`
+
+	Actions []NoopAction `json:"actions,omitempty"`
+
+	// Other are the explanations from ninja that don't name an output.
+	Other []string `json:"other,omitempty"`
+}
+
+// Clean returns whether the build was a no-op.
+func (r *NoopReport) Clean() bool {
+	return r.ActionCount == 0 && len(r.Actions) == 0
+}
+
+var ninjaStatusLine = regexp.MustCompile(`^\[\d+/\d+\] (.*)$`)
+
+// parseNinjaDryRun parses the output of ninja -n -d explain.
+func parseNinjaDryRun(output string) *NoopReport {
+	report := &NoopReport{}
+	actions := make(map[string]*NoopAction)
+
+	for _, line := range strings.Split(output, "\n") {
+		if explanation := strings.TrimPrefix(line, "ninja explain: "); explanation != line {
+			reason := status.ParseNinjaExplanation(explanation)
+			if reason == nil {
+				if !inList(explanation, report.Other) {
+					report.Other = append(report.Other, explanation)
+				}
+				continue
+			}
+			if reason.Output == "" {
+				// Dirty inputs have an explanation of their own if
+				// they are produced by an action, or fail the dry
+				// run if they are missing.
+				continue
+			}
+			action := actions[reason.Output]
+			if action == nil {
+				action = &NoopAction{Output: reason.Output}
+				actions[reason.Output] = action
+			}
+			if !inList(reason.String(), action.Reasons) {
+				action.Reasons = append(action.Reasons, reason.String())
+			}
+		} else if match := ninjaStatusLine.FindStringSubmatch(line); match != nil {
+			report.ActionCount++
+			report.Descriptions = append(report.Descriptions, match[1])
+		}
+	}
+
+	for _, action := range actions {
+		report.Actions = append(report.Actions, *action)
+	}
+	sort.Slice(report.Actions, func(i, j int) bool {
+		return report.Actions[i].Output < report.Actions[j].Output
+	})
+	return report
+}
+
+// ninjaOutputOwners returns the modules that own the given outputs of a ninja
+// file written by blueprint, from the comment that precedes the build
+// statements of each module.
+func ninjaOutputOwners(r io.Reader, outputs []string) (map[string]string, error) {
+	wanted := make(map[string]bool, len(outputs))
+	for _, output := range outputs {
+		wanted[output] = true
+	}
+
+	ret := make(map[string]string)
+	var module, variant string
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 64*1024*1024)
+	var statement strings.Builder
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		// A $ at the end of a line continues the statement on the next
+		// line, unless it is escaped.
+		if statement.Len() > 0 || strings.HasPrefix(line, "build ") {
+			trimmed := strings.TrimRight(line, "$")
+			continued := (len(line)-len(trimmed))%2 == 1
+			if continued {
+				line = line[:len(line)-1]
+			}
+			statement.WriteString(strings.TrimLeft(line, " "))
+			if continued {
+				continue
+			}
+			for _, output := range ninjaBuildOutputs(statement.String()) {
+				if wanted[output] && module != "" {
+					ret[output] = module
+					if variant != "" {
+						ret[output] += " (" + variant + ")"
+					}
+				}
+			}
+			statement.Reset()
+			continue
+		}
+
+		if strings.HasPrefix(line, "# Module:") {
+			module = strings.TrimSpace(strings.TrimPrefix(line, "# Module:"))
+			variant = ""
+		} else if strings.HasPrefix(line, "# Variant:") {
+			variant = strings.TrimSpace(strings.TrimPrefix(line, "# Variant:"))
+		} else if strings.HasPrefix(line, "# Singleton:") {
+			module = "singleton " + strings.TrimSpace(strings.TrimPrefix(line, "# Singleton:"))
+			variant = ""
+		}
+	}
+	return ret, scanner.Err()
+}
+
+// ninjaBuildOutputs returns the unescaped explicit and implicit outputs of a
+// build statement.
+func ninjaBuildOutputs(statement string) []string {
+	var outputs []string
+	var cur strings.Builder
+	flush := func() {
+		if cur.Len() > 0 && cur.String() != "|" {
+			outputs = append(outputs, cur.String())
+		}
+		cur.Reset()
+	}
+	s := strings.TrimPrefix(statement, "build ")
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '$' && i+1 < len(s):
+			i++
+			cur.WriteByte(s[i])
+		case c == ' ':
+			flush()
+		case c == ':':
+			flush()
+			return outputs
+		default:
+			cur.WriteByte(c)
+		}
+	}
+	flush()
+	return outputs
+}
+
+// makeIntermediatesModule matches the intermediates directories of Make
+// modules, which are named after the module.
+var makeIntermediatesModule = regexp.MustCompile(`/obj(?:_[a-z0-9_]+)?/[A-Z_]+/([^/]+)_intermediates/`)
+
+// findNoopActionOwners fills in the modules that own the dirty outputs, from
+// Soong's ninja file or from the Make intermediates directory they are in.
+func findNoopActionOwners(ctx Context, config Config, report *NoopReport) {
+	if len(report.Actions) == 0 {
+		return
+	}
+
+	var outputs []string
+	for _, action := range report.Actions {
+		outputs = append(outputs, action.Output)
+	}
+
+	owners := make(map[string]string)
+	if f, err := os.Open(config.SoongNinjaFile()); err != nil {
+		ctx.Verbosef("Not finding the owners of Soong outputs: %v", err)
+	} else {
+		owners, err = ninjaOutputOwners(f, outputs)
+		f.Close()
+		if err != nil {
+			ctx.Verbosef("Failed to find the owners of Soong outputs: %v", err)
+		}
+	}
+
+	for i := range report.Actions {
+		action := &report.Actions[i]
+		if owner, ok := owners[action.Output]; ok {
+			action.Module = owner
+		} else if match := makeIntermediatesModule.FindStringSubmatch("/" + action.Output); match != nil {
+			action.Module = match[1] + " (make)"
+		}
+	}
+}
+
+// VerifyNoop asks ninja which actions it would run for the goals of the build
+// that just finished, and writes the actions that are dirty and why to
+// reportFile. Actions that are dirty right after a build don't write all of
+// their outputs, don't update their timestamps or are missing restat, and run
+// again in every incremental build.
+func VerifyNoop(ctx Context, config Config, reportFile string) *NoopReport {
+	ctx.BeginTrace(metrics.RunSetupTool, "verify noop")
+	defer ctx.EndTrace()
+
+	lock := BecomeSingletonOrFail(ctx, config)
+	defer lock.Unlock()
+
+	args := []string{
+		"-n",
+		"-d", "explain",
+		"-f", config.CombinedNinjaFile(),
+		"-o", "usesphonyoutputs=yes",
+		"-w", "dupbuild=err",
+	}
+	args = append(args, config.NinjaArgs()...)
+	cmd := Command(ctx, config, "ninja dry run", config.PrebuiltBuildTool("ninja"), args...)
+	cmd.Environment.Set("NINJA_STATUS", "[%f/%t] ")
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		ctx.Println(string(output))
+		ctx.Fatalf("ninja dry run failed: %v", err)
+	}
+
+	report := parseNinjaDryRun(string(output))
+	findNoopActionOwners(ctx, config, report)
+
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		ctx.Fatal(err)
+	}
+	if err := ioutil.WriteFile(reportFile, append(data, '\n'), 0666); err != nil {
+		ctx.Fatalf("Failed to write %s: %v", reportFile, err)
+	}
+
+	if report.Clean() {
+		fmt.Fprintln(ctx.Writer, "The build is a no-op, ninja has no work to do.")
+	} else {
+		printNoopReport(ctx.Writer, report)
+	}
+	fmt.Fprintf(ctx.Writer, "\nWrote the report to %s\n", reportFile)
+
+	return report
+}
+
+func printNoopReport(w io.Writer, report *NoopReport) {
+	fmt.Fprintf(w, "ninja would run %d actions right after a build, with %d dirty outputs:\n",
+		report.ActionCount, len(report.Actions))
+	for i, action := range report.Actions {
+		if i == maxNoopReportActions {
+			fmt.Fprintf(w, "  ... and %d more\n", len(report.Actions)-i)
+			break
+		}
+		module := action.Module
+		if module == "" {
+			module = "unknown module"
+		}
+		fmt.Fprintf(w, "  %s [%s]\n", action.Output, module)
+		for _, reason := range action.Reasons {
+			fmt.Fprintf(w, "    %s\n", reason)
+		}
+	}
+	if len(report.Other) > 0 {
+		fmt.Fprintln(w, "Other explanations from ninja:")
+		for _, other := range report.Other {
+			fmt.Fprintf(w, "  %s\n", other)
+		}
+	}
+}
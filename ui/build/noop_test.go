@@ -0,0 +1,119 @@
+// Copyright 2023 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseNinjaDryRun(t *testing.T) {
+	output := strings.Join([]string{
+		"ninja explain: output out/soong/.intermediates/foo/gen/foo.h doesn't exist",
+		"ninja explain: out/soong/.intermediates/foo/gen/foo.h is dirty",
+		"ninja explain: output out/target/product/generic/obj/ETC/bar_intermediates/bar older than most recent input out/soong/bar.txt (1000 vs 2000)",
+		"ninja explain: command line changed for out/target/product/generic/obj/ETC/bar_intermediates/bar",
+		"ninja explain: something new",
+		"[1/2] genrule foo.h",
+		"[2/2] Install: out/target/product/generic/system/etc/bar",
+		"",
+	}, "\n")
+
+	want := &NoopReport{
+		ActionCount:  2,
+		Descriptions: []string{"genrule foo.h", "Install: out/target/product/generic/system/etc/bar"},
+		Actions: []NoopAction{
+			{
+				Output:  "out/soong/.intermediates/foo/gen/foo.h",
+				Reasons: []string{"missing output: output out/soong/.intermediates/foo/gen/foo.h doesn't exist"},
+			},
+			{
+				Output: "out/target/product/generic/obj/ETC/bar_intermediates/bar",
+				Reasons: []string{
+					"newer input: output out/target/product/generic/obj/ETC/bar_intermediates/bar older than most recent input out/soong/bar.txt (1000 vs 2000)",
+					"command line changed: command line changed for out/target/product/generic/obj/ETC/bar_intermediates/bar",
+				},
+			},
+		},
+		Other: []string{"something new"},
+	}
+	got := parseNinjaDryRun(output)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %+v, got %+v", want, got)
+	}
+	if got.Clean() {
+		t.Errorf("expected the report not to be clean")
+	}
+
+	if clean := parseNinjaDryRun("ninja: no work to do.\n"); !clean.Clean() {
+		t.Errorf("expected a clean report, got %+v", clean)
+	}
+}
+
+func TestNinjaOutputOwners(t *testing.T) {
+	ninja := strings.Join([]string{
+		"# # # # # # # # # # # # # # # # # # # #",
+		"# Module:  foo",
+		"# Variant: android_arm64",
+		"# Type:    genrule",
+		"",
+		"build out/soong/.intermediates/foo/gen/foo.h $",
+		"        out/soong/.intermediates/foo/gen/foo$ bar.h: g.genrule.foo $",
+		"        foo.txt",
+		"",
+		"# # # # # # # # # # # # # # # # # # # #",
+		"# Singleton: bar",
+		"",
+		"build out/soong/bar$:baz.txt | out/soong/bar.d: touch",
+		"",
+	}, "\n")
+
+	got, err := ninjaOutputOwners(strings.NewReader(ninja), []string{
+		"out/soong/.intermediates/foo/gen/foo bar.h",
+		"out/soong/bar:baz.txt",
+		"out/soong/bar.d",
+		"foo.txt",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"out/soong/.intermediates/foo/gen/foo bar.h": "foo (android_arm64)",
+		"out/soong/bar:baz.txt":                      "singleton bar",
+		"out/soong/bar.d":                            "singleton bar",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+}
+
+func TestMakeIntermediatesModule(t *testing.T) {
+	testCases := map[string]string{
+		"out/target/product/generic/obj/ETC/bar_intermediates/bar":                   "bar",
+		"out/target/product/generic/obj_arm/SHARED_LIBRARIES/libx_intermediates/a.o": "libx",
+		"out/host/linux-x86/obj/EXECUTABLES/tool_intermediates/tool":                 "tool",
+		"out/soong/.intermediates/foo/gen/foo.h":                                     "",
+	}
+	for path, want := range testCases {
+		got := ""
+		if match := makeIntermediatesModule.FindStringSubmatch("/" + path); match != nil {
+			got = match[1]
+		}
+		if got != want {
+			t.Errorf("%s: expected %q, got %q", path, want, got)
+		}
+	}
+}
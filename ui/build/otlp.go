@@ -0,0 +1,128 @@
+// Copyright 2023 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	soong_metrics_proto "android/soong/ui/metrics/metrics_proto"
+	"android/soong/ui/tracer"
+)
+
+// otlpSendTimeout is how long soong_ui waits for the collector to accept a
+// batch of the spans of a build.
+const otlpSendTimeout = 10 * time.Second
+
+// otlpBatchSpans is the number of spans that are sent to the collector in one
+// request. Spans are at most a few KB, which keeps requests well below the 4MB
+// default limit of the OpenTelemetry collector.
+const otlpBatchSpans = 1000
+
+// OtlpTraceEndpoint returns the traces endpoint of the OpenTelemetry collector
+// that is configured with the standard OTLP exporter environment variables, or
+// an empty string.
+func OtlpTraceEndpoint(env *Environment) string {
+	if endpoint, ok := env.Get("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT"); ok && endpoint != "" {
+		return endpoint
+	}
+	if endpoint, ok := env.Get("OTEL_EXPORTER_OTLP_ENDPOINT"); ok && endpoint != "" {
+		return strings.TrimSuffix(endpoint, "/") + "/v1/traces"
+	}
+	return ""
+}
+
+// OtlpTraceEnabled returns whether the build should be exported as
+// OpenTelemetry spans, either to a file when SOONG_UI_OTLP_TRACE is set, or
+// to a collector.
+func OtlpTraceEnabled(env *Environment) bool {
+	return env.IsEnvTrue("SOONG_UI_OTLP_TRACE") || OtlpTraceEndpoint(env) != ""
+}
+
+// ExportOtlpTrace adds the phases of soong_ui and the events of soong_build
+// of the build that started at buildStarted to the actions that exporter
+// recorded, and sends them to the configured collector in batches. They are
+// written to build_trace.otlp.json in the logs directory, a batch per line, if
+// no collector is configured or if sending them fails. Failures are only
+// logged, exporting the trace must never fail a build.
+func ExportOtlpTrace(ctx Context, config Config, exporter *tracer.OtlpExporter, buildStarted time.Time) {
+	logsDir := config.LogsDir()
+	logsPrefix := config.GetLogsPrefix()
+
+	base := &soong_metrics_proto.MetricsBase{}
+	if ok, err := readBuildMetrics(logsDir, logsPrefix+"soong_metrics", buildStarted, base); err != nil {
+		ctx.Verboseln("Not exporting the OpenTelemetry trace:", err)
+		return
+	} else if !ok {
+		ctx.Verboseln("Not exporting the OpenTelemetry trace, the build wrote no soong_metrics")
+		return
+	}
+	exporter.SetResource(map[string]string{
+		"android.target_product": base.GetTargetProduct(),
+		"android.build_variant":  strings.ToLower(base.GetTargetBuildVariant().String()),
+		"android.goals":          strings.Join(base.GetBuildConfig().GetTargets(), " "),
+		"android.out_dir":        base.GetOutDir(),
+	})
+	for _, runs := range [][]*soong_metrics_proto.PerfInfo{
+		base.GetSetupTools(), base.GetSoongRuns(), base.GetKatiRuns(), base.GetBazelRuns(), base.GetNinjaRuns(),
+	} {
+		exporter.AddPerfInfos("", runs)
+	}
+
+	soongBuild := &soong_metrics_proto.SoongBuildMetrics{}
+	if ok, err := readBuildMetrics(logsDir, logsPrefix+"soong_build_metrics.pb", buildStarted, soongBuild); err != nil {
+		ctx.Verboseln("Not exporting the soong_build events:", err)
+	} else if ok {
+		exporter.AddPerfInfos("soong_build ", soongBuild.GetEvents())
+	}
+
+	name := "soong_ui"
+	if targets := base.GetBuildConfig().GetTargets(); len(targets) > 0 {
+		name += " " + strings.Join(targets, " ")
+	}
+	batches, err := exporter.Marshal(name, base.GetNonZeroExit(), otlpBatchSpans)
+	if err != nil {
+		ctx.Verboseln("Failed to export the OpenTelemetry trace:", err)
+		return
+	}
+
+	if endpoint := OtlpTraceEndpoint(config.Environment()); endpoint != "" {
+		err := sendOtlpBatches(endpoint, batches)
+		if err == nil {
+			return
+		}
+		ctx.Println("Failed to send the OpenTelemetry trace:", err)
+	}
+
+	file := filepath.Join(logsDir, logsPrefix+"build_trace.otlp.json")
+	if err := tracer.WriteOtlpFile(file, batches); err != nil {
+		ctx.Verboseln("Failed to write the OpenTelemetry trace:", err)
+		os.Remove(file)
+	}
+}
+
+// sendOtlpBatches sends the batches of spans to the collector in order, and
+// stops at the first one that fails.
+func sendOtlpBatches(endpoint string, batches [][]byte) error {
+	for i, data := range batches {
+		if err := tracer.SendOtlp(endpoint, data, otlpSendTimeout); err != nil {
+			return fmt.Errorf("batch %d of %d: %w", i+1, len(batches), err)
+		}
+	}
+	return nil
+}
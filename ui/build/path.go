@@ -15,6 +15,7 @@
 package build
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -79,9 +80,14 @@ func SetupLitePath(ctx Context, config Config, tmpDir string) {
 	myPath := filepath.Join(tmpDir, "path")
 	ensureEmptyDirectoriesExist(ctx, myPath)
 
+	configuration, err := hostToolsConfiguration(config)
+	if err != nil {
+		ctx.Fatalln("Failed to read host tools configuration:", err)
+	}
+
 	os.Setenv("PATH", origPath)
 	// Iterate over the ACL configuration of host tools for this build.
-	for name, pathConfig := range paths.Configuration {
+	for name, pathConfig := range configuration {
 		if !pathConfig.Symlink {
 			// Excludes 'Forbidden' and 'LinuxOnlyPrebuilt' PathConfigs.
 			continue
@@ -150,16 +156,45 @@ func SetupPath(ctx Context, config Config) {
 		ctx.Fatalln("Failed to write original path:", err)
 	}
 
+	// Save the parts of the ACL configuration of host tools for this build
+	// that are different from the one embedded in the interposer.
+	configuration, err := hostToolsConfiguration(config)
+	if err != nil {
+		ctx.Fatalln("Failed to read host tools configuration:", err)
+	}
+	if err := paths.WriteOverrides(interposer+"_config", configuration); err != nil {
+		ctx.Fatalln("Failed to write host tools configuration:", err)
+	}
+
+	// Record every invocation of a host tool, so that the usage of tools
+	// can be audited before they're forbidden.
+	if err := os.MkdirAll(config.LogsDir(), 0777); err != nil {
+		ctx.Fatalln("Failed to create logs directory:", err)
+	}
+	invocationsLog, err := os.Create(config.HostToolInvocationsFile())
+	if err != nil {
+		ctx.Fatalln("Failed to create host tool invocations log:", err)
+	}
+	usage := newHostToolUsage(invocationsLog)
+	config.hostToolUsage = usage
+
 	// Communication with the path interposer works over log entries. Set up the
 	// listener channel for the log entries here.
-	entries, err := paths.LogListener(ctx.Context, interposer+"_log")
+	listenerCtx, cancelListener := context.WithCancel(ctx.Context)
+	entries, err := paths.LogListener(listenerCtx, interposer+"_log")
 	if err != nil {
 		ctx.Fatalln("Failed to listen for path logs:", err)
 	}
+	listenerDone := make(chan struct{})
+	usage.stopListener = func() {
+		cancelListener()
+		<-listenerDone
+	}
 
 	// Loop over all log entry listener channels to validate usage of only
 	// allowed PATH tools at runtime.
 	go func() {
+		defer close(listenerDone)
 		for log := range entries {
 			curPid := os.Getpid()
 			for i, proc := range log.Parents {
@@ -181,7 +216,13 @@ func SetupPath(ctx Context, config Config) {
 			}
 
 			// Validate usage against disallowed or missing PATH tools.
-			config := paths.GetConfig(log.Basename)
+			config := paths.GetConfigFrom(configuration, log.Basename)
+			if err := usage.record(log, config); err != nil {
+				ctx.Verboseln("Failed to record host tool invocation:", err)
+			}
+			if !config.Log && !config.Error {
+				continue
+			}
 			if config.Error {
 				ctx.Printf("Disallowed PATH tool %q used: %#v", log.Basename, log.Args)
 				for _, line := range procPrints {
@@ -224,7 +265,7 @@ func SetupPath(ctx Context, config Config) {
 	// intercepted by the path_interposer binary, and validated with the
 	// LogEntry listener above at build time.
 	for _, name := range execs {
-		if !paths.GetConfig(name).Symlink {
+		if !paths.GetConfigFrom(configuration, name).Symlink {
 			// Ignore host tools that shouldn't be symlinked.
 			continue
 		}
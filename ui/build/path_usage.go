@@ -0,0 +1,262 @@
+// Copyright 2023 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"android/soong/ui/build/paths"
+)
+
+const (
+	// hostToolsConfigFile optionally overrides the PathConfigs of the tools
+	// in paths.Configuration for the tree.
+	hostToolsConfigFile = "build/soong/host_tools.json"
+
+	// hostToolsConfigVar names an optional file that overrides the
+	// PathConfigs of both paths.Configuration and hostToolsConfigFile.
+	hostToolsConfigVar = "ANDROID_BUILD_HOST_TOOLS_CONFIG"
+
+	// maxLoggedArgs is the number of arguments of each invocation that
+	// are recorded, and maxLoggedArgLen the length of each argument.
+	maxLoggedArgs   = 8
+	maxLoggedArgLen = 120
+)
+
+// hostToolsConfiguration returns the table of PathConfigs for the build.
+func hostToolsConfiguration(config Config) (map[string]paths.PathConfig, error) {
+	configuration := paths.Configuration
+
+	files := []string{hostToolsConfigFile}
+	override, _ := config.Environment().Get(hostToolsConfigVar)
+	if override != "" {
+		files = append(files, override)
+	}
+
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if os.IsNotExist(err) && file != override {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		configuration, err = paths.ParseConfiguration(data, configuration)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+	}
+	return configuration, nil
+}
+
+// hostToolInvocation is a line of the host tool invocation log.
+type hostToolInvocation struct {
+	Tool   string `json:"tool"`
+	Config string `json:"config"`
+
+	// Args are the first arguments of the invocation, including the name
+	// of the tool, shortened to keep the log small.
+	Args []string `json:"args"`
+
+	// Parents are the commands of the processes from soong_ui to the
+	// tool. They're only looked up for tools that are logged or
+	// forbidden.
+	Parents []string `json:"parents,omitempty"`
+}
+
+// hostToolStats summarizes the invocations of a tool.
+type hostToolStats struct {
+	config      string
+	invocations int
+
+	// actions counts the invocations by the command that ninja ran.
+	actions map[string]int
+}
+
+// hostToolUsage records every invocation of a host tool through the
+// path_interposer to a log, and summarizes them at the end of the build.
+type hostToolUsage struct {
+	lock  sync.Mutex
+	log   io.WriteCloser
+	tools map[string]*hostToolStats
+
+	// stopListener stops listening for the invocations logged by the
+	// path_interposer, and waits until the ones that were already received
+	// are recorded.
+	stopListener func()
+}
+
+func newHostToolUsage(log io.WriteCloser) *hostToolUsage {
+	return &hostToolUsage{
+		log:   log,
+		tools: make(map[string]*hostToolStats),
+	}
+}
+
+// summarizeArgs shortens the arguments of an invocation for the log.
+func summarizeArgs(args []string) []string {
+	var ret []string
+	for i, arg := range args {
+		if i == maxLoggedArgs {
+			ret = append(ret, fmt.Sprintf("... (%d more)", len(args)-i))
+			break
+		}
+		if len(arg) > maxLoggedArgLen {
+			arg = arg[:maxLoggedArgLen-3] + "..."
+		}
+		ret = append(ret, arg)
+	}
+	return ret
+}
+
+// ninjaAction returns the command that ninja ran in a process tree, or an
+// empty string if the tool wasn't run by ninja.
+func ninjaAction(parents []paths.LogProcess) string {
+	for i, proc := range parents {
+		fields := strings.Fields(proc.Command)
+		if len(fields) > 0 && filepath.Base(fields[0]) == "ninja" && i+1 < len(parents) {
+			return parents[i+1].Command
+		}
+	}
+	return ""
+}
+
+func (u *hostToolUsage) record(entry *paths.LogEntry, config paths.PathConfig) error {
+	invocation := hostToolInvocation{
+		Tool:   entry.Basename,
+		Config: paths.ConfigName(config),
+		Args:   summarizeArgs(entry.Args),
+	}
+	for _, proc := range entry.Parents {
+		invocation.Parents = append(invocation.Parents, proc.Command)
+	}
+
+	u.lock.Lock()
+	defer u.lock.Unlock()
+
+	stats, ok := u.tools[entry.Basename]
+	if !ok {
+		stats = &hostToolStats{
+			config:  invocation.Config,
+			actions: make(map[string]int),
+		}
+		u.tools[entry.Basename] = stats
+	}
+	stats.invocations++
+	if action := ninjaAction(entry.Parents); action != "" {
+		if len(action) > maxLoggedArgLen {
+			action = action[:maxLoggedArgLen-3] + "..."
+		}
+		stats.actions[action]++
+	}
+
+	data, err := json.Marshal(invocation)
+	if err != nil {
+		return err
+	}
+	_, err = u.log.Write(append(data, '\n'))
+	return err
+}
+
+// summary returns a line for each tool that was used, sorted by the number
+// of invocations, with the actions that used logged and forbidden tools the
+// most.
+func (u *hostToolUsage) summary() []string {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+
+	names := make([]string, 0, len(u.tools))
+	for name := range u.tools {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		a, b := u.tools[names[i]], u.tools[names[j]]
+		if a.invocations != b.invocations {
+			return a.invocations > b.invocations
+		}
+		return names[i] < names[j]
+	})
+
+	var lines []string
+	for _, name := range names {
+		stats := u.tools[name]
+		lines = append(lines, fmt.Sprintf("%s (%s): %d invocations", name, stats.config, stats.invocations))
+
+		actions := make([]string, 0, len(stats.actions))
+		for action := range stats.actions {
+			actions = append(actions, action)
+		}
+		sort.Slice(actions, func(i, j int) bool {
+			if stats.actions[actions[i]] != stats.actions[actions[j]] {
+				return stats.actions[actions[i]] > stats.actions[actions[j]]
+			}
+			return actions[i] < actions[j]
+		})
+		for i, action := range actions {
+			if i == 3 {
+				lines = append(lines, fmt.Sprintf("    ... and %d more actions", len(actions)-i))
+				break
+			}
+			lines = append(lines, fmt.Sprintf("    %d from %s", stats.actions[action], action))
+		}
+	}
+	return lines
+}
+
+// loggedTools returns the tools that were used that are configured to be
+// logged, and will become errors in the future.
+func (u *hostToolUsage) loggedTools() []string {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+
+	var tools []string
+	for name, stats := range u.tools {
+		if stats.config == paths.ConfigName(paths.Log) {
+			tools = append(tools, name)
+		}
+	}
+	sort.Strings(tools)
+	return tools
+}
+
+// summarizeHostToolUsage logs the summary of the host tools that were used
+// through the path_interposer at the end of the build.
+func summarizeHostToolUsage(ctx Context, config Config) {
+	u := config.hostToolUsage
+	if u == nil {
+		return
+	}
+	if u.stopListener != nil {
+		u.stopListener()
+	}
+
+	ctx.Verboseln("Host tools used through $PATH:")
+	for _, line := range u.summary() {
+		ctx.Verboseln("  " + line)
+	}
+	if tools := u.loggedTools(); len(tools) > 0 {
+		ctx.Printf("This build used host tools that will not be allowed in the future: %s. See %s for details.",
+			strings.Join(tools, ", "), config.HostToolInvocationsFile())
+	}
+
+	u.log.Close()
+}
@@ -0,0 +1,96 @@
+// Copyright 2023 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"android/soong/ui/build/paths"
+)
+
+type nopWriteCloser struct {
+	*bytes.Buffer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+func TestHostToolUsage(t *testing.T) {
+	var buf bytes.Buffer
+	usage := newHostToolUsage(nopWriteCloser{&buf})
+
+	parents := func(action string) []paths.LogProcess {
+		return []paths.LogProcess{
+			{Pid: 1, Command: "soong_ui --make-mode"},
+			{Pid: 2, Command: "prebuilts/build-tools/linux-x86/bin/ninja -f out/combined.ninja"},
+			{Pid: 3, Command: action},
+			{Pid: 4, Command: "perl script.pl"},
+		}
+	}
+
+	entries := []struct {
+		entry  *paths.LogEntry
+		config paths.PathConfig
+	}{
+		{&paths.LogEntry{Basename: "bash", Args: []string{"bash", "-c", "true"}}, paths.Allowed},
+		{&paths.LogEntry{Basename: "perl", Args: []string{"perl", strings.Repeat("x", 200)}, Parents: parents("/bin/bash -c gen_a")}, paths.Log},
+		{&paths.LogEntry{Basename: "perl", Args: []string{"perl"}, Parents: parents("/bin/bash -c gen_b")}, paths.Log},
+		{&paths.LogEntry{Basename: "perl", Args: []string{"perl"}, Parents: parents("/bin/bash -c gen_a")}, paths.Log},
+	}
+	for _, e := range entries {
+		if err := usage.record(e.entry, e.config); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != len(entries) {
+		t.Fatalf("expected %d lines in the log, got %d", len(entries), len(lines))
+	}
+	var invocation hostToolInvocation
+	if err := json.Unmarshal([]byte(lines[1]), &invocation); err != nil {
+		t.Fatal(err)
+	}
+	if invocation.Tool != "perl" || invocation.Config != "log" || len(invocation.Parents) != 4 {
+		t.Errorf("unexpected invocation %+v", invocation)
+	}
+	if len(invocation.Args[1]) != maxLoggedArgLen || !strings.HasSuffix(invocation.Args[1], "...") {
+		t.Errorf("expected long arguments to be shortened, got %q", invocation.Args[1])
+	}
+
+	want := []string{
+		"perl (log): 3 invocations",
+		"    2 from /bin/bash -c gen_a",
+		"    1 from /bin/bash -c gen_b",
+		"bash (allowed): 1 invocations",
+	}
+	if got := usage.summary(); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected summary %q, got %q", want, got)
+	}
+	if got := usage.loggedTools(); !reflect.DeepEqual(got, []string{"perl"}) {
+		t.Errorf("expected logged tools [perl], got %q", got)
+	}
+}
+
+func TestSummarizeArgs(t *testing.T) {
+	args := []string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j"}
+	want := []string{"a", "b", "c", "d", "e", "f", "g", "h", "... (2 more)"}
+	if got := summarizeArgs(args); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+}
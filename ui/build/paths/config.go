@@ -14,7 +14,16 @@
 
 package paths
 
-import "runtime"
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+)
 
 type PathConfig struct {
 	// Whether to create the symlink in the new PATH for this tool.
@@ -77,12 +86,147 @@ var LinuxOnlyPrebuilt = PathConfig{
 }
 
 func GetConfig(name string) PathConfig {
-	if config, ok := Configuration[name]; ok {
+	return GetConfigFrom(Configuration, name)
+}
+
+// GetConfigFrom returns the PathConfig of a tool in a configuration table like
+// Configuration, or Missing if it isn't listed.
+func GetConfigFrom(configuration map[string]PathConfig, name string) PathConfig {
+	if config, ok := configuration[name]; ok {
 		return config
 	}
 	return Missing
 }
 
+// configNames are the names of the PathConfigs in configuration files.
+var configNames = []struct {
+	name   string
+	config PathConfig
+}{
+	{"allowed", Allowed},
+	{"forbidden", Forbidden},
+	{"log", Log},
+	{"missing", Missing},
+	{"linux_only_prebuilt", LinuxOnlyPrebuilt},
+}
+
+// ConfigName returns the name of a PathConfig in configuration files.
+func ConfigName(config PathConfig) string {
+	for _, c := range configNames {
+		if c.config == config {
+			return c.name
+		}
+	}
+	return fmt.Sprintf("%+v", config)
+}
+
+func configByName(name string) (PathConfig, bool) {
+	for _, c := range configNames {
+		if c.name == name {
+			return c.config, true
+		}
+	}
+	return PathConfig{}, false
+}
+
+type configurationFile struct {
+	// Tools maps the names of tools to the names of their PathConfigs.
+	Tools map[string]string `json:"tools"`
+}
+
+// ParseConfiguration parses a configuration file, which lists the names of
+// the PathConfigs of tools:
+//
+//	{
+//	    "tools": {
+//	        "perl": "log",
+//	        "python": "forbidden"
+//	    }
+//	}
+//
+// The tools are added to a copy of base, replacing the PathConfigs of tools
+// that are already listed in it.
+func ParseConfiguration(data []byte, base map[string]PathConfig) (map[string]PathConfig, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	var file configurationFile
+	if err := decoder.Decode(&file); err != nil {
+		return nil, err
+	}
+
+	configuration := make(map[string]PathConfig, len(base)+len(file.Tools))
+	for name, config := range base {
+		configuration[name] = config
+	}
+
+	names := make([]string, 0, len(file.Tools))
+	for name := range file.Tools {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		config, ok := configByName(file.Tools[name])
+		if !ok {
+			return nil, fmt.Errorf("unknown PATH config %q for %q", file.Tools[name], name)
+		}
+		configuration[name] = config
+		if runtime.GOOS == "darwin" && configuration[name].LinuxOnlyPrebuilt {
+			configuration[name] = Allowed
+		}
+	}
+	return configuration, nil
+}
+
+// WriteOverrides writes the PathConfigs in a configuration table that are
+// different from the ones in Configuration to dir. Each one is written to a
+// file named after the tool that contains the name of its PathConfig, so that
+// the interposer only has to read the configuration of the tool that it runs,
+// and nothing for the tools that aren't overridden.
+func WriteOverrides(dir string, configuration map[string]PathConfig) error {
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return err
+	}
+	write := func(name string, config PathConfig) error {
+		if GetConfig(name) == config {
+			return nil
+		}
+		return ioutil.WriteFile(filepath.Join(dir, name), []byte(ConfigName(config)), 0666)
+	}
+	for name, config := range configuration {
+		if err := write(name, config); err != nil {
+			return err
+		}
+	}
+	for name := range Configuration {
+		if _, ok := configuration[name]; !ok {
+			if err := write(name, Missing); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// LoadOverride returns the PathConfig of a tool written by WriteOverrides, or
+// false if the configuration of the tool isn't overridden.
+func LoadOverride(dir, name string) (PathConfig, bool, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, name))
+	if os.IsNotExist(err) {
+		return PathConfig{}, false, nil
+	} else if err != nil {
+		return PathConfig{}, false, err
+	}
+	config, ok := configByName(string(data))
+	if !ok {
+		return PathConfig{}, false, fmt.Errorf("unknown PATH config %q for %q", data, name)
+	}
+	return config, true, nil
+}
+
 // This list specifies whether a particular binary from $PATH is allowed to be
 // run during the build. For more documentation, see path_interposer.go .
 var Configuration = map[string]PathConfig{
@@ -0,0 +1,91 @@
+// Copyright 2023 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package paths
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseConfiguration(t *testing.T) {
+	base := map[string]PathConfig{
+		"bash": Allowed,
+		"gcc":  Forbidden,
+	}
+
+	configuration, err := ParseConfiguration([]byte(`{"tools": {"perl": "log", "bash": "forbidden"}}`), base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]PathConfig{
+		"bash": Forbidden,
+		"gcc":  Forbidden,
+		"perl": Log,
+	}
+	if !reflect.DeepEqual(configuration, want) {
+		t.Errorf("expected %v, got %v", want, configuration)
+	}
+	if base["bash"] != Allowed {
+		t.Errorf("expected the base configuration not to be modified")
+	}
+
+	if _, err := ParseConfiguration([]byte(`{"tools": {"perl": "sometimes"}}`), base); err == nil ||
+		!strings.Contains(err.Error(), `unknown PATH config "sometimes" for "perl"`) {
+		t.Errorf("expected an error for an unknown PATH config, got %v", err)
+	}
+	if _, err := ParseConfiguration([]byte(`{"tool": {}}`), base); err == nil {
+		t.Errorf("expected an error for an unknown field")
+	}
+}
+
+func TestWriteOverrides(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "config")
+	configuration := make(map[string]PathConfig, len(Configuration))
+	for name, config := range Configuration {
+		configuration[name] = config
+	}
+	configuration["bash"] = Forbidden
+	configuration["perl"] = Log
+	delete(configuration, "gcc")
+
+	if err := WriteOverrides(dir, configuration); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var written []string
+	for _, entry := range entries {
+		written = append(written, entry.Name())
+	}
+	if want := []string{"bash", "gcc", "perl"}; !reflect.DeepEqual(written, want) {
+		t.Errorf("expected overrides for %q, got %q", want, written)
+	}
+
+	for name, want := range map[string]PathConfig{"bash": Forbidden, "perl": Log, "gcc": Missing} {
+		got, ok, err := LoadOverride(dir, name)
+		if err != nil || !ok || got != want {
+			t.Errorf("expected override %v for %s, got %v, %v, %v", want, name, got, ok, err)
+		}
+	}
+	if _, ok, err := LoadOverride(dir, "tar"); ok || err != nil {
+		t.Errorf("expected no override for tar, got %v, %v", ok, err)
+	}
+}
@@ -170,12 +170,8 @@ func logListener(ctx context.Context, logSocket string, lookup socketAddrFunc) (
 	}
 
 	go func() {
-		for {
-			select {
-			case <-ctx.Done():
-				ln.Close()
-			}
-		}
+		<-ctx.Done()
+		ln.Close()
 	}()
 
 	go func() {
@@ -23,6 +23,7 @@ import (
 	"runtime"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestSendLog(t *testing.T) {
@@ -107,6 +108,25 @@ func testSendLog(t *testing.T, socket string, lookup socketAddrFunc) {
 	}
 }
 
+func TestLogListenerCancel(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "s")
+	ctx, cancel := context.WithCancel(context.Background())
+	recv, err := logListener(ctx, socket, getSocketAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cancel()
+	select {
+	case entry, ok := <-recv:
+		if ok {
+			t.Errorf("expected no log entries, got %v", entry)
+		}
+	case <-time.After(10 * time.Second):
+		t.Errorf("expected the listener to stop when the context is canceled")
+	}
+}
+
 func TestSendLogError(t *testing.T) {
 	d, err := ioutil.TempDir("", "log_socket")
 	if err != nil {
@@ -15,11 +15,16 @@
 package build
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"math"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
@@ -29,48 +34,192 @@ import (
 // This file provides cross-process synchronization methods
 // i.e. making sure only one Soong process is running for a given output directory
 
+// BecomeSingletonOrFail waits for the exclusive lock of the output directory,
+// held for the whole build.
 func BecomeSingletonOrFail(ctx Context, config Config) (lock *fileLock) {
 	lockingInfo, err := newLock(config.OutDir())
 	if err != nil {
 		ctx.Logger.Fatal(err)
 	}
+	acquireLockOrFail(ctx, config, lockingInfo)
+	return lockingInfo
+}
+
+// LockConfigOrFail waits for the exclusive lock of the product config of the
+// output directory, held by a build while it runs product config.
+func LockConfigOrFail(ctx Context, config Config) (lock *fileLock) {
+	lockingInfo, err := newConfigLock(config.OutDir(), false)
+	if err != nil {
+		ctx.Logger.Fatal(err)
+	}
+	acquireConfigLockOrFail(ctx, config, lockingInfo)
+	return lockingInfo
+}
+
+// BecomeSharedOrFail waits for a shared lock of the product config of the
+// output directory, for read-only commands like --dumpvar-mode. They run
+// concurrently with each other and with a build, except while the build runs
+// product config.
+func BecomeSharedOrFail(ctx Context, config Config) (lock *fileLock) {
+	lockingInfo, err := newConfigLock(config.OutDir(), true)
+	if err != nil {
+		ctx.Logger.Fatal(err)
+	}
+	acquireConfigLockOrFail(ctx, config, lockingInfo)
+	return lockingInfo
+}
+
+func acquireLockOrFail(ctx Context, config Config, lock *fileLock) {
 	lockfilePollDuration := time.Second
 	lockfileTimeout := time.Second * 10
 	if envTimeout := os.Getenv("SOONG_LOCK_TIMEOUT"); envTimeout != "" {
+		var err error
 		lockfileTimeout, err = time.ParseDuration(envTimeout)
 		if err != nil {
 			ctx.Logger.Fatalf("failure parsing SOONG_LOCK_TIMEOUT %q: %s", envTimeout, err)
 		}
 	}
-	err = lockSynchronous(*lockingInfo, newSleepWaiter(lockfilePollDuration, lockfileTimeout), ctx.Logger)
+	acquireLockWithWaiterOrFail(ctx, config, lock, newSleepWaiter(lockfilePollDuration, lockfileTimeout))
+}
+
+// acquireConfigLockOrFail waits for the lock of the product config without a
+// timeout. It is only held while product config runs, so unlike the lock of
+// the output directory a busy lock means that the holder will release it soon.
+func acquireConfigLockOrFail(ctx Context, config Config, lock *fileLock) {
+	acquireLockWithWaiterOrFail(ctx, config, lock, newPollWaiter(time.Second))
+}
+
+func acquireLockWithWaiterOrFail(ctx Context, config Config, lock *fileLock, waiter waiter) {
+	err := lockSynchronous(*lock, waiter, ctx.Logger)
 	if err != nil {
 		ctx.Logger.Fatal(err)
 	}
-	return lockingInfo
+
+	holder := lockHolder{
+		Pid:     os.Getpid(),
+		Command: os.Args,
+		Started: config.BuildStartedTimeOrDefault(time.Now()),
+		Goals:   config.Arguments(),
+		Shared:  lock.shared,
+	}
+	if err := lock.recordHolder(holder); err != nil {
+		ctx.Verbosef("Failed to record the holder of %s: %v", lock.description(), err)
+	}
 }
 
 type lockable interface {
 	tryLock() error
 	Unlock() error
 	description() string
+
+	// holders returns the live processes that hold the lock.
+	holders() []lockHolder
 }
 
 var _ lockable = (*fileLock)(nil)
 
 type fileLock struct {
 	File *os.File
+
+	// shared is whether the lock is shared with other shared holders.
+	shared bool
+}
+
+// lockHolder describes a process that holds a lock. It is recorded next to
+// the lock file so that the processes that wait for the lock can explain who
+// they are waiting for.
+type lockHolder struct {
+	Pid     int       `json:"pid"`
+	Command []string  `json:"command"`
+	Started time.Time `json:"started"`
+	Goals   []string  `json:"goals,omitempty"`
+	Shared  bool      `json:"shared,omitempty"`
+}
+
+func (h lockHolder) String() string {
+	mode := "exclusive"
+	if h.Shared {
+		mode = "shared"
+	}
+	ret := fmt.Sprintf("PID %d (%s, started %s, %s ago): %s", h.Pid, mode,
+		h.Started.Format("15:04:05"), time.Since(h.Started).Round(time.Second), strings.Join(h.Command, " "))
+	if len(h.Goals) > 0 {
+		ret += fmt.Sprintf(" [goals: %s]", strings.Join(h.Goals, " "))
+	}
+	return ret
 }
 
 func (l fileLock) description() (path string) {
 	return l.File.Name()
 }
 func (l fileLock) tryLock() (err error) {
-	return syscall.Flock(int(l.File.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	how := syscall.LOCK_EX
+	if l.shared {
+		how = syscall.LOCK_SH
+	}
+	return syscall.Flock(int(l.File.Fd()), how|syscall.LOCK_NB)
 }
 func (l fileLock) Unlock() (err error) {
+	os.Remove(l.holderFile(os.Getpid()))
 	return l.File.Close()
 }
 
+// holdersDir contains a file for each process that holds the lock.
+func (l fileLock) holdersDir() string {
+	return l.File.Name() + ".holders"
+}
+
+func (l fileLock) holderFile(pid int) string {
+	return filepath.Join(l.holdersDir(), strconv.Itoa(pid)+".json")
+}
+
+func (l fileLock) recordHolder(holder lockHolder) error {
+	if err := os.MkdirAll(l.holdersDir(), 0777); err != nil {
+		return err
+	}
+	data, err := json.Marshal(holder)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(l.holderFile(holder.Pid), data, 0666)
+}
+
+// holders returns the recorded holders of the lock whose processes are still
+// running, and removes the records of the processes that exited without
+// releasing the lock.
+func (l fileLock) holders() []lockHolder {
+	files, err := ioutil.ReadDir(l.holdersDir())
+	if err != nil {
+		return nil
+	}
+
+	var ret []lockHolder
+	for _, file := range files {
+		if !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+		path := filepath.Join(l.holdersDir(), file.Name())
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		var holder lockHolder
+		if err := json.Unmarshal(data, &holder); err != nil {
+			continue
+		}
+		if holder.Pid == os.Getpid() {
+			continue
+		}
+		if err := syscall.Kill(holder.Pid, 0); err == syscall.ESRCH {
+			os.Remove(path)
+			continue
+		}
+		ret = append(ret, holder)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Started.Before(ret[j].Started) })
+	return ret
+}
+
 func lockSynchronous(lock lockable, waiter waiter, logger logger.Logger) (err error) {
 
 	waited := false
@@ -88,14 +237,29 @@ func lockSynchronous(lock lockable, waiter waiter, logger logger.Logger) (err er
 		done, description := waiter.checkDeadline()
 
 		if !waited {
-			logger.Printf("Waiting up to %s to lock %v to ensure no other Soong process is running in the same output directory\n", description, lock.description())
+			if description != "" {
+				logger.Printf("Waiting up to %s to lock %v to ensure no other Soong process is running in the same output directory\n", description, lock.description())
+			} else {
+				logger.Printf("Waiting to lock %v until the Soong processes that hold it release it\n", lock.description())
+			}
+			for _, holder := range lock.holders() {
+				logger.Printf("  The lock is held by %s\n", holder)
+			}
 		}
 
 		waited = true
 
 		if done {
-			return fmt.Errorf("Tried to lock %s, but timed out %s . Make sure no other Soong process is using it",
+			err = fmt.Errorf("Tried to lock %s, but timed out %s . Make sure no other Soong process is using it",
 				lock.description(), waiter.summarize())
+			if holders := lock.holders(); len(holders) > 0 {
+				var lines []string
+				for _, holder := range holders {
+					lines = append(lines, "  "+holder.String())
+				}
+				err = fmt.Errorf("%w. It is held by:\n%s", err, strings.Join(lines, "\n"))
+			}
+			return err
 		} else {
 			waiter.wait()
 		}
@@ -103,14 +267,22 @@ func lockSynchronous(lock lockable, waiter waiter, logger logger.Logger) (err er
 }
 
 func newLock(basedir string) (lock *fileLock, err error) {
-	lockPath := filepath.Join(basedir, ".lock")
+	return openLock(filepath.Join(basedir, ".lock"), false)
+}
 
-	os.MkdirAll(basedir, 0777)
+// newConfigLock returns the lock of the product config of the output
+// directory.
+func newConfigLock(basedir string, shared bool) (lock *fileLock, err error) {
+	return openLock(filepath.Join(basedir, ".config_lock"), shared)
+}
+
+func openLock(lockPath string, shared bool) (lock *fileLock, err error) {
+	os.MkdirAll(filepath.Dir(lockPath), 0777)
 	lockfileDescriptor, err := os.OpenFile(lockPath, os.O_RDWR|os.O_CREATE, 0666)
 	if err != nil {
 		return nil, errors.New("failed to open " + lockPath)
 	}
-	lockingInfo := &fileLock{File: lockfileDescriptor}
+	lockingInfo := &fileLock{File: lockfileDescriptor, shared: shared}
 
 	return lockingInfo, nil
 }
@@ -149,3 +321,24 @@ func (s *sleepWaiter) checkDeadline() (done bool, remainder string) {
 func (s sleepWaiter) summarize() (summary string) {
 	return fmt.Sprintf("polling every %v until %v", s.sleepInterval, s.totalWait)
 }
+
+// pollWaiter waits until the lock is released, without a deadline.
+type pollWaiter struct {
+	sleepInterval time.Duration
+}
+
+var _ waiter = (*pollWaiter)(nil)
+
+func newPollWaiter(interval time.Duration) *pollWaiter {
+	return &pollWaiter{interval}
+}
+
+func (p pollWaiter) wait() {
+	time.Sleep(p.sleepInterval)
+}
+func (p pollWaiter) checkDeadline() (done bool, remainder string) {
+	return false, ""
+}
+func (p pollWaiter) summarize() (summary string) {
+	return fmt.Sprintf("polling every %v", p.sleepInterval)
+}
@@ -20,8 +20,10 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 	"syscall"
 	"testing"
+	"time"
 
 	"android/soong/ui/logger"
 )
@@ -102,6 +104,10 @@ func (c *countLock) Unlock() (err error) {
 	return fmt.Errorf("Not locked: %s", c.description())
 }
 
+func (c *countLock) holders() []lockHolder {
+	return []lockHolder{{Pid: 1234, Command: []string{"soong_ui", "--make-mode", "droid"}, Started: time.Now(), Goals: []string{"droid"}}}
+}
+
 // end of util methods
 
 // start of tests
@@ -239,3 +245,100 @@ func TestLockTimedOut(t *testing.T) {
 		t.Fatalf("Waited an incorrect number of times; expected %v, got %v", waiter.maxNumWaits, waiter.numWaitsElapsed)
 	}
 }
+
+func TestLockTimedOutReportsHolders(t *testing.T) {
+	var buf strings.Builder
+	log := logger.New(&buf)
+	err := lockSynchronous(testLockCountingTo(3), newCountWaiter(2), log)
+	if err == nil {
+		t.Fatal("expected the lock to time out")
+	}
+	for _, s := range []string{buf.String(), err.Error()} {
+		if !strings.Contains(s, "PID 1234 (exclusive") || !strings.Contains(s, "soong_ui --make-mode droid [goals: droid]") {
+			t.Errorf("expected the holder of the lock to be reported, got %q", s)
+		}
+	}
+}
+
+func TestLockPollWaiterDoesNotTimeOut(t *testing.T) {
+	var buf strings.Builder
+	log := logger.New(&buf)
+	err := lockSynchronous(testLockCountingTo(3), newPollWaiter(time.Millisecond), log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "Waiting to lock") || !strings.Contains(buf.String(), "PID 1234") {
+		t.Errorf("expected the wait and the holder of the lock to be reported, got %q", buf.String())
+	}
+}
+
+func TestSharedLock(t *testing.T) {
+	dir := t.TempDir()
+	first, err := newConfigLock(dir, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := newConfigLock(dir, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exclusive, err := newConfigLock(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer exclusive.Unlock()
+
+	if err := first.tryLock(); err != nil {
+		t.Fatalf("Failed to take a shared lock: %v", err)
+	}
+	if err := second.tryLock(); err != nil {
+		t.Fatalf("Failed to take a second shared lock: %v", err)
+	}
+	if err := exclusive.tryLock(); err == nil {
+		t.Fatal("Permitted taking an exclusive lock while shared locks are held")
+	}
+	first.Unlock()
+	second.Unlock()
+	if err := exclusive.tryLock(); err != nil {
+		t.Fatalf("Failed to take an exclusive lock after the shared locks were released: %v", err)
+	}
+}
+
+func TestLockHolders(t *testing.T) {
+	lock, err := newLock(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lock.Unlock()
+
+	// Start a process that exits immediately, to get the PID of a holder that
+	// exited without releasing the lock.
+	exited := exec.Command("true")
+	if err := exited.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	started := time.Now().Add(-time.Minute).Truncate(time.Second)
+	for _, holder := range []lockHolder{
+		{Pid: os.Getppid(), Command: []string{"soong_ui", "--dumpvar-mode", "TARGET_PRODUCT"}, Started: started, Shared: true},
+		{Pid: exited.Process.Pid, Command: []string{"soong_ui", "--make-mode"}, Started: started},
+		{Pid: os.Getpid(), Command: []string{"soong_ui", "--make-mode"}, Started: started},
+	} {
+		if err := lock.recordHolder(holder); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	holders := lock.holders()
+	if len(holders) != 1 || holders[0].Pid != os.Getppid() || !holders[0].Shared || !holders[0].Started.Equal(started) {
+		t.Errorf("expected only the running holder of the lock, got %+v", holders)
+	}
+	if _, err := os.Stat(lock.holderFile(exited.Process.Pid)); !os.IsNotExist(err) {
+		t.Errorf("expected the record of the exited holder to be removed, got %v", err)
+	}
+
+	lock.Unlock()
+	if _, err := os.Stat(lock.holderFile(os.Getpid())); !os.IsNotExist(err) {
+		t.Errorf("expected the record of this process to be removed when the lock is released, got %v", err)
+	}
+}
@@ -0,0 +1,258 @@
+// Copyright 2023 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+
+	"android/soong/makedeps"
+	soong_build_error_proto "android/soong/ui/status/build_error_proto"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// lastNinjaBuild records the most recent invocation of ninja, so that
+// soong_ui --retry-failed can build the same targets again without re-running
+// product config, Soong and Kati. It is removed at the start of every build and
+// written right before ninja runs, so it only exists when the most recent build
+// got as far as running ninja.
+type lastNinjaBuild struct {
+	// Goals are the goals that were passed to soong_ui.
+	Goals []string `json:"goals"`
+
+	// Targets are the ninja targets that the goals were translated to.
+	Targets []string `json:"targets"`
+
+	// Product and Variant are the TARGET_PRODUCT and TARGET_BUILD_VARIANT
+	// of the build.
+	Product string `json:"product"`
+	Variant string `json:"variant"`
+
+	// KatiSuffix is set when the combined ninja file included the ninja
+	// files generated by Kati.
+	KatiSuffix string `json:"kati_suffix,omitempty"`
+
+	// NinjaFiles contains the modification time in nanoseconds of each of
+	// the ninja files that were passed to ninja.
+	NinjaFiles map[string]int64 `json:"ninja_files"`
+}
+
+// retryState is set on the config by RetryFailed.
+type retryState struct {
+	previous *lastNinjaBuild
+
+	// failedOutputs are passed to ninja ahead of the targets of the
+	// previous build. They are only set when the ninja files of the
+	// previous build are reused, otherwise they may no longer exist.
+	failedOutputs []string
+}
+
+// ReadFailedOutputs returns the outputs of the actions that failed, as
+// recorded in a build_error file written by status.NewProtoErrorLog.
+func ReadFailedOutputs(filename string) ([]string, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	buildError := &soong_build_error_proto.BuildError{}
+	if err := proto.Unmarshal(data, buildError); err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+
+	var outputs []string
+	seen := make(map[string]bool)
+	for _, actionError := range buildError.GetActionErrors() {
+		for _, output := range actionError.GetArtifacts() {
+			if !seen[output] {
+				seen[output] = true
+				outputs = append(outputs, output)
+			}
+		}
+	}
+	return outputs, nil
+}
+
+// RetryFailed rebuilds the goals of the previous build after some of its
+// actions failed. When the ninja files of the previous build are still up to
+// date, ninja is run on them directly with the failed outputs and the previous
+// targets, which only rebuilds the failed outputs and the outputs that depend
+// on them. Otherwise the previous goals are built as usual.
+func RetryFailed(ctx Context, config Config, failedOutputs []string) {
+	if len(config.Arguments()) > 0 {
+		ctx.Fatalln("--retry-failed builds the goals of the previous build, and doesn't take goals of its own")
+	}
+
+	if len(failedOutputs) == 0 {
+		ctx.Println("No actions failed in the previous build, nothing to retry.")
+		return
+	}
+
+	previous, err := readLastNinjaBuild(config.LastNinjaBuildFile())
+	if os.IsNotExist(err) {
+		ctx.Fatalln("The previous build failed before running ninja, there are no actions to retry.")
+	} else if err != nil {
+		ctx.Fatalln("Failed to read the previous build:", err)
+	}
+
+	config.retry = &retryState{previous: previous}
+	config.arguments = previous.Goals
+	config.checkbuild = inList("checkbuild", previous.Goals)
+
+	if reason := previous.staleReason(config); reason != "" {
+		ctx.Printf("Re-running analysis before retrying the failed actions because %s.", reason)
+	} else {
+		ctx.Printf("Retrying %d failed outputs with the ninja files of the previous build.", len(failedOutputs))
+		for _, output := range failedOutputs {
+			ctx.Verboseln("Retrying", output)
+		}
+
+		config.retry.failedOutputs = failedOutputs
+		config.arguments = previous.Targets
+		config.skipConfig = true
+		config.skipSoong = true
+		config.skipKati = true
+		config.skipKatiNinja = previous.KatiSuffix == ""
+		config.katiSuffix = previous.KatiSuffix
+	}
+
+	Build(ctx, config)
+}
+
+func readLastNinjaBuild(filename string) (*lastNinjaBuild, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &lastNinjaBuild{}
+	if err := json.Unmarshal(data, b); err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	return b, nil
+}
+
+// ninjaFiles returns the ninja files that are used by the combined ninja file.
+func ninjaFiles(config Config) []string {
+	files := []string{config.CombinedNinjaFile(), config.SoongNinjaFile()}
+	if !config.SkipKatiNinja() && config.HasKatiSuffix() {
+		files = append(files, config.KatiBuildNinjaFile(), config.KatiPackageNinjaFile())
+	}
+	return files
+}
+
+// writeLastNinjaBuild records the ninja invocation that is about to run for
+// --retry-failed.
+func writeLastNinjaBuild(ctx Context, config Config) {
+	b := &lastNinjaBuild{
+		Goals:      config.Arguments(),
+		Targets:    config.NinjaArgs(),
+		NinjaFiles: make(map[string]int64),
+	}
+	if config.retry != nil {
+		// Keep the original goals, so that a retry of a retry can
+		// still re-run analysis if it needs to.
+		b.Goals = config.retry.previous.Goals
+	}
+	b.Product, _ = config.Environment().Get("TARGET_PRODUCT")
+	b.Variant, _ = config.Environment().Get("TARGET_BUILD_VARIANT")
+	if !config.SkipKatiNinja() && config.HasKatiSuffix() {
+		b.KatiSuffix = config.KatiSuffix()
+	}
+
+	for _, file := range ninjaFiles(config) {
+		info, err := os.Stat(file)
+		if err != nil {
+			ctx.Verboseln("Not recording ninja invocation:", err)
+			return
+		}
+		b.NinjaFiles[file] = info.ModTime().UnixNano()
+	}
+
+	data, err := json.Marshal(b)
+	if err != nil {
+		ctx.Verboseln("Failed to marshal ninja invocation:", err)
+		return
+	}
+	if err := ioutil.WriteFile(config.LastNinjaBuildFile(), data, 0666); err != nil {
+		ctx.Verboseln("Failed to record ninja invocation:", err)
+	}
+}
+
+// staleReason returns why the ninja files of the build can't be reused, or an
+// empty string if they are still up to date. Soong's ninja file is checked
+// against all of the Android.bp files and other inputs listed in its depfile,
+// changes to the makefiles read by Kati are not detected.
+func (b *lastNinjaBuild) staleReason(config Config) string {
+	product, _ := config.Environment().Get("TARGET_PRODUCT")
+	variant, _ := config.Environment().Get("TARGET_BUILD_VARIANT")
+	if product != b.Product || variant != b.Variant {
+		return fmt.Sprintf("the product changed from %s-%s to %s-%s", b.Product, b.Variant, product, variant)
+	}
+
+	files := make([]string, 0, len(b.NinjaFiles))
+	for file := range b.NinjaFiles {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return fmt.Sprintf("%s can't be read: %s", file, err)
+		}
+		if info.ModTime().UnixNano() != b.NinjaFiles[file] {
+			return fmt.Sprintf("%s was regenerated", file)
+		}
+	}
+
+	soongNinjaFile := config.SoongNinjaFile()
+	mtime, ok := b.NinjaFiles[soongNinjaFile]
+	if !ok {
+		return fmt.Sprintf("%s wasn't used", soongNinjaFile)
+	}
+	return changedDepfileInput(soongNinjaFile+".d", mtime)
+}
+
+// changedDepfileInput returns a description of the first input listed in a
+// depfile that was modified after mtime, or an empty string if there are none.
+func changedDepfileInput(depfile string, mtime int64) string {
+	f, err := os.Open(depfile)
+	if err != nil {
+		return fmt.Sprintf("%s can't be read: %s", depfile, err)
+	}
+	defer f.Close()
+
+	deps, err := makedeps.Parse(depfile, f)
+	if err != nil {
+		return fmt.Sprintf("%s can't be parsed: %s", depfile, err)
+	}
+
+	for _, input := range deps.Inputs {
+		info, err := os.Stat(input)
+		if os.IsNotExist(err) {
+			return fmt.Sprintf("%s was removed", input)
+		} else if err != nil {
+			return fmt.Sprintf("%s can't be read: %s", input, err)
+		}
+		if info.ModTime().UnixNano() > mtime {
+			return fmt.Sprintf("%s changed", input)
+		}
+	}
+	return ""
+}
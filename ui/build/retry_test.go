@@ -0,0 +1,154 @@
+// Copyright 2023 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	soong_build_error_proto "android/soong/ui/status/build_error_proto"
+
+	"google.golang.org/protobuf/proto"
+)
+
+func TestReadFailedOutputs(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "build_error")
+	data, err := proto.Marshal(&soong_build_error_proto.BuildError{
+		ErrorMessages: []string{"something went wrong"},
+		ActionErrors: []*soong_build_error_proto.BuildActionError{
+			{Description: proto.String("a"), Artifacts: []string{"out/a", "out/a.d"}},
+			{Description: proto.String("b"), Artifacts: []string{"out/b"}},
+			{Description: proto.String("a again"), Artifacts: []string{"out/a"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filename, data, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ReadFailedOutputs(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"out/a", "out/a.d", "out/b"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected failed outputs %q, got %q", want, got)
+	}
+
+	if _, err := ReadFailedOutputs(filepath.Join(t.TempDir(), "missing")); !os.IsNotExist(err) {
+		t.Errorf("expected a not exist error for a missing file, got %v", err)
+	}
+}
+
+func TestLastNinjaBuildStaleReason(t *testing.T) {
+	ctx := testContext()
+
+	setup := func(t *testing.T) (Config, string) {
+		outDir := t.TempDir()
+		env := Environment([]string{
+			"OUT_DIR=" + outDir,
+			"TARGET_PRODUCT=aosp_arm",
+			"TARGET_BUILD_VARIANT=eng",
+		})
+		config := Config{&configImpl{
+			environ:       &env,
+			arguments:     []string{"droid"},
+			skipKati:      true,
+			skipKatiNinja: true,
+		}}
+
+		androidBp := filepath.Join(outDir, "Android.bp")
+		for _, file := range []string{androidBp, config.CombinedNinjaFile(), config.SoongNinjaFile()} {
+			if err := os.MkdirAll(filepath.Dir(file), 0777); err != nil {
+				t.Fatal(err)
+			}
+			if err := ioutil.WriteFile(file, nil, 0666); err != nil {
+				t.Fatal(err)
+			}
+		}
+		depfile := config.SoongNinjaFile() + ".d"
+		if err := ioutil.WriteFile(depfile, []byte(config.SoongNinjaFile()+": "+androidBp+"\n"), 0666); err != nil {
+			t.Fatal(err)
+		}
+
+		// Make sure the inputs are older than the ninja files.
+		past := time.Now().Add(-time.Hour)
+		if err := os.Chtimes(androidBp, past, past); err != nil {
+			t.Fatal(err)
+		}
+
+		writeLastNinjaBuild(ctx, config)
+		return config, androidBp
+	}
+
+	read := func(t *testing.T, config Config) *lastNinjaBuild {
+		b, err := readLastNinjaBuild(config.LastNinjaBuildFile())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+
+	t.Run("unchanged", func(t *testing.T) {
+		config, _ := setup(t)
+		b := read(t, config)
+		if !reflect.DeepEqual(b.Goals, []string{"droid"}) || !reflect.DeepEqual(b.Targets, []string{"droid"}) {
+			t.Errorf("expected goals and targets to be recorded, got %q and %q", b.Goals, b.Targets)
+		}
+		if reason := b.staleReason(config); reason != "" {
+			t.Errorf("expected ninja files to be up to date, got %q", reason)
+		}
+	})
+
+	t.Run("regenerated", func(t *testing.T) {
+		config, _ := setup(t)
+		b := read(t, config)
+		future := time.Now().Add(time.Hour)
+		if err := os.Chtimes(config.SoongNinjaFile(), future, future); err != nil {
+			t.Fatal(err)
+		}
+		if reason := b.staleReason(config); !strings.Contains(reason, "was regenerated") {
+			t.Errorf("expected regenerated ninja file, got %q", reason)
+		}
+	})
+
+	t.Run("input changed", func(t *testing.T) {
+		config, androidBp := setup(t)
+		b := read(t, config)
+		future := time.Now().Add(time.Hour)
+		if err := os.Chtimes(androidBp, future, future); err != nil {
+			t.Fatal(err)
+		}
+		if reason := b.staleReason(config); reason != androidBp+" changed" {
+			t.Errorf("expected changed Android.bp, got %q", reason)
+		}
+	})
+
+	t.Run("product changed", func(t *testing.T) {
+		config, _ := setup(t)
+		b := read(t, config)
+		config.Environment().Set("TARGET_PRODUCT", "aosp_x86")
+		if reason := b.staleReason(config); !strings.Contains(reason, "product changed") {
+			t.Errorf("expected changed product, got %q", reason)
+		}
+	})
+}
@@ -16,15 +16,21 @@ package build
 
 import (
 	"bytes"
+	"fmt"
 	"os"
 	"os/exec"
 	"os/user"
 	"path/filepath"
 	"strings"
 	"sync"
+
+	"github.com/google/blueprint/microfactory"
 )
 
 type Sandbox struct {
+	// Name is the phase of the build in the sandbox policy.
+	Name string
+
 	Enabled              bool
 	DisableWhenUsingGoma bool
 
@@ -37,10 +43,20 @@ var (
 		Enabled: true,
 	}
 
-	dumpvarsSandbox = basicSandbox
-	katiSandbox     = basicSandbox
-	soongSandbox    = basicSandbox
-	ninjaSandbox    = Sandbox{
+	dumpvarsSandbox = Sandbox{
+		Name:    "dumpvars",
+		Enabled: true,
+	}
+	katiSandbox = Sandbox{
+		Name:    "kati",
+		Enabled: true,
+	}
+	soongSandbox = Sandbox{
+		Name:    "soong",
+		Enabled: true,
+	}
+	ninjaSandbox = Sandbox{
+		Name:                 "ninja",
 		Enabled:              true,
 		DisableWhenUsingGoma: true,
 
@@ -58,6 +74,11 @@ var sandboxConfig struct {
 	srcDir  string
 	outDir  string
 	distDir string
+
+	// tracer is the filetrace binary that records the accesses of the
+	// phases with restricted paths, or "" if it failed to build.
+	tracerOnce sync.Once
+	tracer     string
 }
 
 func (c *Cmd) sandboxSupported() bool {
@@ -218,13 +239,55 @@ func (c *Cmd) wrapSandbox() {
 		sandboxArgs = append(sandboxArgs, "-B", sandboxConfig.distDir)
 	}
 
+	// Apply the sandbox policy of the phase after the default mounts, so
+	// that it can restrict paths in the source directory.
+	policy := c.config.sandboxPolicy.phase(c.Sandbox.Name)
+	var mounts []sandboxMount
+	for _, m := range policy.mounts(sandboxConfig.srcDir) {
+		if isParentDir(m.path, sandboxConfig.srcDir) || isParentDir(m.path, sandboxConfig.outDir) {
+			c.ctx.Fatalf("The %s sandbox policy can't mount %s as %s, it contains the source or output directory",
+				c.Sandbox.Name, m.path, m.kind)
+		}
+		info, err := os.Stat(m.path)
+		if err != nil {
+			c.ctx.Verbosef("Skipping %s path %s of the %s sandbox policy: %v", m.kind, m.path, c.Sandbox.Name, err)
+			continue
+		}
+		mounts = append(mounts, m)
+		switch m.kind {
+		case sandboxMountReadOnly:
+			sandboxArgs = append(sandboxArgs, "-R", m.path)
+		case sandboxMountReadWrite:
+			sandboxArgs = append(sandboxArgs, "-B", m.path)
+		case sandboxMountHidden:
+			if info.IsDir() {
+				sandboxArgs = append(sandboxArgs, "-T", m.path)
+			} else {
+				sandboxArgs = append(sandboxArgs, "-R", "/dev/null:"+m.path)
+			}
+		case sandboxMountTmpfs:
+			if !info.IsDir() {
+				c.ctx.Fatalf("The %s sandbox policy can't mount a tmpfs on %s, it is not a directory", c.Sandbox.Name, m.path)
+			}
+			sandboxArgs = append(sandboxArgs, "-T", m.path)
+		}
+	}
+
+	allowNetwork := false
 	if c.Sandbox.AllowBuildBrokenUsesNetwork && c.config.BuildBrokenUsesNetwork() {
 		c.ctx.Printf("AllowBuildBrokenUsesNetwork: %v", c.Sandbox.AllowBuildBrokenUsesNetwork)
 		c.ctx.Printf("BuildBrokenUsesNetwork: %v", c.config.BuildBrokenUsesNetwork())
-		sandboxArgs = append(sandboxArgs, "-N")
-	} else if dlv, _ := c.config.Environment().Get("SOONG_DELVE"); dlv != "" {
+		allowNetwork = true
+	}
+	if policy != nil && policy.Network != nil {
+		allowNetwork = *policy.Network
+	}
+	if dlv, _ := c.config.Environment().Get("SOONG_DELVE"); dlv != "" {
 		// The debugger is enabled and soong_build will pause until a remote delve process connects, allow
 		// network connections.
+		allowNetwork = true
+	}
+	if allowNetwork {
 		sandboxArgs = append(sandboxArgs, "-N")
 	}
 
@@ -234,9 +297,48 @@ func (c *Cmd) wrapSandbox() {
 	c.Args = append(sandboxArgs, c.Args[1:]...)
 	c.Path = nsjailPath
 
+	// Trace the accesses to the restricted paths, to report them if the
+	// command fails.
+	file := filepath.Join(sandboxConfig.outDir, fmt.Sprintf(".%s_accesses.%d.json", c.Sandbox.Name, os.Getpid()))
+	if accesses := newSandboxAccesses(c.Sandbox.Name, mounts, file); accesses != nil {
+		if tracer := c.sandboxTracer(); tracer != "" {
+			args := []string{tracer, "-o", file}
+			for _, path := range accesses.paths() {
+				args = append(args, "-p", path)
+			}
+			// filetrace runs nsjail with the same argv.
+			args = append(args, "--", nsjailPath)
+			c.Args = append(args, c.Args...)
+			c.Path = tracer
+			c.sandboxAccesses = accesses
+		}
+	}
+
 	env := Environment(c.Env)
 	if _, hasUser := env.Get("USER"); hasUser {
 		env.Set("USER", "nobody")
 	}
 	c.Env = []string(env)
 }
+
+// sandboxTracer returns the filetrace binary, building it with microfactory
+// the first time that it is needed.
+func (c *Cmd) sandboxTracer() string {
+	sandboxConfig.tracerOnce.Do(func() {
+		tracer := filepath.Join(c.config.OutDir(), ".filetrace")
+		if err := os.MkdirAll(c.config.OutDir(), 0777); err != nil {
+			c.ctx.Println("Failed to create the out directory, not tracing sandboxed commands:", err)
+			return
+		}
+
+		var cfg microfactory.Config
+		cfg.Map("android/soong", "build/soong")
+		cfg.TrimPath, _ = filepath.Abs(".")
+		if _, err := microfactory.Build(&cfg, tracer, "android/soong/cmd/filetrace"); err != nil {
+			c.ctx.Println("Failed to build filetrace, not tracing sandboxed commands:", err)
+			return
+		}
+		sandboxConfig.tracer = tracer
+	})
+	return sandboxConfig.tracer
+}
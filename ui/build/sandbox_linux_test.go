@@ -15,7 +15,10 @@
 package build
 
 import (
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -76,6 +79,69 @@ func TestMountFlagsSrcDirRWAllowlist(t *testing.T) {
 	}
 }
 
+func TestMountFlagsSandboxPolicy(t *testing.T) {
+	dir := t.TempDir()
+	for _, d := range []string{"hidden", "ro"} {
+		if err := os.Mkdir(filepath.Join(dir, d), 0777); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "hidden_file"), nil, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	// Use a fake tracer instead of building filetrace.
+	sandboxConfig.tracerOnce.Do(func() {
+		sandboxConfig.tracer = "path/to/filetrace"
+	})
+
+	network := true
+	c := testCmd()
+	c.Sandbox = katiSandbox
+	c.config.sandboxPolicy = &sandboxPolicy{phases: map[string]*sandboxPhasePolicy{
+		"kati": {
+			ReadOnly: []string{filepath.Join(dir, "ro")},
+			Hidden:   []string{filepath.Join(dir, "hidden"), filepath.Join(dir, "hidden_file"), filepath.Join(dir, "missing")},
+			Network:  &network,
+		},
+	}}
+	c.wrapSandbox()
+
+	if c.Path != "path/to/filetrace" || c.Args[0] != "path/to/filetrace" {
+		t.Errorf("A command with restricted paths is not traced, got %q %q", c.Path, c.Args)
+	}
+	if !isExpectedMountFlag(c.Args, filepath.Join(dir, "hidden"), "-p") ||
+		!isExpectedMountFlag(c.Args, filepath.Join(dir, "ro"), "-p") {
+		t.Errorf("The accesses to the restricted paths are not recorded, got %q", c.Args)
+	}
+	if c.sandboxAccesses == nil {
+		t.Error("The accesses of a command with restricted paths are not reported")
+	}
+
+	// The arguments of nsjail follow the arguments of filetrace.
+	args := c.Args[index(c.Args, "--")+1:]
+	if args[0] != nsjailPath || args[1] != "-x" {
+		t.Errorf("The traced command is not nsjail with its arguments, got %q", args)
+	}
+	if !isExpectedMountFlag(args, filepath.Join(dir, "ro"), "-R") {
+		t.Error("Mount flag of a read-only path is not correct, expect -R")
+	}
+	if !isExpectedMountFlag(args, filepath.Join(dir, "hidden"), "-T") {
+		t.Error("Mount flag of a hidden directory is not correct, expect -T")
+	}
+	if !isExpectedMountFlag(args, "/dev/null:"+filepath.Join(dir, "hidden_file"), "-R") {
+		t.Error("Mount flag of a hidden file is not correct, expect -R /dev/null")
+	}
+	for _, arg := range c.Args {
+		if strings.Contains(arg, "missing") {
+			t.Error("Paths that don't exist should not be mounted or traced")
+		}
+	}
+	if index(args, "-N") > index(args, "--") {
+		t.Error("Network access allowed by the sandbox policy is not enabled")
+	}
+}
+
 // utils for setting up test
 func testConfig() Config {
 	// create a minimal testConfig
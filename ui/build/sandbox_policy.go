@@ -0,0 +1,328 @@
+// Copyright 2023 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"android/soong/filetrace"
+)
+
+const (
+	// sandboxPolicyFile is the sandbox policy of the tree.
+	sandboxPolicyFile = "build/soong/sandbox_policy.json"
+
+	// sandboxPolicyProductDir contains optional policies named after
+	// TARGET_PRODUCT that override the policy of the tree.
+	sandboxPolicyProductDir = "build/soong/sandbox_policy"
+
+	// sandboxPolicyOverrideVar names an optional policy file that overrides
+	// both the policy of the tree and the policy of the product.
+	sandboxPolicyOverrideVar = "ANDROID_BUILD_SANDBOX_POLICY"
+
+	// maxSandboxAccessLines is the number of accesses that are reported for
+	// each restricted path that a failed sandboxed command accessed.
+	maxSandboxAccessLines = 3
+)
+
+// The phases of the build that run in the sandbox, named after the Sandbox
+// that they use.
+var sandboxPhases = []string{"dumpvars", "kati", "soong", "ninja"}
+
+// sandboxPhasePolicy declares the mounts and network access of a sandboxed
+// phase, in addition to the mounts that every sandboxed phase has. Paths are
+// relative to the source directory, or absolute.
+type sandboxPhasePolicy struct {
+	// ReadOnly and ReadWrite are mounted from the host read-only and
+	// read-write.
+	ReadOnly  []string `json:"read_only,omitempty"`
+	ReadWrite []string `json:"read_write,omitempty"`
+
+	// Hidden directories are replaced with an empty directory, and hidden
+	// files with an empty file.
+	Hidden []string `json:"hidden,omitempty"`
+
+	// Tmpfs directories are replaced with an empty writable tmpfs.
+	Tmpfs []string `json:"tmpfs,omitempty"`
+
+	// Network allows or denies network access, overriding
+	// BUILD_BROKEN_USES_NETWORK.
+	Network *bool `json:"network,omitempty"`
+}
+
+type sandboxPolicyJson struct {
+	Phases map[string]sandboxPhasePolicy `json:"phases"`
+}
+
+// sandboxPolicy is the merged sandbox policy of the tree, the product and the
+// override file. A phase that is declared in a later file replaces the
+// declaration in an earlier one.
+type sandboxPolicy struct {
+	phases map[string]*sandboxPhasePolicy
+}
+
+// sandboxMountKind is how a path in a sandbox policy is mounted.
+type sandboxMountKind string
+
+const (
+	sandboxMountReadOnly  sandboxMountKind = "read-only"
+	sandboxMountReadWrite sandboxMountKind = "read-write"
+	sandboxMountHidden    sandboxMountKind = "hidden"
+	sandboxMountTmpfs     sandboxMountKind = "tmpfs"
+)
+
+// restricted returns whether a command may fail because it tried to use a
+// path with this kind of mount.
+func (k sandboxMountKind) restricted() bool {
+	return k != sandboxMountReadWrite
+}
+
+type sandboxMount struct {
+	kind sandboxMountKind
+	path string
+}
+
+// sandboxPolicyFiles returns the policy files that apply to the build, in order
+// of increasing priority. Only the override file is required to exist.
+func sandboxPolicyFiles(env *Environment) (files []string, required []string) {
+	return layeredPolicyFiles(env, sandboxPolicyFile, sandboxPolicyProductDir, sandboxPolicyOverrideVar)
+}
+
+// loadSandboxPolicy reads and validates the given policy files. Missing files
+// are skipped unless they are in required.
+func loadSandboxPolicy(files []string, required []string) (*sandboxPolicy, error) {
+	p := &sandboxPolicy{phases: make(map[string]*sandboxPhasePolicy)}
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if os.IsNotExist(err) && !inList(file, required) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		phases, err := parseSandboxPolicy(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		for name := range phases {
+			phase := phases[name]
+			p.phases[name] = &phase
+		}
+	}
+	return p, nil
+}
+
+// parseSandboxPolicy parses and validates the contents of a policy file.
+func parseSandboxPolicy(data []byte) (map[string]sandboxPhasePolicy, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	var policy sandboxPolicyJson
+	if err := decoder.Decode(&policy); err != nil {
+		return nil, err
+	}
+
+	for name, phase := range policy.Phases {
+		if !inList(name, sandboxPhases) {
+			return nil, fmt.Errorf("unknown sandbox phase %q, expected one of %q", name, sandboxPhases)
+		}
+
+		seen := make(map[string]bool)
+		for _, m := range phase.declaredMounts() {
+			if m.path == "" {
+				return nil, fmt.Errorf("%s: empty %s path", name, m.kind)
+			}
+			clean := filepath.Clean(m.path)
+			if clean == "." || clean == "/" {
+				return nil, fmt.Errorf("%s: %s path %q can't be the whole tree", name, m.kind, m.path)
+			}
+			if clean == ".." || strings.HasPrefix(clean, "../") {
+				return nil, fmt.Errorf("%s: %s path %q is outside of the source directory, use an absolute path",
+					name, m.kind, m.path)
+			}
+			if seen[clean] {
+				return nil, fmt.Errorf("%s: %q is declared more than once", name, m.path)
+			}
+			seen[clean] = true
+		}
+	}
+	return policy.Phases, nil
+}
+
+// declaredMounts returns the paths of the phase as they were declared.
+func (pp *sandboxPhasePolicy) declaredMounts() []sandboxMount {
+	var mounts []sandboxMount
+	add := func(kind sandboxMountKind, paths []string) {
+		for _, path := range paths {
+			mounts = append(mounts, sandboxMount{kind, path})
+		}
+	}
+	add(sandboxMountReadOnly, pp.ReadOnly)
+	add(sandboxMountReadWrite, pp.ReadWrite)
+	add(sandboxMountHidden, pp.Hidden)
+	add(sandboxMountTmpfs, pp.Tmpfs)
+	return mounts
+}
+
+// phase returns the policy of a sandboxed phase, or nil if the policy doesn't
+// declare it.
+func (p *sandboxPolicy) phase(name string) *sandboxPhasePolicy {
+	if p == nil {
+		return nil
+	}
+	return p.phases[name]
+}
+
+// mounts returns the mounts of the phase with absolute paths, sorted so that
+// parent directories are mounted before their children.
+func (pp *sandboxPhasePolicy) mounts(srcDir string) []sandboxMount {
+	if pp == nil {
+		return nil
+	}
+
+	mounts := pp.declaredMounts()
+	for i := range mounts {
+		if filepath.IsAbs(mounts[i].path) {
+			mounts[i].path = filepath.Clean(mounts[i].path)
+		} else {
+			mounts[i].path = filepath.Join(srcDir, mounts[i].path)
+		}
+	}
+	sort.SliceStable(mounts, func(i, j int) bool {
+		return mounts[i].path < mounts[j].path
+	})
+	return mounts
+}
+
+// sandboxAccesses reports the accesses of a failed sandboxed command to the
+// paths that the sandbox policy restricts. The accesses are recorded by
+// filetrace, which runs the sandbox.
+type sandboxAccesses struct {
+	phase  string
+	mounts []sandboxMount
+
+	// file is where filetrace writes the accesses.
+	file string
+
+	accesses []filetrace.Access
+}
+
+// newSandboxAccesses returns a sandboxAccesses for the restricted mounts, or nil
+// if none of the mounts are restricted.
+func newSandboxAccesses(phase string, mounts []sandboxMount, file string) *sandboxAccesses {
+	for _, m := range mounts {
+		if m.kind.restricted() {
+			return &sandboxAccesses{phase: phase, mounts: mounts, file: file}
+		}
+	}
+	return nil
+}
+
+// paths returns the paths that filetrace needs to record the accesses to.
+func (a *sandboxAccesses) paths() []string {
+	var ret []string
+	for _, m := range a.mounts {
+		if m.kind.restricted() {
+			ret = append(ret, m.path)
+		}
+	}
+	return ret
+}
+
+// load reads the accesses that filetrace recorded.
+func (a *sandboxAccesses) load() error {
+	if a == nil {
+		return nil
+	}
+	accesses, err := filetrace.ReadFile(a.file)
+	if err != nil {
+		return err
+	}
+	a.accesses = accesses
+	return nil
+}
+
+// remove removes the file of the accesses.
+func (a *sandboxAccesses) remove() {
+	if a != nil {
+		os.Remove(a.file)
+	}
+}
+
+// mount returns the innermost mount that contains path, or -1 if there is
+// none.
+func (a *sandboxAccesses) mount(path string) int {
+	ret := -1
+	for i, m := range a.mounts {
+		if isParentDir(m.path, path) && (ret < 0 || len(m.path) > len(a.mounts[ret].path)) {
+			ret = i
+		}
+	}
+	return ret
+}
+
+// report returns the lines that describe the accesses of the command that the
+// sandbox policy restricted. Only writes are restricted by read-only mounts.
+func (a *sandboxAccesses) report() []string {
+	if a == nil {
+		return nil
+	}
+
+	lines := make([][]string, len(a.mounts))
+	counts := make([]int, len(a.mounts))
+	for _, access := range a.accesses {
+		i := a.mount(access.Path)
+		if i < 0 || !a.mounts[i].kind.restricted() || (a.mounts[i].kind == sandboxMountReadOnly && !access.Write) {
+			continue
+		}
+		counts[i]++
+		if len(lines[i]) < maxSandboxAccessLines {
+			line := "read " + access.Path
+			if access.Write {
+				line = "write " + access.Path
+			}
+			if access.Errno != 0 {
+				line += ": " + access.Errno.Error()
+			}
+			lines[i] = append(lines[i], line)
+		}
+	}
+
+	var ret []string
+	for i, m := range a.mounts {
+		if counts[i] == 0 {
+			continue
+		}
+		if ret == nil {
+			ret = append(ret, fmt.Sprintf("The %s sandbox policy restricts these paths that the failing command accessed:", a.phase))
+		}
+		ret = append(ret, fmt.Sprintf("  %s (%s), %d files:", m.path, m.kind, counts[i]))
+		for _, line := range lines[i] {
+			ret = append(ret, "    "+line)
+		}
+	}
+	return ret
+}
+
+// isParentDir returns whether dir is path or one of its parent directories.
+func isParentDir(dir, path string) bool {
+	return path == dir || strings.HasPrefix(path, dir+"/")
+}
@@ -0,0 +1,182 @@
+// Copyright 2023 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+
+	"android/soong/filetrace"
+)
+
+func TestParseSandboxPolicy(t *testing.T) {
+	testCases := []struct {
+		name   string
+		policy string
+		err    string
+	}{
+		{
+			name: "valid",
+			policy: `{"phases": {
+				"kati": {"hidden": ["vendor/secret"], "read_only": ["/opt/tools"], "network": false},
+				"ninja": {"tmpfs": ["/var/cache/tool"], "read_write": ["vendor/cache"]}
+			}}`,
+		},
+		{
+			name:   "unknown field",
+			policy: `{"phases": {"kati": {"hiden": ["vendor/secret"]}}}`,
+			err:    `unknown field "hiden"`,
+		},
+		{
+			name:   "unknown phase",
+			policy: `{"phases": {"make": {"hidden": ["vendor/secret"]}}}`,
+			err:    `unknown sandbox phase "make"`,
+		},
+		{
+			name:   "empty path",
+			policy: `{"phases": {"kati": {"hidden": [""]}}}`,
+			err:    "kati: empty hidden path",
+		},
+		{
+			name:   "whole tree",
+			policy: `{"phases": {"kati": {"read_only": ["./"]}}}`,
+			err:    `kati: read-only path "./" can't be the whole tree`,
+		},
+		{
+			name:   "outside of the source directory",
+			policy: `{"phases": {"soong": {"tmpfs": ["../cache"]}}}`,
+			err:    `soong: tmpfs path "../cache" is outside of the source directory`,
+		},
+		{
+			name:   "duplicate",
+			policy: `{"phases": {"kati": {"hidden": ["vendor/secret"], "read_only": ["vendor/secret/"]}}}`,
+			err:    `kati: "vendor/secret" is declared more than once`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := parseSandboxPolicy([]byte(tc.policy))
+			if tc.err == "" && err != nil {
+				t.Errorf("unexpected error: %s", err)
+			} else if tc.err != "" && (err == nil || !strings.Contains(err.Error(), tc.err)) {
+				t.Errorf("expected error containing %q, got %v", tc.err, err)
+			}
+		})
+	}
+}
+
+func TestSandboxPolicy(t *testing.T) {
+	dir := t.TempDir()
+	tree := filepath.Join(dir, "tree.json")
+	product := filepath.Join(dir, "product.json")
+	if err := ioutil.WriteFile(tree, []byte(`{"phases": {
+		"kati": {"hidden": ["vendor/old"]},
+		"ninja": {"read_only": ["/opt/tools"]}
+	}}`), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(product, []byte(`{"phases": {
+		"kati": {"hidden": ["vendor/secret/b", "vendor/secret"], "read_write": ["/var/cache"], "network": true}
+	}}`), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	policy, err := loadSandboxPolicy([]string{tree, product, filepath.Join(dir, "missing.json")}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kati := policy.phase("kati")
+	if kati == nil || kati.Network == nil || !*kati.Network {
+		t.Fatalf("expected the product to allow network access in kati, got %+v", kati)
+	}
+	want := []sandboxMount{
+		{sandboxMountHidden, "/src/vendor/secret"},
+		{sandboxMountHidden, "/src/vendor/secret/b"},
+		{sandboxMountReadWrite, "/var/cache"},
+	}
+	if got := kati.mounts("/src"); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected kati mounts %v, got %v", want, got)
+	}
+	if got := policy.phase("ninja").mounts("/src"); !reflect.DeepEqual(got, []sandboxMount{{sandboxMountReadOnly, "/opt/tools"}}) {
+		t.Errorf("expected the ninja policy of the tree to be kept, got %v", got)
+	}
+	if got := policy.phase("soong"); got != nil {
+		t.Errorf("expected no policy for soong, got %+v", got)
+	}
+
+	var nilPolicy *sandboxPolicy
+	if got := nilPolicy.phase("kati").mounts("/src"); got != nil {
+		t.Errorf("expected no mounts without a policy, got %v", got)
+	}
+}
+
+func TestSandboxAccesses(t *testing.T) {
+	mounts := []sandboxMount{
+		{sandboxMountHidden, "/src/vendor/secret"},
+		{sandboxMountReadOnly, "/opt/tools"},
+		{sandboxMountReadWrite, "/src/vendor/cache"},
+		{sandboxMountReadWrite, "/src/vendor/secret/shared"},
+	}
+	file := filepath.Join(t.TempDir(), "accesses.json")
+	accesses := newSandboxAccesses("kati", mounts, file)
+	if want := []string{"/src/vendor/secret", "/opt/tools"}; !reflect.DeepEqual(accesses.paths(), want) {
+		t.Errorf("expected traced paths %q, got %q", want, accesses.paths())
+	}
+
+	err := filetrace.WriteFile(file, []filetrace.Access{
+		{Path: "/src/vendor/secret/Android.mk", Errno: syscall.ENOENT},
+		{Path: "/opt/tools/bin/tool"},
+		{Path: "/src/vendor/secret/a.mk", Errno: syscall.ENOENT},
+		{Path: "/opt/tools/out", Write: true, Errno: syscall.EROFS},
+		{Path: "/src/vendor/secret/b.mk"},
+		{Path: "/src/vendor/secret/c.mk"},
+		{Path: "/src/vendor/secret/shared/d.mk"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := accesses.load(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"The kati sandbox policy restricts these paths that the failing command accessed:",
+		"  /src/vendor/secret (hidden), 4 files:",
+		"    read /src/vendor/secret/Android.mk: no such file or directory",
+		"    read /src/vendor/secret/a.mk: no such file or directory",
+		"    read /src/vendor/secret/b.mk",
+		"  /opt/tools (read-only), 1 files:",
+		"    write /opt/tools/out: read-only file system",
+	}
+	if got := accesses.report(); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected report %q, got %q", want, got)
+	}
+
+	accesses.remove()
+	if _, err := os.Stat(file); !os.IsNotExist(err) {
+		t.Errorf("expected the accesses file to be removed, got %v", err)
+	}
+
+	if got := newSandboxAccesses("kati", mounts[2:3], file); got != nil {
+		t.Errorf("expected no accesses to be recorded without restricted paths")
+	}
+}
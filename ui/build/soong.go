@@ -463,6 +463,10 @@ func runSoong(ctx Context, config Config) {
 		ctx.Fatalf("failed to write environment file %s: %s", envFile, err)
 	}
 
+	// Record the state of the Soong ninja file before the environment check
+	// removes a stale environment file, to explain why it's regenerated.
+	regenCheck := newSoongRegenCheck(config, soongBuildEnv)
+
 	func() {
 		ctx.BeginTrace(metrics.RunSoong, "environment check")
 		defer ctx.EndTrace()
@@ -563,6 +567,10 @@ func runSoong(ctx Context, config Config) {
 
 	ninja("bootstrap", "bootstrap.ninja", targets...)
 
+	if config.SoongBuildInvocationNeeded() {
+		reportSoongRegen(ctx, config, regenCheck)
+	}
+
 	distGzipFile(ctx, config, config.SoongNinjaFile(), "soong")
 	distFile(ctx, config, config.SoongVarsFile(), "soong")
 
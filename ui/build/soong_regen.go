@@ -0,0 +1,278 @@
+// Copyright 2023 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"android/soong/makedeps"
+	"android/soong/shared"
+)
+
+// maxSoongRegenReasons is the maximum number of changed inputs that are listed
+// as the reasons for regenerating the Soong ninja file, so that a repo sync
+// doesn't print every Android.bp file in the tree.
+const maxSoongRegenReasons = 20
+
+// soongRegenCheck records the state of the Soong ninja file before the
+// bootstrap ninja file runs, so that soong_ui can explain why soong_build
+// regenerated it afterwards. Ninja itself only tracks mtimes, so the changed
+// environment variables and soong.variables fields are compared against the
+// values used by the previous soong_build run.
+type soongRegenCheck struct {
+	// ninjaMtime is the modification time of the Soong ninja file in
+	// nanoseconds, or 0 if it didn't exist.
+	ninjaMtime int64
+
+	// envChanges describes the environment variables used by the previous
+	// soong_build run that changed value.
+	envChanges []string
+}
+
+// newSoongRegenCheck records the state of the Soong ninja file. It must be
+// called before checkEnvironmentFile removes a stale used environment file.
+func newSoongRegenCheck(config Config, env *Environment) *soongRegenCheck {
+	r := &soongRegenCheck{}
+	info, err := os.Stat(config.SoongNinjaFile())
+	if err != nil {
+		return r
+	}
+	r.ninjaMtime = info.ModTime().UnixNano()
+
+	usedEnv, err := shared.EnvFromFile(config.UsedEnvFile(soongBuildTag))
+	if err != nil {
+		r.envChanges = []string{"the environment used by the previous soong_build run can't be read"}
+		return r
+	}
+	r.envChanges = changedEnvironment(usedEnv, env)
+	return r
+}
+
+// changedEnvironment describes the variables in used whose value differs in env.
+func changedEnvironment(used map[string]string, env *Environment) []string {
+	var changes []string
+	for key, old := range used {
+		cur, _ := env.Get(key)
+		if cur != old {
+			changes = append(changes, fmt.Sprintf("environment variable %s changed from %q to %q", key, old, cur))
+		}
+	}
+	sort.Strings(changes)
+	return changes
+}
+
+// reasons returns why the Soong ninja file was regenerated, or nil if it
+// wasn't.
+func (r *soongRegenCheck) reasons(config Config) []string {
+	info, err := os.Stat(config.SoongNinjaFile())
+	if err != nil || info.ModTime().UnixNano() == r.ninjaMtime {
+		return nil
+	}
+	if r.ninjaMtime == 0 {
+		return []string{fmt.Sprintf("%s didn't exist", config.SoongNinjaFile())}
+	}
+
+	var reasons []string
+	if r.changedSince(filepath.Join(config.HostToolDir(), "soong_build")) {
+		reasons = append(reasons, "soong_build was rebuilt")
+	}
+	reasons = append(reasons, r.envChanges...)
+
+	inputs, err := r.changedInputs(config.SoongNinjaFile() + ".d")
+	if err != nil {
+		reasons = append(reasons, err.Error())
+	}
+	var globLists []string
+	globDir := filepath.Join(config.SoongOutDir(), "globs")
+	for _, input := range inputs {
+		switch {
+		case strings.HasPrefix(input, globDir+"/"):
+			globLists = append(globLists, input)
+		case input == config.SoongVarsFile():
+			changes, err := changedSoongVariables(config.LastSoongVarsFile(), config.SoongVarsFile())
+			if err != nil {
+				reasons = append(reasons, fmt.Sprintf("%s changed", input))
+			}
+			reasons = append(reasons, changes...)
+		case filepath.Base(input) == "Android.bp.list":
+			reasons = append(reasons, "Android.bp files were added or removed")
+		default:
+			reasons = append(reasons, fmt.Sprintf("%s changed", input))
+		}
+	}
+	if len(globLists) > 0 {
+		reasons = append(reasons, r.changedGlobs(filepath.Join(globDir, soongBuildTag), globLists)...)
+	}
+
+	if len(reasons) == 0 {
+		reasons = []string{"unknown, none of the inputs of soong_build changed"}
+	} else if len(reasons) > maxSoongRegenReasons {
+		more := len(reasons) - maxSoongRegenReasons
+		reasons = append(reasons[:maxSoongRegenReasons], fmt.Sprintf("... and %d more", more))
+	}
+	return reasons
+}
+
+func (r *soongRegenCheck) changedSince(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.ModTime().UnixNano() > r.ninjaMtime
+}
+
+// changedInputs returns the inputs listed in a depfile that were modified
+// after the Soong ninja file was last written.
+func (r *soongRegenCheck) changedInputs(depfile string) ([]string, error) {
+	f, err := os.Open(depfile)
+	if err != nil {
+		return nil, fmt.Errorf("%s can't be read: %s", depfile, err)
+	}
+	defer f.Close()
+
+	deps, err := makedeps.Parse(depfile, f)
+	if err != nil {
+		return nil, fmt.Errorf("%s can't be parsed: %s", depfile, err)
+	}
+
+	var inputs []string
+	for _, input := range deps.Inputs {
+		if r.changedSince(input) {
+			inputs = append(inputs, input)
+		}
+	}
+	return inputs, nil
+}
+
+// changedGlobs returns the glob results in globDir that were rewritten by
+// bpglob after the Soong ninja file was last written. bpglob only rewrites a
+// result when the list of files matching the glob changes, and the name of the
+// result file is derived from the glob pattern. The glob list files, which
+// list the results and are inputs of the Soong ninja file, are skipped.
+func (r *soongRegenCheck) changedGlobs(globDir string, globLists []string) []string {
+	var globs []string
+	filepath.WalkDir(globDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || strings.HasSuffix(path, ".d") || inList(path, globLists) {
+			return nil
+		}
+		if r.changedSince(path) {
+			rel, _ := filepath.Rel(globDir, path)
+			globs = append(globs, fmt.Sprintf("the result of glob %s changed", rel))
+		}
+		return nil
+	})
+	if len(globs) == 0 {
+		globs = []string{"glob results changed"}
+	}
+	return globs
+}
+
+// changedSoongVariables describes the fields that differ between two
+// soong.variables files.
+func changedSoongVariables(oldFile, newFile string) ([]string, error) {
+	read := func(file string) (map[string]json.RawMessage, error) {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		vars := make(map[string]json.RawMessage)
+		if err := json.Unmarshal(data, &vars); err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		return vars, nil
+	}
+
+	oldVars, err := read(oldFile)
+	if err != nil {
+		return nil, err
+	}
+	newVars, err := read(newFile)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]bool)
+	for key := range oldVars {
+		keys[key] = true
+	}
+	for key := range newVars {
+		keys[key] = true
+	}
+
+	var changes []string
+	for key := range keys {
+		oldValue, newValue := compactJson(oldVars[key]), compactJson(newVars[key])
+		if oldValue != newValue {
+			changes = append(changes, fmt.Sprintf("soong.variables field %s changed from %s to %s",
+				key, oldValue, newValue))
+		}
+	}
+	sort.Strings(changes)
+	return changes, nil
+}
+
+// compactJson returns a short form of a JSON value for messages.
+func compactJson(value json.RawMessage) string {
+	if value == nil {
+		return "<unset>"
+	}
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, value); err != nil {
+		buf.Reset()
+		buf.Write(value)
+	}
+	s := buf.String()
+	if len(s) > 80 {
+		s = s[:77] + "..."
+	}
+	return s
+}
+
+// reportSoongRegen stores the reasons for regenerating the Soong ninja file in
+// the metrics and logs them, or prints them with --explain-regen. It also saves
+// the soong.variables used by this soong_build run for the next comparison.
+func reportSoongRegen(ctx Context, config Config, r *soongRegenCheck) {
+	reasons := r.reasons(config)
+	if len(reasons) > 0 {
+		ctx.Metrics.SetSoongRegenReasons(reasons)
+		if config.explainRegen {
+			ctx.Println("soong_build regenerated the Soong ninja file because:")
+			for _, reason := range reasons {
+				ctx.Println("  " + reason)
+			}
+		} else {
+			for _, reason := range reasons {
+				ctx.Verboseln("soong_build regenerated the Soong ninja file because", reason)
+			}
+		}
+	} else if config.explainRegen {
+		ctx.Println("The Soong ninja file was up to date.")
+	}
+
+	if exists, _ := fileExists(config.LastSoongVarsFile()); len(reasons) > 0 || !exists {
+		data, err := ioutil.ReadFile(config.SoongVarsFile())
+		if err == nil {
+			err = ioutil.WriteFile(config.LastSoongVarsFile(), data, 0666)
+		}
+		if err != nil {
+			ctx.Verboseln("Failed to save soong.variables:", err)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright 2023 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"android/soong/shared"
+)
+
+func TestSoongRegenReasons(t *testing.T) {
+	past := time.Now().Add(-time.Hour)
+	future := time.Now().Add(time.Hour)
+
+	writeFile := func(t *testing.T, file, contents string) {
+		if err := os.MkdirAll(filepath.Dir(file), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(file, []byte(contents), 0666); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(file, past, past); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	touch := func(t *testing.T, file string) {
+		if err := os.Chtimes(file, future, future); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	setup := func(t *testing.T) (Config, *Environment, string) {
+		outDir := t.TempDir()
+		env := Environment([]string{
+			"OUT_DIR=" + outDir,
+			"FOO=foo",
+		})
+		config := Config{&configImpl{environ: &env}}
+
+		androidBp := filepath.Join(outDir, "Android.bp")
+		globList := filepath.Join(config.SoongOutDir(), "globs", soongBuildTag, "0")
+		writeFile(t, androidBp, "")
+		writeFile(t, globList, "")
+		writeFile(t, filepath.Join(config.SoongOutDir(), "globs", soongBuildTag, "src", "*.go"), "a.go\n")
+		writeFile(t, config.SoongVarsFile(), `{"Platform_sdk_version": 33, "Eng": false}`)
+		writeFile(t, config.LastSoongVarsFile(), `{"Platform_sdk_version": 33, "Eng": false}`)
+		writeFile(t, config.SoongNinjaFile(), "")
+		writeFile(t, config.SoongNinjaFile()+".d",
+			config.SoongNinjaFile()+": "+androidBp+" "+globList+" "+config.SoongVarsFile()+"\n")
+
+		envData, err := shared.EnvFileContents(map[string]string{"FOO": "foo"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		writeFile(t, config.UsedEnvFile(soongBuildTag), string(envData))
+
+		return config, &env, androidBp
+	}
+
+	t.Run("up to date", func(t *testing.T) {
+		config, env, _ := setup(t)
+		r := newSoongRegenCheck(config, env)
+		if reasons := r.reasons(config); reasons != nil {
+			t.Errorf("expected no reasons, got %q", reasons)
+		}
+	})
+
+	t.Run("missing", func(t *testing.T) {
+		config, env, _ := setup(t)
+		os.Remove(config.SoongNinjaFile())
+		r := newSoongRegenCheck(config, env)
+		writeFile(t, config.SoongNinjaFile(), "")
+		want := []string{config.SoongNinjaFile() + " didn't exist"}
+		if reasons := r.reasons(config); !reflect.DeepEqual(reasons, want) {
+			t.Errorf("expected %q, got %q", want, reasons)
+		}
+	})
+
+	t.Run("inputs changed", func(t *testing.T) {
+		config, env, androidBp := setup(t)
+		env.Set("FOO", "bar")
+		r := newSoongRegenCheck(config, env)
+
+		touch(t, androidBp)
+		touch(t, filepath.Join(config.SoongOutDir(), "globs", soongBuildTag, "0"))
+		touch(t, filepath.Join(config.SoongOutDir(), "globs", soongBuildTag, "src", "*.go"))
+		writeFile(t, config.SoongVarsFile(), `{"Platform_sdk_version": 34, "Eng": false}`)
+		touch(t, config.SoongVarsFile())
+		touch(t, config.SoongNinjaFile())
+
+		want := []string{
+			`environment variable FOO changed from "foo" to "bar"`,
+			androidBp + " changed",
+			"soong.variables field Platform_sdk_version changed from 33 to 34",
+			"the result of glob src/*.go changed",
+		}
+		if reasons := r.reasons(config); !reflect.DeepEqual(reasons, want) {
+			t.Errorf("expected %q, got %q", want, reasons)
+		}
+	})
+}
@@ -259,6 +259,12 @@ func (m *Metrics) SetSoongBuildMetrics(metrics *soong_metrics_proto.SoongBuildMe
 	m.metrics.SoongBuildMetrics = metrics
 }
 
+// SetSoongRegenReasons sets the reasons why soong_build regenerated the Soong
+// ninja file.
+func (m *Metrics) SetSoongRegenReasons(reasons []string) {
+	m.metrics.SoongRegenReasons = reasons
+}
+
 // A CriticalUserJourneysMetrics is a struct that contains critical user journey
 // metrics. These critical user journeys are defined under cuj/cuj.go file.
 type CriticalUserJourneysMetrics struct {
@@ -13,6 +13,9 @@ type ProcStatus struct {
 	// Process PID.
 	pid int
 
+	// Name of the executable of the process.
+	Name string
+
 	// Peak virtual memory size.
 	VmPeak uint64
 
@@ -67,6 +70,8 @@ type ProcStatus struct {
 func fillProcStatus(s *ProcStatus, key, value string) {
 	v := strToUint64(value)
 	switch key {
+	case "Name":
+		s.Name = value
 	case "VmPeak":
 		s.VmPeak = v
 	case "VmSize":
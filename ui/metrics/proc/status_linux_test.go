@@ -94,6 +94,7 @@ nonvoluntary_ctxt_switches:     32
 
 var expectedStatus = &ProcStatus{
 	pid:          4032827,
+	Name:         "fake_process",
 	VmPeak:       750829568,
 	VmSize:       750829568,
 	VmLck:        135168,
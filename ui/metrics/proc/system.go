@@ -0,0 +1,74 @@
+package proc
+
+import (
+	"strings"
+)
+
+// MemInfo is the system wide memory usage, in bytes.
+type MemInfo struct {
+	// Total usable RAM.
+	MemTotal uint64
+
+	// RAM that is not used at all.
+	MemFree uint64
+
+	// Estimate of the RAM that is available for starting new processes
+	// without swapping.
+	MemAvailable uint64
+
+	// Total swap space.
+	SwapTotal uint64
+
+	// Unused swap space.
+	SwapFree uint64
+}
+
+func fillMemInfo(m *MemInfo, key, value string) {
+	v := strToUint64(value)
+	switch key {
+	case "MemTotal":
+		m.MemTotal = v
+	case "MemFree":
+		m.MemFree = v
+	case "MemAvailable":
+		m.MemAvailable = v
+	case "SwapTotal":
+		m.SwapTotal = v
+	case "SwapFree":
+		m.SwapFree = v
+	}
+}
+
+// CPUStat is the time that all CPUs of the system together have spent in each
+// state since boot, in clock ticks.
+type CPUStat struct {
+	User    uint64
+	Nice    uint64
+	System  uint64
+	Idle    uint64
+	IOWait  uint64
+	IRQ     uint64
+	SoftIRQ uint64
+	Steal   uint64
+}
+
+// Total returns the time spent in all states.
+func (c *CPUStat) Total() uint64 {
+	return c.User + c.Nice + c.System + c.Idle + c.IOWait + c.IRQ + c.SoftIRQ + c.Steal
+}
+
+// Busy returns the time spent doing work, which excludes idle time and time
+// spent waiting for IO.
+func (c *CPUStat) Busy() uint64 {
+	return c.Total() - c.Idle - c.IOWait
+}
+
+func fillCPUStat(c *CPUStat, fields []string) {
+	values := []*uint64{&c.User, &c.Nice, &c.System, &c.Idle, &c.IOWait, &c.IRQ, &c.SoftIRQ, &c.Steal}
+	for i, field := range fields {
+		if i == len(values) {
+			break
+		}
+		*values[i] = strToUint64(strings.TrimSpace(field))
+	}
+}
@@ -0,0 +1,29 @@
+package proc
+
+import (
+	"android/soong/finder/fs"
+)
+
+// NewMemInfo returns a zero filled value of MemInfo as it is not supported
+// for darwin distribution based.
+func NewMemInfo(_ fs.FileSystem) (*MemInfo, error) {
+	return &MemInfo{}, nil
+}
+
+// NewCPUStat returns a zero filled value of CPUStat as it is not supported
+// for darwin distribution based.
+func NewCPUStat(_ fs.FileSystem) (*CPUStat, error) {
+	return &CPUStat{}, nil
+}
+
+// ChildPids returns no children as it is not supported for darwin
+// distribution based.
+func ChildPids(_ int, _ fs.FileSystem) ([]int, error) {
+	return nil, nil
+}
+
+// DescendantPids returns no descendants as it is not supported for darwin
+// distribution based.
+func DescendantPids(_ int, _ fs.FileSystem) ([]int, error) {
+	return nil, nil
+}
@@ -0,0 +1,111 @@
+package proc
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"android/soong/finder/fs"
+)
+
+// NewMemInfo returns the system wide memory usage from /proc/meminfo.
+func NewMemInfo(fileSystem fs.FileSystem) (*MemInfo, error) {
+	data, err := readFile("/proc/meminfo", fileSystem)
+	if err != nil {
+		return &MemInfo{}, err
+	}
+
+	m := &MemInfo{}
+	for _, l := range strings.Split(string(data), "\n") {
+		if !strings.Contains(l, ":") {
+			continue
+		}
+		kv := strings.SplitN(l, ":", 2)
+		fillMemInfo(m, strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1]))
+	}
+
+	return m, nil
+}
+
+// NewCPUStat returns the time spent by all CPUs in each state from the
+// aggregate cpu line of /proc/stat.
+func NewCPUStat(fileSystem fs.FileSystem) (*CPUStat, error) {
+	data, err := readFile("/proc/stat", fileSystem)
+	if err != nil {
+		return &CPUStat{}, err
+	}
+
+	for _, l := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(l)
+		if len(fields) > 0 && fields[0] == "cpu" {
+			c := &CPUStat{}
+			fillCPUStat(c, fields[1:])
+			return c, nil
+		}
+	}
+
+	return &CPUStat{}, fmt.Errorf("no cpu line in /proc/stat")
+}
+
+// ChildPids returns the sorted PIDs of the direct children of a process,
+// including the children started by any of its threads.
+func ChildPids(pid int, fileSystem fs.FileSystem) ([]int, error) {
+	taskDir := filepath.Join("/proc", strconv.Itoa(pid), "task")
+	tasks, err := fileSystem.ReadDir(taskDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var pids []int
+	for _, task := range tasks {
+		childrenFname := filepath.Join(taskDir, task.Name(), "children")
+		data, err := readFile(childrenFname, fileSystem)
+		if err != nil {
+			// The thread may have exited since it was listed.
+			continue
+		}
+		for _, field := range strings.Fields(string(data)) {
+			child, err := strconv.Atoi(field)
+			if err != nil {
+				return nil, fmt.Errorf("invalid pid %q in %s", field, childrenFname)
+			}
+			pids = append(pids, child)
+		}
+	}
+	sort.Ints(pids)
+	return pids, nil
+}
+
+// DescendantPids returns the PIDs of the children of a process, their
+// children, and so on. Processes that exit while the tree is walked are
+// skipped.
+func DescendantPids(pid int, fileSystem fs.FileSystem) ([]int, error) {
+	children, err := ChildPids(pid, fileSystem)
+	if err != nil {
+		return nil, err
+	}
+
+	var pids []int
+	for len(children) > 0 {
+		child := children[0]
+		children = children[1:]
+		pids = append(pids, child)
+		if grandchildren, err := ChildPids(child, fileSystem); err == nil {
+			children = append(children, grandchildren...)
+		}
+	}
+	return pids, nil
+}
+
+func readFile(filename string, fileSystem fs.FileSystem) ([]byte, error) {
+	r, err := fileSystem.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	return ioutil.ReadAll(r)
+}
@@ -0,0 +1,135 @@
+package proc
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"android/soong/finder/fs"
+)
+
+func TestNewMemInfo(t *testing.T) {
+	fs := fs.NewMockFs(nil)
+	if err := fs.MkDirs("/proc"); err != nil {
+		t.Fatalf("failed to create proc dir: %v", err)
+	}
+	if err := fs.WriteFile("/proc/meminfo", memInfoData, 0644); err != nil {
+		t.Fatalf("failed to write meminfo: %v", err)
+	}
+
+	memInfo, err := NewMemInfo(fs)
+	if err != nil {
+		t.Fatalf("got %v, want nil for error", err)
+	}
+
+	expected := &MemInfo{
+		MemTotal:     1024 * 65790752,
+		MemFree:      1024 * 2203824,
+		MemAvailable: 1024 * 41375284,
+		SwapTotal:    1024 * 1000444,
+		SwapFree:     1024 * 800444,
+	}
+	if !reflect.DeepEqual(memInfo, expected) {
+		t.Errorf("got %v, expecting %v for MemInfo", memInfo, expected)
+	}
+}
+
+func TestNewCPUStat(t *testing.T) {
+	fs := fs.NewMockFs(nil)
+	if err := fs.MkDirs("/proc"); err != nil {
+		t.Fatalf("failed to create proc dir: %v", err)
+	}
+	if err := fs.WriteFile("/proc/stat", statData, 0644); err != nil {
+		t.Fatalf("failed to write stat: %v", err)
+	}
+
+	cpuStat, err := NewCPUStat(fs)
+	if err != nil {
+		t.Fatalf("got %v, want nil for error", err)
+	}
+
+	expected := &CPUStat{
+		User:    4705,
+		Nice:    356,
+		System:  584,
+		Idle:    3699,
+		IOWait:  23,
+		IRQ:     23,
+		SoftIRQ: 0,
+		Steal:   5,
+	}
+	if !reflect.DeepEqual(cpuStat, expected) {
+		t.Errorf("got %v, expecting %v for CPUStat", cpuStat, expected)
+	}
+	if got, want := cpuStat.Busy(), uint64(4705+356+584+23+0+5); got != want {
+		t.Errorf("got %d, expecting %d for busy time", got, want)
+	}
+}
+
+func writeChildren(t *testing.T, fs *fs.MockFs, pid, task int, children string) {
+	t.Helper()
+	dir := fmt.Sprintf("/proc/%d/task/%d", pid, task)
+	if err := fs.MkDirs(dir); err != nil {
+		t.Fatalf("failed to create task dir: %v", err)
+	}
+	if err := fs.WriteFile(dir+"/children", []byte(children), 0644); err != nil {
+		t.Fatalf("failed to write children: %v", err)
+	}
+}
+
+func TestChildPids(t *testing.T) {
+	fs := fs.NewMockFs(nil)
+	writeChildren(t, fs, 100, 100, "101 205 ")
+	writeChildren(t, fs, 100, 102, "310 ")
+
+	pids, err := ChildPids(100, fs)
+	if err != nil {
+		t.Fatalf("got %v, want nil for error", err)
+	}
+	if expected := []int{101, 205, 310}; !reflect.DeepEqual(pids, expected) {
+		t.Errorf("got %v, expecting %v for child pids", pids, expected)
+	}
+}
+
+func TestDescendantPids(t *testing.T) {
+	fs := fs.NewMockFs(nil)
+	writeChildren(t, fs, 100, 100, "101 ")
+	writeChildren(t, fs, 101, 101, "102 103 ")
+	writeChildren(t, fs, 102, 102, "104 ")
+	writeChildren(t, fs, 103, 103, "")
+	// 104 exited after it was listed.
+
+	pids, err := DescendantPids(100, fs)
+	if err != nil {
+		t.Fatalf("got %v, want nil for error", err)
+	}
+	if expected := []int{101, 102, 103, 104}; !reflect.DeepEqual(pids, expected) {
+		t.Errorf("got %v, expecting %v for descendant pids", pids, expected)
+	}
+}
+
+var memInfoData = []byte(`MemTotal:       65790752 kB
+MemFree:         2203824 kB
+MemAvailable:   41375284 kB
+Buffers:         1297188 kB
+Cached:         36424520 kB
+SwapCached:        10736 kB
+Active:         27107624 kB
+Inactive:       31851552 kB
+SwapTotal:       1000444 kB
+SwapFree:         800444 kB
+Dirty:              1412 kB
+HugePages_Total:       0
+Hugepagesize:       2048 kB
+`)
+
+var statData = []byte(`cpu  4705 356 584 3699 23 23 0 5 0 0
+cpu0 1393 280 32 1009 6 3 0 2 0 0
+cpu1 3312 76 552 2690 17 20 0 3 0 0
+intr 114930548 113199788 3 0 5 263 0 4 [...]
+ctxt 1990473
+btime 1062191376
+processes 2915
+procs_running 1
+procs_blocked 0
+`)
@@ -0,0 +1,156 @@
+// Copyright 2023 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package status
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const actionHistoryHeader = "# action history v1"
+
+// actionHistory stores how long each action took the last time it ran, along
+// with the length of the longest chain of dependent actions that started with
+// it. It's saved across builds so that the remaining time of a build can be
+// estimated before its actions have run.
+type actionHistory struct {
+	entries map[string]actionHistoryEntry
+
+	// meanDuration is the average duration of all of the entries.
+	meanDuration time.Duration
+}
+
+type actionHistoryEntry struct {
+	// duration is the wall time of the action itself.
+	duration time.Duration
+
+	// chain is the wall time of the longest path through the action and
+	// the actions that depend on it, including the action itself.
+	chain time.Duration
+}
+
+// actionHistoryKey returns the key an action is stored under, which is its
+// first output, or its description if it doesn't have any outputs.
+func actionHistoryKey(action *Action) string {
+	if len(action.Outputs) > 0 {
+		return action.Outputs[0]
+	}
+	return action.Description
+}
+
+func newActionHistory() *actionHistory {
+	return &actionHistory{
+		entries: make(map[string]actionHistoryEntry),
+	}
+}
+
+// loadActionHistory reads an action history written by save. A missing file
+// results in an empty history.
+func loadActionHistory(filename string) (*actionHistory, error) {
+	h := newActionHistory()
+
+	f, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		return h, nil
+	} else if err != nil {
+		return h, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1024*1024)
+	if !scanner.Scan() || scanner.Text() != actionHistoryHeader {
+		// Unknown version, start over.
+		return h, nil
+	}
+
+	for scanner.Scan() {
+		fields := strings.SplitN(scanner.Text(), "\t", 3)
+		if len(fields) != 3 {
+			return newActionHistory(), fmt.Errorf("%s: malformed line %q", filename, scanner.Text())
+		}
+		duration, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			return newActionHistory(), fmt.Errorf("%s: %w", filename, err)
+		}
+		chain, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return newActionHistory(), fmt.Errorf("%s: %w", filename, err)
+		}
+		h.entries[fields[2]] = actionHistoryEntry{
+			duration: time.Duration(duration) * time.Millisecond,
+			chain:    time.Duration(chain) * time.Millisecond,
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return newActionHistory(), fmt.Errorf("%s: %w", filename, err)
+	}
+
+	h.updateMean()
+	return h, nil
+}
+
+func (h *actionHistory) updateMean() {
+	if len(h.entries) == 0 {
+		h.meanDuration = 0
+		return
+	}
+	var total time.Duration
+	for _, e := range h.entries {
+		total += e.duration
+	}
+	h.meanDuration = total / time.Duration(len(h.entries))
+}
+
+func (h *actionHistory) lookup(action *Action) (actionHistoryEntry, bool) {
+	e, ok := h.entries[actionHistoryKey(action)]
+	return e, ok
+}
+
+func (h *actionHistory) set(action *Action, e actionHistoryEntry) {
+	h.entries[actionHistoryKey(action)] = e
+}
+
+// save writes the history to filename, replacing it atomically.
+func (h *actionHistory) save(filename string) error {
+	keys := make([]string, 0, len(h.entries))
+	for k := range h.entries {
+		// The file is line based, so skip the rare description that
+		// can't be stored in it.
+		if !strings.ContainsRune(k, '\n') {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	buf := &strings.Builder{}
+	fmt.Fprintln(buf, actionHistoryHeader)
+	for _, k := range keys {
+		e := h.entries[k]
+		fmt.Fprintf(buf, "%d\t%d\t%s\n", e.duration.Milliseconds(), e.chain.Milliseconds(), k)
+	}
+
+	tempPath := filename + ".tmp"
+	if err := ioutil.WriteFile(tempPath, []byte(buf.String()), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tempPath, filename)
+}
@@ -20,13 +20,44 @@ import (
 	"android/soong/ui/logger"
 )
 
-func NewCriticalPath(log logger.Logger) StatusOutput {
-	return &criticalPath{
-		log:     log,
-		running: make(map[*Action]time.Time),
-		nodes:   make(map[string]*node),
-		clock:   osClock{},
+// CriticalPathFiles are the files used by the critical path output. Files
+// that are empty are not used.
+type CriticalPathFiles struct {
+	// History is where the durations of the actions are saved at the end of
+	// the build. The durations from previous builds are used to estimate the
+	// remaining time of this one.
+	History string
+
+	// ModuleActions lists the actions of each Soong module. A
+	// CriticalPathReport is written to ModuleReport at the end of the
+	// build, which groups the critical path by Soong module if
+	// ModuleActions exists.
+	ModuleActions string
+	ModuleReport  string
+}
+
+// NewCriticalPath returns a StatusOutput that logs the critical path of the
+// build to the verbose log.
+func NewCriticalPath(log logger.Logger, files CriticalPathFiles) StatusOutput {
+	cp := &criticalPath{
+		log:               log,
+		running:           make(map[*Action]time.Time),
+		nodes:             make(map[string]*node),
+		clock:             osClock{},
+		historyFile:       files.History,
+		moduleActionsFile: files.ModuleActions,
+		moduleReportFile:  files.ModuleReport,
+	}
+
+	if files.History != "" {
+		history, err := loadActionHistory(files.History)
+		if err != nil {
+			log.Verboseln("Failed to load action history:", err)
+		}
+		cp.history = history
 	}
+
+	return cp
 }
 
 type criticalPath struct {
@@ -35,9 +66,23 @@ type criticalPath struct {
 	nodes   map[string]*node
 	running map[*Action]time.Time
 
+	// order contains every node in the order its action finished.
+	order []*node
+
+	// maxRunning is the largest number of actions that have been running
+	// at the same time, used as the expected parallelism of the rest of
+	// the build.
+	maxRunning int
+
 	start, end time.Time
 
 	clock clock
+
+	history     *actionHistory
+	historyFile string
+
+	moduleActionsFile string
+	moduleReportFile  string
 }
 
 type clock interface {
@@ -63,6 +108,9 @@ func (cp *criticalPath) StartAction(action *Action, counts Counts) {
 		cp.start = start
 	}
 	cp.running[action] = start
+	if len(cp.running) > cp.maxRunning {
+		cp.maxRunning = len(cp.running)
+	}
 }
 
 func (cp *criticalPath) FinishAction(result ActionResult, counts Counts) {
@@ -97,6 +145,7 @@ func (cp *criticalPath) FinishAction(result ActionResult, counts Counts) {
 		for _, output := range result.Action.Outputs {
 			cp.nodes[output] = node
 		}
+		cp.order = append(cp.order, node)
 
 		cp.end = end
 	}
@@ -125,6 +174,17 @@ func (cp *criticalPath) Flush() {
 			cp.log.Verbosef("   %2d:%02d %s",
 				seconds/60, seconds%60, criticalPath[i].action.Description)
 		}
+
+		if cp.moduleReportFile != "" {
+			cp.writeModuleReport(criticalPath)
+		}
+	}
+
+	if cp.history != nil {
+		cp.updateHistory()
+		if err := cp.history.save(cp.historyFile); err != nil {
+			cp.log.Verboseln("Failed to save action history:", err)
+		}
 	}
 }
 
@@ -152,3 +212,95 @@ func (cp *criticalPath) criticalPath() []*node {
 
 	return criticalPath
 }
+
+// updateHistory records the duration of every action that finished during this
+// build, along with the longest chain of dependent actions that started with
+// it, into the action history. Entries for actions that didn't run are kept.
+func (cp *criticalPath) updateHistory() {
+	// Find the actions that consume the outputs of each action.
+	consumers := make(map[*node][]*node)
+	for _, n := range cp.nodes {
+		for _, input := range n.action.Inputs {
+			if producer := cp.nodes[input]; producer != nil && producer != n {
+				consumers[producer] = append(consumers[producer], n)
+			}
+		}
+	}
+
+	chains := make(map[*node]time.Duration)
+	var chain func(n *node) time.Duration
+	chain = func(n *node) time.Duration {
+		if c, ok := chains[n]; ok {
+			return c
+		}
+		// Guard against cycles, which ninja would not have run.
+		chains[n] = n.duration
+
+		var longest time.Duration
+		for _, consumer := range consumers[n] {
+			if c := chain(consumer); c > longest {
+				longest = c
+			}
+		}
+		chains[n] = n.duration + longest
+		return chains[n]
+	}
+
+	for _, n := range cp.nodes {
+		cp.history.set(n.action, actionHistoryEntry{
+			duration: n.duration,
+			chain:    chain(n),
+		})
+	}
+	cp.history.updateMean()
+}
+
+// estimate uses the action history to estimate how much longer the build will
+// take. Actions that are running are expected to take as long as they did last
+// time, followed by the rest of their chain of dependent actions. Actions that
+// haven't started yet are expected to take the average duration, spread over
+// as many actions as have been seen running in parallel.
+func (cp *criticalPath) estimate(counts Counts) Estimate {
+	if cp.history == nil || len(cp.history.entries) == 0 {
+		return Estimate{}
+	}
+
+	now := cp.clock.Now()
+	var estimate Estimate
+	var remainingWork time.Duration
+
+	for action, start := range cp.running {
+		entry, ok := cp.history.lookup(action)
+		if !ok {
+			entry = actionHistoryEntry{
+				duration: cp.history.meanDuration,
+				chain:    cp.history.meanDuration,
+			}
+		}
+
+		remaining := entry.duration - now.Sub(start)
+		if remaining < 0 {
+			remaining = 0
+		}
+		remainingWork += remaining
+
+		if chain := entry.chain - entry.duration + remaining; chain > estimate.CriticalPath {
+			estimate.CriticalPath = chain
+		}
+	}
+
+	if waiting := counts.TotalActions - counts.StartedActions; waiting > 0 {
+		remainingWork += time.Duration(waiting) * cp.history.meanDuration
+	}
+
+	parallelism := cp.maxRunning
+	if parallelism < 1 {
+		parallelism = 1
+	}
+	estimate.Remaining = remainingWork / time.Duration(parallelism)
+	if estimate.Remaining < estimate.CriticalPath {
+		estimate.Remaining = estimate.CriticalPath
+	}
+
+	return estimate
+}
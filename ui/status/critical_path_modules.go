@@ -0,0 +1,311 @@
+// Copyright 2023 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package status
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"time"
+)
+
+// CriticalPathReport is the critical path of a build grouped by the Soong
+// module that owns each action, written as JSON by the critical path output.
+type CriticalPathReport struct {
+	// CriticalPathMs is the length of the critical path of the build.
+	CriticalPathMs int64 `json:"critical_path_ms"`
+
+	// Modules contains every module that has actions on the critical
+	// path, ordered by how much shorter the critical path would be if
+	// their actions took no time at all. It is empty if the module
+	// actions file of soong_build wasn't written.
+	Modules []*CriticalPathModule `json:"modules"`
+}
+
+// CriticalPathModule describes the actions of a single module on the critical
+// path, and what the critical path would have been if they had been faster.
+type CriticalPathModule struct {
+	// Name, Type and Blueprint identify the module. Actions that don't
+	// belong to any Soong module, for example those from Kati, are
+	// grouped under an empty name.
+	Name      string `json:"name"`
+	Type      string `json:"type,omitempty"`
+	Blueprint string `json:"blueprint,omitempty"`
+
+	// Actions is the number of actions of the module on the critical
+	// path.
+	Actions int `json:"actions"`
+
+	// DurationMs is the total time of the module's actions on the
+	// critical path.
+	DurationMs int64 `json:"duration_ms"`
+
+	// TwiceAsFastMs is the length of the critical path if all of the
+	// actions of the module had taken half as long.
+	TwiceAsFastMs int64 `json:"twice_as_fast_ms"`
+
+	// RemovedMs is the length of the critical path if all of the actions
+	// of the module had taken no time.
+	RemovedMs int64 `json:"removed_ms"`
+}
+
+// jsonModuleActions is the subset of the entries in the file written by
+// soong_build --module_actions_file that is needed to map outputs to modules.
+type jsonModuleActions struct {
+	Name      string
+	Type      string
+	Blueprint string
+	Module    struct {
+		Actions []struct {
+			Outputs []string
+		}
+	}
+}
+
+type moduleKey struct {
+	name, blueprint string
+}
+
+type moduleInfo struct {
+	moduleKey
+	typ string
+}
+
+// readModuleOwners streams the module actions file and returns the owning
+// module of every output of the modules that own at least one of the wanted
+// outputs. The file can be very large, so the outputs of other modules are
+// not kept.
+func readModuleOwners(r io.Reader, wanted map[string]bool) (map[string]*moduleInfo, error) {
+	owners := make(map[string]*moduleInfo)
+
+	decoder := json.NewDecoder(r)
+	if t, err := decoder.Token(); err != nil {
+		return nil, err
+	} else if t != json.Delim('[') {
+		return nil, fmt.Errorf("expected a list of modules, got %v", t)
+	}
+
+	for decoder.More() {
+		var m jsonModuleActions
+		if err := decoder.Decode(&m); err != nil {
+			return nil, err
+		}
+
+		found := false
+		for _, action := range m.Module.Actions {
+			for _, output := range action.Outputs {
+				if wanted[output] {
+					found = true
+				}
+			}
+		}
+		if !found {
+			continue
+		}
+
+		info := &moduleInfo{
+			moduleKey: moduleKey{name: m.Name, blueprint: m.Blueprint},
+			typ:       m.Type,
+		}
+		for _, action := range m.Module.Actions {
+			for _, output := range action.Outputs {
+				owners[output] = info
+			}
+		}
+	}
+
+	return owners, nil
+}
+
+// nodeOwner returns the module that owns the action of a node, or nil.
+func nodeOwner(n *node, owners map[string]*moduleInfo) *moduleInfo {
+	for _, output := range n.action.Outputs {
+		if owner := owners[output]; owner != nil {
+			return owner
+		}
+	}
+	return nil
+}
+
+// longestPath returns the length of the longest chain of dependent actions in
+// the build, using duration to compute the length of each action.
+func (cp *criticalPath) longestPath(producers [][]int, duration func(i int) time.Duration) time.Duration {
+	finish := make([]time.Duration, len(cp.order))
+	var longest time.Duration
+	// cp.order is in the order the actions finished, so the producers of
+	// the inputs of each action come before it.
+	for i := range cp.order {
+		var start time.Duration
+		for _, p := range producers[i] {
+			if finish[p] > start {
+				start = finish[p]
+			}
+		}
+		finish[i] = start + duration(i)
+		if finish[i] > longest {
+			longest = finish[i]
+		}
+	}
+	return longest
+}
+
+// moduleReport groups the critical path by owning module, and computes how
+// long the critical path would have been if the actions of each module on it
+// had been twice as fast or had taken no time. The critical path may move to
+// another chain of actions when a module is made faster, so the whole graph of
+// actions is taken into account.
+func (cp *criticalPath) moduleReport(criticalPath []*node, owners map[string]*moduleInfo) *CriticalPathReport {
+	index := make(map[*node]int, len(cp.order))
+	for i, n := range cp.order {
+		index[n] = i
+	}
+
+	producers := make([][]int, len(cp.order))
+	ownerOf := make([]*moduleInfo, len(cp.order))
+	for i, n := range cp.order {
+		for _, input := range n.action.Inputs {
+			if p, ok := index[cp.nodes[input]]; ok && p < i {
+				producers[i] = append(producers[i], p)
+			}
+		}
+		ownerOf[i] = nodeOwner(n, owners)
+	}
+
+	var noModule moduleInfo
+	modules := make(map[moduleKey]*CriticalPathModule)
+	var keys []moduleKey
+	for _, n := range criticalPath {
+		owner := ownerOf[index[n]]
+		if owner == nil {
+			owner = &noModule
+		}
+		m := modules[owner.moduleKey]
+		if m == nil {
+			m = &CriticalPathModule{
+				Name:      owner.name,
+				Type:      owner.typ,
+				Blueprint: owner.blueprint,
+			}
+			modules[owner.moduleKey] = m
+			keys = append(keys, owner.moduleKey)
+		}
+		m.Actions++
+		m.DurationMs += n.duration.Milliseconds()
+	}
+
+	report := &CriticalPathReport{}
+	if len(criticalPath) > 0 {
+		report.CriticalPathMs = criticalPath[0].cumulativeDuration.Milliseconds()
+	}
+
+	for _, key := range keys {
+		owned := func(i int) bool {
+			owner := ownerOf[i]
+			if owner == nil {
+				return key == noModule.moduleKey
+			}
+			return owner.moduleKey == key
+		}
+
+		m := modules[key]
+		m.TwiceAsFastMs = cp.longestPath(producers, func(i int) time.Duration {
+			if owned(i) {
+				return cp.order[i].duration / 2
+			}
+			return cp.order[i].duration
+		}).Milliseconds()
+		m.RemovedMs = cp.longestPath(producers, func(i int) time.Duration {
+			if owned(i) {
+				return 0
+			}
+			return cp.order[i].duration
+		}).Milliseconds()
+
+		report.Modules = append(report.Modules, m)
+	}
+
+	sort.SliceStable(report.Modules, func(i, j int) bool {
+		return report.Modules[i].RemovedMs < report.Modules[j].RemovedMs
+	})
+
+	return report
+}
+
+// writeModuleReport writes the report of the critical path grouped by module
+// to cp.moduleReportFile, and logs the modules with the largest impact to the
+// verbose log.
+func (cp *criticalPath) writeModuleReport(criticalPath []*node) {
+	wanted := make(map[string]bool)
+	for _, n := range criticalPath {
+		for _, output := range n.action.Outputs {
+			wanted[output] = true
+		}
+	}
+
+	var report *CriticalPathReport
+	f, err := os.Open(cp.moduleActionsFile)
+	if os.IsNotExist(err) {
+		// The module actions file is only written when the
+		// json-module-graph goal is built, so only the length of the
+		// critical path can be reported.
+		report = &CriticalPathReport{CriticalPathMs: criticalPath[0].cumulativeDuration.Milliseconds()}
+	} else if err != nil {
+		cp.log.Verboseln("Failed to read module actions:", err)
+		return
+	} else {
+		defer f.Close()
+
+		owners, err := readModuleOwners(f, wanted)
+		if err != nil {
+			cp.log.Verbosef("Failed to read module actions from %s: %s", cp.moduleActionsFile, err)
+			return
+		}
+
+		report = cp.moduleReport(criticalPath, owners)
+	}
+
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		cp.log.Verboseln("Failed to marshal critical path report:", err)
+		return
+	}
+	if err := ioutil.WriteFile(cp.moduleReportFile, data, 0644); err != nil {
+		cp.log.Verboseln("Failed to write critical path report:", err)
+		return
+	}
+
+	if len(report.Modules) > 0 {
+		cp.log.Verbose("critical path by module (time on critical path, critical path if 2x faster, if removed):")
+	}
+	for i, m := range report.Modules {
+		if i == 10 {
+			break
+		}
+		name := m.Name
+		if name == "" {
+			name = "<not a soong module>"
+		}
+		cp.log.Verbosef("   %5s %5s %5s %s", msMinutesSeconds(m.DurationMs),
+			msMinutesSeconds(m.TwiceAsFastMs), msMinutesSeconds(m.RemovedMs), name)
+	}
+	cp.log.Verbosef("full report written to %s", cp.moduleReportFile)
+}
+
+func msMinutesSeconds(ms int64) string {
+	return MinutesSeconds(time.Duration(ms) * time.Millisecond)
+}
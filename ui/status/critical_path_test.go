@@ -15,9 +15,15 @@
 package status
 
 import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
+
+	"android/soong/ui/logger"
 )
 
 type testCriticalPath struct {
@@ -137,7 +143,7 @@ func TestCriticalPath(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			cp := &testCriticalPath{
-				criticalPath: NewCriticalPath(nil).(*criticalPath),
+				criticalPath: NewCriticalPath(nil, CriticalPathFiles{}).(*criticalPath),
 				actions:      make(map[int]*Action),
 			}
 
@@ -164,3 +170,126 @@ func TestCriticalPath(t *testing.T) {
 		})
 	}
 }
+
+func TestCriticalPathHistory(t *testing.T) {
+	historyFile := filepath.Join(t.TempDir(), "action_history")
+	log := logger.New(ioutil.Discard)
+
+	// a -> b -> c, d
+	first := &testCriticalPath{
+		criticalPath: NewCriticalPath(log, CriticalPathFiles{History: historyFile}).(*criticalPath),
+		actions:      make(map[int]*Action),
+	}
+	first.start(0, 0, []string{"a"}, nil)
+	first.start(3, 0, []string{"d"}, nil)
+	first.finish(0, 1000*time.Millisecond)
+	first.finish(3, 1000*time.Millisecond)
+	first.start(1, 1000*time.Millisecond, []string{"b"}, []string{"a"})
+	first.finish(1, 3000*time.Millisecond)
+	first.start(2, 3000*time.Millisecond, []string{"c"}, []string{"b"})
+	first.finish(2, 4000*time.Millisecond)
+	first.Flush()
+
+	second := &testCriticalPath{
+		criticalPath: NewCriticalPath(log, CriticalPathFiles{History: historyFile}).(*criticalPath),
+		actions:      make(map[int]*Action),
+	}
+
+	wantEntries := map[string]actionHistoryEntry{
+		"a": {duration: 1000 * time.Millisecond, chain: 4000 * time.Millisecond},
+		"b": {duration: 2000 * time.Millisecond, chain: 3000 * time.Millisecond},
+		"c": {duration: 1000 * time.Millisecond, chain: 1000 * time.Millisecond},
+		"d": {duration: 1000 * time.Millisecond, chain: 1000 * time.Millisecond},
+	}
+	if !reflect.DeepEqual(second.history.entries, wantEntries) {
+		t.Errorf("history entries = %v, want %v", second.history.entries, wantEntries)
+	}
+
+	second.Counts = Counts{TotalActions: 4, StartedActions: 1, RunningActions: 1}
+	second.start(0, 0, []string{"a"}, nil)
+	second.clock = testClock(time.Unix(0, 0).Add(500 * time.Millisecond))
+
+	// a has 500ms left, followed by 3000ms for b and c. The 3 actions that
+	// haven't started are expected to take the mean of 1250ms each.
+	want := Estimate{
+		Remaining:    500*time.Millisecond + 3*1250*time.Millisecond,
+		CriticalPath: 3500 * time.Millisecond,
+	}
+	if got := second.estimate(second.Counts); got != want {
+		t.Errorf("estimate() = %+v, want %+v", got, want)
+	}
+}
+
+func TestCriticalPathModuleReport(t *testing.T) {
+	//  a
+	//  |\
+	//  b c
+	//  |/
+	//  d
+	cp := &testCriticalPath{
+		criticalPath: NewCriticalPath(nil, CriticalPathFiles{}).(*criticalPath),
+		actions:      make(map[int]*Action),
+	}
+	cp.start(0, 0, []string{"a"}, nil)
+	cp.finish(0, 1000*time.Millisecond)
+	cp.start(1, 1000*time.Millisecond, []string{"b"}, []string{"a"})
+	cp.start(2, 1000*time.Millisecond, []string{"c"}, []string{"a"})
+	cp.finish(1, 2000*time.Millisecond)
+	cp.finish(2, 3000*time.Millisecond)
+	cp.start(3, 3000*time.Millisecond, []string{"d"}, []string{"b", "c"})
+	cp.finish(3, 4000*time.Millisecond)
+
+	moduleActions := `[
+		{"Name": "liba", "Type": "cc_library", "Blueprint": "a/Android.bp", "Module": {"Actions": [
+			{"Inputs": [], "Outputs": ["a"]},
+			{"Inputs": ["b", "c"], "Outputs": ["d"]}
+		]}},
+		{"Name": "libb", "Type": "cc_library", "Blueprint": "b/Android.bp", "Module": {"Actions": [
+			{"Inputs": ["a"], "Outputs": ["b"]}
+		]}},
+		{"Name": "libc", "Type": "genrule", "Blueprint": "c/Android.bp", "Module": {"Actions": [
+			{"Inputs": ["a"], "Outputs": ["c"]}
+		]}}
+	]`
+
+	criticalPath := cp.criticalPath.criticalPath()
+	wanted := map[string]bool{"a": true, "c": true, "d": true}
+	owners, err := readModuleOwners(strings.NewReader(moduleActions), wanted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := owners["b"]; ok {
+		t.Errorf("expected outputs of libb, which isn't on the critical path, to be skipped")
+	}
+
+	got := cp.moduleReport(criticalPath, owners)
+	want := &CriticalPathReport{
+		CriticalPathMs: 4000,
+		Modules: []*CriticalPathModule{
+			{
+				Name:          "liba",
+				Type:          "cc_library",
+				Blueprint:     "a/Android.bp",
+				Actions:       2,
+				DurationMs:    2000,
+				TwiceAsFastMs: 3000,
+				RemovedMs:     2000,
+			},
+			{
+				Name:      "libc",
+				Type:      "genrule",
+				Blueprint: "c/Android.bp",
+				Actions:   1,
+				// When c is faster the critical path moves to b.
+				DurationMs:    2000,
+				TwiceAsFastMs: 3000,
+				RemovedMs:     3000,
+			},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		gotJson, _ := json.MarshalIndent(got, "", "  ")
+		wantJson, _ := json.MarshalIndent(want, "", "  ")
+		t.Errorf("moduleReport() = %s, want %s", gotJson, wantJson)
+	}
+}
@@ -0,0 +1,320 @@
+// Copyright 2023 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package status
+
+import (
+	"encoding/json"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"android/soong/ui/logger"
+)
+
+// eventSocketClientBuffer is the number of events that may be queued for a
+// single client before it is considered too slow and disconnected. Clients
+// must never be able to block the build.
+const eventSocketClientBuffer = 4096
+
+// StreamEvent is a single newline-delimited JSON record written to the clients
+// of the event socket.
+type StreamEvent struct {
+	// Type is one of "snapshot", "start", "finish", "message" or "output".
+	Type string `json:"type"`
+
+	// Time is the time the event was produced, in milliseconds since the
+	// Unix epoch.
+	Time int64 `json:"time"`
+
+	Counts *StreamCounts `json:"counts,omitempty"`
+
+	// Action is set for "start" and "finish" events.
+	Action *StreamAction `json:"action,omitempty"`
+
+	// Running is only set for "snapshot" events, which are sent once when a
+	// client connects, and lists the actions that are currently in flight.
+	Running []*StreamAction `json:"running,omitempty"`
+
+	// Level and Message are set for "message" events.
+	Level   string `json:"level,omitempty"`
+	Message string `json:"message,omitempty"`
+
+	// Output is set for "finish" events with output, and "output" events.
+	Output string `json:"output,omitempty"`
+
+	// Error is set for "finish" events of failed actions.
+	Error string `json:"error,omitempty"`
+}
+
+// StreamCounts is the JSON representation of Counts.
+type StreamCounts struct {
+	Total    int `json:"total"`
+	Running  int `json:"running"`
+	Started  int `json:"started"`
+	Finished int `json:"finished"`
+	Failed   int `json:"failed"`
+
+	// RemainingMs and CriticalPathMs are the estimated remaining time of
+	// the build and its critical path, when known.
+	RemainingMs    int64 `json:"remaining_ms,omitempty"`
+	CriticalPathMs int64 `json:"critical_path_ms,omitempty"`
+}
+
+// StreamAction is the JSON representation of an Action.
+type StreamAction struct {
+	Description string   `json:"description,omitempty"`
+	Command     string   `json:"command,omitempty"`
+	Outputs     []string `json:"outputs,omitempty"`
+
+	// StartTime is in milliseconds since the Unix epoch.
+	StartTime int64 `json:"start_time"`
+}
+
+type eventSocketClient struct {
+	conn   net.Conn
+	events chan []byte
+}
+
+type eventSocket struct {
+	log      logger.Logger
+	path     string
+	listener net.Listener
+
+	// Protects everything below, the accept loop adds clients concurrently
+	// with the calls from Status.
+	lock          sync.Mutex
+	clients       map[*eventSocketClient]bool
+	running       map[*Action]int64
+	counts        Counts
+	failedActions int
+	closed        bool
+}
+
+// NewEventSocket returns a StatusOutput that listens on a Unix domain socket
+// at path, and streams every status event to each connected client as
+// newline-delimited JSON. Clients may connect and disconnect at any point
+// during the build, and receive a snapshot of the in-flight actions on
+// connect.
+func NewEventSocket(log logger.Logger, path string) StatusOutput {
+	// A socket left over from a previous (crashed) build would make Listen
+	// fail.
+	os.Remove(path)
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		// This is expected when the path is longer than sun_path allows,
+		// which is not worth bothering the user about.
+		log.Verboseln("Failed to create build event socket:", err)
+		return nil
+	}
+
+	e := &eventSocket{
+		log:      log,
+		path:     path,
+		listener: listener,
+		clients:  make(map[*eventSocketClient]bool),
+		running:  make(map[*Action]int64),
+	}
+
+	go e.acceptLoop()
+
+	return e
+}
+
+func (e *eventSocket) acceptLoop() {
+	for {
+		conn, err := e.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		client := &eventSocketClient{
+			conn:   conn,
+			events: make(chan []byte, eventSocketClientBuffer),
+		}
+
+		e.lock.Lock()
+		if e.closed {
+			e.lock.Unlock()
+			conn.Close()
+			return
+		}
+		client.events <- e.marshal(e.snapshot())
+		e.clients[client] = true
+		e.lock.Unlock()
+
+		go e.writeLoop(client)
+	}
+}
+
+func (e *eventSocket) writeLoop(client *eventSocketClient) {
+	defer client.conn.Close()
+
+	for event := range client.events {
+		if _, err := client.conn.Write(event); err != nil {
+			e.lock.Lock()
+			e.removeClient(client)
+			e.lock.Unlock()
+
+			// Drain the channel so that it can be garbage collected.
+			for range client.events {
+			}
+			return
+		}
+	}
+}
+
+// removeClient must be called with the lock held.
+func (e *eventSocket) removeClient(client *eventSocketClient) {
+	if e.clients[client] {
+		delete(e.clients, client)
+		close(client.events)
+	}
+}
+
+// snapshot must be called with the lock held.
+func (e *eventSocket) snapshot() *StreamEvent {
+	event := e.newEvent("snapshot")
+	for action, startTime := range e.running {
+		event.Running = append(event.Running, streamAction(action, startTime))
+	}
+	return event
+}
+
+// newEvent must be called with the lock held.
+func (e *eventSocket) newEvent(typ string) *StreamEvent {
+	return &StreamEvent{
+		Type: typ,
+		Time: time.Now().UnixMilli(),
+		Counts: &StreamCounts{
+			Total:    e.counts.TotalActions,
+			Running:  e.counts.RunningActions,
+			Started:  e.counts.StartedActions,
+			Finished: e.counts.FinishedActions,
+			Failed:   e.failedActions,
+
+			RemainingMs:    e.counts.Estimate.Remaining.Milliseconds(),
+			CriticalPathMs: e.counts.Estimate.CriticalPath.Milliseconds(),
+		},
+	}
+}
+
+func streamAction(action *Action, startTime int64) *StreamAction {
+	return &StreamAction{
+		Description: action.Description,
+		Command:     action.Command,
+		Outputs:     action.Outputs,
+		StartTime:   startTime,
+	}
+}
+
+func (e *eventSocket) marshal(event *StreamEvent) []byte {
+	data, err := json.Marshal(event)
+	if err != nil {
+		// All of the fields are plain strings and numbers, so this should
+		// never happen.
+		e.log.Println("Failed to marshal build event:", err)
+		return nil
+	}
+	return append(data, '\n')
+}
+
+// send must be called with the lock held.
+func (e *eventSocket) send(event *StreamEvent) {
+	if len(e.clients) == 0 {
+		return
+	}
+
+	data := e.marshal(event)
+	for client := range e.clients {
+		select {
+		case client.events <- data:
+		default:
+			// The client isn't keeping up, drop it rather than slowing
+			// down the build.
+			e.removeClient(client)
+		}
+	}
+}
+
+func (e *eventSocket) StartAction(action *Action, counts Counts) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	startTime := time.Now().UnixMilli()
+	e.running[action] = startTime
+	e.counts = counts
+
+	event := e.newEvent("start")
+	event.Action = streamAction(action, startTime)
+	e.send(event)
+}
+
+func (e *eventSocket) FinishAction(result ActionResult, counts Counts) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	startTime := e.running[result.Action]
+	delete(e.running, result.Action)
+	e.counts = counts
+	if result.Error != nil {
+		e.failedActions++
+	}
+
+	event := e.newEvent("finish")
+	event.Action = streamAction(result.Action, startTime)
+	event.Output = result.Output
+	if result.Error != nil {
+		event.Error = result.Error.Error()
+	}
+	e.send(event)
+}
+
+func (e *eventSocket) Message(level MsgLevel, message string) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	event := e.newEvent("message")
+	event.Level = level.String()
+	event.Message = message
+	e.send(event)
+}
+
+func (e *eventSocket) Flush() {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	if e.closed {
+		return
+	}
+	e.closed = true
+
+	e.listener.Close()
+	for client := range e.clients {
+		e.removeClient(client)
+	}
+	os.Remove(e.path)
+}
+
+func (e *eventSocket) Write(p []byte) (int, error) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	event := e.newEvent("output")
+	event.Output = string(p)
+	e.send(event)
+	return len(p), nil
+}
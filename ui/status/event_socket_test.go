@@ -0,0 +1,126 @@
+// Copyright 2023 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package status
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"android/soong/ui/logger"
+)
+
+type eventSocketReader struct {
+	conn    net.Conn
+	scanner *bufio.Scanner
+}
+
+func dialEventSocket(t *testing.T, path string) *eventSocketReader {
+	t.Helper()
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatalf("failed to connect to event socket: %s", err)
+	}
+	return &eventSocketReader{conn: conn, scanner: bufio.NewScanner(conn)}
+}
+
+func (r *eventSocketReader) next(t *testing.T) *StreamEvent {
+	t.Helper()
+	if !r.scanner.Scan() {
+		t.Fatalf("unexpected end of event stream: %v", r.scanner.Err())
+	}
+	event := &StreamEvent{}
+	if err := json.Unmarshal(r.scanner.Bytes(), event); err != nil {
+		t.Fatalf("failed to parse event %q: %s", r.scanner.Text(), err)
+	}
+	return event
+}
+
+func TestEventSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.sock")
+
+	status := &Status{}
+	output := NewEventSocket(logger.New(ioutil.Discard), path)
+	if output == nil {
+		t.Fatal("failed to create event socket")
+	}
+	status.AddOutput(output)
+	tool := status.StartTool()
+	tool.SetTotalActions(3)
+
+	first := dialEventSocket(t, path)
+	if event := first.next(t); event.Type != "snapshot" || len(event.Running) != 0 {
+		t.Errorf("expected empty snapshot, got %+v", event)
+	}
+
+	a := &Action{Description: "a", Outputs: []string{"out/a"}}
+	b := &Action{Description: "b", Outputs: []string{"out/b"}}
+	tool.StartAction(a)
+	tool.StartAction(b)
+
+	if event := first.next(t); event.Type != "start" || event.Action.Description != "a" {
+		t.Errorf("expected start of a, got %+v", event)
+	}
+	if event := first.next(t); event.Type != "start" || event.Action.Description != "b" {
+		t.Errorf("expected start of b, got %+v", event)
+	}
+
+	tool.FinishAction(ActionResult{Action: a, Output: "warning", Error: fmt.Errorf("exit status 1")})
+	event := first.next(t)
+	if event.Type != "finish" || event.Action.Description != "a" {
+		t.Errorf("expected finish of a, got %+v", event)
+	}
+	if event.Output != "warning" || event.Error != "exit status 1" {
+		t.Errorf("expected output and error of a, got %+v", event)
+	}
+	want := StreamCounts{Total: 3, Running: 1, Started: 2, Finished: 1, Failed: 1}
+	if *event.Counts != want {
+		t.Errorf("expected counts %+v, got %+v", want, *event.Counts)
+	}
+
+	// A client attaching mid-build sees the action that is still running.
+	second := dialEventSocket(t, path)
+	event = second.next(t)
+	if event.Type != "snapshot" || len(event.Running) != 1 || event.Running[0].Description != "b" {
+		t.Errorf("expected snapshot with b running, got %+v", event)
+	}
+
+	// Detaching a client doesn't affect the others.
+	first.conn.Close()
+
+	tool.Print("hello")
+	if event := second.next(t); event.Type != "message" || event.Level != "print" || event.Message != "hello" {
+		t.Errorf("expected print message, got %+v", event)
+	}
+
+	tool.FinishAction(ActionResult{Action: b})
+	tool.Finish()
+	if event := second.next(t); event.Type != "finish" || event.Action.Description != "b" {
+		t.Errorf("expected finish of b, got %+v", event)
+	}
+
+	status.Finish()
+	if second.scanner.Scan() {
+		t.Errorf("expected event stream to be closed, got %q", second.scanner.Text())
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("expected event socket to be removed, got %v", err)
+	}
+}
@@ -62,6 +62,10 @@ func (v *verboseLog) FinishAction(result ActionResult, counts Counts) {
 
 	fmt.Fprintf(v.w, "[%d/%d] %s\n", counts.FinishedActions, counts.TotalActions, cmd)
 
+	if result.RebuildReason != nil {
+		fmt.Fprintf(v.w, "Rebuild reason: %s\n", result.RebuildReason)
+	}
+
 	if result.Error != nil {
 		fmt.Fprintf(v.w, "FAILED: %s\n", strings.Join(result.Outputs, " "))
 	}
@@ -124,6 +128,9 @@ func (e *errorLog) FinishAction(result ActionResult, counts Counts) {
 	if result.Command != "" {
 		fmt.Fprintf(e.w, "Command: %s\n", result.Command)
 	}
+	if result.RebuildReason != nil {
+		fmt.Fprintf(e.w, "Rebuild reason: %s\n", result.RebuildReason)
+	}
 	fmt.Fprintf(e.w, "Output:\n%s\n", result.Output)
 }
 
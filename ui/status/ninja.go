@@ -107,6 +107,7 @@ func (n *NinjaReader) run() {
 	r := bufio.NewReader(f)
 
 	running := map[uint32]*Action{}
+	explanations := newNinjaExplanations()
 
 	for {
 		size, err := readVarInt(r)
@@ -146,12 +147,14 @@ func (n *NinjaReader) run() {
 				Inputs:      msg.EdgeStarted.Inputs,
 				Command:     msg.EdgeStarted.GetCommand(),
 			}
+			action.RebuildReason = explanations.reason(action)
 			n.status.StartAction(action)
 			running[msg.EdgeStarted.GetId()] = action
 		}
 		if msg.EdgeFinished != nil {
 			if started, ok := running[msg.EdgeFinished.GetId()]; ok {
 				delete(running, msg.EdgeFinished.GetId())
+				explanations.finish(started)
 
 				var err error
 				exitCode := int(msg.EdgeFinished.GetStatus())
@@ -188,7 +191,11 @@ func (n *NinjaReader) run() {
 			case ninja_frontend.Status_Message_ERROR:
 				n.status.Error(message)
 			case ninja_frontend.Status_Message_DEBUG:
-				n.status.Verbose(message)
+				// The explanations from ninja -d explain are
+				// attached to the actions they explain instead.
+				if !explanations.parse(msg.Message.GetMessage()) {
+					n.status.Verbose(message)
+				}
 			default:
 				n.status.Print(message)
 			}
@@ -0,0 +1,283 @@
+// Copyright 2023 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package status
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+
+	"android/soong/ui/logger"
+	soong_rebuild_reasons_proto "android/soong/ui/status/rebuild_reasons_proto"
+)
+
+type RebuildReasonKind int
+
+const (
+	// RebuildReasonUnknown is used when ninja didn't explain why an
+	// action ran.
+	RebuildReasonUnknown RebuildReasonKind = iota
+	// RebuildReasonMissingOutput is used when an output of the action
+	// doesn't exist.
+	RebuildReasonMissingOutput
+	// RebuildReasonNewerInput is used when an input of the action is
+	// newer than its outputs.
+	RebuildReasonNewerInput
+	// RebuildReasonCommandLineChanged is used when the command line of the
+	// action changed, or wasn't found in the ninja log.
+	RebuildReasonCommandLineChanged
+	// RebuildReasonDirtyDependency is used when an input of the action is
+	// produced by another action that runs in this build.
+	RebuildReasonDirtyDependency
+	// RebuildReasonRestat is used when an input is newer than the mtime
+	// that ninja recorded for a restat output of the action.
+	RebuildReasonRestat
+	// RebuildReasonMissingDeps is used when the dependencies recorded from
+	// the depfile of the action are missing.
+	RebuildReasonMissingDeps
+)
+
+func (k RebuildReasonKind) String() string {
+	switch k {
+	case RebuildReasonMissingOutput:
+		return "missing output"
+	case RebuildReasonNewerInput:
+		return "newer input"
+	case RebuildReasonCommandLineChanged:
+		return "command line changed"
+	case RebuildReasonDirtyDependency:
+		return "dirty dependency"
+	case RebuildReasonRestat:
+		return "restat"
+	case RebuildReasonMissingDeps:
+		return "missing deps"
+	default:
+		return "unknown"
+	}
+}
+
+// RebuildReason is why ninja considered an action dirty, as parsed from the
+// output of ninja -d explain.
+type RebuildReason struct {
+	Kind RebuildReasonKind
+
+	// Output is the output that ninja considered dirty, if any.
+	Output string
+
+	// Input is the input that caused the action to run, if any.
+	Input string
+
+	// Explanation is the message printed by ninja.
+	Explanation string
+}
+
+func (r *RebuildReason) String() string {
+	if r.Explanation != "" {
+		return fmt.Sprintf("%s: %s", r.Kind, r.Explanation)
+	}
+	return r.Kind.String()
+}
+
+// ninjaExplanationPatterns match the messages printed by ninja -d explain that
+// explain why an output is dirty. The first submatch is the output, the second
+// one the input.
+var ninjaExplanationPatterns = []struct {
+	kind    RebuildReasonKind
+	pattern *regexp.Regexp
+}{
+	{RebuildReasonMissingOutput, regexp.MustCompile(`^output (.+?)(?: of phony edge with no inputs)? doesn't exist$`)},
+	{RebuildReasonRestat, regexp.MustCompile(`^restat of output (.+) older than most recent input (.+) \(-?\d+ vs -?\d+\)$`)},
+	{RebuildReasonRestat, regexp.MustCompile(`^recorded mtime of (.+) older than most recent input (.+) \(-?\d+ vs -?\d+\)$`)},
+	{RebuildReasonNewerInput, regexp.MustCompile(`^output (.+) older than most recent input (.+) \(-?\d+ vs -?\d+\)$`)},
+	{RebuildReasonCommandLineChanged, regexp.MustCompile(`^command line changed for (.+)$`)},
+	{RebuildReasonCommandLineChanged, regexp.MustCompile(`^command line not found in log for (.+)$`)},
+	{RebuildReasonMissingDeps, regexp.MustCompile(`^deps for '(.+)' are missing$`)},
+}
+
+// ninjaDirtyInputPatterns match the messages printed by ninja -d explain about
+// inputs that make the actions that use them dirty.
+var ninjaDirtyInputPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`^(.+) is dirty$`),
+	regexp.MustCompile(`^(.+) has no in-edge and is missing$`),
+}
+
+// ninjaExplanations collects the explanations printed by ninja -d explain,
+// which are printed while ninja scans the graph, before the actions they
+// explain start.
+type ninjaExplanations struct {
+	// outputs are the explanations of the outputs of actions that haven't
+	// finished yet.
+	outputs map[string]*RebuildReason
+
+	// dirtyInputs are the explanations of inputs that make the actions that
+	// use them dirty. They are kept until ninja exits, since any number of
+	// actions may use the same input.
+	dirtyInputs map[string]string
+
+	// explaining is set once any explanation has been parsed, so that
+	// actions of ninja invocations without -d explain don't get unknown
+	// reasons.
+	explaining bool
+}
+
+func newNinjaExplanations() *ninjaExplanations {
+	return &ninjaExplanations{
+		outputs:     make(map[string]*RebuildReason),
+		dirtyInputs: make(map[string]string),
+	}
+}
+
+// ParseNinjaExplanation parses a message printed by ninja -d explain, and
+// returns nil if the message isn't an explanation. Messages about inputs that
+// make the actions that use them dirty are returned as a dirty dependency with
+// only the input set.
+func ParseNinjaExplanation(message string) *RebuildReason {
+	explanation := strings.TrimPrefix(message, "ninja explain: ")
+	explanation = strings.TrimPrefix(explanation, "explain: ")
+
+	for _, p := range ninjaExplanationPatterns {
+		if m := p.pattern.FindStringSubmatch(explanation); m != nil {
+			reason := &RebuildReason{
+				Kind:        p.kind,
+				Output:      m[1],
+				Explanation: explanation,
+			}
+			if len(m) > 2 {
+				reason.Input = m[2]
+			}
+			return reason
+		}
+	}
+
+	for _, p := range ninjaDirtyInputPatterns {
+		if m := p.FindStringSubmatch(explanation); m != nil {
+			return &RebuildReason{
+				Kind:        RebuildReasonDirtyDependency,
+				Input:       m[1],
+				Explanation: explanation,
+			}
+		}
+	}
+
+	return nil
+}
+
+// parse records the explanation in a message from ninja, and returns false if
+// the message isn't an explanation.
+func (n *ninjaExplanations) parse(message string) bool {
+	reason := ParseNinjaExplanation(message)
+	if reason == nil {
+		return false
+	}
+	n.explaining = true
+
+	if reason.Output == "" {
+		if _, ok := n.dirtyInputs[reason.Input]; !ok {
+			n.dirtyInputs[reason.Input] = reason.Explanation
+		}
+	} else if _, ok := n.outputs[reason.Output]; !ok {
+		// Keep the first explanation, later ones are usually
+		// consequences of it.
+		n.outputs[reason.Output] = reason
+	}
+	return true
+}
+
+// reason returns why an action that is starting is dirty, or nil if ninja
+// isn't explaining.
+func (n *ninjaExplanations) reason(action *Action) *RebuildReason {
+	if !n.explaining {
+		return nil
+	}
+	for _, output := range action.Outputs {
+		if reason, ok := n.outputs[output]; ok {
+			delete(n.outputs, output)
+			return reason
+		}
+	}
+	for _, input := range action.Inputs {
+		if explanation, ok := n.dirtyInputs[input]; ok {
+			return &RebuildReason{
+				Kind:        RebuildReasonDirtyDependency,
+				Input:       input,
+				Explanation: explanation,
+			}
+		}
+	}
+	return &RebuildReason{Kind: RebuildReasonUnknown}
+}
+
+// finish forgets the explanations of the outputs of an action that finished.
+// ninja may explain more than one output of an action, only the first one is
+// used by reason.
+func (n *ninjaExplanations) finish(action *Action) {
+	for _, output := range action.Outputs {
+		delete(n.outputs, output)
+	}
+}
+
+type rebuildReasonsLog struct {
+	reasons  soong_rebuild_reasons_proto.RebuildReasons
+	filename string
+	log      logger.Logger
+}
+
+// NewRebuildReasonsLog returns a StatusOutput that writes the rebuild reasons
+// of all actions that ran to a RebuildReasons proto when the build finishes.
+func NewRebuildReasonsLog(log logger.Logger, filename string) StatusOutput {
+	os.Remove(filename)
+	return &rebuildReasonsLog{
+		filename: filename,
+		log:      log,
+	}
+}
+
+func (r *rebuildReasonsLog) StartAction(action *Action, counts Counts) {}
+
+func (r *rebuildReasonsLog) FinishAction(result ActionResult, counts Counts) {
+	reason := result.RebuildReason
+	if reason == nil {
+		return
+	}
+
+	// The values of RebuildReasonKind match ActionRebuildReason_Kind.
+	r.reasons.Actions = append(r.reasons.Actions, &soong_rebuild_reasons_proto.ActionRebuildReason{
+		Description: proto.String(result.Description),
+		Outputs:     result.Outputs,
+		Kind:        soong_rebuild_reasons_proto.ActionRebuildReason_Kind(reason.Kind).Enum(),
+		Output:      proto.String(reason.Output),
+		Input:       proto.String(reason.Input),
+		Explanation: proto.String(reason.Explanation),
+	})
+}
+
+func (r *rebuildReasonsLog) Flush() {
+	if len(r.reasons.Actions) == 0 {
+		return
+	}
+	if err := writeToFile(&r.reasons, r.filename); err != nil {
+		r.log.Printf("Failed to write file %s: %v\n", r.filename, err)
+	}
+}
+
+func (r *rebuildReasonsLog) Message(level MsgLevel, message string) {}
+
+func (r *rebuildReasonsLog) Write(p []byte) (int, error) {
+	return 0, errors.New("not supported")
+}
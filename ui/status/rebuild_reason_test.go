@@ -0,0 +1,177 @@
+// Copyright 2023 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package status
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+
+	"android/soong/ui/logger"
+	soong_rebuild_reasons_proto "android/soong/ui/status/rebuild_reasons_proto"
+)
+
+func TestNinjaExplanations(t *testing.T) {
+	explanations := newNinjaExplanations()
+	if reason := explanations.reason(&Action{Outputs: []string{"out/missing"}}); reason != nil {
+		t.Errorf("expected no reason before ninja explains anything, got %v", reason)
+	}
+
+	for _, message := range []string{
+		"output out/missing doesn't exist",
+		"output out/newer older than most recent input src/a.c (1000 vs 2000)",
+		"restat of output out/restat older than most recent input out/gen.h (1000 vs 2000)",
+		"command line changed for out/cmd",
+		"out/missing is dirty",
+		"ninja explain: deps for 'out/deps.o' are missing",
+	} {
+		if !explanations.parse(message) {
+			t.Errorf("expected %q to be parsed as an explanation", message)
+		}
+	}
+	if explanations.parse("ninja stats:") {
+		t.Errorf("expected other messages not to be parsed as explanations")
+	}
+
+	testCases := []struct {
+		name   string
+		action *Action
+		want   RebuildReason
+	}{
+		{
+			name:   "missing output",
+			action: &Action{Outputs: []string{"out/missing"}},
+			want: RebuildReason{
+				Kind:        RebuildReasonMissingOutput,
+				Output:      "out/missing",
+				Explanation: "output out/missing doesn't exist",
+			},
+		},
+		{
+			name:   "newer input",
+			action: &Action{Outputs: []string{"out/other", "out/newer"}},
+			want: RebuildReason{
+				Kind:        RebuildReasonNewerInput,
+				Output:      "out/newer",
+				Input:       "src/a.c",
+				Explanation: "output out/newer older than most recent input src/a.c (1000 vs 2000)",
+			},
+		},
+		{
+			name:   "restat",
+			action: &Action{Outputs: []string{"out/restat"}},
+			want: RebuildReason{
+				Kind:        RebuildReasonRestat,
+				Output:      "out/restat",
+				Input:       "out/gen.h",
+				Explanation: "restat of output out/restat older than most recent input out/gen.h (1000 vs 2000)",
+			},
+		},
+		{
+			name:   "command line",
+			action: &Action{Outputs: []string{"out/cmd"}},
+			want: RebuildReason{
+				Kind:        RebuildReasonCommandLineChanged,
+				Output:      "out/cmd",
+				Explanation: "command line changed for out/cmd",
+			},
+		},
+		{
+			name:   "dirty dependency",
+			action: &Action{Outputs: []string{"out/user"}, Inputs: []string{"src/b.c", "out/missing"}},
+			want: RebuildReason{
+				Kind:        RebuildReasonDirtyDependency,
+				Input:       "out/missing",
+				Explanation: "out/missing is dirty",
+			},
+		},
+		{
+			name:   "missing deps",
+			action: &Action{Outputs: []string{"out/deps.o"}},
+			want: RebuildReason{
+				Kind:        RebuildReasonMissingDeps,
+				Output:      "out/deps.o",
+				Explanation: "deps for 'out/deps.o' are missing",
+			},
+		},
+		{
+			name:   "unknown",
+			action: &Action{Outputs: []string{"out/unexplained"}},
+			want:   RebuildReason{Kind: RebuildReasonUnknown},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := explanations.reason(tc.action); *got != tc.want {
+				t.Errorf("expected %+v, got %+v", tc.want, *got)
+			}
+		})
+	}
+
+	// The explanations of the other outputs of an action are forgotten
+	// when it finishes.
+	explanations.parse("output out/multi1 doesn't exist")
+	explanations.parse("output out/multi2 doesn't exist")
+	multi := &Action{Outputs: []string{"out/multi1", "out/multi2"}}
+	explanations.reason(multi)
+	explanations.finish(multi)
+	if _, ok := explanations.outputs["out/multi2"]; ok {
+		t.Errorf("expected the explanations of a finished action to be removed")
+	}
+}
+
+func TestRebuildReasonsLog(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "rebuild_reasons.pb")
+	log := NewRebuildReasonsLog(logger.New(ioutil.Discard), filename)
+
+	log.FinishAction(ActionResult{Action: &Action{Description: "no reason"}}, Counts{})
+	log.FinishAction(ActionResult{Action: &Action{
+		Description: "compile a.c",
+		Outputs:     []string{"out/a.o"},
+		RebuildReason: &RebuildReason{
+			Kind:        RebuildReasonNewerInput,
+			Output:      "out/a.o",
+			Input:       "a.c",
+			Explanation: "output out/a.o older than most recent input a.c (1 vs 2)",
+		},
+	}}, Counts{})
+	log.Flush()
+
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reasons := &soong_rebuild_reasons_proto.RebuildReasons{}
+	if err := proto.Unmarshal(data, reasons); err != nil {
+		t.Fatal(err)
+	}
+
+	want := &soong_rebuild_reasons_proto.RebuildReasons{
+		Actions: []*soong_rebuild_reasons_proto.ActionRebuildReason{{
+			Description: proto.String("compile a.c"),
+			Outputs:     []string{"out/a.o"},
+			Kind:        soong_rebuild_reasons_proto.ActionRebuildReason_NEWER_INPUT.Enum(),
+			Output:      proto.String("out/a.o"),
+			Input:       proto.String("a.c"),
+			Explanation: proto.String("output out/a.o older than most recent input a.c (1 vs 2)"),
+		}},
+	}
+	if !proto.Equal(reasons, want) {
+		t.Errorf("expected %v, got %v", want, reasons)
+	}
+}
@@ -0,0 +1,378 @@
+// Copyright 2023 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.28.0
+// 	protoc        v3.21.7
+// source: rebuild_reasons.proto
+
+package rebuild_reasons_proto
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type ActionRebuildReason_Kind int32
+
+const (
+	// Ninja didn't explain why the action ran.
+	ActionRebuildReason_UNKNOWN ActionRebuildReason_Kind = 0
+	// An output of the action doesn't exist.
+	ActionRebuildReason_MISSING_OUTPUT ActionRebuildReason_Kind = 1
+	// An input of the action is newer than its outputs.
+	ActionRebuildReason_NEWER_INPUT ActionRebuildReason_Kind = 2
+	// The command line of the action changed since it last ran.
+	ActionRebuildReason_COMMAND_LINE_CHANGED ActionRebuildReason_Kind = 3
+	// An input of the action is the output of another action that runs
+	// in this build.
+	ActionRebuildReason_DIRTY_DEPENDENCY ActionRebuildReason_Kind = 4
+	// An input is newer than the mtime that ninja recorded for a restat
+	// output of the action.
+	ActionRebuildReason_RESTAT ActionRebuildReason_Kind = 5
+	// The dependencies recorded from the depfile of the action are missing.
+	ActionRebuildReason_MISSING_DEPS ActionRebuildReason_Kind = 6
+)
+
+// Enum value maps for ActionRebuildReason_Kind.
+var (
+	ActionRebuildReason_Kind_name = map[int32]string{
+		0: "UNKNOWN",
+		1: "MISSING_OUTPUT",
+		2: "NEWER_INPUT",
+		3: "COMMAND_LINE_CHANGED",
+		4: "DIRTY_DEPENDENCY",
+		5: "RESTAT",
+		6: "MISSING_DEPS",
+	}
+	ActionRebuildReason_Kind_value = map[string]int32{
+		"UNKNOWN":              0,
+		"MISSING_OUTPUT":       1,
+		"NEWER_INPUT":          2,
+		"COMMAND_LINE_CHANGED": 3,
+		"DIRTY_DEPENDENCY":     4,
+		"RESTAT":               5,
+		"MISSING_DEPS":         6,
+	}
+)
+
+func (x ActionRebuildReason_Kind) Enum() *ActionRebuildReason_Kind {
+	p := new(ActionRebuildReason_Kind)
+	*p = x
+	return p
+}
+
+func (x ActionRebuildReason_Kind) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (ActionRebuildReason_Kind) Descriptor() protoreflect.EnumDescriptor {
+	return file_rebuild_reasons_proto_enumTypes[0].Descriptor()
+}
+
+func (ActionRebuildReason_Kind) Type() protoreflect.EnumType {
+	return &file_rebuild_reasons_proto_enumTypes[0]
+}
+
+func (x ActionRebuildReason_Kind) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Do not use.
+func (x *ActionRebuildReason_Kind) UnmarshalJSON(b []byte) error {
+	num, err := protoimpl.X.UnmarshalJSONEnum(x.Descriptor(), b)
+	if err != nil {
+		return err
+	}
+	*x = ActionRebuildReason_Kind(num)
+	return nil
+}
+
+// Deprecated: Use ActionRebuildReason_Kind.Descriptor instead.
+func (ActionRebuildReason_Kind) EnumDescriptor() ([]byte, []int) {
+	return file_rebuild_reasons_proto_rawDescGZIP(), []int{1, 0}
+}
+
+type RebuildReasons struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// List of the actions that ran during the build, and why ninja
+	// considered them dirty.
+	Actions []*ActionRebuildReason `protobuf:"bytes,1,rep,name=actions" json:"actions,omitempty"`
+}
+
+func (x *RebuildReasons) Reset() {
+	*x = RebuildReasons{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_rebuild_reasons_proto_msgTypes[0]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *RebuildReasons) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RebuildReasons) ProtoMessage() {}
+
+func (x *RebuildReasons) ProtoReflect() protoreflect.Message {
+	mi := &file_rebuild_reasons_proto_msgTypes[0]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RebuildReasons.ProtoReflect.Descriptor instead.
+func (*RebuildReasons) Descriptor() ([]byte, []int) {
+	return file_rebuild_reasons_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *RebuildReasons) GetActions() []*ActionRebuildReason {
+	if x != nil {
+		return x.Actions
+	}
+	return nil
+}
+
+type ActionRebuildReason struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// Description of the action.
+	Description *string `protobuf:"bytes,1,opt,name=description" json:"description,omitempty"`
+	// List of outputs of the action.
+	Outputs []string                  `protobuf:"bytes,2,rep,name=outputs" json:"outputs,omitempty"`
+	Kind    *ActionRebuildReason_Kind `protobuf:"varint,3,opt,name=kind,enum=soong_build_rebuild_reasons.ActionRebuildReason_Kind" json:"kind,omitempty"`
+	// The output that ninja considered dirty, if any.
+	Output *string `protobuf:"bytes,4,opt,name=output" json:"output,omitempty"`
+	// The input that caused the action to run, if any.
+	Input *string `protobuf:"bytes,5,opt,name=input" json:"input,omitempty"`
+	// The explanation printed by ninja -d explain.
+	Explanation *string `protobuf:"bytes,6,opt,name=explanation" json:"explanation,omitempty"`
+}
+
+func (x *ActionRebuildReason) Reset() {
+	*x = ActionRebuildReason{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_rebuild_reasons_proto_msgTypes[1]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *ActionRebuildReason) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ActionRebuildReason) ProtoMessage() {}
+
+func (x *ActionRebuildReason) ProtoReflect() protoreflect.Message {
+	mi := &file_rebuild_reasons_proto_msgTypes[1]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ActionRebuildReason.ProtoReflect.Descriptor instead.
+func (*ActionRebuildReason) Descriptor() ([]byte, []int) {
+	return file_rebuild_reasons_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *ActionRebuildReason) GetDescription() string {
+	if x != nil && x.Description != nil {
+		return *x.Description
+	}
+	return ""
+}
+
+func (x *ActionRebuildReason) GetOutputs() []string {
+	if x != nil {
+		return x.Outputs
+	}
+	return nil
+}
+
+func (x *ActionRebuildReason) GetKind() ActionRebuildReason_Kind {
+	if x != nil && x.Kind != nil {
+		return *x.Kind
+	}
+	return ActionRebuildReason_UNKNOWN
+}
+
+func (x *ActionRebuildReason) GetOutput() string {
+	if x != nil && x.Output != nil {
+		return *x.Output
+	}
+	return ""
+}
+
+func (x *ActionRebuildReason) GetInput() string {
+	if x != nil && x.Input != nil {
+		return *x.Input
+	}
+	return ""
+}
+
+func (x *ActionRebuildReason) GetExplanation() string {
+	if x != nil && x.Explanation != nil {
+		return *x.Explanation
+	}
+	return ""
+}
+
+var File_rebuild_reasons_proto protoreflect.FileDescriptor
+
+var file_rebuild_reasons_proto_rawDesc = []byte{
+	0x0a, 0x15, 0x72, 0x65, 0x62, 0x75, 0x69, 0x6c, 0x64, 0x5f, 0x72, 0x65, 0x61, 0x73, 0x6f, 0x6e,
+	0x73, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x12, 0x1b, 0x73, 0x6f, 0x6f, 0x6e, 0x67, 0x5f, 0x62,
+	0x75, 0x69, 0x6c, 0x64, 0x5f, 0x72, 0x65, 0x62, 0x75, 0x69, 0x6c, 0x64, 0x5f, 0x72, 0x65, 0x61,
+	0x73, 0x6f, 0x6e, 0x73, 0x22, 0x5c, 0x0a, 0x0e, 0x52, 0x65, 0x62, 0x75, 0x69, 0x6c, 0x64, 0x52,
+	0x65, 0x61, 0x73, 0x6f, 0x6e, 0x73, 0x12, 0x4a, 0x0a, 0x07, 0x61, 0x63, 0x74, 0x69, 0x6f, 0x6e,
+	0x73, 0x18, 0x01, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x30, 0x2e, 0x73, 0x6f, 0x6f, 0x6e, 0x67, 0x5f,
+	0x62, 0x75, 0x69, 0x6c, 0x64, 0x5f, 0x72, 0x65, 0x62, 0x75, 0x69, 0x6c, 0x64, 0x5f, 0x72, 0x65,
+	0x61, 0x73, 0x6f, 0x6e, 0x73, 0x2e, 0x41, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x52, 0x65, 0x62, 0x75,
+	0x69, 0x6c, 0x64, 0x52, 0x65, 0x61, 0x73, 0x6f, 0x6e, 0x52, 0x07, 0x61, 0x63, 0x74, 0x69, 0x6f,
+	0x6e, 0x73, 0x22, 0xf5, 0x02, 0x0a, 0x13, 0x41, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x52, 0x65, 0x62,
+	0x75, 0x69, 0x6c, 0x64, 0x52, 0x65, 0x61, 0x73, 0x6f, 0x6e, 0x12, 0x20, 0x0a, 0x0b, 0x64, 0x65,
+	0x73, 0x63, 0x72, 0x69, 0x70, 0x74, 0x69, 0x6f, 0x6e, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52,
+	0x0b, 0x64, 0x65, 0x73, 0x63, 0x72, 0x69, 0x70, 0x74, 0x69, 0x6f, 0x6e, 0x12, 0x18, 0x0a, 0x07,
+	0x6f, 0x75, 0x74, 0x70, 0x75, 0x74, 0x73, 0x18, 0x02, 0x20, 0x03, 0x28, 0x09, 0x52, 0x07, 0x6f,
+	0x75, 0x74, 0x70, 0x75, 0x74, 0x73, 0x12, 0x49, 0x0a, 0x04, 0x6b, 0x69, 0x6e, 0x64, 0x18, 0x03,
+	0x20, 0x01, 0x28, 0x0e, 0x32, 0x35, 0x2e, 0x73, 0x6f, 0x6f, 0x6e, 0x67, 0x5f, 0x62, 0x75, 0x69,
+	0x6c, 0x64, 0x5f, 0x72, 0x65, 0x62, 0x75, 0x69, 0x6c, 0x64, 0x5f, 0x72, 0x65, 0x61, 0x73, 0x6f,
+	0x6e, 0x73, 0x2e, 0x41, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x52, 0x65, 0x62, 0x75, 0x69, 0x6c, 0x64,
+	0x52, 0x65, 0x61, 0x73, 0x6f, 0x6e, 0x2e, 0x4b, 0x69, 0x6e, 0x64, 0x52, 0x04, 0x6b, 0x69, 0x6e,
+	0x64, 0x12, 0x16, 0x0a, 0x06, 0x6f, 0x75, 0x74, 0x70, 0x75, 0x74, 0x18, 0x04, 0x20, 0x01, 0x28,
+	0x09, 0x52, 0x06, 0x6f, 0x75, 0x74, 0x70, 0x75, 0x74, 0x12, 0x14, 0x0a, 0x05, 0x69, 0x6e, 0x70,
+	0x75, 0x74, 0x18, 0x05, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x69, 0x6e, 0x70, 0x75, 0x74, 0x12,
+	0x20, 0x0a, 0x0b, 0x65, 0x78, 0x70, 0x6c, 0x61, 0x6e, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x18, 0x06,
+	0x20, 0x01, 0x28, 0x09, 0x52, 0x0b, 0x65, 0x78, 0x70, 0x6c, 0x61, 0x6e, 0x61, 0x74, 0x69, 0x6f,
+	0x6e, 0x22, 0x86, 0x01, 0x0a, 0x04, 0x4b, 0x69, 0x6e, 0x64, 0x12, 0x0b, 0x0a, 0x07, 0x55, 0x4e,
+	0x4b, 0x4e, 0x4f, 0x57, 0x4e, 0x10, 0x00, 0x12, 0x12, 0x0a, 0x0e, 0x4d, 0x49, 0x53, 0x53, 0x49,
+	0x4e, 0x47, 0x5f, 0x4f, 0x55, 0x54, 0x50, 0x55, 0x54, 0x10, 0x01, 0x12, 0x0f, 0x0a, 0x0b, 0x4e,
+	0x45, 0x57, 0x45, 0x52, 0x5f, 0x49, 0x4e, 0x50, 0x55, 0x54, 0x10, 0x02, 0x12, 0x18, 0x0a, 0x14,
+	0x43, 0x4f, 0x4d, 0x4d, 0x41, 0x4e, 0x44, 0x5f, 0x4c, 0x49, 0x4e, 0x45, 0x5f, 0x43, 0x48, 0x41,
+	0x4e, 0x47, 0x45, 0x44, 0x10, 0x03, 0x12, 0x14, 0x0a, 0x10, 0x44, 0x49, 0x52, 0x54, 0x59, 0x5f,
+	0x44, 0x45, 0x50, 0x45, 0x4e, 0x44, 0x45, 0x4e, 0x43, 0x59, 0x10, 0x04, 0x12, 0x0a, 0x0a, 0x06,
+	0x52, 0x45, 0x53, 0x54, 0x41, 0x54, 0x10, 0x05, 0x12, 0x10, 0x0a, 0x0c, 0x4d, 0x49, 0x53, 0x53,
+	0x49, 0x4e, 0x47, 0x5f, 0x44, 0x45, 0x50, 0x53, 0x10, 0x06, 0x42, 0x2f, 0x5a, 0x2d, 0x61, 0x6e,
+	0x64, 0x72, 0x6f, 0x69, 0x64, 0x2f, 0x73, 0x6f, 0x6f, 0x6e, 0x67, 0x2f, 0x75, 0x69, 0x2f, 0x73,
+	0x74, 0x61, 0x74, 0x75, 0x73, 0x2f, 0x72, 0x65, 0x62, 0x75, 0x69, 0x6c, 0x64, 0x5f, 0x72, 0x65,
+	0x61, 0x73, 0x6f, 0x6e, 0x73, 0x5f, 0x70, 0x72, 0x6f, 0x74, 0x6f,
+}
+
+var (
+	file_rebuild_reasons_proto_rawDescOnce sync.Once
+	file_rebuild_reasons_proto_rawDescData = file_rebuild_reasons_proto_rawDesc
+)
+
+func file_rebuild_reasons_proto_rawDescGZIP() []byte {
+	file_rebuild_reasons_proto_rawDescOnce.Do(func() {
+		file_rebuild_reasons_proto_rawDescData = protoimpl.X.CompressGZIP(file_rebuild_reasons_proto_rawDescData)
+	})
+	return file_rebuild_reasons_proto_rawDescData
+}
+
+var file_rebuild_reasons_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
+var file_rebuild_reasons_proto_msgTypes = make([]protoimpl.MessageInfo, 2)
+var file_rebuild_reasons_proto_goTypes = []interface{}{
+	(ActionRebuildReason_Kind)(0), // 0: soong_build_rebuild_reasons.ActionRebuildReason.Kind
+	(*RebuildReasons)(nil),        // 1: soong_build_rebuild_reasons.RebuildReasons
+	(*ActionRebuildReason)(nil),   // 2: soong_build_rebuild_reasons.ActionRebuildReason
+}
+var file_rebuild_reasons_proto_depIdxs = []int32{
+	2, // 0: soong_build_rebuild_reasons.RebuildReasons.actions:type_name -> soong_build_rebuild_reasons.ActionRebuildReason
+	0, // 1: soong_build_rebuild_reasons.ActionRebuildReason.kind:type_name -> soong_build_rebuild_reasons.ActionRebuildReason.Kind
+	2, // [2:2] is the sub-list for method output_type
+	2, // [2:2] is the sub-list for method input_type
+	2, // [2:2] is the sub-list for extension type_name
+	2, // [2:2] is the sub-list for extension extendee
+	0, // [0:2] is the sub-list for field type_name
+}
+
+func init() { file_rebuild_reasons_proto_init() }
+func file_rebuild_reasons_proto_init() {
+	if File_rebuild_reasons_proto != nil {
+		return
+	}
+	if !protoimpl.UnsafeEnabled {
+		file_rebuild_reasons_proto_msgTypes[0].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*RebuildReasons); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_rebuild_reasons_proto_msgTypes[1].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*ActionRebuildReason); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: file_rebuild_reasons_proto_rawDesc,
+			NumEnums:      1,
+			NumMessages:   2,
+			NumExtensions: 0,
+			NumServices:   0,
+		},
+		GoTypes:           file_rebuild_reasons_proto_goTypes,
+		DependencyIndexes: file_rebuild_reasons_proto_depIdxs,
+		EnumInfos:         file_rebuild_reasons_proto_enumTypes,
+		MessageInfos:      file_rebuild_reasons_proto_msgTypes,
+	}.Build()
+	File_rebuild_reasons_proto = out.File
+	file_rebuild_reasons_proto_rawDesc = nil
+	file_rebuild_reasons_proto_goTypes = nil
+	file_rebuild_reasons_proto_depIdxs = nil
+}
@@ -18,7 +18,9 @@
 package status
 
 import (
+	"fmt"
 	"sync"
+	"time"
 )
 
 // Action describes an action taken (or as Ninja calls them, Edges).
@@ -40,6 +42,10 @@ type Action struct {
 	// It's optional, but one of either Description or Command should be
 	// set.
 	Command string
+
+	// RebuildReason is the (optional) reason why the action ran in an
+	// incremental build.
+	RebuildReason *RebuildReason
 }
 
 // ActionResult describes the result of running an Action.
@@ -105,6 +111,37 @@ type Counts struct {
 	// FinishedActions are the number of actions that have been finished
 	// with FinishAction.
 	FinishedActions int
+
+	// Estimate is how much longer the build is expected to take. It is
+	// only set on the counts passed to StatusOutputs, and only when
+	// durations from previous builds are available.
+	Estimate Estimate
+}
+
+// Estimate describes how much longer the build is expected to take, based on
+// how long the same actions took in previous builds. The zero value means that
+// no estimate is available.
+type Estimate struct {
+	// Remaining is the expected wall time until all actions have
+	// finished.
+	Remaining time.Duration
+
+	// CriticalPath is the expected wall time of the longest chain of
+	// dependent actions that is still running or waiting to run.
+	CriticalPath time.Duration
+}
+
+// MinutesSeconds formats a duration as minutes and seconds, like 3:07.
+func MinutesSeconds(d time.Duration) string {
+	seconds := int(d.Round(time.Second).Seconds())
+	return fmt.Sprintf("%d:%02d", seconds/60, seconds%60)
+}
+
+// estimator is implemented by StatusOutputs that can estimate the remaining
+// time of the build. Status passes the estimate of the first such output to
+// all of the outputs as part of Counts.
+type estimator interface {
+	estimate(counts Counts) Estimate
 }
 
 // ToolStatus is the interface used by tools to report on their Actions, and to
@@ -183,6 +220,21 @@ func (l MsgLevel) Prefix() string {
 	}
 }
 
+func (l MsgLevel) String() string {
+	switch l {
+	case VerboseLvl:
+		return "verbose"
+	case StatusLvl:
+		return "status"
+	case PrintLvl:
+		return "print"
+	case ErrorLvl:
+		return "error"
+	default:
+		panic("Unknown message level")
+	}
+}
+
 // StatusOutput is the interface used to get status information as a Status
 // output.
 //
@@ -271,6 +323,7 @@ func (s *Status) startAction(action *Action) {
 
 	s.counts.RunningActions += 1
 	s.counts.StartedActions += 1
+	s.counts.Estimate = s.estimate()
 
 	for _, o := range s.outputs {
 		o.StartAction(action, s.counts)
@@ -283,12 +336,23 @@ func (s *Status) finishAction(result ActionResult) {
 
 	s.counts.RunningActions -= 1
 	s.counts.FinishedActions += 1
+	s.counts.Estimate = s.estimate()
 
 	for _, o := range s.outputs {
 		o.FinishAction(result, s.counts)
 	}
 }
 
+// estimate must be called with the lock held.
+func (s *Status) estimate() Estimate {
+	for _, o := range s.outputs {
+		if e, ok := o.(estimator); ok {
+			return e.estimate(s.counts)
+		}
+	}
+	return Estimate{}
+}
+
 func (s *Status) message(level MsgLevel, msg string) {
 	s.lock.Lock()
 	defer s.lock.Unlock()
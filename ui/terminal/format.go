@@ -93,6 +93,12 @@ func (s formatter) progress(counts status.Counts) string {
 			fmt.Fprintf(buf, "%3d%%", 100*counts.FinishedActions/counts.TotalActions)
 		case 'e':
 			fmt.Fprintf(buf, "%.3f", time.Since(s.start).Seconds())
+		case 'E':
+			if counts.Estimate.Remaining > 0 {
+				fmt.Fprintf(buf, "%.3f", counts.Estimate.Remaining.Seconds())
+			} else {
+				buf.WriteRune('?')
+			}
 		default:
 			buf.WriteString("unknown placeholder '")
 			buf.WriteByte(c)
@@ -102,6 +108,16 @@ func (s formatter) progress(counts status.Counts) string {
 	return buf.String()
 }
 
+// estimate returns the expected remaining time of the build and its critical
+// path, or an empty string if there is no estimate.
+func (s formatter) estimate(counts status.Counts) string {
+	if counts.Estimate.Remaining == 0 {
+		return ""
+	}
+	return fmt.Sprintf("[ETA %s, critical path %s] ",
+		status.MinutesSeconds(counts.Estimate.Remaining), status.MinutesSeconds(counts.Estimate.CriticalPath))
+}
+
 func (s formatter) result(result status.ActionResult) string {
 	var ret string
 	if result.Error != nil {
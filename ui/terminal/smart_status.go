@@ -132,7 +132,7 @@ func (s *smartStatusOutput) StartAction(action *status.Action, counts status.Cou
 		str = action.Command
 	}
 
-	progress := s.formatter.progress(counts)
+	progress := s.formatter.progress(counts) + s.formatter.estimate(counts)
 
 	s.lock.Lock()
 	defer s.lock.Unlock()
@@ -151,7 +151,7 @@ func (s *smartStatusOutput) FinishAction(result status.ActionResult, counts stat
 		str = result.Command
 	}
 
-	progress := s.formatter.progress(counts) + str
+	progress := s.formatter.progress(counts) + s.formatter.estimate(counts) + str
 
 	output := s.formatter.result(result)
 
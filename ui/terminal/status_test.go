@@ -20,6 +20,7 @@ import (
 	"os"
 	"syscall"
 	"testing"
+	"time"
 
 	"android/soong/ui/status"
 )
@@ -295,3 +296,27 @@ func TestSmartStatusOutputWidthChange(t *testing.T) {
 		t.Errorf("want:\n%q\ngot:\n%q", w, g)
 	}
 }
+
+func TestSmartStatusOutputEstimate(t *testing.T) {
+	os.Setenv(tableHeightEnVar, "")
+
+	smart := &fakeSmartTerminal{termWidth: 80}
+	stat := NewStatusOutput(smart, "", false, false, false)
+
+	runner := newRunner(stat, 2)
+	runner.counts.Estimate = status.Estimate{
+		Remaining:    65 * time.Second,
+		CriticalPath: 30 * time.Second,
+	}
+	runner.startAction(action1)
+	runner.counts.Estimate = status.Estimate{}
+	runner.finishAction(result1)
+
+	stat.Flush()
+
+	w := "\r\x1b[1m[  0% 0/2] [ETA 1:05, critical path 0:30] action1\x1b[0m\x1b[K\r\x1b[1m[ 50% 1/2] action1\x1b[0m\x1b[K\n"
+
+	if g := smart.String(); g != w {
+		t.Errorf("want:\n%q\ngot:\n%q", w, g)
+	}
+}
@@ -0,0 +1,419 @@
+// Copyright 2023 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracer
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	soong_metrics_proto "android/soong/ui/metrics/metrics_proto"
+	"android/soong/ui/status"
+)
+
+// The OpenTelemetry span kind and status codes, from
+// opentelemetry/proto/trace/v1/trace.proto.
+const (
+	otlpSpanKindInternal = 1
+
+	otlpStatusCodeOk    = 1
+	otlpStatusCodeError = 2
+)
+
+// maxOtlpStatusMessage is the length that the error messages of failed
+// actions are truncated to.
+const maxOtlpStatusMessage = 1024
+
+// The OTLP JSON encoding of an ExportTraceServiceRequest. Trace and span IDs
+// are hex encoded, and 64 bit integers are strings.
+type otlpTraces struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope   `json:"scope"`
+	Spans []*otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            *otlpStatus     `json:"status,omitempty"`
+
+	start, end time.Time
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue *string `json:"stringValue,omitempty"`
+	IntValue    *string `json:"intValue,omitempty"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+func otlpString(key, value string) otlpAttribute {
+	return otlpAttribute{key, otlpValue{StringValue: &value}}
+}
+
+func otlpInt(key string, value uint64) otlpAttribute {
+	s := strconv.FormatUint(value, 10)
+	return otlpAttribute{key, otlpValue{IntValue: &s}}
+}
+
+// OtlpExporter converts the phases of soong_ui, the events of soong_build and
+// the actions run by ninja into OpenTelemetry spans, and exports them in the
+// OTLP JSON format. The phases and events are nested by time, and the actions
+// are children of the innermost phase that they ran in.
+type OtlpExporter struct {
+	lock sync.Mutex
+
+	traceID string
+
+	// parentSpanID is the span of the caller of soong_ui, from the
+	// TRACEPARENT environment variable.
+	parentSpanID string
+
+	phases  []*otlpSpan
+	actions []*otlpSpan
+	running map[*status.Action]time.Time
+
+	resource []otlpAttribute
+}
+
+// NewOtlpExporter returns an OtlpExporter. If traceparent is a W3C trace
+// context header, the spans are part of its trace, under its span, so that
+// the build shows up in the trace of the CI job that runs it.
+func NewOtlpExporter(traceparent string) *OtlpExporter {
+	e := &OtlpExporter{
+		running: make(map[*status.Action]time.Time),
+	}
+	if traceID, spanID, ok := parseTraceparent(traceparent); ok {
+		e.traceID = traceID
+		e.parentSpanID = spanID
+	} else {
+		e.traceID = randomHex(16)
+	}
+	return e
+}
+
+// parseTraceparent parses a version 00 traceparent header, see
+// https://www.w3.org/TR/trace-context/#traceparent-header.
+func parseTraceparent(traceparent string) (traceID, spanID string, ok bool) {
+	fields := strings.Split(strings.TrimSpace(traceparent), "-")
+	if len(fields) != 4 || fields[0] != "00" || len(fields[1]) != 32 || len(fields[2]) != 16 {
+		return "", "", false
+	}
+	for _, f := range fields[1:3] {
+		if _, err := hex.DecodeString(f); err != nil || strings.Trim(f, "0") == "" {
+			return "", "", false
+		}
+	}
+	return strings.ToLower(fields[1]), strings.ToLower(fields[2]), true
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		// Fall back to the time, the IDs only need to be unique within
+		// the build.
+		binary.BigEndian.PutUint64(b, uint64(time.Now().UnixNano()))
+	}
+	return hex.EncodeToString(b)
+}
+
+// SetResource sets the attributes of the build, like the product and the
+// goals.
+func (e *OtlpExporter) SetResource(attributes map[string]string) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	keys := make([]string, 0, len(attributes))
+	for key := range attributes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	e.resource = []otlpAttribute{otlpString("service.name", "soong_ui")}
+	for _, key := range keys {
+		e.resource = append(e.resource, otlpString(key, attributes[key]))
+	}
+}
+
+// AddPerfInfos adds the phases of soong_ui, or the events of soong_build, as
+// spans.
+func (e *OtlpExporter) AddPerfInfos(prefix string, perfInfos []*soong_metrics_proto.PerfInfo) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	for _, info := range perfInfos {
+		start := time.Unix(0, int64(info.GetStartTime()))
+		span := &otlpSpan{
+			Name:  prefix + info.GetDescription(),
+			start: start,
+			end:   start.Add(time.Duration(info.GetRealTime())),
+			Attributes: []otlpAttribute{
+				otlpString("soong.phase", info.GetName()),
+			},
+		}
+		for _, proc := range info.GetProcessesResourceInfo() {
+			span.Attributes = append(span.Attributes,
+				otlpInt("soong.process."+proc.GetName()+".max_rss_kb", proc.GetMaxRssKb()),
+				otlpInt("soong.process."+proc.GetName()+".user_time_us", proc.GetUserTimeMicros()),
+				otlpInt("soong.process."+proc.GetName()+".system_time_us", proc.GetSystemTimeMicros()))
+		}
+		if info.GetNonZeroExit() {
+			span.Status = &otlpStatus{Code: otlpStatusCodeError, Message: truncateOtlpMessage(info.GetErrorMessage())}
+		}
+		e.phases = append(e.phases, span)
+	}
+}
+
+func truncateOtlpMessage(s string) string {
+	if len(s) > maxOtlpStatusMessage {
+		return s[:maxOtlpStatusMessage-3] + "..."
+	}
+	return s
+}
+
+// StatusOutput returns a StatusOutput that records the actions run by ninja
+// as spans.
+func (e *OtlpExporter) StatusOutput() status.StatusOutput {
+	return &otlpStatusOutput{e}
+}
+
+type otlpStatusOutput struct {
+	e *OtlpExporter
+}
+
+func (s *otlpStatusOutput) StartAction(action *status.Action, counts status.Counts) {
+	s.e.lock.Lock()
+	defer s.e.lock.Unlock()
+	s.e.running[action] = time.Now()
+}
+
+func (s *otlpStatusOutput) FinishAction(result status.ActionResult, counts status.Counts) {
+	s.e.lock.Lock()
+	defer s.e.lock.Unlock()
+
+	start, ok := s.e.running[result.Action]
+	if !ok {
+		return
+	}
+	delete(s.e.running, result.Action)
+
+	name := result.Action.Description
+	if name == "" && len(result.Action.Outputs) > 0 {
+		name = result.Action.Outputs[0]
+	}
+	span := &otlpSpan{
+		Name:  name,
+		start: start,
+		end:   time.Now(),
+		Attributes: []otlpAttribute{
+			otlpInt("soong.action.max_rss_kb", result.Stats.MaxRssKB),
+			otlpInt("soong.action.user_time_ms", uint64(result.Stats.UserTime)),
+			otlpInt("soong.action.system_time_ms", uint64(result.Stats.SystemTime)),
+		},
+	}
+	if len(result.Action.Outputs) > 0 {
+		span.Attributes = append(span.Attributes,
+			otlpString("soong.action.output", result.Action.Outputs[0]),
+			otlpInt("soong.action.outputs", uint64(len(result.Action.Outputs))))
+	}
+	if result.Error != nil {
+		span.Status = &otlpStatus{Code: otlpStatusCodeError, Message: truncateOtlpMessage(result.Error.Error())}
+	}
+	s.e.actions = append(s.e.actions, span)
+}
+
+func (s *otlpStatusOutput) Flush()                                        {}
+func (s *otlpStatusOutput) Message(level status.MsgLevel, message string) {}
+func (s *otlpStatusOutput) Write(p []byte) (int, error)                   { return len(p), nil }
+
+// spans assigns IDs and parents to the spans, and returns them with a root
+// span for the whole build.
+func (e *OtlpExporter) spans(name string, failed bool) []*otlpSpan {
+	root := &otlpSpan{Name: name}
+	if failed {
+		root.Status = &otlpStatus{Code: otlpStatusCodeError}
+	} else {
+		root.Status = &otlpStatus{Code: otlpStatusCodeOk}
+	}
+	for _, span := range append(append([]*otlpSpan(nil), e.phases...), e.actions...) {
+		if root.start.IsZero() || span.start.Before(root.start) {
+			root.start = span.start
+		}
+		if span.end.After(root.end) {
+			root.end = span.end
+		}
+	}
+
+	phases := append([]*otlpSpan(nil), e.phases...)
+	sort.SliceStable(phases, func(i, j int) bool {
+		if !phases[i].start.Equal(phases[j].start) {
+			return phases[i].start.Before(phases[j].start)
+		}
+		return phases[i].end.After(phases[j].end)
+	})
+
+	setID := func(span, parent *otlpSpan) {
+		span.TraceID = e.traceID
+		span.SpanID = randomHex(8)
+		span.Kind = otlpSpanKindInternal
+		span.StartTimeUnixNano = strconv.FormatInt(span.start.UnixNano(), 10)
+		span.EndTimeUnixNano = strconv.FormatInt(span.end.UnixNano(), 10)
+		if parent != nil {
+			span.ParentSpanID = parent.SpanID
+		}
+	}
+	setID(root, nil)
+	root.ParentSpanID = e.parentSpanID
+
+	contains := func(outer, inner *otlpSpan) bool {
+		return !inner.start.Before(outer.start) && !inner.end.After(outer.end)
+	}
+
+	// Phases are nested in the innermost phase that contains them, which
+	// is on the stack of phases that started before them.
+	var stack []*otlpSpan
+	for _, phase := range phases {
+		for len(stack) > 0 && !contains(stack[len(stack)-1], phase) {
+			stack = stack[:len(stack)-1]
+		}
+		parent := root
+		if len(stack) > 0 {
+			parent = stack[len(stack)-1]
+		}
+		setID(phase, parent)
+		stack = append(stack, phase)
+	}
+
+	for _, action := range e.actions {
+		parent := root
+		for _, phase := range phases {
+			if contains(phase, action) && (parent == root || contains(parent, phase)) {
+				parent = phase
+			}
+		}
+		setID(action, parent)
+	}
+
+	ret := []*otlpSpan{root}
+	ret = append(ret, phases...)
+	ret = append(ret, e.actions...)
+	return ret
+}
+
+// Marshal returns the spans of the build under a root span with the given
+// name, as OTLP JSON ExportTraceServiceRequests of at most batchSpans spans
+// each, so that a large build doesn't exceed the request size limit of the
+// collector.
+func (e *OtlpExporter) Marshal(name string, failed bool, batchSpans int) ([][]byte, error) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	resource := e.resource
+	if resource == nil {
+		resource = []otlpAttribute{otlpString("service.name", "soong_ui")}
+	}
+
+	var ret [][]byte
+	spans := e.spans(name, failed)
+	for len(spans) > 0 {
+		batch := spans
+		if len(batch) > batchSpans {
+			batch = batch[:batchSpans]
+		}
+		spans = spans[len(batch):]
+
+		data, err := json.Marshal(&otlpTraces{
+			ResourceSpans: []otlpResourceSpans{{
+				Resource: otlpResource{Attributes: resource},
+				ScopeSpans: []otlpScopeSpans{{
+					Scope: otlpScope{Name: "android/soong/ui/tracer"},
+					Spans: batch,
+				}},
+			}},
+		})
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, data)
+	}
+	return ret, nil
+}
+
+// WriteOtlpFile writes batches of OTLP JSON data to a file, one per line like
+// the file exporter of the OpenTelemetry collector.
+func WriteOtlpFile(filename string, batches [][]byte) error {
+	var buf bytes.Buffer
+	for _, data := range batches {
+		buf.Write(data)
+		buf.WriteByte('\n')
+	}
+	return ioutil.WriteFile(filename, buf.Bytes(), 0666)
+}
+
+// SendOtlp sends OTLP JSON data to the traces endpoint of a collector with
+// OTLP/HTTP, for example http://localhost:4318/v1/traces.
+func SendOtlp(endpoint string, data []byte, timeout time.Duration) error {
+	client := &http.Client{Timeout: timeout}
+	resp, err := client.Post(endpoint, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("%s: %s %s", endpoint, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
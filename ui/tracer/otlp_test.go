@@ -0,0 +1,222 @@
+// Copyright 2023 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracer
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	soong_metrics_proto "android/soong/ui/metrics/metrics_proto"
+	"android/soong/ui/status"
+
+	"google.golang.org/protobuf/proto"
+)
+
+func otlpPerfInfo(desc string, start time.Time, realTime time.Duration) *soong_metrics_proto.PerfInfo {
+	return &soong_metrics_proto.PerfInfo{
+		Name:        proto.String("test"),
+		Description: proto.String(desc),
+		StartTime:   proto.Uint64(uint64(start.UnixNano())),
+		RealTime:    proto.Uint64(uint64(realTime)),
+	}
+}
+
+func TestOtlpExporter(t *testing.T) {
+	const traceparent = "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"
+	e := NewOtlpExporter(traceparent)
+
+	start := time.Now().Add(-time.Minute)
+	e.AddPerfInfos("", []*soong_metrics_proto.PerfInfo{
+		otlpPerfInfo("soong", start, 20*time.Second),
+		otlpPerfInfo("blueprint bootstrap", start.Add(time.Second), 2*time.Second),
+		otlpPerfInfo("ninja", start.Add(30*time.Second), 2*time.Minute),
+	})
+	e.AddPerfInfos("soong_build ", []*soong_metrics_proto.PerfInfo{
+		otlpPerfInfo("mutator.deps", start.Add(5*time.Second), time.Second),
+	})
+
+	output := e.StatusOutput()
+	action := &status.Action{Description: "cc foo.o", Outputs: []string{"out/foo.o"}}
+	output.StartAction(action, status.Counts{})
+	output.FinishAction(status.ActionResult{
+		Action: action,
+		Error:  fmt.Errorf("exit status 1"),
+		Stats:  status.ActionResultStats{MaxRssKB: 1024},
+	}, status.Counts{})
+
+	batches, err := e.Marshal("soong_ui droid", true, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(batches) != 1 {
+		t.Fatalf("expected a single batch, got %d", len(batches))
+	}
+	data := batches[0]
+	traces := &otlpTraces{}
+	if err := json.Unmarshal(data, traces); err != nil {
+		t.Fatal(err)
+	}
+	if len(traces.ResourceSpans) != 1 || len(traces.ResourceSpans[0].ScopeSpans) != 1 {
+		t.Fatalf("expected a single resource and scope, got %s", data)
+	}
+
+	spans := make(map[string]*otlpSpan)
+	ids := make(map[string]string)
+	for _, span := range traces.ResourceSpans[0].ScopeSpans[0].Spans {
+		spans[span.Name] = span
+		ids[span.SpanID] = span.Name
+		if span.TraceID != "0af7651916cd43dd8448eb211c80319c" {
+			t.Errorf("%s: expected the trace of the traceparent, got %q", span.Name, span.TraceID)
+		}
+	}
+
+	parents := map[string]string{
+		"soong":                    "soong_ui droid",
+		"blueprint bootstrap":      "soong",
+		"soong_build mutator.deps": "soong",
+		"ninja":                    "soong_ui droid",
+		"cc foo.o":                 "ninja",
+	}
+	for name, want := range parents {
+		span, ok := spans[name]
+		if !ok {
+			t.Errorf("missing span %q", name)
+			continue
+		}
+		if got := ids[span.ParentSpanID]; got != want {
+			t.Errorf("%s: expected parent %q, got %q", name, want, got)
+		}
+	}
+
+	root := spans["soong_ui droid"]
+	if root.ParentSpanID != "b7ad6b7169203331" {
+		t.Errorf("expected the root span to be a child of the traceparent, got %q", root.ParentSpanID)
+	}
+	if root.Status == nil || root.Status.Code != otlpStatusCodeError {
+		t.Errorf("expected the failed build to have an error status, got %+v", root.Status)
+	}
+	if want := fmt.Sprint(start.UnixNano()); root.StartTimeUnixNano != want {
+		t.Errorf("expected the root span to start at %s, got %s", want, root.StartTimeUnixNano)
+	}
+	if a := spans["cc foo.o"]; a.Status == nil || a.Status.Message != "exit status 1" {
+		t.Errorf("expected the failed action to have an error status, got %+v", a.Status)
+	}
+}
+
+func TestOtlpExporterBatches(t *testing.T) {
+	e := NewOtlpExporter("")
+	start := time.Now().Add(-time.Minute)
+	e.AddPerfInfos("", []*soong_metrics_proto.PerfInfo{
+		otlpPerfInfo("soong", start, 20*time.Second),
+		otlpPerfInfo("kati", start.Add(20*time.Second), 10*time.Second),
+		otlpPerfInfo("ninja", start.Add(30*time.Second), 20*time.Second),
+		otlpPerfInfo("ninja cleanup", start.Add(50*time.Second), time.Second),
+	})
+
+	batches, err := e.Marshal("soong_ui", false, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The root span and the 4 phases are split into batches of at most 2
+	// spans, that are complete requests of the same trace.
+	var names []string
+	traceIDs := make(map[string]bool)
+	for _, data := range batches {
+		traces := &otlpTraces{}
+		if err := json.Unmarshal(data, traces); err != nil {
+			t.Fatal(err)
+		}
+		if len(traces.ResourceSpans) != 1 || len(traces.ResourceSpans[0].ScopeSpans) != 1 {
+			t.Fatalf("expected a single resource and scope, got %s", data)
+		}
+		spans := traces.ResourceSpans[0].ScopeSpans[0].Spans
+		if len(spans) > 2 {
+			t.Errorf("expected at most 2 spans in a batch, got %d", len(spans))
+		}
+		for _, span := range spans {
+			names = append(names, span.Name)
+			traceIDs[span.TraceID] = true
+		}
+	}
+	if len(batches) != 3 {
+		t.Errorf("expected 3 batches, got %d", len(batches))
+	}
+	if want := []string{"soong_ui", "soong", "kati", "ninja", "ninja cleanup"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("expected spans %q, got %q", want, names)
+	}
+	if len(traceIDs) != 1 {
+		t.Errorf("expected the batches to be in the same trace, got %v", traceIDs)
+	}
+
+	// The file has a batch per line.
+	file := filepath.Join(t.TempDir(), "build_trace.otlp.json")
+	if err := WriteOtlpFile(file, batches); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	if len(lines) != len(batches) || lines[0] != string(batches[0]) {
+		t.Errorf("expected a line per batch, got %q", data)
+	}
+}
+
+func TestParseTraceparent(t *testing.T) {
+	testCases := map[string]bool{
+		"00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01": true,
+		"": false,
+		"01-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01": false,
+		"00-00000000000000000000000000000000-b7ad6b7169203331-01": false,
+		"00-0af7651916cd43dd8448eb211c80319c-xyz":                 false,
+	}
+	for traceparent, want := range testCases {
+		if _, _, got := parseTraceparent(traceparent); got != want {
+			t.Errorf("%q: expected %v, got %v", traceparent, want, got)
+		}
+	}
+}
+
+func TestSendOtlp(t *testing.T) {
+	var received []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" || r.Header.Get("Content-Type") != "application/json" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		received, _ = ioutil.ReadAll(r.Body)
+	}))
+	defer server.Close()
+
+	if err := SendOtlp(server.URL+"/v1/traces", []byte("{}"), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if string(received) != "{}" {
+		t.Errorf("expected the collector to receive the spans, got %q", received)
+	}
+	if err := SendOtlp(server.URL+"/v1/logs", []byte("{}"), time.Minute); err == nil {
+		t.Errorf("expected an error from the collector")
+	}
+}
@@ -0,0 +1,279 @@
+// Copyright 2023 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracer
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"android/soong/ui/logger"
+	perfetto_proto "android/soong/ui/tracer/perfetto_proto"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+)
+
+// perfettoSequenceId is the id of the only sequence of packets in the trace.
+const perfettoSequenceId = 1
+
+// perfettoArgs is implemented by the args of events that can be written to
+// Perfetto traces as debug annotations.
+type perfettoArgs interface {
+	debugAnnotations() []*perfetto_proto.DebugAnnotation
+}
+
+type threadKey struct {
+	pid, tid uint64
+}
+
+type counterKey struct {
+	name, series string
+}
+
+// perfettoWriter writes the same events as the JSON trace in the Perfetto
+// protobuf trace format, which can be opened in https://ui.perfetto.dev. It is
+// much smaller than the JSON format, and supports counter tracks.
+//
+// A Perfetto trace is a Trace message, which only contains repeated
+// TracePackets. Each packet is written as a separate length-delimited field, so
+// that the trace can be streamed to the file.
+type perfettoWriter struct {
+	buf  bytes.Buffer
+	file *os.File
+	w    io.WriteCloser
+
+	nextUuid       uint64
+	processTracks  map[uint64]uint64
+	threadTracks   map[threadKey]uint64
+	threadNames    map[threadKey]string
+	counterTracks  map[counterKey]uint64
+	processNames   map[uint64]string
+	describedNames map[uint64]string
+}
+
+// perfettoFilename returns the name of the Perfetto trace that is written next
+// to the JSON trace with the given name.
+func perfettoFilename(filename string) string {
+	filename = strings.TrimSuffix(filename, ".gz")
+	return strings.TrimSuffix(filename, ".trace") + ".perfetto-trace.gz"
+}
+
+func (p *perfettoWriter) startBuffer() {
+	p.buf = bytes.Buffer{}
+	p.w = nopCloser{&p.buf}
+
+	p.nextUuid = 1
+	p.processTracks = make(map[uint64]uint64)
+	p.threadTracks = make(map[threadKey]uint64)
+	p.threadNames = make(map[threadKey]string)
+	p.counterTracks = make(map[counterKey]uint64)
+	p.describedNames = make(map[uint64]string)
+	p.processNames = map[uint64]string{
+		0: "soong_ui",
+		1: "actions",
+	}
+}
+
+func (p *perfettoWriter) close(log logger.Logger) {
+	if p.file != nil {
+		if err := p.w.Close(); err != nil {
+			log.Println("Error closing perfetto trace writer:", err)
+		}
+
+		if err := p.file.Close(); err != nil {
+			log.Println("Error closing perfetto trace file:", err)
+		}
+		p.file = nil
+	}
+}
+
+func (p *perfettoWriter) setOutput(log logger.Logger, filename string) {
+	f, err := logger.CreateFileWithRotation(filename, 5)
+	if err != nil {
+		log.Println("Failed to create perfetto trace file:", err)
+		return
+	}
+	p.file = f
+	p.w = gzip.NewWriter(f)
+
+	if _, err := io.Copy(p.w, &p.buf); err != nil {
+		log.Println("Failed to write perfetto trace buffer to file:", err)
+	}
+	p.buf = bytes.Buffer{}
+}
+
+func (p *perfettoWriter) writePacket(log logger.Logger, packet *perfetto_proto.TracePacket) {
+	packet.TrustedPacketSequenceId = proto.Uint32(perfettoSequenceId)
+
+	data, err := proto.Marshal(packet)
+	if err != nil {
+		log.Println("Failed to marshal perfetto packet:", err)
+		return
+	}
+
+	// Field 1 of the Trace message.
+	var header []byte
+	header = protowire.AppendTag(header, 1, protowire.BytesType)
+	header = protowire.AppendVarint(header, uint64(len(data)))
+	if _, err := p.w.Write(append(header, data...)); err != nil {
+		log.Println("Perfetto trace write error:", err)
+	}
+}
+
+func (p *perfettoWriter) describeTrack(log logger.Logger, uuid, parent uint64, name string, counter *perfetto_proto.CounterDescriptor) {
+	if described, ok := p.describedNames[uuid]; ok && described == name {
+		return
+	}
+	p.describedNames[uuid] = name
+
+	descriptor := &perfetto_proto.TrackDescriptor{
+		Uuid:    proto.Uint64(uuid),
+		Name:    proto.String(name),
+		Counter: counter,
+	}
+	if parent != 0 {
+		descriptor.ParentUuid = proto.Uint64(parent)
+	}
+	p.writePacket(log, &perfetto_proto.TracePacket{
+		Data: &perfetto_proto.TracePacket_TrackDescriptor{TrackDescriptor: descriptor},
+	})
+}
+
+// processTrack returns the uuid of the track that groups all of the tracks
+// of a pid, describing it if necessary.
+func (p *perfettoWriter) processTrack(log logger.Logger, pid uint64) uint64 {
+	uuid, ok := p.processTracks[pid]
+	if !ok {
+		uuid = p.nextUuid
+		p.nextUuid++
+		p.processTracks[pid] = uuid
+
+		name, ok := p.processNames[pid]
+		if !ok {
+			name = fmt.Sprintf("process %d", pid)
+		}
+		p.describeTrack(log, uuid, 0, name, nil)
+	}
+	return uuid
+}
+
+// threadTrack returns the uuid of the track that contains the slices of a
+// thread, describing it if necessary.
+func (p *perfettoWriter) threadTrack(log logger.Logger, key threadKey) uint64 {
+	uuid, ok := p.threadTracks[key]
+	if !ok {
+		uuid = p.nextUuid
+		p.nextUuid++
+		p.threadTracks[key] = uuid
+	}
+
+	name, ok := p.threadNames[key]
+	if !ok {
+		// Actions are distributed over tids as if they were CPUs.
+		name = fmt.Sprintf("cpu %d", key.tid)
+	}
+	p.describeTrack(log, uuid, p.processTrack(log, key.pid), name, nil)
+	return uuid
+}
+
+func (p *perfettoWriter) writeEvent(log logger.Logger, event *viewerEvent) {
+	key := threadKey{pid: event.Pid, tid: event.Tid}
+
+	switch event.Phase {
+	case "M":
+		if arg, ok := event.Arg.(*nameArg); ok && event.Name == "thread_name" {
+			p.threadNames[key] = arg.Name
+			p.threadTrack(log, key)
+		}
+	case "B":
+		p.writePacket(log, &perfetto_proto.TracePacket{
+			Timestamp: proto.Uint64(event.Time * 1000),
+			Data: &perfetto_proto.TracePacket_TrackEvent{TrackEvent: &perfetto_proto.TrackEvent{
+				Type:      perfetto_proto.TrackEvent_TYPE_SLICE_BEGIN.Enum(),
+				TrackUuid: proto.Uint64(p.threadTrack(log, key)),
+				Name:      proto.String(event.Name),
+			}},
+		})
+	case "E":
+		p.writePacket(log, &perfetto_proto.TracePacket{
+			Timestamp: proto.Uint64(event.Time * 1000),
+			Data: &perfetto_proto.TracePacket_TrackEvent{TrackEvent: &perfetto_proto.TrackEvent{
+				Type:      perfetto_proto.TrackEvent_TYPE_SLICE_END.Enum(),
+				TrackUuid: proto.Uint64(p.threadTrack(log, key)),
+			}},
+		})
+	case "X":
+		uuid := p.threadTrack(log, key)
+		begin := &perfetto_proto.TrackEvent{
+			Type:      perfetto_proto.TrackEvent_TYPE_SLICE_BEGIN.Enum(),
+			TrackUuid: proto.Uint64(uuid),
+			Name:      proto.String(event.Name),
+		}
+		if args, ok := event.Arg.(perfettoArgs); ok {
+			begin.DebugAnnotations = args.debugAnnotations()
+		}
+		p.writePacket(log, &perfetto_proto.TracePacket{
+			Timestamp: proto.Uint64(event.Time * 1000),
+			Data:      &perfetto_proto.TracePacket_TrackEvent{TrackEvent: begin},
+		})
+		p.writePacket(log, &perfetto_proto.TracePacket{
+			Timestamp: proto.Uint64((event.Time + event.Dur) * 1000),
+			Data: &perfetto_proto.TracePacket_TrackEvent{TrackEvent: &perfetto_proto.TrackEvent{
+				Type:      perfetto_proto.TrackEvent_TYPE_SLICE_END.Enum(),
+				TrackUuid: proto.Uint64(uuid),
+			}},
+		})
+	}
+}
+
+// writeCounter writes a value to the counter track for each of the series of
+// a counter, describing the tracks if necessary.
+func (p *perfettoWriter) writeCounter(log logger.Logger, timestamp uint64, name string,
+	unit perfetto_proto.CounterDescriptor_Unit, values map[string]float64) {
+
+	series := make([]string, 0, len(values))
+	for s := range values {
+		series = append(series, s)
+	}
+	sort.Strings(series)
+
+	for _, s := range series {
+		key := counterKey{name: name, series: s}
+		uuid, ok := p.counterTracks[key]
+		if !ok {
+			uuid = p.nextUuid
+			p.nextUuid++
+			p.counterTracks[key] = uuid
+			p.describeTrack(log, uuid, p.processTrack(log, 0), name+" "+s,
+				&perfetto_proto.CounterDescriptor{Unit: unit.Enum()})
+		}
+
+		p.writePacket(log, &perfetto_proto.TracePacket{
+			Timestamp: proto.Uint64(timestamp),
+			Data: &perfetto_proto.TracePacket_TrackEvent{TrackEvent: &perfetto_proto.TrackEvent{
+				Type:      perfetto_proto.TrackEvent_TYPE_COUNTER.Enum(),
+				TrackUuid: proto.Uint64(uuid),
+				CounterValueField: &perfetto_proto.TrackEvent_DoubleCounterValue{
+					DoubleCounterValue: values[s],
+				},
+			}},
+		})
+	}
+}
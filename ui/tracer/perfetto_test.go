@@ -0,0 +1,158 @@
+// Copyright 2023 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracer
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"android/soong/ui/logger"
+	"android/soong/ui/status"
+	perfetto_proto "android/soong/ui/tracer/perfetto_proto"
+
+	"google.golang.org/protobuf/proto"
+)
+
+func readPerfettoTrace(t *testing.T, filename string) *perfetto_proto.Trace {
+	t.Helper()
+	f, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	r, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	trace := &perfetto_proto.Trace{}
+	if err := proto.Unmarshal(data, trace); err != nil {
+		t.Fatalf("failed to parse perfetto trace: %s", err)
+	}
+	return trace
+}
+
+func TestPerfettoTrace(t *testing.T) {
+	dir := t.TempDir()
+
+	trace := New(logger.New(ioutil.Discard))
+	// Events before SetOutput are buffered.
+	trace.Begin("setup", MainThread)
+	trace.End(MainThread)
+	trace.SetOutput(filepath.Join(dir, "build.trace"))
+
+	action := &status.Action{Description: "compile", Outputs: []string{"out/a.o"}}
+	statusTracer := trace.StatusTracer()
+	statusTracer.StartAction(action, status.Counts{})
+	statusTracer.FinishAction(status.ActionResult{Action: action, Stats: status.ActionResultStats{MaxRssKB: 42}}, status.Counts{})
+
+	trace.writeCounter(time.Unix(1, 0), "memory", perfetto_proto.CounterDescriptor_UNIT_SIZE_BYTES,
+		map[string]float64{"used": 1024, "available": 2048})
+	trace.Close()
+
+	if _, err := os.Stat(filepath.Join(dir, "build.trace.gz")); err != nil {
+		t.Errorf("expected JSON trace: %s", err)
+	}
+
+	tracks := make(map[uint64]*perfetto_proto.TrackDescriptor)
+	var slices []string
+	var ends int
+	counters := make(map[string]float64)
+	for _, packet := range readPerfettoTrace(t, filepath.Join(dir, "build.perfetto-trace.gz")).GetPacket() {
+		if packet.GetTrustedPacketSequenceId() != perfettoSequenceId {
+			t.Errorf("expected sequence id on all packets, got %v", packet)
+		}
+		if d := packet.GetTrackDescriptor(); d != nil {
+			tracks[d.GetUuid()] = d
+		}
+		if e := packet.GetTrackEvent(); e != nil {
+			if tracks[e.GetTrackUuid()] == nil {
+				t.Errorf("event on a track that wasn't described: %v", e)
+				continue
+			}
+			switch e.GetType() {
+			case perfetto_proto.TrackEvent_TYPE_SLICE_BEGIN:
+				slices = append(slices, tracks[e.GetTrackUuid()].GetName()+": "+e.GetName())
+				if e.GetName() == "out/a.o" {
+					annotations := make(map[string]uint64)
+					for _, a := range e.GetDebugAnnotations() {
+						annotations[a.GetName()] = a.GetUintValue()
+					}
+					if annotations["max_rss_kb"] != 42 {
+						t.Errorf("expected max_rss_kb annotation, got %v", e.GetDebugAnnotations())
+					}
+				}
+			case perfetto_proto.TrackEvent_TYPE_SLICE_END:
+				ends++
+			case perfetto_proto.TrackEvent_TYPE_COUNTER:
+				track := tracks[e.GetTrackUuid()]
+				if track.GetCounter().GetUnit() != perfetto_proto.CounterDescriptor_UNIT_SIZE_BYTES {
+					t.Errorf("expected counter track in bytes, got %v", track)
+				}
+				if packet.GetTimestamp() != uint64(time.Second) {
+					t.Errorf("expected counter at 1s, got %d", packet.GetTimestamp())
+				}
+				counters[track.GetName()] = e.GetDoubleCounterValue()
+			}
+		}
+	}
+
+	wantSlices := []string{"main: setup", "cpu 0: out/a.o"}
+	if len(slices) != len(wantSlices) || slices[0] != wantSlices[0] || slices[1] != wantSlices[1] {
+		t.Errorf("expected slices %q, got %q", wantSlices, slices)
+	}
+	if ends != len(wantSlices) {
+		t.Errorf("expected %d slice ends, got %d", len(wantSlices), ends)
+	}
+	if counters["memory used"] != 1024 || counters["memory available"] != 2048 {
+		t.Errorf("expected memory counters, got %v", counters)
+	}
+}
+
+func TestSampleResources(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("resource usage is only read from /proc on linux")
+	}
+	dir := t.TempDir()
+
+	trace := New(logger.New(ioutil.Discard))
+	trace.SetOutput(filepath.Join(dir, "build.trace"))
+	stop := trace.SampleResources(time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	stop()
+	trace.Close()
+
+	names := make(map[string]bool)
+	for _, packet := range readPerfettoTrace(t, filepath.Join(dir, "build.perfetto-trace.gz")).GetPacket() {
+		if d := packet.GetTrackDescriptor(); d != nil && d.GetCounter() != nil {
+			names[d.GetName()] = true
+		}
+	}
+	for _, name := range []string{"cpu busy_percent", "memory used", "swap used"} {
+		if !names[name] {
+			t.Errorf("expected counter track %q, got %v", name, names)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+// Copyright 2023 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracer
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"android/soong/finder/fs"
+	"android/soong/ui/metrics/proc"
+	perfetto_proto "android/soong/ui/tracer/perfetto_proto"
+)
+
+// resourceSampler reads the resource usage of the system and of a process
+// tree from /proc, and writes them to the trace as counters.
+type resourceSampler struct {
+	tracer     *tracerImpl
+	fileSystem fs.FileSystem
+	pid        int
+
+	// lastCPU is the previous sample of the CPU times, the load of the
+	// CPUs is computed from the difference between two samples.
+	lastCPU *proc.CPUStat
+}
+
+func (t *tracerImpl) SampleResources(interval time.Duration) func() {
+	s := &resourceSampler{
+		tracer:     t,
+		fileSystem: fs.OsFs,
+		pid:        os.Getpid(),
+	}
+
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			s.sample(time.Now())
+			select {
+			case <-ticker.C:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-finished
+	}
+}
+
+func (s *resourceSampler) sample(now time.Time) {
+	if cpu, err := proc.NewCPUStat(s.fileSystem); err == nil {
+		if s.lastCPU != nil && cpu.Total() > s.lastCPU.Total() {
+			busy := float64(cpu.Busy()-s.lastCPU.Busy()) / float64(cpu.Total()-s.lastCPU.Total())
+			s.tracer.writeCounter(now, "cpu", perfetto_proto.CounterDescriptor_UNIT_UNSPECIFIED,
+				map[string]float64{"busy_percent": 100 * busy})
+		}
+		s.lastCPU = cpu
+	}
+
+	if mem, err := proc.NewMemInfo(s.fileSystem); err == nil && mem.MemTotal > 0 {
+		s.tracer.writeCounter(now, "memory", perfetto_proto.CounterDescriptor_UNIT_SIZE_BYTES,
+			map[string]float64{
+				"used":      float64(mem.MemTotal - mem.MemAvailable),
+				"available": float64(mem.MemAvailable),
+			})
+		s.tracer.writeCounter(now, "swap", perfetto_proto.CounterDescriptor_UNIT_SIZE_BYTES,
+			map[string]float64{"used": float64(mem.SwapTotal - mem.SwapFree)})
+	}
+
+	rss := make(map[string]float64)
+	s.sampleRss(s.pid, rss)
+	// The actions run by ninja are grandchildren of soong_ui, so the whole
+	// process tree is sampled.
+	if descendants, err := proc.DescendantPids(s.pid, s.fileSystem); err == nil {
+		for _, pid := range descendants {
+			s.sampleRss(pid, rss)
+		}
+	}
+	if len(rss) > 0 {
+		s.tracer.writeCounter(now, "rss", perfetto_proto.CounterDescriptor_UNIT_SIZE_BYTES, rss)
+	}
+}
+
+func (s *resourceSampler) sampleRss(pid int, rss map[string]float64) {
+	// The process may have exited since it was listed.
+	status, err := proc.NewProcStatus(pid, s.fileSystem)
+	if err != nil || status.VmRss == 0 {
+		return
+	}
+	rss[fmt.Sprintf("%s %d", status.Name, pid)] = float64(status.VmRss)
+}
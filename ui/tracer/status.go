@@ -16,7 +16,10 @@ package tracer
 
 import (
 	"android/soong/ui/status"
+	perfetto_proto "android/soong/ui/tracer/perfetto_proto"
 	"time"
+
+	"google.golang.org/protobuf/proto"
 )
 
 func (t *tracerImpl) StatusTracer() status.StatusOutput {
@@ -106,6 +109,26 @@ type statsArg struct {
 	InvoluntaryContextSwitches uint64 `json:"involuntary_context_switches"`
 }
 
+func (s *statsArg) debugAnnotations() []*perfetto_proto.DebugAnnotation {
+	annotation := func(name string, value uint64) *perfetto_proto.DebugAnnotation {
+		return &perfetto_proto.DebugAnnotation{
+			Name:  proto.String(name),
+			Value: &perfetto_proto.DebugAnnotation_UintValue{UintValue: value},
+		}
+	}
+	return []*perfetto_proto.DebugAnnotation{
+		annotation("user_time", uint64(s.UserTime)),
+		annotation("system_time_ms", uint64(s.SystemTime)),
+		annotation("max_rss_kb", s.MaxRssKB),
+		annotation("minor_page_faults", s.MinorPageFaults),
+		annotation("major_page_faults", s.MajorPageFaults),
+		annotation("io_input_kb", s.IOInputKB),
+		annotation("io_output_kb", s.IOOutputKB),
+		annotation("voluntary_context_switches", s.VoluntaryContextSwitches),
+		annotation("involuntary_context_switches", s.InvoluntaryContextSwitches),
+	}
+}
+
 func (s *statusOutput) Flush()                                        {}
 func (s *statusOutput) Message(level status.MsgLevel, message string) {}
 
@@ -17,6 +17,9 @@
 //
 // It implements the JSON Array Format defined here:
 // https://docs.google.com/document/d/1CvAClvFfyA5R-PhYUmn5OOQtYMH4h6I0nSsKchNAySU/edit
+//
+// The same events are also written in the Perfetto protobuf trace format, which
+// can be opened in https://ui.perfetto.dev.
 package tracer
 
 import (
@@ -32,6 +35,7 @@ import (
 
 	"android/soong/ui/logger"
 	"android/soong/ui/status"
+	perfetto_proto "android/soong/ui/tracer/perfetto_proto"
 )
 
 type Thread uint64
@@ -51,6 +55,11 @@ type Tracer interface {
 	StatusTracer() status.StatusOutput
 
 	NewThread(name string) Thread
+
+	// SampleResources records the CPU, memory and swap usage of the system
+	// and the RSS of soong_ui and its child processes as counters every
+	// interval, until the returned function is called.
+	SampleResources(interval time.Duration) (stop func())
 }
 
 type tracerImpl struct {
@@ -61,6 +70,8 @@ type tracerImpl struct {
 	file *os.File
 	w    io.WriteCloser
 
+	perfetto perfettoWriter
+
 	firstEvent bool
 	nextTid    uint64
 }
@@ -104,6 +115,7 @@ func New(log logger.Logger) *tracerImpl {
 func (t *tracerImpl) startBuffer() {
 	t.w = nopCloser{&t.buf}
 	fmt.Fprintln(t.w, "[")
+	t.perfetto.startBuffer()
 
 	t.defineThread(MainThread, "main")
 }
@@ -120,11 +132,13 @@ func (t *tracerImpl) close() {
 			t.log.Println("Error closing trace file:", err)
 		}
 		t.file = nil
+		t.perfetto.close(t.log)
 		t.startBuffer()
 	}
 }
 
-// SetOutput creates the output file (rotating old files).
+// SetOutput creates the output file (rotating old files), and the Perfetto
+// trace next to it.
 func (t *tracerImpl) SetOutput(filename string) {
 	t.lock.Lock()
 	defer t.lock.Unlock()
@@ -151,6 +165,8 @@ func (t *tracerImpl) SetOutput(filename string) {
 		t.log.Println("Failed to write trace buffer to file:", err)
 	}
 	t.buf = bytes.Buffer{}
+
+	t.perfetto.setOutput(t.log, perfettoFilename(filename))
 }
 
 // Close closes the output file. Any future events will be buffered until the
@@ -186,6 +202,27 @@ func (t *tracerImpl) writeEventLocked(event *viewerEvent) {
 	if _, err = t.w.Write(bytes); err != nil {
 		t.log.Println("Trace write error:", err)
 	}
+
+	t.perfetto.writeEvent(t.log, event)
+}
+
+// writeCounter writes the values of each of the series of a counter at the
+// given time.
+func (t *tracerImpl) writeCounter(timestamp time.Time, name string,
+	unit perfetto_proto.CounterDescriptor_Unit, values map[string]float64) {
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.writeEventLocked(&viewerEvent{
+		Name:  name,
+		Phase: "C",
+		Time:  uint64(timestamp.UnixNano()) / 1000,
+		Pid:   0,
+		Tid:   0,
+		Arg:   values,
+	})
+	t.perfetto.writeCounter(t.log, uint64(timestamp.UnixNano()), name, unit, values)
 }
 
 func (t *tracerImpl) defineThread(thread Thread, name string) {